@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+)
+
+func (app *application) listUsers(w http.ResponseWriter, r *http.Request) {
+	var input userQuery
+
+	v := validator.New()
+
+	input.readQuery(r.URL.Query(), app, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.repos.User.GetAll(r.Context(), input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"users": users, "metadata": metadata}, paginationLinks(r, metadata))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Change the role of a user, which replaces their permissions with the role's preset.
+func (app *application) updateUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Role != "", "role", "must be provided")
+	v.Check(data.IsRole(input.Role), "role", fmt.Sprintf("must be one of %v", data.Roles))
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	var permissions data.Permissions
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		before, err := repos.Permission.GetAllForUser(r.Context(), int64(id))
+		if err != nil {
+			return err
+		}
+
+		permissions, err = repos.Permission.AssignRole(r.Context(), int64(id), input.Role)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionAssignRole, data.AuditEntityUser, int64(id),
+			envelope{"permissions": before},
+			envelope{"role": input.Role, "permissions": permissions},
+		)
+	})
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"user_id": id, "role": input.Role, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
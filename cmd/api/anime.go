@@ -4,10 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/jsonpatch"
 	"github.com/ziliscite/purplelight/internal/repository"
 	"github.com/ziliscite/purplelight/internal/validator"
+	"maps"
+	"mime"
 	"net/http"
-	"strconv"
 )
 
 func (app *application) createAnime(w http.ResponseWriter, r *http.Request) {
@@ -33,13 +35,32 @@ func (app *application) createAnime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.repos.Anime.InsertAnime(anime)
+	// Insert the anime and record it in the audit log in one transaction.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.InsertAnime(r.Context(), anime)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityAnime, int64(anime.ID), nil, anime)
+	})
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually
 		// add a message to the validator instance, and then call our
 		case errors.Is(err, repository.ErrDuplicateEntry):
-			v.AddError("title", "an anime with this title already exists")
+			// Both the title and the external IDs are unique, so find out which one
+			// is taken.
+			field, err := externalDuplicate(r.Context(), app.repos.Anime, anime)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if field == "" {
+				field = "title"
+			}
+
+			v.AddError(field, fmt.Sprintf("an anime with this %s already exists", field))
 			app.insertConflict(w, r, v.Errors)
 		default:
 			app.dbWriteError(w, r, err)
@@ -52,11 +73,11 @@ func (app *application) createAnime(w http.ResponseWriter, r *http.Request) {
 	// empty http.Header map and then use the Set() method to add a new Location header,
 	// interpolating the system-generated ID for our new movie in the URL.
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/anime/%d", anime.ID))
+	headers.Set("Location", versionPath(r, "/anime/%d", anime.ID))
 
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.write(w, http.StatusCreated, envelope{"anime": anime}, headers)
+	err = app.write(w, r, http.StatusCreated, envelope{"anime": anime}, headers)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -79,29 +100,46 @@ func (app *application) listAnime(w http.ResponseWriter, r *http.Request) {
 	// and the input struct as arguments.
 	input.readQuery(qs, app, v)
 
+	// v2 paginates with cursors, unless a page is asked for. Relevance can't be used as
+	// a cursor, so title searches sorted by it keep the pages.
+	if versionOf(r) >= apiV2 && input.Filters.Cursor == nil && !qs.Has("page") && input.Filters.Sort != data.SortRelevance {
+		input.Filters.Cursor = &data.Cursor{Sort: input.Filters.Sort}
+	}
+
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
 	// Check the Validator instance for any errors and use the failedValidationResponse()
 	// helper to send the client a response if necessary.
+	data.ValidateAnimeSearch(v, input.AnimeSearch, input.Filters)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidation(w, r, v.Errors)
 		return
 	}
 
 	// Call the GetAll() method on the movies repository to get a slice of Movie structs
-	anime, metadata, err := app.repos.Anime.GetAll(input.Title, input.Status, input.Season, input.AnimeType, input.Tags, input.Filters)
+	anime, metadata, err := app.repos.Anime.GetAll(r.Context(), input.AnimeSearch, input.Filters)
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
+	// The writes still in flight when the sync was taken commit with an updated_at from
+	// before it, and a lagging replica hasn't seen the latest ones yet, so the next sync
+	// starts some time before it. The anime changed in between are listed twice, which
+	// clients merge by ID and version.
+	if metadata.SyncedAt != nil {
+		syncedAt := metadata.SyncedAt.Add(-app.config.sync.overlap)
+		metadata.SyncedAt = &syncedAt
+	}
+
 	// not sure if proceed with this or nah
-	if len(anime) == 0 {
+	// An empty delta sync only means that nothing changed.
+	if len(anime) == 0 && input.UpdatedSince == nil {
 		app.notFound(w, r)
 		return
 	}
 
-	err = app.write(w, http.StatusOK, envelope{"anime": anime, "metadata": metadata}, nil)
+	err = app.write(w, r, http.StatusOK, envelope{"anime": sparse(anime, input.Fields), "metadata": metadata}, paginationLinks(r, metadata))
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -114,13 +152,129 @@ func (app *application) showAnime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	anime, err := app.repos.Anime.GetAnime(id)
+	v := validator.New()
+	fields := app.readFields(r.URL.Query(), animeFields, v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnime(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	// Clients polling an anime get a 304 Not Modified, without a body, as long as it
+	// hasn't changed. These don't count as views.
+	headers := make(http.Header)
+	etag := versionETag(int64(anime.ID), anime.Version, fields)
+	setValidators(headers, etag, anime.UpdatedAt)
+
+	if notModified(r, etag, anime.UpdatedAt) {
+		maps.Copy(w.Header(), headers)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	app.activity.recordView(anime.ID)
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": sparse(anime, fields)}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Show the anime linked to an ID on an external source, e.g. GET /v1/anime/external/mal/1
+// for the anime with the MyAnimeList ID 1.
+func (app *application) showAnimeByExternalID(w http.ResponseWriter, r *http.Request) {
+	source := r.PathValue("source")
+	if !validator.PermittedValue(source, data.ExternalSources...) {
+		app.notFound(w, r)
+		return
+	}
+
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	v := validator.New()
+	fields := app.readFields(r.URL.Query(), animeFields, v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnimeByExternalID(r.Context(), source, id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": sparse(anime, fields)}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Show the anime with a slug, e.g. GET /v1/anime/slug/youjo-senki. Slugs follow the
+// titles, so links to an anime that got renamed can stop working; the ID never changes.
+func (app *application) showAnimeBySlug(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	fields := app.readFields(r.URL.Query(), animeFields, v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnimeBySlug(r.Context(), r.PathValue("slug"))
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": sparse(anime, fields)}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// List the anime most similar to an anime, judging by the tags they share. The limit
+// parameter sets how many are returned.
+func (app *application) listSimilarAnime(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= data.MaxSimilarAnime, "limit", "must be a maximum of 50")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Make sure the anime exists, so that an unknown one is a 404 rather than an empty
+	// list.
+	anime, err := app.repos.Anime.GetAnime(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	similar, err := app.repos.Anime.GetSimilar(r.Context(), anime.ID, limit)
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
-	err = app.write(w, http.StatusOK, envelope{"anime": anime}, nil)
+	err = app.write(w, r, http.StatusOK, envelope{"similar": similar}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -133,28 +287,32 @@ func (app *application) updateAnime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	anime, err := app.repos.Anime.GetAnime(id)
+	// The version the update starts from is read from the primary, as a lagging read
+	// replica would fail the preconditions of a client updating twice in a row.
+	anime, err := app.repos.Anime.GetAnime(repository.ReadPrimary(r.Context()), id)
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
-	// If the request contains a X-Expected-Version header, verify that the movie
-	// version in the database matches the expected version specified in the header.
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.Itoa(int(anime.Version)) != r.Header.Get("X-Expected-Version") {
-			app.editConflict(w, r)
-			return
-		}
+	// If the request has an If-Match header, verify that the anime hasn't changed since
+	// the client fetched it.
+	if !app.checkPreconditions(w, r, versionETag(int64(anime.ID), anime.Version, nil), int(anime.Version)) {
+		return
 	}
 
+	// Clients replace an anime by sending back what they fetched, with their changes,
+	// so the read-only fields such as id and version are ignored rather than rejected.
 	var request animeRequest
-	err = app.readBody(w, r, &request)
+	err = app.readBodyWith(w, r, &request, bodyOptions{allowUnknownFields: true})
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
 
+	// Keep a copy of the record as it was, for the audit log.
+	before := *anime
+
 	v := validator.New()
 	request.toPut(anime, v)
 
@@ -163,13 +321,26 @@ func (app *application) updateAnime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.repos.Anime.UpdateAnime(anime)
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.UpdateAnime(r.Context(), anime)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(anime.ID), &before, anime)
+	})
 	if err != nil {
 		app.dbWriteError(w, r, err)
 		return
 	}
 
-	err = app.write(w, http.StatusOK, envelope{"anime": anime}, nil)
+	app.notifyNewEpisodes(r.Context(), &before, anime)
+
+	// Send the validators of the new version, for the next conditional request.
+	headers := make(http.Header)
+	setValidators(headers, versionETag(int64(anime.ID), anime.Version, nil), anime.UpdatedAt)
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": anime}, headers)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -183,76 +354,190 @@ func (app *application) deleteAnime(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.repos.Anime.DeleteAnime(id)
+	// Soft delete the anime, sending a 404 Not Found response to the client if there
+	// isn't a matching record. It can be brought back with the restore endpoint.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		before, err := repos.Anime.GetAnime(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		err = repos.Anime.DeleteAnime(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionDelete, data.AuditEntityAnime, int64(id), before, nil)
+	})
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
 	// Return a 200 OK status code along with a success message.
-	err = app.write(w, http.StatusOK, envelope{"message": "anime successfully deleted"}, nil)
+	err = app.write(w, r, http.StatusOK, envelope{"message": "anime successfully deleted"}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
 }
 
-func (app *application) partiallyUpdateAnime(w http.ResponseWriter, r *http.Request) {
+// Restore a soft deleted anime, responding with the restored record.
+func (app *application) restoreAnime(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readID(r)
 	if err != nil {
 		app.notFound(w, r)
 		return
 	}
 
-	anime, err := app.repos.Anime.GetAnime(id)
+	var anime *data.Anime
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.RestoreAnime(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		anime, err = repos.Anime.GetAnime(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionRestore, data.AuditEntityAnime, int64(id), nil, anime)
+	})
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.Itoa(int(anime.Version)) != r.Header.Get("X-Expected-Version") {
-			app.editConflict(w, r)
-			return
+	err = app.write(w, r, http.StatusOK, envelope{"anime": anime}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Permanently delete an anime, whether or not it was soft deleted before. This can't be
+// undone, so it's only available to admins.
+func (app *application) purgeAnime(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.PurgeAnime(r.Context(), id)
+		if err != nil {
+			return err
 		}
+
+		return app.audit(r, repos, data.AuditActionPurge, data.AuditEntityAnime, int64(id), nil, nil)
+	})
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
 	}
 
-	var request animeRequest
-	err = app.readBody(w, r, &request)
+	err = app.write(w, r, http.StatusOK, envelope{"message": "anime permanently deleted"}, nil)
 	if err != nil {
-		app.badRequest(w, r, err)
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) partiallyUpdateAnime(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// As in updateAnime, the version is read from the primary.
+	anime, err := app.repos.Anime.GetAnime(repository.ReadPrimary(r.Context()), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
 		return
 	}
 
-	request.toPatch(anime)
+	if !app.checkPreconditions(w, r, versionETag(int64(anime.ID), anime.Version, nil), int(anime.Version)) {
+		return
+	}
+
+	// Keep a copy of the record as it was, for the audit log.
+	before := *anime
 
 	v := validator.New()
+
+	// The body is either a JSON Patch document, or an object of the fields to change,
+	// the fields it leaves out staying as they are.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == jsonpatch.MediaType {
+		var patch jsonpatch.Patch
+		err = app.readBody(w, r, &patch)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		err = applyJSONPatch(anime, patch, v)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrInvalid):
+				app.badRequest(w, r, err)
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.error(w, r, http.StatusConflict, err.Error())
+			case errors.Is(err, jsonpatch.ErrPathNotFound), errors.Is(err, errPatchResult):
+				app.failedValidation(w, r, map[string]string{"patch": err.Error()})
+			default:
+				app.serverError(w, r, err)
+			}
+			return
+		}
+	} else {
+		var request animeRequest
+		err = app.readBody(w, r, &request)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		request.toPatch(anime)
+	}
+
 	if data.ValidateAnime(v, anime); !v.Valid() {
 		app.failedValidation(w, r, v.Errors)
 		return
 	}
 
-	err = app.repos.Anime.UpdateAnime(anime)
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.UpdateAnime(r.Context(), anime)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(anime.ID), &before, anime)
+	})
 	if err != nil {
 		app.dbWriteError(w, r, err)
 		return
 	}
 
-	err = app.write(w, http.StatusOK, envelope{"anime": anime}, nil)
+	app.notifyNewEpisodes(r.Context(), &before, anime)
+
+	// Send the validators of the new version, for the next conditional request.
+	headers := make(http.Header)
+	setValidators(headers, versionETag(int64(anime.ID), anime.Version, nil), anime.UpdatedAt)
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": anime}, headers)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
 }
 
 func (app *application) listTags(w http.ResponseWriter, r *http.Request) {
-	tags, err := app.repos.Anime.GetAllTags()
+	tags, err := app.repos.Anime.GetAllTags(r.Context())
 	if err != nil {
 		app.dbReadError(w, r, err)
 		return
 	}
 
-	err = app.write(w, http.StatusOK, envelope{"tags": tags}, nil)
+	err = app.write(w, r, http.StatusOK, envelope{"tags": tags}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
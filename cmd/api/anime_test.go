@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+// animeRouter routes the anime handlers of app, as the given user.
+func animeRouter(app *application, user *data.User) http.Handler {
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/v1/anime", app.createAnime)
+	router.HandlerFunc(http.MethodGet, "/v1/anime/:id", app.showAnime)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, app.contextSetUser(r, user))
+	})
+}
+
+func TestCreateAndShowAnime(t *testing.T) {
+	app := newTestApplication(t, Config{})
+	user := insertUser(t, app, "alice@example.com", true, data.PermissionAnimeWrite)
+	router := animeRouter(app, user)
+
+	body := `{"title": "Frieren", "type": "TV", "status": "Upcoming", "tags": ["Fantasy"]}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/anime", strings.NewReader(body)))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var created struct {
+		Anime data.Anime `json:"anime"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+
+	location := rr.Header().Get("Location")
+	if location != "/v1/anime/1" || created.Anime.ID != 1 {
+		t.Fatalf("got anime %d at %q, want anime 1 at /v1/anime/1", created.Anime.ID, location)
+	}
+
+	// The creation is audited, as the user who made it.
+	entries, _, err := app.repos.Audit.GetAll(context.Background(), &user.ID, data.AuditEntityAnime, nil, data.AuditActionCreate, data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].EntityID != 1 {
+		t.Errorf("got %d audit entries, want the creation of anime 1", len(entries))
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, location, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("show: got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var shown struct {
+		Anime data.Anime `json:"anime"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &shown); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	if shown.Anime.Title != "Frieren" || shown.Anime.Version != created.Anime.Version {
+		t.Errorf("got %q at version %d, want %q at version %d", shown.Anime.Title, shown.Anime.Version, "Frieren", created.Anime.Version)
+	}
+
+	// Polling with the ETag of the response gets a 304 Not Modified.
+	r := httptest.NewRequest(http.MethodGet, location, nil)
+	r.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("conditional show: got status %d, want %d", rr.Code, http.StatusNotModified)
+	}
+}
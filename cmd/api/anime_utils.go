@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/jsonpatch"
+	"github.com/ziliscite/purplelight/internal/repository"
 	"github.com/ziliscite/purplelight/internal/validator"
 	"net/url"
+	"slices"
 )
 
 type animeRequest struct {
@@ -15,6 +23,12 @@ type animeRequest struct {
 	Year     *int32          `json:"year,"`
 	Duration *data.Duration  `json:"duration,"`
 	Tags     []string        `json:"tags,omitempty"`
+
+	Studios   []string `json:"studios"`
+	Synopsis  *string  `json:"synopsis"`
+	AltTitles []string `json:"alt_titles"`
+	MalID     *int32   `json:"mal_id"`
+	AniListID *int32   `json:"anilist_id"`
 }
 
 func (a animeRequest) nilCheck(v *validator.Validator) bool {
@@ -48,6 +62,12 @@ func (a animeRequest) toPost(v *validator.Validator) *data.Anime {
 		Year:     a.Year,
 		Duration: a.Duration,
 		Tags:     a.Tags,
+
+		Studios:   a.Studios,
+		Synopsis:  deref(a.Synopsis),
+		AltTitles: a.AltTitles,
+		MalID:     a.MalID,
+		AniListID: a.AniListID,
 	}
 }
 
@@ -65,6 +85,11 @@ func (a animeRequest) toPut(anime *data.Anime, v *validator.Validator) {
 	anime.Year = a.Year
 	anime.Duration = a.Duration
 	anime.Tags = a.Tags
+	anime.Studios = a.Studios
+	anime.Synopsis = deref(a.Synopsis)
+	anime.AltTitles = a.AltTitles
+	anime.MalID = a.MalID
+	anime.AniListID = a.AniListID
 }
 
 func (a animeRequest) toPatch(anime *data.Anime) {
@@ -106,15 +131,126 @@ func (a animeRequest) toPatch(anime *data.Anime) {
 	if a.Tags != nil {
 		anime.Tags = a.Tags
 	}
+
+	if a.Studios != nil {
+		anime.Studios = a.Studios
+	}
+
+	if a.Synopsis != nil {
+		anime.Synopsis = *a.Synopsis
+	}
+
+	if a.AltTitles != nil {
+		anime.AltTitles = a.AltTitles
+	}
+
+	if a.MalID != nil {
+		anime.MalID = a.MalID
+	}
+
+	if a.AniListID != nil {
+		anime.AniListID = a.AniListID
+	}
+}
+
+// errPatchResult is wrapped by the errors of a JSON Patch which applies, but leaves
+// something other than an anime, such as a field of the wrong type or one which can't
+// be changed.
+var errPatchResult = errors.New("the patched anime is invalid")
+
+// applyJSONPatch applies a JSON Patch document to the anime. The patch works on the
+// fields of the anime which clients may change, with the lists always there, even
+// empty, for the patches to add to. The result is read like the body of a PUT, so the
+// fields a PUT requires are reported to v when the patch removes them.
+func applyJSONPatch(anime *data.Anime, patch jsonpatch.Patch, v *validator.Validator) error {
+	js, err := json.Marshal(map[string]any{
+		"title":      anime.Title,
+		"type":       anime.Type,
+		"episodes":   anime.Episodes,
+		"status":     anime.Status,
+		"season":     anime.Season,
+		"year":       anime.Year,
+		"duration":   anime.Duration,
+		"tags":       append([]string{}, anime.Tags...),
+		"studios":    append([]string{}, anime.Studios...),
+		"synopsis":   anime.Synopsis,
+		"alt_titles": append([]string{}, anime.AltTitles...),
+		"mal_id":     anime.MalID,
+		"anilist_id": anime.AniListID,
+	})
+	if err != nil {
+		return err
+	}
+
+	var doc any
+	if err := json.Unmarshal(js, &doc); err != nil {
+		return err
+	}
+
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		return err
+	}
+
+	js, err = json.Marshal(patched)
+	if err != nil {
+		return err
+	}
+
+	var request animeRequest
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&request); err != nil {
+		return fmt.Errorf("%w: %v", errPatchResult, err)
+	}
+
+	request.toPut(anime, v)
+	return nil
+}
+
+// deref returns the value p points to, or the zero value when p is nil.
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+
+	return *p
+}
+
+// externalDuplicate returns the name of the first external ID field (such as "mal_id")
+// of the anime that is already linked to another anime, or "" if there is none.
+func externalDuplicate(ctx context.Context, store repository.AnimeStore, anime *data.Anime) (string, error) {
+	for _, source := range data.ExternalSources {
+		id := anime.ExternalID(source)
+		if id == nil {
+			continue
+		}
+
+		other, err := store.GetAnimeByExternalID(ctx, source, *id)
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			continue
+		case err != nil:
+			return "", err
+		}
+
+		if other.ID != anime.ID {
+			return source + "_id", nil
+		}
+	}
+
+	return "", nil
 }
 
+// animeFields is the safelist of fields that clients can select with the fields query
+// string parameter.
+var animeFields = []string{"id", "title", "slug", "type", "episodes", "status", "season", "year", "duration", "tags", "studios", "synopsis", "alt_titles", "cover_url", "mal_id", "anilist_id", "rank", "version", "deleted"}
+
 type animeQuery struct {
-	Title     string
-	Status    string
-	Season    string
-	AnimeType string
-	Tags      []string
+	data.AnimeSearch
 	data.Filters
+	Fields []string
 }
 
 func (aq *animeQuery) readQuery(qs url.Values, app *application, v *validator.Validator) {
@@ -122,8 +258,24 @@ func (aq *animeQuery) readQuery(qs url.Values, app *application, v *validator.Va
 	// to defaults of an empty string and an empty slice respectively if they are not
 	// provided by the client.
 	aq.Title = app.readString(qs, "title", "")
+	aq.Synopsis = app.readString(qs, "synopsis", "")
+
+	// The q parameter searches every text field at once, for the clients which don't
+	// know, or care, which one the words are in.
+	aq.Query = app.readString(qs, "q", "")
 	aq.Tags = app.readCSV(qs, "tags", []string{})
 
+	// By default an anime must have all the tags; tags_match=any lists the anime that
+	// have at least one of them.
+	aq.TagsMatch = app.readString(qs, "tags_match", data.TagsMatchAll)
+
+	// Only list the anime made by the named studio.
+	aq.Studio = app.readString(qs, "studio", "")
+
+	// The match mode decides how the title is compared: word for word by default, or
+	// fuzzily, which also finds titles with typos or partial words.
+	aq.Match = app.readString(qs, "match", data.MatchWords)
+
 	// Extract the status, season, and type query string values, falling back to the
 	// zero value for each type if they are not provided by the client.
 	aq.Status = app.readIota(qs, "status", "", v, data.StatusToEnum)
@@ -132,6 +284,15 @@ func (aq *animeQuery) readQuery(qs url.Values, app *application, v *validator.Va
 
 	aq.AnimeType = app.readIota(qs, "anime_type", "", v, data.TypeToEnum)
 
+	// The year range is optional at both ends, so year_min=2000 alone lists everything
+	// released since 2000.
+	aq.YearMin = app.readOptionalInt32(qs, "year_min", v)
+	aq.YearMax = app.readOptionalInt32(qs, "year_max", v)
+
+	// Same for the episode count, e.g. episodes_max=13 for short series.
+	aq.EpisodesMin = app.readOptionalInt32(qs, "episodes_min", v)
+	aq.EpisodesMax = app.readOptionalInt32(qs, "episodes_max", v)
+
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
@@ -139,9 +300,36 @@ func (aq *animeQuery) readQuery(qs url.Values, app *application, v *validator.Va
 	aq.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	// Extract the sort query string value, falling back to "id" if it is not provided
-	// by the client (which will imply a ascending sort on movie ID).
-	aq.Filters.Sort = app.readString(qs, "sort", "id")
+	// by the client (which will imply a ascending sort on movie ID). A q search lists
+	// the best matches first, unless asked otherwise.
+	defaultSort := "id"
+	if aq.Query != "" {
+		defaultSort = data.SortRelevance
+	}
+	aq.Filters.Sort = app.readString(qs, "sort", defaultSort)
 
 	// Add the supported sort values for this endpoint to the sort safelist.
-	aq.Filters.SortSafeList = []string{"id", "title", "year", "episodes", "-id", "-title", "-year", "-episodes"}
+	// Relevance only goes one way: best match first.
+	aq.Filters.SortSafeList = []string{"id", "title", "year", "episodes", "-id", "-title", "-year", "-episodes", data.SortRelevance}
+
+	// The presence of the after parameter switches to keyset pagination. An empty value
+	// starts at the beginning of the listing; otherwise it must be a cursor taken from
+	// the metadata of a previous page.
+	aq.Filters.Cursor = app.readCursor(qs, "after", aq.Filters.Sort, v)
+
+	// List screens usually only need a few fields, such as fields=id,title,year.
+	aq.Fields = app.readFields(qs, animeFields, v)
+
+	// Offline clients sync the changes since their last sync, passing the synced_at of
+	// its metadata; 1970-01-01T00:00:00Z fetches everything the first time.
+	aq.UpdatedSince = app.readTime(qs, "updated_since", v)
+
+	// Whatever the fields, a delta sync has to tell the tombstones apart.
+	if aq.UpdatedSince != nil && len(aq.Fields) > 0 {
+		for _, field := range []string{"id", "deleted"} {
+			if !slices.Contains(aq.Fields, field) {
+				aq.Fields = append(aq.Fields, field)
+			}
+		}
+	}
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+		ExpiresIn   *int     `json:"expires_in_days"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Keys created with another key can't escalate beyond that key's permissions.
+	if app.contextGetAPIKey(r) != nil {
+		app.notPermitted(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	var ttl time.Duration
+	if input.ExpiresIn != nil {
+		v.Check(*input.ExpiresIn > 0, "expires_in_days", "must be a positive integer")
+		v.Check(*input.ExpiresIn <= 365, "expires_in_days", "must be a maximum of 365")
+		ttl = time.Duration(*input.ExpiresIn) * 24 * time.Hour
+	}
+
+	// A key can only be granted permissions that its owner has.
+	permissions, err := app.repos.Permission.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, code := range input.Permissions {
+		v.Check(data.IsPermission(code), "permissions", fmt.Sprintf("unknown permission %q", code))
+		v.Check(permissions.Include(code), "permissions", fmt.Sprintf("you don't have the %q permission", code))
+	}
+
+	key, err := data.GenerateAPIKey(user.ID, input.Name, input.Permissions, ttl)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.APIKey.Insert(r.Context(), key)
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	// The plaintext key is only ever included in this response.
+	err = app.write(w, r, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.repos.APIKey.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.repos.APIKey.Delete(r.Context(), int64(id), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
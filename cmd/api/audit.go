@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"net/url"
+)
+
+// The audit() helper records a change made by the user of the request. It takes the
+// repositories to write with, which should be the ones of the transaction making the
+// change, so that the entry and the change are committed together.
+func (app *application) audit(r *http.Request, repos repository.Repositories, action, entity string, entityID int64, before, after any) error {
+	var userID *int64
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		userID = &user.ID
+	}
+
+	return auditAs(r.Context(), repos, userID, action, entity, entityID, before, after)
+}
+
+// auditAs records a change made by the given user, or by the system itself when the
+// user ID is nil. It is for changes made outside of a request, such as from the command
+// line.
+//
+// Every audited change makes it to the change stream as well, with the record after
+// the change, or before it when there is no after.
+func auditAs(ctx context.Context, repos repository.Repositories, userID *int64, action, entity string, entityID int64, before, after any) error {
+	entry, err := data.NewAuditEntry(userID, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+
+	err = repos.Audit.Insert(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	record := entry.After
+	if record == nil {
+		record = entry.Before
+	}
+
+	return recordEvent(ctx, repos, action, entity, entityID, record)
+}
+
+type auditQuery struct {
+	UserID   *int64
+	Entity   string
+	EntityID *int64
+	Action   string
+	data.Filters
+}
+
+func (aq *auditQuery) readQuery(qs url.Values, app *application, v *validator.Validator) {
+	aq.UserID = app.readOptionalInt64(qs, "user_id", v)
+	aq.Entity = app.readString(qs, "entity", "")
+	aq.EntityID = app.readOptionalInt64(qs, "entity_id", v)
+	aq.Action = app.readString(qs, "action", "")
+
+	aq.Filters.Page = app.readInt(qs, "page", 1, v)
+	aq.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Most recent entries first, unless asked otherwise.
+	aq.Filters.Sort = app.readString(qs, "sort", "-id")
+	aq.Filters.SortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+}
+
+// List the audit log, newest entries first.
+func (app *application) listAuditLog(w http.ResponseWriter, r *http.Request) {
+	var input auditQuery
+
+	v := validator.New()
+
+	input.readQuery(r.URL.Query(), app, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.repos.Audit.GetAll(r.Context(), input.UserID, input.Entity, input.EntityID, input.Action, input.Filters)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"audit_log": entries, "metadata": metadata}, paginationLinks(r, metadata))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
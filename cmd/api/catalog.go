@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/catalog"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// newCatalogs returns the catalogs anime can be imported from, by source name. Each one
+// gets its own client, so that they are rate limited independently.
+func newCatalogs(cfg Config) map[string]catalog.Source {
+	catalogs := make(map[string]catalog.Source)
+	for _, source := range data.ExternalSources {
+		client := catalog.NewClient(cfg.catalog.rps, cfg.catalog.burst, cfg.catalog.retries)
+		catalogs[source], _ = catalog.NewSource(source, client)
+	}
+
+	return catalogs
+}
+
+// catalogFailure describes an anime fetched from a catalog that couldn't be saved.
+type catalogFailure struct {
+	ExternalID int32             `json:"external_id"`
+	Title      string            `json:"title"`
+	Reason     string            `json:"reason,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// catalogReport is the summary of an import of a whole season.
+type catalogReport struct {
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  []catalogFailure `json:"failed"`
+}
+
+// saveCatalogAnime creates an anime fetched from a catalog, or updates the anime that
+// is already linked to one of its external IDs, and returns the audit action taken.
+// The cover and the external IDs the catalog doesn't know about are kept on update.
+func (app *application) saveCatalogAnime(ctx context.Context, userID *int64, anime *data.Anime) (string, error) {
+	var action string
+	var before *data.Anime
+
+	err := app.tx.WithinTx(ctx, func(repos repository.Repositories) error {
+		var existing *data.Anime
+		for _, source := range data.ExternalSources {
+			id := anime.ExternalID(source)
+			if id == nil {
+				continue
+			}
+
+			found, err := repos.Anime.GetAnimeByExternalID(ctx, source, *id)
+			switch {
+			case errors.Is(err, repository.ErrRecordNotFound):
+				continue
+			case err != nil:
+				return err
+			}
+
+			existing = found
+			break
+		}
+
+		if existing == nil {
+			action = data.AuditActionCreate
+
+			err := repos.Anime.InsertAnime(ctx, anime)
+			if err != nil {
+				return err
+			}
+
+			return auditAs(ctx, repos, userID, action, data.AuditEntityAnime, int64(anime.ID), nil, anime)
+		}
+
+		action = data.AuditActionUpdate
+		before = existing
+
+		anime.ID = existing.ID
+		anime.Version = existing.Version
+		anime.CreatedAt = existing.CreatedAt
+		anime.CoverURL = existing.CoverURL
+		if anime.MalID == nil {
+			anime.MalID = existing.MalID
+		}
+		if anime.AniListID == nil {
+			anime.AniListID = existing.AniListID
+		}
+
+		err := repos.Anime.UpdateAnime(ctx, anime)
+		if err != nil {
+			return err
+		}
+
+		return auditAs(ctx, repos, userID, action, data.AuditEntityAnime, int64(anime.ID), existing, anime)
+	})
+	if err == nil && action == data.AuditActionUpdate {
+		app.notifyNewEpisodes(ctx, before, anime)
+	}
+
+	return action, err
+}
+
+// saveCatalogSeason fetches every anime of a season from the catalog and saves them
+// one by one, so that one bad anime doesn't fail the whole season.
+func (app *application) saveCatalogSeason(ctx context.Context, userID *int64, source catalog.Source, year int32, season data.Season) (*catalogReport, error) {
+	fetched, err := source.Season(ctx, year, season)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &catalogReport{Failed: make([]catalogFailure, 0)}
+
+	for _, anime := range fetched {
+		failure := catalogFailure{ExternalID: *anime.ExternalID(source.Name()), Title: anime.Title}
+
+		v := validator.New()
+		if data.ValidateAnime(v, anime); !v.Valid() {
+			failure.Errors = v.Errors
+			report.Failed = append(report.Failed, failure)
+			continue
+		}
+
+		action, err := app.saveCatalogAnime(ctx, userID, anime)
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEntry):
+			failure.Reason = "an anime with this title already exists"
+			report.Failed = append(report.Failed, failure)
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		if action == data.AuditActionCreate {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
+
+// readCatalog reads the source path parameter, and returns the catalog for it.
+func (app *application) readCatalog(r *http.Request) (catalog.Source, bool) {
+	source, ok := app.catalogs[r.PathValue("source")]
+	return source, ok
+}
+
+// Import a single anime from a catalog by its ID there, e.g. POST /v1/admin/import/mal/1.
+// The anime is created, or updated if it has been imported before.
+func (app *application) importCatalogAnime(w http.ResponseWriter, r *http.Request) {
+	source, ok := app.readCatalog(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	anime, err := source.Anime(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrNotFound):
+			app.notFound(w, r)
+		default:
+			app.catalogUnavailable(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateAnime(v, anime); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	var userID *int64
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		userID = &user.ID
+	}
+
+	action, err := app.saveCatalogAnime(r.Context(), userID, anime)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEntry):
+			v.AddError("title", "an anime with this title already exists")
+			app.insertConflict(w, r, v.Errors)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	headers := make(http.Header)
+	if action == data.AuditActionCreate {
+		status = http.StatusCreated
+		headers.Set("Location", versionPath(r, "/anime/%d", anime.ID))
+	}
+
+	err = app.write(w, r, status, envelope{"anime": anime}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Import every anime of a season from a catalog, e.g.
+// POST /v1/admin/import/anilist/seasons/2024/spring.
+func (app *application) importCatalogSeason(w http.ResponseWriter, r *http.Request) {
+	source, ok := app.readCatalog(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+
+	year, season, err := parseSeason(r.PathValue("year") + "/" + r.PathValue("season"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	var userID *int64
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		userID = &user.ID
+	}
+
+	report, err := app.saveCatalogSeason(r.Context(), userID, source, year, season)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrUnavailable):
+			app.catalogUnavailable(w, r, err)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// parseSeason parses a season written as year/season, such as 2024/spring.
+func parseSeason(s string) (int32, data.Season, error) {
+	yearPart, seasonPart, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid season %q, must be written as year/season", s)
+	}
+
+	year, err := strconv.ParseInt(yearPart, 10, 32)
+	if err != nil || year < 1917 {
+		return 0, "", fmt.Errorf("invalid season year %q", yearPart)
+	}
+
+	season, err := data.SeasonToEnum(seasonPart)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return int32(year), data.Season(season), nil
+}
+
+// runCatalogImport runs the import asked for on the command line, and logs its outcome.
+// Changes are audited as made by the system.
+func (app *application) runCatalogImport(ctx context.Context) error {
+	cfg := app.config.catalog
+
+	source, ok := app.catalogs[cfg.importSource]
+	if !ok {
+		return fmt.Errorf("invalid -import-source %q, must be one of %v", cfg.importSource, data.ExternalSources)
+	}
+
+	switch {
+	case cfg.importSeason != "":
+		year, season, err := parseSeason(cfg.importSeason)
+		if err != nil {
+			return err
+		}
+
+		report, err := app.saveCatalogSeason(ctx, nil, source, year, season)
+		if err != nil {
+			return err
+		}
+
+		for _, failure := range report.Failed {
+			app.logger.Warn("anime not imported", "external_id", failure.ExternalID, "title", failure.Title, "reason", failure.Reason, "errors", failure.Errors)
+		}
+		app.logger.Info("season imported", "created", report.Created, "updated", report.Updated, "failed", len(report.Failed))
+
+	case cfg.importID > 0:
+		anime, err := source.Anime(ctx, int32(cfg.importID))
+		if err != nil {
+			return err
+		}
+
+		v := validator.New()
+		if data.ValidateAnime(v, anime); !v.Valid() {
+			return fmt.Errorf("invalid anime %q: %v", anime.Title, v.Errors)
+		}
+
+		action, err := app.saveCatalogAnime(ctx, nil, anime)
+		if err != nil {
+			return err
+		}
+		app.logger.Info("anime imported", "id", anime.ID, "title", anime.Title, "action", action)
+
+	default:
+		return errors.New("-import-source requires either -import-id or -import-season")
+	}
+
+	return nil
+}
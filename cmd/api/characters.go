@@ -0,0 +1,272 @@
+package main
+
+import (
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+)
+
+type castRequest struct {
+	Role        *string `json:"role"`
+	VoiceActors []struct {
+		PersonID int32  `json:"person_id"`
+		Language string `json:"language"`
+	} `json:"voice_actors"`
+}
+
+// List the characters of an anime, with their voice actors.
+func (app *application) listAnimeCharacters(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// Make sure the anime exists (and isn't soft deleted) first, so that an unknown
+	// anime is a 404 rather than an empty cast.
+	_, err = app.repos.Anime.GetAnime(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	cast, err := app.repos.Character.GetCast(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"characters": cast}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Add a character to the cast of an anime, or update their role and voice actors.
+func (app *application) setAnimeCharacter(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	characterID, err := app.readIntParam(r, "character_id")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	var request castRequest
+	err = app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(request.Role != nil, "role", "must be provided"); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	member := &data.CastMember{
+		Character:   data.Character{ID: characterID},
+		Role:        *request.Role,
+		VoiceActors: make([]data.VoiceActor, len(request.VoiceActors)),
+	}
+	for i, actor := range request.VoiceActors {
+		member.VoiceActors[i] = data.VoiceActor{Person: data.Person{ID: actor.PersonID}, Language: actor.Language}
+	}
+
+	if data.ValidateCastMember(v, member); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Soft deleted anime are still in the table, so check that this one isn't.
+	_, err = app.repos.Anime.GetAnime(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Character.SetCastMember(r.Context(), id, member)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(id), nil, envelope{"character": member})
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	cast, err := app.repos.Character.GetCast(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"characters": cast}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Remove a character from the cast of an anime.
+func (app *application) deleteAnimeCharacter(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	characterID, err := app.readIntParam(r, "character_id")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Character.DeleteCastMember(r.Context(), id, characterID)
+		if err != nil {
+			return err
+		}
+
+		before := envelope{"character": data.CastMember{Character: data.Character{ID: characterID}}}
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(id), before, nil)
+	})
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "character successfully removed from the anime"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) createCharacter(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name  string `json:"name"`
+		About string `json:"about"`
+	}
+
+	err := app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	character := &data.Character{Name: request.Name, About: request.About}
+
+	v := validator.New()
+	if data.ValidateCharacter(v, character); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Character.InsertCharacter(r.Context(), character)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityCharacter, int64(character.ID), nil, character)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", versionPath(r, "/characters/%d", character.ID))
+
+	err = app.write(w, r, http.StatusCreated, envelope{"character": character}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) showCharacter(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	character, err := app.repos.Character.GetCharacter(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"character": character}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) createPerson(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	person := &data.Person{Name: request.Name}
+
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Character.InsertPerson(r.Context(), person)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityPerson, int64(person.ID), nil, person)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", versionPath(r, "/people/%d", person.ID))
+
+	err = app.write(w, r, http.StatusCreated, envelope{"person": person}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) showPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	person, err := app.repos.Character.GetPerson(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// setupLevel is how much of the application a command needs set up before it runs.
+type setupLevel int
+
+const (
+	// setupConfig sets up nothing but the config, the logger and the storage.
+	setupConfig setupLevel = iota
+	// setupDatabase connects to the database as well.
+	setupDatabase
+	// setupFull sets up everything the server uses, which needs the schema migrated.
+	setupFull
+)
+
+// command is a subcommand of the binary, such as migrate. The commands share the config
+// and the setup of the server, so that they work on the same database, with the same
+// repositories, as the server does.
+type command struct {
+	name string
+	// args describes the arguments of the command, and usage what it does, for -help.
+	args  string
+	usage string
+	setup setupLevel
+	run   func(app *application, args []string) error
+}
+
+// The names of the commands.
+const (
+	cmdServe           = "serve"
+	cmdMigrate         = "migrate"
+	cmdSeed            = "seed"
+	cmdCreateSuperuser = "createsuperuser"
+	cmdRoutes          = "routes"
+)
+
+// commands lists the commands, in the order -help shows them. Running the binary without
+// any command serves the API.
+var commands = []command{
+	{
+		name:  cmdServe,
+		usage: "Serve the API (default)",
+		setup: setupFull,
+		run:   (*application).runServe,
+	},
+	{
+		name:  cmdMigrate,
+		args:  "up|down [N]|status",
+		usage: "Apply the pending database migrations, revert the last N of them (1 by default), or list them",
+		setup: setupDatabase,
+		run:   (*application).runMigrate,
+	},
+	{
+		name:  cmdSeed,
+		usage: "Fill the database with sample anime, tags and users, for development",
+		setup: setupFull,
+		run:   (*application).runSeed,
+	},
+	{
+		name:  cmdCreateSuperuser,
+		args:  "<name> <email>",
+		usage: "Create an activated admin user, with the password read from the standard input",
+		setup: setupFull,
+		run:   (*application).runCreateSuperuser,
+	},
+	{
+		name:  cmdRoutes,
+		usage: "List the routes of the API",
+		setup: setupConfig,
+		run:   (*application).runRoutes,
+	},
+}
+
+// lookupCommand returns the command of a command line, such as [migrate up], along with
+// its arguments. An empty command line is the serve command.
+func lookupCommand(commandLine []string) (command, []string, bool) {
+	name, args := cmdServe, []string(nil)
+	if len(commandLine) > 0 {
+		name, args = commandLine[0], commandLine[1:]
+	}
+
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		return command{}, nil, false
+	}
+
+	return commands[i], args, true
+}
+
+// usage prints the usage of the binary, with its commands and flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+
+	tw := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.usage)
+	}
+	_ = tw.Flush()
+
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// runServe serves the API, or runs the catalog import asked for with -import-source
+// instead.
+func (app *application) runServe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	if app.config.catalog.importSource != "" {
+		return app.runCatalogImport(context.Background())
+	}
+
+	return app.serve()
+}
+
+// runCreateSuperuser creates an activated user with the admin role. The password is read
+// from the first line of the standard input, so that it doesn't end up in the shell
+// history: echo "$PASSWORD" | purplelight createsuperuser Admin admin@example.com.
+func (app *application) runCreateSuperuser(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: createsuperuser <name> <email>")
+	}
+
+	// Prompt for the password when it's typed in. It's echoed, as the standard library
+	// can't turn that off.
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	user := &data.User{
+		Name:      args[0],
+		Email:     args[1],
+		Activated: true,
+	}
+
+	err = user.Password.Set(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return fmt.Errorf("invalid user: %v", v.Errors)
+	}
+
+	ctx := context.Background()
+	err = app.tx.WithinTx(ctx, func(repos repository.Repositories) error {
+		err := repos.User.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = recordEvent(ctx, repos, data.AuditActionCreate, data.AuditEntityUser, user.ID, user)
+		if err != nil {
+			return err
+		}
+
+		permissions, err := repos.Permission.AssignRole(ctx, user.ID, data.RoleAdmin)
+		if err != nil {
+			return err
+		}
+
+		return auditAs(ctx, repos, nil, data.AuditActionAssignRole, data.AuditEntityUser, user.ID,
+			nil,
+			envelope{"role": data.RoleAdmin, "permissions": permissions},
+		)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateEntry) {
+			return errors.New("a user with this email address already exists")
+		}
+		return err
+	}
+
+	app.logger.Info("superuser created", "id", user.ID, "email", user.Email)
+	return nil
+}
+
+// routeList records the routes registered with it, as "GET /v1/anime/:id", for the
+// routes command.
+type routeList []string
+
+func (l *routeList) HandlerFunc(method, path string, _ http.HandlerFunc) {
+	*l = append(*l, method+" "+path)
+}
+
+func (l *routeList) Handle(pattern string, _ http.Handler) {
+	*l = append(*l, pattern)
+}
+
+func (l *routeList) HandleFunc(pattern string, _ func(http.ResponseWriter, *http.Request)) {
+	*l = append(*l, pattern)
+}
+
+// runRoutes prints the routes of the API, ordered by path.
+func (app *application) runRoutes(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	var routes routeList
+	app.registerRoutes(&routes, &routes)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH")
+
+	// Sort by path, then by method.
+	slices.SortFunc(routes, func(a, b string) int {
+		methodA, pathA, _ := strings.Cut(a, " ")
+		methodB, pathB, _ := strings.Cut(b, " ")
+		return cmp.Or(strings.Compare(pathA, pathB), strings.Compare(methodA, methodB))
+	})
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		fmt.Fprintf(tw, "%s\t%s\n", method, path)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionETag returns the strong entity tag of a versioned resource, which changes
+// whenever the resource does. Representations leaving out some fields, through the
+// fields parameter, get a tag of their own.
+func versionETag(id int64, version int32, fields []string) string {
+	if len(fields) == 0 {
+		return fmt.Sprintf(`"%d-%d"`, id, version)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(fields, ",")))
+
+	return fmt.Sprintf(`"%d-%d-%x"`, id, version, h.Sum32())
+}
+
+// etagMatches reports whether a list of entity tags, as sent in If-None-Match, holds
+// etag or is "*". The comparison is the weak one, so W/"1-2" matches "1-2".
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified reports whether the client already holds the current representation of
+// a resource, going by the conditional headers of a GET request. If-None-Match takes
+// precedence over If-Modified-Since, as the latter only has a precision of a second.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// setValidators sets the ETag and Last-Modified headers of a response.
+func setValidators(headers http.Header, etag string, lastModified time.Time) {
+	headers.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// etagMatchesStrong reports whether a list of entity tags, as sent in If-Match, holds
+// etag or is "*". The comparison is the strong one, so weak tags never match.
+func etagMatchesStrong(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (candidate == etag && !strings.HasPrefix(etag, "W/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPreconditions checks the precondition of a request updating a versioned
+// resource, before it is changed, and sends the error response if it doesn't hold. It
+// returns whether the update can go ahead.
+//
+// The precondition is If-Match, holding the ETag the client got the resource with. The
+// X-Expected-Version header, holding the version number, is still honoured while
+// app.config.expectedVersionHeader is set, but responses to it are marked deprecated.
+func (app *application) checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, version int) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatchesStrong(im, etag) {
+			app.preconditionFailed(w, r)
+			return false
+		}
+		return true
+	}
+
+	expected := r.Header.Get("X-Expected-Version")
+	if expected == "" {
+		return true
+	}
+
+	if !app.config.expectedVersionHeader {
+		app.badRequest(w, r, errors.New("the X-Expected-Version header is no longer supported, use If-Match with the ETag of the resource instead"))
+		return false
+	}
+
+	w.Header().Set("Deprecation", "true")
+	if strconv.Itoa(version) != expected {
+		app.editConflict(w, r)
+		return false
+	}
+
+	return true
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/reporting"
 	"net/http"
 )
 
@@ -14,10 +15,18 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The apiKeyContextKey is used to store the api key a request was authenticated with, if
+// any. Its absence means the request used a token (or is anonymous).
+const apiKeyContextKey = contextKey("api_key")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if !user.IsAnonymous() {
+		reporting.SetUser(r.Context(), user.ID)
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -34,3 +43,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetAPIKey() method returns a new copy of the request with the api key
+// added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() retrieves the api key from the request context, returning nil
+// when the request wasn't authenticated with an api key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"slices"
+)
+
+const (
+	// coverMaxBytes is the largest cover image accepted.
+	coverMaxBytes = 5 << 20
+
+	// Bounds on the width and height of cover images, in pixels.
+	coverMinSize = 100
+	coverMaxSize = 4096
+)
+
+// coverTypes maps the accepted image content types to their file extension.
+var coverTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Upload the cover image of an anime, as the "cover" file of a multipart form. The
+// image is checked, stored, and its URL is saved on the anime.
+func (app *application) uploadAnimeCover(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// Leave some room for the rest of the multipart body on top of the image itself.
+	r.Body = http.MaxBytesReader(w, r.Body, coverMaxBytes+64<<10)
+
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequest(w, r, &bodyTooLargeError{what: "cover", limit: coverMaxBytes})
+		case errors.Is(err, http.ErrMissingFile):
+			app.badRequest(w, r, errors.New(`multipart form must contain a "cover" file`))
+		default:
+			app.badRequest(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, coverMaxBytes+1))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	// Trust the content itself rather than the content type claimed by the client.
+	contentType := http.DetectContentType(content)
+
+	v := validator.New()
+	v.Check(len(content) <= coverMaxBytes, "cover", fmt.Sprintf("must not be larger than %d bytes", coverMaxBytes))
+	v.Check(coverTypes[contentType] != "", "cover", "must be a jpeg, png or gif image")
+	if v.Valid() {
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if v.Check(err == nil, "cover", "must be a valid image"); err == nil {
+			sizes := []int{config.Width, config.Height}
+			v.Check(slices.Min(sizes) >= coverMinSize, "cover", fmt.Sprintf("must be at least %dx%d pixels", coverMinSize, coverMinSize))
+			v.Check(slices.Max(sizes) <= coverMaxSize, "cover", fmt.Sprintf("must be at most %dx%d pixels", coverMaxSize, coverMaxSize))
+		}
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnime(repository.ReadPrimary(r.Context()), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	before := *anime
+
+	key := fmt.Sprintf("anime/%d.%s", anime.ID, coverTypes[contentType])
+	url, err := app.storage.Put(r.Context(), key, bytes.NewReader(content), contentType)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The key is the same for every upload, so the version is added to the URL to get
+	// clients (and caches) to fetch the new image.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		anime.CoverURL = fmt.Sprintf("%s?v=%d", url, anime.Version+1)
+
+		version, err := repos.Anime.SetCover(r.Context(), anime.ID, anime.CoverURL)
+		if err != nil {
+			return err
+		}
+		anime.Version = version
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(anime.ID), &before, anime)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"anime": anime}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
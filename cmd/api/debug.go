@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// pprofPrefix is where the profiles are served on the API, behind the users:admin
+// permission.
+const pprofPrefix = "/v1/admin/debug/pprof/"
+
+// pprofHandler serves the runtime profiles under /debug/pprof/, as net/http/pprof does
+// on the default ServeMux. We don't use the default ServeMux, so that importing pprof
+// doesn't expose anything by itself.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// The index serves the named profiles as well, such as heap and goroutine.
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// serveDebug starts the debug server, serving the profiles without authentication, if
+// a debug address is set. As the API server has a write timeout, the CPU profiles and
+// execution traces it serves can't be longer than a few seconds, whereas this one has
+// no such limit. It should only listen on a private interface, such as localhost:6060.
+//
+// The returned function shuts the debug server down.
+func (app *application) serveDebug() func(ctx context.Context) error {
+	if app.config.debugAddr == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	srv := &http.Server{
+		Addr:              app.config.debugAddr,
+		Handler:           pprofHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	go func() {
+		app.logger.Info("starting debug server", "addr", srv.Addr)
+
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("debug server failed", "addr", srv.Addr, "error", err.Error())
+		}
+	}()
+
+	return srv.Shutdown
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	swaggerFiles "github.com/swaggo/files/v2"
+	"net/http"
+)
+
+// docsPrefix is where the API explorer is served.
+const docsPrefix = "/v1/docs/"
+
+// swaggerInitializer replaces the script of the Swagger UI bundle pointing it at the
+// petstore example, to load our OpenAPI document instead. The token entered through the
+// Authorize button is kept across reloads.
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/v1/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    persistAuthorization: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// docsHandler serves the API explorer: the Swagger UI, embedded in the binary, showing
+// the OpenAPI document of the API. Requests made from it go through the API like any
+// other, with the token or API key entered in it.
+func docsHandler() http.Handler {
+	files := http.StripPrefix(docsPrefix, http.FileServerFS(swaggerFiles.FS))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == docsPrefix+"swagger-initializer.js" {
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write([]byte(swaggerInitializer))
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
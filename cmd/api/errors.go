@@ -1,28 +1,83 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/ziliscite/purplelight/internal/reporting"
 	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // The logError() method is a generic helper for logging an error message along
 // with the current request method and URL as attributes in the log entry.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+}
+
+// The reportError() method sends an unexpected error to the error tracker, along with
+// the request it happened in, if any, and the user making it.
+func (app *application) reportError(ctx context.Context, r *http.Request, err error) {
+	app.reporter.Report(ctx, reporting.Event{
+		Err:       err,
+		Request:   r,
+		RequestID: telemetry.RequestID(ctx),
+		UserID:    reporting.UserID(ctx),
+	})
+}
+
+// The logAuthenticationFailure() method logs a rejected authentication token together
+// with the requesting client and, if the token is known (for example because it has
+// expired), the client it was originally issued to.
+func (app *application) logAuthenticationFailure(r *http.Request, tokenPlaintext string) {
+	args := []any{
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"client_ip", app.clientIP(r),
+		"user_agent", r.UserAgent(),
+	}
+
+	token, err := app.repos.Token.GetIssuance(r.Context(), tokenPlaintext)
+	if err == nil {
+		args = append(args,
+			"token_user_id", token.UserID,
+			"token_scope", token.Scope,
+			"token_expiry", token.Expiry,
+			"issued_at", token.CreatedAt,
+			"issued_ip", token.ClientIP,
+			"issued_user_agent", token.UserAgent,
+		)
+	}
+
+	app.logger.WarnContext(r.Context(), "authentication failed", args...)
 }
 
 // The error() method is a generic helper for sending JSON-formatted error
 // messages to the client with a given status code. Note that we're using the any
 // type for the message parameter, rather than just a string type, as this gives us
 // more flexibility over the values that we can include in the response.
+//
+// The ID of the request is included, so that users can quote it when reporting a
+// problem, and we can find the matching log lines.
 func (app *application) error(w http.ResponseWriter, r *http.Request, status int, message any) {
+	if versionOf(r) >= apiV2 {
+		app.problem(w, r, status, message)
+		return
+	}
+
+	env := envelope{"error": message}
+	if id := telemetry.RequestID(r.Context()); id != "" {
+		env["request_id"] = id
+	}
 
 	// Write the response using the write() helper. If this happens to return an
 	// error, then log it and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
-	err := app.write(w, status, envelope{"error": message}, nil)
+	err := app.write(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -33,13 +88,31 @@ func (app *application) error(w http.ResponseWriter, r *http.Request, status int
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // error() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
+//
+// Errors caused by a deadline passing, be it the one of the request or of a single
+// query, are reported with requestTimedOut() instead.
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		app.requestTimedOut(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
+	app.reportError(r.Context(), r, err)
 
 	message := "the server encountered a problem and could not process your request"
 	app.error(w, r, http.StatusInternalServerError, message)
 }
 
+// The requestTimedOut() method sends a 503 Service Unavailable response when the
+// request couldn't be handled in time.
+func (app *application) requestTimedOut(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.WarnContext(r.Context(), "request timed out", "method", r.Method, "uri", r.URL.RequestURI(), "error", err.Error())
+
+	message := "the request took too long to process, please try again later"
+	app.error(w, r, http.StatusServiceUnavailable, message)
+}
+
 // The notFound() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
@@ -54,8 +127,22 @@ func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request)
 	app.error(w, r, http.StatusMethodNotAllowed, message)
 }
 
-// The badRequest() method will be used to send a 400 Bad Request status code
+// The badRequest() method will be used to send a 400 Bad Request status code, or a
+// 413 Content Too Large one when the body was over its size limit. A field of the wrong
+// type is reported as an object, like the validation errors.
 func (app *application) badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *bodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		app.error(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	var fieldError *bodyFieldError
+	if errors.As(err, &fieldError) {
+		app.error(w, r, http.StatusBadRequest, map[string]string{fieldError.field: fieldError.message})
+		return
+	}
+
 	app.error(w, r, http.StatusBadRequest, err.Error())
 }
 
@@ -74,6 +161,13 @@ func (app *application) editConflict(w http.ResponseWriter, r *http.Request) {
 	app.error(w, r, http.StatusConflict, message)
 }
 
+// The preconditionFailed() method sends a 412 Precondition Failed response, when the
+// resource has changed since the client fetched the version its If-Match is for.
+func (app *application) preconditionFailed(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you fetched it, please fetch it again"
+	app.error(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded, please wait"
 	app.error(w, r, http.StatusTooManyRequests, message)
@@ -84,6 +178,43 @@ func (app *application) invalidCredentials(w http.ResponseWriter, r *http.Reques
 	app.error(w, r, http.StatusUnauthorized, message)
 }
 
+// The accountLocked() method sends a 423 Locked response with a Retry-After header
+// telling the client how many seconds to wait before trying to log in again.
+func (app *application) accountLocked(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("account temporarily locked due to too many failed login attempts, try again in %d seconds", seconds)
+	app.error(w, r, http.StatusLocked, message)
+}
+
+// The emailThrottled() method sends a 429 Too Many Requests response with a Retry-After
+// header, when an email was sent to the user too recently to send another one.
+func (app *application) emailThrottled(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("an email was sent to you recently, please try again in %d seconds", seconds)
+	app.error(w, r, http.StatusTooManyRequests, message)
+}
+
+// The underMaintenance() method sends a 503 Service Unavailable response while the
+// maintenance mode is on.
+func (app *application) underMaintenance(w http.ResponseWriter, r *http.Request) {
+	message := "the server is down for maintenance, please try again later"
+	app.error(w, r, http.StatusServiceUnavailable, message)
+}
+
+// The serverBusy() method sends a 503 Service Unavailable response with a Retry-After
+// header, when the server is handling as many requests as it can.
+func (app *application) serverBusy(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := "the server is busy, please try again later"
+	app.error(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) invalidAuthenticationToken(w http.ResponseWriter, r *http.Request) {
 	// Indicating that a Bearer token is expected in the Authorization header.
 	w.Header().Set("WWW-Authenticate", "Bearer")
@@ -92,6 +223,11 @@ func (app *application) invalidAuthenticationToken(w http.ResponseWriter, r *htt
 	app.error(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidAPIKey(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired api key"
+	app.error(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequired(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.error(w, r, http.StatusUnauthorized, message)
@@ -102,6 +238,27 @@ func (app *application) inactiveAccount(w http.ResponseWriter, r *http.Request)
 	app.error(w, r, http.StatusForbidden, message)
 }
 
+// The unsupportedMediaType() method sends a 415 response listing the content types the
+// endpoint accepts.
+func (app *application) unsupportedMediaType(w http.ResponseWriter, r *http.Request, accepted ...string) {
+	message := fmt.Sprintf("unsupported content type, must be one of %v", accepted)
+	app.error(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The catalogUnavailable() method sends a 502 response when an external catalog, such
+// as Jikan, can't be reached.
+func (app *application) catalogUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the anime catalog could not be reached, please try again later"
+	app.error(w, r, http.StatusBadGateway, message)
+}
+
+func (app *application) ipNotAllowed(w http.ResponseWriter, r *http.Request) {
+	message := "your IP address is not allowed to access this resource"
+	app.error(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermitted(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.error(w, r, http.StatusForbidden, message)
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"github.com/ziliscite/purplelight/internal/broker"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"strconv"
+	"time"
+)
+
+// totalEventsPublished counts the events published to the message broker.
+var totalEventsPublished = expvar.NewInt("total_events_published")
+
+// recordEvent records a change for the change stream. Like audit(), it takes the
+// repositories of the transaction making the change, so that the event is committed
+// along with it. record is the record after the change, or before it when it was
+// deleted.
+func recordEvent(ctx context.Context, repos repository.Repositories, action, entity string, entityID int64, record any) error {
+	event, err := data.NewEvent(action, entity, entityID, record)
+	if err != nil {
+		return err
+	}
+
+	return repos.Event.Insert(ctx, event)
+}
+
+// The relayEvents() job publishes the events recorded to the message broker, every
+// interval. It runs until the done channel is closed, and is tracked by the application
+// WaitGroup so that shutdown waits for the batch being published. Without a broker, the
+// events are left in the table, until their retention is over.
+func (app *application) relayEvents(done <-chan struct{}) {
+	if app.broker == nil {
+		return
+	}
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.events.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			app.publishPendingEvents()
+		}
+	}()
+}
+
+// publishPendingEvents publishes the events yet to be published, batch after batch
+// until none is left, in the order they were recorded. Each batch is read and marked as
+// published within a transaction holding the lock of the relay, so that the instances
+// of the API don't publish the same events, or publish them out of order.
+//
+// When a batch fails, the events stay pending and the relay stops until the next tick,
+// rather than skipping them: the stream must hold every change, in order. The failure
+// is counted on the first event of the batch. The only events skipped are those the
+// broker will never take, such as one over its max payload, which would otherwise
+// hold back the stream forever: they are given up on, and kept with the reason until
+// they are purged.
+func (app *application) publishPendingEvents() {
+	for {
+		ctx, span := telemetry.Start(context.Background(), "job.relayEvents")
+
+		var pending int
+		var dead int64
+		var publishErr error
+		err := app.tx.WithinTx(ctx, func(repos repository.Repositories) error {
+			locked, err := repos.Event.TryLock(ctx)
+			if err != nil || !locked {
+				return err
+			}
+
+			events, err := repos.Event.GetPending(ctx, app.config.events.batch)
+			if err != nil {
+				return err
+			}
+			pending = len(events)
+			if pending == 0 {
+				return nil
+			}
+
+			msgs, ids, err := app.eventMessages(events)
+			if err != nil {
+				return err
+			}
+
+			publishErr = app.broker.Publish(ctx, msgs)
+
+			var msgErr *broker.MessageError
+			if errors.As(publishErr, &msgErr) {
+				dead, err = strconv.ParseInt(msgErr.ID, 10, 64)
+				if err != nil {
+					return err
+				}
+				return repos.Event.MarkDead(ctx, dead, publishErr.Error())
+			}
+			if publishErr != nil {
+				return repos.Event.MarkFailed(ctx, events[0].ID, publishErr.Error())
+			}
+
+			return repos.Event.MarkPublished(ctx, ids)
+		})
+		telemetry.End(span, err)
+
+		switch {
+		case err != nil:
+			// The events published, if any, are published again with the next tick.
+			app.logger.Error("failed to relay events", "error", err.Error())
+			return
+		case dead != 0:
+			// The rest of the batch goes with the next round.
+			app.logger.Error("event can't be published, giving up on it", "event_id", dead, "error", publishErr.Error())
+			continue
+		case publishErr != nil:
+			app.logger.Error("failed to publish events, will retry", "count", pending, "error", publishErr.Error())
+			return
+		}
+
+		totalEventsPublished.Add(int64(pending))
+
+		if pending < app.config.events.batch {
+			return
+		}
+	}
+}
+
+// eventMessages returns the messages to publish for the events, with their IDs. An
+// event is published as JSON under the subject <prefix>.<entity>.<action>, such as
+// purplelight.anime.update, so that the consumers can subscribe to just the entities,
+// or the actions, they care about.
+func (app *application) eventMessages(events []*data.Event) ([]broker.Message, []int64, error) {
+	msgs := make([]broker.Message, len(events))
+	ids := make([]int64, len(events))
+
+	for i, event := range events {
+		js, err := json.Marshal(event)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msgs[i] = broker.Message{
+			ID:      strconv.FormatInt(event.ID, 10),
+			Subject: app.config.events.subjectPrefix + "." + event.Type(),
+			Data:    js,
+		}
+		ids[i] = event.ID
+	}
+
+	return msgs, ids, nil
+}
+
+// The purgeEvents() job deletes the events past their retention. Without a broker, the
+// events are never published, so those are deleted as well.
+func (app *application) purgeEvents(ctx context.Context) error {
+	n, err := app.repos.Event.Purge(ctx, time.Now().Add(-app.config.events.retention), app.broker == nil)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Info("purged events", "count", n)
+	}
+
+	return nil
+}
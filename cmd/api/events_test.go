@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/ziliscite/purplelight/internal/broker"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+// testBroker records the messages it publishes, and refuses those of the rejected IDs
+// as too large.
+type testBroker struct {
+	published []string
+	rejected  []string
+}
+
+func (b *testBroker) Publish(_ context.Context, msgs []broker.Message) error {
+	for _, msg := range msgs {
+		if slices.Contains(b.rejected, msg.ID) {
+			return &broker.MessageError{ID: msg.ID, Err: broker.ErrMaxPayload}
+		}
+	}
+
+	for _, msg := range msgs {
+		b.published = append(b.published, msg.ID)
+	}
+	return nil
+}
+
+func (b *testBroker) Ping(context.Context) error { return nil }
+
+func (b *testBroker) Close() error { return nil }
+
+func TestPublishPendingEventsSkipsDeadEvents(t *testing.T) {
+	var cfg Config
+	cfg.events.batch = 2
+	cfg.events.subjectPrefix = "purplelight"
+
+	app := newTestApplication(t, cfg)
+	b := &testBroker{rejected: []string{"2"}}
+	app.broker = b
+
+	for i := range 4 {
+		if err := recordEvent(context.Background(), app.repos, data.AuditActionCreate, data.AuditEntityAnime, int64(i+1), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The rest of the events are published in order, past the one the broker refuses.
+	app.publishPendingEvents()
+
+	if want := []string{"1", "3", "4"}; !slices.Equal(b.published, want) {
+		t.Fatalf("published %v, want %v", b.published, want)
+	}
+
+	// The event given up on isn't pending anymore.
+	pending, err := app.repos.Event.GetPending(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d events still pending", len(pending))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportFlushEvery is the number of rows written between flushes of the response.
+const exportFlushEvery = 100
+
+// Stream the whole catalog as NDJSON (the default) or CSV. The output uses the same
+// formats as the import endpoint, so an export can be imported into another instance
+// as is.
+func (app *application) exportAnime(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", "ndjson")
+	if v.Check(validator.PermittedValue(format, "ndjson", "csv"), "format", "must be one of [ndjson csv]"); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	var write func(anime *data.Anime) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", contentTypeCSV)
+		w.Header().Set("Content-Disposition", `attachment; filename="anime.csv"`)
+
+		cw := csv.NewWriter(w)
+		err := cw.Write(csvColumns)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		write = func(anime *data.Anime) error {
+			return cw.Write(animeCSVRecord(anime))
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+	default:
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.Header().Set("Content-Disposition", `attachment; filename="anime.ndjson"`)
+
+		// Encode() terminates every value with a newline, which is exactly NDJSON.
+		enc := json.NewEncoder(w)
+		write = func(anime *data.Anime) error {
+			return enc.Encode(anime)
+		}
+		flush = rc.Flush
+	}
+
+	rows := 0
+	err := app.repos.Anime.Export(r.Context(), func(anime *data.Anime) error {
+		if err := write(anime); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// The status line has most likely been sent by now, so all that's left to do on
+	// failure is to log the error; the client sees a truncated body.
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// animeCSVRecord formats an anime as a row matching csvColumns.
+func animeCSVRecord(anime *data.Anime) []string {
+	optional := func(n *int32) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(int(*n))
+	}
+
+	var season, duration string
+	if anime.Season != nil {
+		season = string(*anime.Season)
+	}
+	if anime.Duration != nil {
+		duration = strconv.Itoa(int(*anime.Duration))
+	}
+
+	return []string{
+		strconv.Itoa(int(anime.ID)),
+		anime.Title,
+		string(anime.Type),
+		optional(anime.Episodes),
+		string(anime.Status),
+		season,
+		optional(anime.Year),
+		duration,
+		strings.Join(anime.Tags, "|"),
+		strings.Join(anime.Studios, "|"),
+		anime.Synopsis,
+		strings.Join(anime.AltTitles, "|"),
+		optional(anime.MalID),
+		optional(anime.AniListID),
+		strconv.Itoa(int(anime.Version)),
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/url"
+	"slices"
+)
+
+// readFields reads the comma-separated fields query string value, which restricts the
+// fields serialized for a resource. Every field must be in the safelist. A nil result
+// means that all fields are wanted.
+func (app *application) readFields(qs url.Values, safelist []string, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", nil)
+
+	for _, field := range fields {
+		if !validator.PermittedValue(field, safelist...) {
+			v.AddError("fields", "invalid field: "+field)
+			break
+		}
+	}
+
+	return fields
+}
+
+// sparse wraps a value of an envelope so that only the given fields of it are written.
+// The value may be a single object or a slice of them. Handlers opt in by wrapping the
+// resource they write:
+//
+//	app.write(w, r, http.StatusOK, envelope{"anime": sparse(anime, fields)}, nil)
+func sparse(value any, fields []string) any {
+	if len(fields) == 0 {
+		return value
+	}
+
+	return fieldset{value: value, fields: fields}
+}
+
+// fieldset implements json.Marshaler by encoding its value as usual, then keeping only
+// the wanted fields of each object, in the order they were asked for.
+type fieldset struct {
+	value  any
+	fields []string
+}
+
+func (f fieldset) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(f.value)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(js)
+	if len(trimmed) == 0 || (trimmed[0] != '[' && trimmed[0] != '{') {
+		return js, nil
+	}
+
+	if trimmed[0] == '{' {
+		return f.filter(trimmed)
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, err
+	}
+
+	for i, item := range items {
+		if items[i], err = f.filter(item); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(items)
+}
+
+// filter keeps the wanted fields of a single JSON object. Fields that are omitted from
+// the object (omitempty) stay omitted.
+func (f fieldset) filter(object json.RawMessage) ([]byte, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(object, &all); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	written := make([]string, 0, len(f.fields))
+	for _, field := range f.fields {
+		value, ok := all[field]
+		if !ok || slices.Contains(written, field) {
+			continue
+		}
+
+		if len(written) > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+
+		written = append(written, field)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
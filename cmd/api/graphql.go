@@ -0,0 +1,908 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/graphql"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The GraphQL endpoint serves the anime, the tags and the users in one schema, which
+// lets clients fetch, say, a watchlist along with its anime in a single request. It
+// is built on the same repositories, validation and audit log as the REST routes, and
+// every field checks the permission its route requires.
+
+// graphqlRequest holds what the resolvers of a GraphQL request share: the HTTP request,
+// for its user, and the loaders batching the reads of the request.
+type graphqlRequest struct {
+	r *http.Request
+
+	anime       *graphql.Loader[int32, *data.Anime]
+	permissions *graphql.Loader[int64, data.Permissions]
+}
+
+const graphqlContextKey = contextKey("graphql")
+
+func (app *application) newGraphQLRequest(r *http.Request) *graphqlRequest {
+	return &graphqlRequest{
+		r: r,
+		anime: graphql.NewLoader(func(ctx context.Context, ids []int32) (map[int32]*data.Anime, error) {
+			anime, err := app.repos.Anime.GetAnimeByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+
+			byID := make(map[int32]*data.Anime, len(anime))
+			for _, a := range anime {
+				byID[a.ID] = a
+			}
+			return byID, nil
+		}),
+		permissions: graphql.NewLoader(app.repos.Permission.GetAllForUsers),
+	}
+}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	gr, ok := ctx.Value(graphqlContextKey).(*graphqlRequest)
+	if !ok {
+		panic("missing graphql request in context")
+	}
+
+	return gr
+}
+
+// The graphql() handler runs a GraphQL request. As per the GraphQL over HTTP spec, the
+// errors of the request itself, such as a syntax error or a missing permission, are
+// sent in the body of a 200 OK; only a body which isn't a GraphQL request at all is a
+// 400 Bad Request.
+func (app *application) graphql(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request graphql.Request
+		err := app.readBodyWith(w, r, &request, bodyOptions{allowUnknownFields: true})
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		if strings.TrimSpace(request.Query) == "" {
+			app.badRequest(w, r, errors.New("body must contain a query"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), graphqlContextKey, app.newGraphQLRequest(r))
+		response := schema.Execute(ctx, request)
+
+		// The response has the shape the GraphQL clients expect, so it's written as it
+		// is rather than through write(), whose envelope could be unwrapped.
+		js, err := json.Marshal(response)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaTypeJSON)
+		w.Write(append(js, '\n'))
+	}
+}
+
+// The showGraphQLSchema() handler sends the schema in the GraphQL schema definition
+// language, for the clients to generate their types from.
+func (app *application) showGraphQLSchema(schema *graphql.Schema) http.HandlerFunc {
+	sdl := schema.SDL()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(sdl))
+	}
+}
+
+// graphqlAuthorize checks that the user of a GraphQL request has the permission code,
+// as requirePermission() does for the routes. The read permissions opened up to the
+// anonymous clients are checked in the same way, as the GraphQL queries only read.
+func (app *application) graphqlAuthorize(ctx context.Context, code string) error {
+	if app.config.anonymous.read && slices.Contains(publicReadPermissions, code) {
+		return nil
+	}
+
+	gr := graphqlRequestFrom(ctx)
+
+	// The fields of the admins serve what the admin routes do, so they are only open to
+	// the admin allowlist as well.
+	if code == data.PermissionUsersAdmin {
+		ip, err := clientIP(gr.r)
+		if err != nil {
+			return app.graphqlError(ctx, err)
+		}
+
+		if !app.ipRules.adminAllows(ip) {
+			return graphql.Errorf("FORBIDDEN", "your IP address is not allowed to access this resource")
+		}
+	}
+
+	user, err := app.graphqlActivatedUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := gr.permissions.Load(ctx, user.ID)()
+	if err != nil {
+		return app.graphqlError(ctx, err)
+	}
+
+	if !permissions.(data.Permissions).Include(code) {
+		return graphqlNotPermitted()
+	}
+
+	// Requests made with an api key are further restricted to the permissions that were
+	// granted to that key.
+	if key := app.contextGetAPIKey(gr.r); key != nil && !key.Permissions.Include(code) {
+		return graphqlNotPermitted()
+	}
+
+	return nil
+}
+
+// graphqlAuthenticatedUser returns the user of a GraphQL request, as long as it isn't
+// anonymous.
+func (app *application) graphqlAuthenticatedUser(ctx context.Context) (*data.User, error) {
+	user := app.contextGetUser(graphqlRequestFrom(ctx).r)
+	if user.IsAnonymous() {
+		return nil, graphql.Errorf("UNAUTHENTICATED", "you must be authenticated to access this resource")
+	}
+
+	return user, nil
+}
+
+// graphqlActivatedUser returns the user of a GraphQL request, as long as it's activated.
+func (app *application) graphqlActivatedUser(ctx context.Context) (*data.User, error) {
+	user, err := app.graphqlAuthenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Activated {
+		return nil, graphql.Errorf("FORBIDDEN", "your user account must be activated to access this resource")
+	}
+
+	return user, nil
+}
+
+func graphqlNotPermitted() error {
+	return graphql.Errorf("FORBIDDEN", "your user account doesn't have the necessary permissions to access this resource")
+}
+
+// graphqlInvalid reports the errors of a validator, keyed by field as in the responses
+// of the routes.
+func graphqlInvalid(errors map[string]string) error {
+	err := graphql.Errorf("BAD_USER_INPUT", "the input is invalid")
+	err.Extensions["errors"] = errors
+	return err
+}
+
+// graphqlError turns an error of the repositories into the error of a field, with the
+// same message as the routes send for it. The unexpected ones are logged and reported,
+// and sent as a generic message.
+func (app *application) graphqlError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrRecordNotFound):
+		return graphql.Errorf("NOT_FOUND", "the requested resource could not be found")
+	case errors.Is(err, repository.ErrDuplicateEntry):
+		return graphql.Errorf("CONFLICT", "record already exists")
+	case errors.Is(err, repository.ErrDeadlockDetected) || errors.Is(err, repository.ErrEditConflict):
+		return graphql.Errorf("CONFLICT", "unable to proceed due to a edit conflict, please try again")
+	case errors.Is(err, repository.ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+		return graphql.Errorf("TIMEOUT", "the request took too long to process, please try again later")
+	}
+
+	r := graphqlRequestFrom(ctx).r
+	app.logError(r, err)
+	app.reportError(ctx, r, err)
+
+	return graphql.Errorf("INTERNAL_SERVER_ERROR", "the server encountered a problem and could not process your request")
+}
+
+// graphqlQuery turns the arguments of a listing into the query string of its route, so
+// that the listing is read with the same defaults and checks as the route's.
+func graphqlQuery(args graphql.Args) url.Values {
+	qs := url.Values{}
+	for name, v := range args {
+		switch v := v.(type) {
+		case string:
+			qs.Set(name, v)
+		case int:
+			qs.Set(name, strconv.Itoa(v))
+		case bool:
+			qs.Set(name, strconv.FormatBool(v))
+		case []any:
+			qs.Set(name, strings.Join(args.Strings(name), ","))
+		}
+	}
+
+	return qs
+}
+
+// pageComplexity is the complexity of a paginated listing, whose selection is counted
+// once for every item of a page.
+func pageComplexity(args graphql.Args, child int) int {
+	pageSize := args.Int("page_size")
+	if !args.Has("page_size") {
+		pageSize = 20
+	}
+
+	return 1 + max(pageSize, 1)*child
+}
+
+// The listings of the schema, with their metadata.
+type (
+	graphqlAnimeList struct {
+		Anime    []*data.Anime `json:"anime"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+	graphqlUserList struct {
+		Users    []*data.User  `json:"users"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+	graphqlWatchlist struct {
+		Entries  []*data.WatchlistEntry `json:"entries"`
+		Metadata data.Metadata          `json:"metadata"`
+	}
+)
+
+// timestamp resolves a time field of the source as an RFC 3339 string.
+func timestamp[T any](get func(source T) time.Time) graphql.ResolveFunc {
+	return func(p graphql.ResolveParams) (any, error) {
+		return get(p.Source.(T)).Format(time.RFC3339), nil
+	}
+}
+
+// graphqlSchema builds the schema of the GraphQL endpoint.
+func (app *application) graphqlSchema() *graphql.Schema {
+	animeType := &graphql.Enum{Name: "AnimeType", Values: []string{string(data.TV), string(data.Movie), string(data.OVA), string(data.ONA), string(data.Special)}}
+	status := &graphql.Enum{Name: "Status", Values: []string{string(data.Ongoing), string(data.Finished), string(data.Upcoming)}}
+	season := &graphql.Enum{Name: "Season", Values: []string{string(data.Spring), string(data.Summer), string(data.Fall), string(data.Winter)}}
+	watchStatus := &graphql.Enum{Name: "WatchStatus", Values: data.WatchStatuses}
+
+	strings := graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(graphql.String)))
+
+	metadata := &graphql.Object{Name: "Metadata", Fields: graphql.Fields{
+		"current_page":  {Type: graphql.Int},
+		"page_size":     {Type: graphql.Int},
+		"first_page":    {Type: graphql.Int},
+		"last_page":     {Type: graphql.Int},
+		"total_records": {Type: graphql.Int},
+	}}
+
+	anime := &graphql.Object{Name: "Anime", Fields: graphql.Fields{
+		"id":         {Type: graphql.NonNullOf(graphql.Int)},
+		"title":      {Type: graphql.NonNullOf(graphql.String)},
+		"slug":       {Type: graphql.String},
+		"type":       {Type: animeType},
+		"episodes":   {Type: graphql.Int},
+		"status":     {Type: status},
+		"season":     {Type: season},
+		"year":       {Type: graphql.Int},
+		"duration":   {Type: graphql.Int, Description: "The duration of an episode, in minutes."},
+		"tags":       {Type: strings},
+		"studios":    {Type: strings},
+		"synopsis":   {Type: graphql.String},
+		"alt_titles": {Type: strings},
+		"cover_url":  {Type: graphql.String},
+		"mal_id":     {Type: graphql.Int},
+		"anilist_id": {Type: graphql.Int},
+		"version":    {Type: graphql.NonNullOf(graphql.Int)},
+	}}
+	// The lists of an anime are never null, even when they're empty.
+	for _, name := range []string{"tags", "studios", "alt_titles"} {
+		anime.Fields[name].Resolve = func(p graphql.ResolveParams) (any, error) {
+			a := p.Source.(*data.Anime)
+			switch name {
+			case "tags":
+				return nonNil(a.Tags), nil
+			case "studios":
+				return nonNil(a.Studios), nil
+			default:
+				return nonNil(a.AltTitles), nil
+			}
+		}
+	}
+
+	tag := &graphql.Object{Name: "Tag", Fields: graphql.Fields{
+		"id":   {Type: graphql.NonNullOf(graphql.Int)},
+		"name": {Type: graphql.NonNullOf(graphql.String)},
+	}}
+
+	watchlistEntry := &graphql.Object{Name: "WatchlistEntry", Fields: graphql.Fields{
+		"anime_id":         {Type: graphql.NonNullOf(graphql.Int)},
+		"title":            {Type: graphql.NonNullOf(graphql.String)},
+		"episodes":         {Type: graphql.Int},
+		"status":           {Type: graphql.NonNullOf(watchStatus)},
+		"episodes_watched": {Type: graphql.NonNullOf(graphql.Int)},
+		"created_at":       {Type: graphql.NonNullOf(graphql.String), Resolve: timestamp(func(e *data.WatchlistEntry) time.Time { return e.CreatedAt })},
+		"updated_at":       {Type: graphql.NonNullOf(graphql.String), Resolve: timestamp(func(e *data.WatchlistEntry) time.Time { return e.UpdatedAt })},
+		"version":          {Type: graphql.NonNullOf(graphql.Int)},
+		"anime": {Type: anime, Description: "The anime itself, null if it has been deleted since.",
+			Resolve: app.resolveWatchlistAnime},
+	}}
+
+	watchlist := &graphql.Object{Name: "Watchlist", Fields: graphql.Fields{
+		"entries":  {Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(watchlistEntry)))},
+		"metadata": {Type: graphql.NonNullOf(metadata)},
+	}}
+
+	user := &graphql.Object{Name: "User", Fields: graphql.Fields{
+		"id":          {Type: graphql.NonNullOf(graphql.Int)},
+		"name":        {Type: graphql.NonNullOf(graphql.String)},
+		"email":       {Type: graphql.NonNullOf(graphql.String)},
+		"activated":   {Type: graphql.NonNullOf(graphql.Boolean)},
+		"created_at":  {Type: graphql.NonNullOf(graphql.String), Resolve: timestamp(func(u *data.User) time.Time { return u.CreatedAt })},
+		"permissions": {Type: strings, Resolve: app.resolveUserPermissions},
+		"watchlist": {Type: graphql.NonNullOf(watchlist), Description: "The watchlist of the user, only readable by the user themselves.",
+			Args: map[string]*graphql.Argument{
+				"status":    {Type: watchStatus},
+				"page":      {Type: graphql.Int, Default: 1},
+				"page_size": {Type: graphql.Int, Default: 20},
+				"sort":      {Type: graphql.String, Default: "-updated_at"},
+			},
+			Resolve: app.resolveWatchlist, Complexity: pageComplexity},
+	}}
+
+	animeList := &graphql.Object{Name: "AnimeList", Fields: graphql.Fields{
+		"anime":    {Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(anime)))},
+		"metadata": {Type: graphql.NonNullOf(metadata)},
+	}}
+
+	userList := &graphql.Object{Name: "UserList", Fields: graphql.Fields{
+		"users":    {Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(user)))},
+		"metadata": {Type: graphql.NonNullOf(metadata)},
+	}}
+
+	animeInput := &graphql.InputObject{Name: "AnimeInput", Description: "The fields of an anime. Those left out are kept as they are by update_anime.", Fields: map[string]*graphql.Argument{
+		"title":      {Type: graphql.String},
+		"type":       {Type: animeType},
+		"episodes":   {Type: graphql.Int},
+		"status":     {Type: status},
+		"season":     {Type: season},
+		"year":       {Type: graphql.Int},
+		"duration":   {Type: graphql.Int},
+		"tags":       {Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+		"studios":    {Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+		"synopsis":   {Type: graphql.String},
+		"alt_titles": {Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+		"mal_id":     {Type: graphql.Int},
+		"anilist_id": {Type: graphql.Int},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: graphql.Fields{
+		"anime": {Type: anime, Args: map[string]*graphql.Argument{"id": {Type: graphql.NonNullOf(graphql.Int)}},
+			Resolve: app.resolveAnime},
+		"anime_by_slug": {Type: anime, Args: map[string]*graphql.Argument{"slug": {Type: graphql.NonNullOf(graphql.String)}},
+			Resolve: app.resolveAnimeBySlug},
+		"anime_list": {Type: graphql.NonNullOf(animeList), Description: "List and search the anime, as GET /v1/anime does.",
+			Args: map[string]*graphql.Argument{
+				"q":            {Type: graphql.String, Description: "Search the titles, the alternative titles, the tags and the synopses at once."},
+				"title":        {Type: graphql.String},
+				"synopsis":     {Type: graphql.String},
+				"match":        {Type: graphql.String, Default: data.MatchWords},
+				"tags":         {Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+				"tags_match":   {Type: graphql.String, Default: data.TagsMatchAll},
+				"studio":       {Type: graphql.String},
+				"status":       {Type: status},
+				"season":       {Type: season},
+				"anime_type":   {Type: animeType},
+				"year_min":     {Type: graphql.Int},
+				"year_max":     {Type: graphql.Int},
+				"episodes_min": {Type: graphql.Int},
+				"episodes_max": {Type: graphql.Int},
+				"page":         {Type: graphql.Int, Default: 1},
+				"page_size":    {Type: graphql.Int, Default: 20},
+				"sort":         {Type: graphql.String, Description: "id by default, or relevance with q."},
+			},
+			Resolve: app.resolveAnimeList, Complexity: pageComplexity},
+		"tags": {Type: strings, Resolve: app.resolveTags},
+		"tag": {Type: tag, Args: map[string]*graphql.Argument{"name": {Type: graphql.NonNullOf(graphql.String)}},
+			Resolve: app.resolveTag},
+		"me": {Type: graphql.NonNullOf(user), Description: "The user the request is authenticated as.",
+			Resolve: app.resolveMe},
+		"users": {Type: graphql.NonNullOf(userList), Description: "List the users, as GET /v1/admin/users does.",
+			Args: map[string]*graphql.Argument{
+				"email":     {Type: graphql.String},
+				"activated": {Type: graphql.Boolean},
+				"page":      {Type: graphql.Int, Default: 1},
+				"page_size": {Type: graphql.Int, Default: 20},
+				"sort":      {Type: graphql.String, Default: "id"},
+			},
+			Resolve: app.resolveUsers, Complexity: pageComplexity},
+	}}
+
+	mutation := &graphql.Object{Name: "Mutation", Fields: graphql.Fields{
+		"create_anime": {Type: graphql.NonNullOf(anime), Args: map[string]*graphql.Argument{"input": {Type: graphql.NonNullOf(animeInput)}},
+			Resolve: app.resolveCreateAnime},
+		"update_anime": {Type: graphql.NonNullOf(anime), Description: "Update the fields of an anime given in the input. A version fails the update if the anime has changed since, as If-Match does.",
+			Args: map[string]*graphql.Argument{
+				"id":      {Type: graphql.NonNullOf(graphql.Int)},
+				"input":   {Type: graphql.NonNullOf(animeInput)},
+				"version": {Type: graphql.Int},
+			},
+			Resolve: app.resolveUpdateAnime},
+		"delete_anime": {Type: graphql.NonNullOf(graphql.Boolean), Args: map[string]*graphql.Argument{"id": {Type: graphql.NonNullOf(graphql.Int)}},
+			Resolve: app.resolveDeleteAnime},
+		"create_tag": {Type: graphql.NonNullOf(tag), Args: map[string]*graphql.Argument{"name": {Type: graphql.NonNullOf(graphql.String)}},
+			Resolve: app.resolveCreateTag},
+		"delete_tag": {Type: graphql.NonNullOf(graphql.Boolean), Description: "Delete a tag. Tags still used by some anime are only deleted with force, which removes them from those anime, unless one has no other tag.",
+			Args: map[string]*graphql.Argument{
+				"name":  {Type: graphql.NonNullOf(graphql.String)},
+				"force": {Type: graphql.Boolean, Default: false},
+			},
+			Resolve: app.resolveDeleteTag},
+		"update_me": {Type: graphql.NonNullOf(user), Args: map[string]*graphql.Argument{"name": {Type: graphql.String}},
+			Resolve: app.resolveUpdateMe},
+	}}
+
+	return &graphql.Schema{
+		Query:         query,
+		Mutation:      mutation,
+		MaxDepth:      app.config.graphql.maxDepth,
+		MaxComplexity: app.config.graphql.maxComplexity,
+	}
+}
+
+// nonNil returns s, or an empty slice if it's nil.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	return s
+}
+
+func (app *application) resolveAnime(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	anime, err := app.repos.Anime.GetAnime(p.Context, int32(p.Args.Int("id")))
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	app.activity.recordView(anime.ID)
+	return anime, nil
+}
+
+func (app *application) resolveAnimeBySlug(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	anime, err := app.repos.Anime.GetAnimeBySlug(p.Context, p.Args.String("slug"))
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	app.activity.recordView(anime.ID)
+	return anime, nil
+}
+
+func (app *application) resolveAnimeList(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	var input animeQuery
+
+	v := validator.New()
+	input.readQuery(graphqlQuery(p.Args), app, v)
+
+	data.ValidateAnimeSearch(v, input.AnimeSearch, input.Filters)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	anime, metadata, err := app.repos.Anime.GetAll(p.Context, input.AnimeSearch, input.Filters)
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return &graphqlAnimeList{Anime: anime, Metadata: metadata}, nil
+}
+
+func (app *application) resolveTags(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	tags, err := app.repos.Anime.GetAllTags(p.Context)
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return nonNil(tags), nil
+}
+
+func (app *application) resolveTag(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	tag, err := app.repos.Anime.GetTag(p.Context, p.Args.String("name"))
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return tag, nil
+}
+
+func (app *application) resolveMe(p graphql.ResolveParams) (any, error) {
+	return app.graphqlAuthenticatedUser(p.Context)
+}
+
+func (app *application) resolveUsers(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionUsersAdmin); err != nil {
+		return nil, err
+	}
+
+	var input userQuery
+
+	v := validator.New()
+	input.readQuery(graphqlQuery(p.Args), app, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	users, metadata, err := app.repos.User.GetAll(p.Context, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return &graphqlUserList{Users: users, Metadata: metadata}, nil
+}
+
+// The permissions of the users are loaded together, which makes listing the users
+// with their permissions two queries rather than one for every user.
+func (app *application) resolveUserPermissions(p graphql.ResolveParams) (any, error) {
+	permissions := graphqlRequestFrom(p.Context).permissions.Load(p.Context, p.Source.(*data.User).ID)
+
+	return graphql.Thunk(func() (any, error) {
+		codes, err := permissions()
+		if err != nil {
+			return nil, app.graphqlError(p.Context, err)
+		}
+		return nonNil(codes.(data.Permissions)), nil
+	}), nil
+}
+
+// Only the users themselves may read their watchlist, which takes an activated account,
+// as the watchlist routes do.
+func (app *application) resolveWatchlist(p graphql.ResolveParams) (any, error) {
+	user, err := app.graphqlActivatedUser(p.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Source.(*data.User).ID != user.ID {
+		return nil, graphqlNotPermitted()
+	}
+
+	var input watchlistQuery
+
+	v := validator.New()
+	input.readQuery(graphqlQuery(p.Args), app, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	entries, metadata, err := app.repos.Watchlist.GetAll(p.Context, user.ID, input.Status, input.Filters)
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return &graphqlWatchlist{Entries: nonNil(entries), Metadata: metadata}, nil
+}
+
+// The anime of a watchlist are loaded together, in one query for the whole page.
+func (app *application) resolveWatchlistAnime(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeRead); err != nil {
+		return nil, err
+	}
+
+	anime := graphqlRequestFrom(p.Context).anime.Load(p.Context, p.Source.(*data.WatchlistEntry).AnimeID)
+
+	return graphql.Thunk(func() (any, error) {
+		a, err := anime()
+		if err != nil {
+			return nil, app.graphqlError(p.Context, err)
+		}
+		return a, nil
+	}), nil
+}
+
+// animeRequestFrom reads an AnimeInput into the request body of the anime routes, the
+// fields left out being nil.
+func animeRequestFrom(input graphql.Args) animeRequest {
+	var request animeRequest
+
+	request.Title = graphqlString(input, "title")
+	request.Synopsis = graphqlString(input, "synopsis")
+	request.Episodes = graphqlInt32(input, "episodes")
+	request.Year = graphqlInt32(input, "year")
+	request.MalID = graphqlInt32(input, "mal_id")
+	request.AniListID = graphqlInt32(input, "anilist_id")
+	request.Tags = input.Strings("tags")
+	request.Studios = input.Strings("studios")
+	request.AltTitles = input.Strings("alt_titles")
+
+	if s := graphqlString(input, "type"); s != nil {
+		t := data.AnimeType(*s)
+		request.Type = &t
+	}
+	if s := graphqlString(input, "status"); s != nil {
+		status := data.Status(*s)
+		request.Status = &status
+	}
+	if s := graphqlString(input, "season"); s != nil {
+		season := data.Season(*s)
+		request.Season = &season
+	}
+	if n := graphqlInt32(input, "duration"); n != nil {
+		d := data.Duration(*n)
+		request.Duration = &d
+	}
+
+	return request
+}
+
+func graphqlString(args graphql.Args, name string) *string {
+	s, ok := args[name].(string)
+	if !ok {
+		return nil
+	}
+
+	return &s
+}
+
+func graphqlInt32(args graphql.Args, name string) *int32 {
+	n, ok := args[name].(int)
+	if !ok {
+		return nil
+	}
+
+	i := int32(n)
+	return &i
+}
+
+func (app *application) resolveCreateAnime(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeWrite); err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	anime := animeRequestFrom(p.Args.Input("input")).toPost(v)
+	if anime == nil {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	if data.ValidateAnime(v, anime); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	r := graphqlRequestFrom(p.Context).r
+	err := app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		err := repos.Anime.InsertAnime(p.Context, anime)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityAnime, int64(anime.ID), nil, anime)
+	})
+	if err != nil {
+		if !errors.Is(err, repository.ErrDuplicateEntry) {
+			return nil, app.graphqlError(p.Context, err)
+		}
+
+		// Both the title and the external IDs are unique, so find out which one is
+		// taken.
+		field, err := externalDuplicate(p.Context, app.repos.Anime, anime)
+		if err != nil {
+			return nil, app.graphqlError(p.Context, err)
+		}
+		if field == "" {
+			field = "title"
+		}
+
+		conflict := graphql.Errorf("CONFLICT", "record already exists")
+		conflict.Extensions["errors"] = map[string]string{field: fmt.Sprintf("an anime with this %s already exists", field)}
+		return nil, conflict
+	}
+
+	return anime, nil
+}
+
+func (app *application) resolveUpdateAnime(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeWrite); err != nil {
+		return nil, err
+	}
+
+	// As in updateAnime, the version is read from the primary.
+	anime, err := app.repos.Anime.GetAnime(repository.ReadPrimary(p.Context), int32(p.Args.Int("id")))
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	if p.Args.Has("version") && p.Args["version"] != nil && p.Args.Int("version") != int(anime.Version) {
+		return nil, graphql.Errorf("PRECONDITION_FAILED", "the resource has been modified since you fetched it, please fetch it again")
+	}
+
+	// Keep a copy of the record as it was, for the audit log.
+	before := *anime
+
+	animeRequestFrom(p.Args.Input("input")).toPatch(anime)
+
+	v := validator.New()
+	if data.ValidateAnime(v, anime); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	r := graphqlRequestFrom(p.Context).r
+	err = app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		err := repos.Anime.UpdateAnime(p.Context, anime)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityAnime, int64(anime.ID), &before, anime)
+	})
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	app.notifyNewEpisodes(p.Context, &before, anime)
+
+	return anime, nil
+}
+
+func (app *application) resolveDeleteAnime(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionAnimeWrite); err != nil {
+		return nil, err
+	}
+
+	id := int32(p.Args.Int("id"))
+	r := graphqlRequestFrom(p.Context).r
+
+	err := app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		before, err := repos.Anime.GetAnime(p.Context, id)
+		if err != nil {
+			return err
+		}
+
+		err = repos.Anime.DeleteAnime(p.Context, id)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionDelete, data.AuditEntityAnime, int64(id), before, nil)
+	})
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return true, nil
+}
+
+func (app *application) resolveCreateTag(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionTagsWrite); err != nil {
+		return nil, err
+	}
+
+	tag := &data.Tag{Name: p.Args.String("name")}
+
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	r := graphqlRequestFrom(p.Context).r
+	err := app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		err := repos.Anime.InsertTag(p.Context, tag)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityTag, int64(tag.ID), nil, tag)
+	})
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return tag, nil
+}
+
+func (app *application) resolveDeleteTag(p graphql.ResolveParams) (any, error) {
+	if err := app.graphqlAuthorize(p.Context, data.PermissionTagsWrite); err != nil {
+		return nil, err
+	}
+
+	tag, err := app.repos.Anime.GetTag(p.Context, p.Args.String("name"))
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	r := graphqlRequestFrom(p.Context).r
+	err = app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		err := repos.Anime.DeleteTag(p.Context, tag.ID, p.Args.Bool("force"))
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionDelete, data.AuditEntityTag, int64(tag.ID), tag, nil)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrRecordInUse) {
+			return nil, graphql.Errorf("CONFLICT", "tag is still used by some anime, use force: true to delete it anyway")
+		}
+		if errors.Is(err, repository.ErrOnlyTag) {
+			return nil, graphql.Errorf("CONFLICT", "tag is the only tag of some anime, which must keep at least one")
+		}
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return true, nil
+}
+
+func (app *application) resolveUpdateMe(p graphql.ResolveParams) (any, error) {
+	current, err := app.graphqlActivatedUser(p.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.repos.User.Get(p.Context, current.ID)
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	if name := graphqlString(p.Args, "name"); name != nil {
+		user.Name = *name
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, graphqlInvalid(v.Errors)
+	}
+
+	// Update() only succeeds if the version hasn't changed since we read the record, so
+	// concurrent edits surface as an edit conflict.
+	err = app.tx.WithinTx(p.Context, func(repos repository.Repositories) error {
+		err := repos.User.Update(p.Context, user)
+		if err != nil {
+			return err
+		}
+
+		return recordEvent(p.Context, repos, data.AuditActionUpdate, data.AuditEntityUser, user.ID, user)
+	})
+	if err != nil {
+		return nil, app.graphqlError(p.Context, err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/graphql"
+)
+
+func TestGraphQLAuthorize(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool
+		activated bool
+		// permissions are those of the user, and key those of its api key, if any.
+		permissions []string
+		key         data.Permissions
+		readOpen    bool
+		// adminAllowed is the admin allowlist; the requests come from 192.0.2.1.
+		adminAllowed []string
+		code         string
+		// want is the code of the expected error, or empty when the request is allowed.
+		want string
+	}{
+		{name: "anonymous", anonymous: true, code: data.PermissionAnimeRead, want: "UNAUTHENTICATED"},
+		{name: "anonymous read", anonymous: true, readOpen: true, code: data.PermissionAnimeRead},
+		{name: "anonymous write", anonymous: true, readOpen: true, code: data.PermissionAnimeWrite, want: "UNAUTHENTICATED"},
+		{name: "unactivated", permissions: []string{data.PermissionAnimeRead}, code: data.PermissionAnimeRead, want: "FORBIDDEN"},
+		{name: "without the permission", activated: true, permissions: []string{data.PermissionAnimeRead}, code: data.PermissionAnimeWrite, want: "FORBIDDEN"},
+		{name: "with the permission", activated: true, permissions: []string{data.PermissionAnimeRead}, code: data.PermissionAnimeRead},
+		{
+			name:        "api key without the permission",
+			activated:   true,
+			permissions: []string{data.PermissionAnimeRead, data.PermissionAnimeWrite},
+			key:         data.Permissions{data.PermissionAnimeRead},
+			code:        data.PermissionAnimeWrite,
+			want:        "FORBIDDEN",
+		},
+		{
+			name:         "admin outside the allowlist",
+			activated:    true,
+			permissions:  []string{data.PermissionUsersAdmin},
+			adminAllowed: []string{"203.0.113.0/24"},
+			code:         data.PermissionUsersAdmin,
+			want:         "FORBIDDEN",
+		},
+		{
+			name:         "admin inside the allowlist",
+			activated:    true,
+			permissions:  []string{data.PermissionUsersAdmin},
+			adminAllowed: []string{"192.0.2.0/24"},
+			code:         data.PermissionUsersAdmin,
+		},
+		{
+			name:         "allowlist of the admins only",
+			activated:    true,
+			permissions:  []string{data.PermissionAnimeRead},
+			adminAllowed: []string{"203.0.113.0/24"},
+			code:         data.PermissionAnimeRead,
+		},
+		{
+			name:        "api key with the permission",
+			activated:   true,
+			permissions: []string{data.PermissionAnimeRead, data.PermissionAnimeWrite},
+			key:         data.Permissions{data.PermissionAnimeRead},
+			code:        data.PermissionAnimeRead,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			cfg.anonymous.read = tt.readOpen
+			app := newTestApplication(t, cfg)
+
+			prefixes, err := parseCIDRs(tt.adminAllowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.ipRules.set(ipAdminAllowlist, prefixes)
+
+			r := httptest.NewRequest("POST", "/v1/graphql", nil)
+			if tt.anonymous {
+				r = app.contextSetUser(r, data.AnonymousUser)
+			} else {
+				r = app.contextSetUser(r, insertUser(t, app, "alice@example.com", tt.activated, tt.permissions...))
+			}
+			if tt.key != nil {
+				r = app.contextSetAPIKey(r, &data.APIKey{Permissions: tt.key})
+			}
+
+			ctx := context.WithValue(r.Context(), graphqlContextKey, app.newGraphQLRequest(r))
+
+			err = app.graphqlAuthorize(ctx, tt.code)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var gerr *graphql.Error
+			if !errors.As(err, &gerr) {
+				t.Fatalf("got error %v, want a GraphQL error", err)
+			}
+			if code := gerr.Extensions["code"]; code != tt.want {
+				t.Errorf("got code %v, want %s", code, tt.want)
+			}
+		})
+	}
+}
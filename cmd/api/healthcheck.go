@@ -1,16 +1,96 @@
 package main
 
 import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"github.com/ziliscite/purplelight/internal/vcs"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// probeTimeout is how long the dependencies have to answer a deep healthcheck.
+const probeTimeout = 3 * time.Second
+
+// A probe checks that a dependency of the API is up. The API can't serve requests
+// without a critical dependency, and only runs degraded without the other ones.
+type probe struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// probeResult is the outcome of a probe, as reported by the deep healthcheck.
+type probeResult struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runProbes runs every probe at once, and reports whether the critical ones succeeded.
+func (app *application) runProbes(ctx context.Context) (map[string]probeResult, bool, bool) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		results  = make(map[string]probeResult, len(app.probes))
+		healthy  = true
+		degraded = false
+	)
+
+	for _, p := range app.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := p.check(ctx)
+			result := probeResult{Status: "up", Critical: p.critical, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[p.name] = result
+			if err != nil {
+				if p.critical {
+					healthy = false
+				} else {
+					degraded = true
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return results, healthy, degraded
+}
+
+// Report the status of the API. With deep=true, the dependencies are probed too, and
+// the response is a 503 when a critical one is down.
 func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	deep := app.readBool(r.URL.Query(), "deep", v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
 	response := struct {
-		Environment string `json:"environment"`
-		Version     string `json:"version"`
+		Environment string        `json:"environment"`
+		Version     string        `json:"version"`
+		Build       vcs.BuildInfo `json:"build"`
 	}{
 		Environment: app.config.Env(),
 		Version:     version,
+		Build:       vcs.Info(),
 	}
 
 	env := envelope{
@@ -18,7 +98,21 @@ func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) {
 		"system_info": response,
 	}
 
-	err := app.write(w, http.StatusOK, env, nil)
+	status := http.StatusOK
+	if deep != nil && *deep {
+		results, healthy, degraded := app.runProbes(r.Context())
+		env["dependencies"] = results
+
+		switch {
+		case !healthy:
+			env["status"] = "unavailable"
+			status = http.StatusServiceUnavailable
+		case degraded:
+			env["status"] = "degraded"
+		}
+	}
+
+	err := app.write(w, r, status, env, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/reporting"
+	"github.com/ziliscite/purplelight/internal/telemetry"
 	"github.com/ziliscite/purplelight/internal/validator"
 	"io"
+	"maps"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -17,36 +27,72 @@ import (
 // Retrieve the "id" URL parameter, convert it to an integer, and return it.
 // If the operation isn't successful, return 0 and an error.
 func (app *application) readID(r *http.Request) (int32, error) {
+	return app.readIntParam(r, "id")
+}
+
+// Retrieve a positive integer URL parameter by name, such as "id" or "version".
+func (app *application) readIntParam(r *http.Request, name string) (int32, error) {
 	// When httprouter is parsing a request, any interpolated URL parameters will be
 	// stored in the request context. We can use the ParamsFromContext() function to
 	// retrieve a slice containing these parameter names and values.
 	params := httprouter.ParamsFromContext(r.Context())
 
-	// We can then use the ByName() method to get the value of the "id" parameter from
-	// the slice. In our project all movies will have a unique positive integer ID, but
-	// the value returned by ByName() is always a string. So we try to convert it to a base 10 integer (with a bit size of 64).
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 32)
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	// We can then use the ByName() method to get the value of the parameter from the
+	// slice. In our project all IDs and versions are positive integers, but the value
+	// returned by ByName() is always a string. So we try to convert it to a base 10
+	// integer (with a bit size of 32).
+	value := params.ByName(name)
+
+	// Routes served by the ServeMux in front of the router keep their parameters on the
+	// request itself instead.
+	if value == "" {
+		value = r.PathValue(name)
+	}
+
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return int32(id), nil
+	return int32(n), nil
+}
+
+// Retrieve the "name" URL parameter. Tags and studios are addressed by name, the same
+// way they appear on anime and in the listing filters.
+func (app *application) readName(r *http.Request) string {
+	return httprouter.ParamsFromContext(r.Context()).ByName("name")
 }
 
 type envelope map[string]any
 
 // Define a write() helper for sending responses. This takes the destination
-// http.ResponseWriter, the HTTP status code to send, the data to encode to JSON, and a
-// header map containing any additional HTTP headers we want to include in the response.
-func (app *application) write(w http.ResponseWriter, code int, data envelope, headers http.Header) error {
+// http.ResponseWriter, the request being responded to, the HTTP status code to send, the
+// data to encode, and a header map containing any additional HTTP headers we want to
+// include in the response. The data is encoded to JSON, or to MessagePack if the
+// request's Accept header prefers it. Clients opting out of the envelope get its value
+// alone, see unwrap().
+func (app *application) write(w http.ResponseWriter, r *http.Request, code int, data envelope, headers http.Header) error {
+	var body any = data
+	if !wantsEnvelope(r) {
+		body = unwrap(data, w.Header())
+	}
+
 	// Encode the data to JSON, returning the error if there was one.
-	js, err := json.Marshal(data)
+	js, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
 
-	// Append a newline to make it easier to view in terminal applications.
-	js = append(js, '\n')
+	contentType := negotiate(r)
+	if contentType == mediaTypeMsgpack {
+		js, err = jsonToMsgpack(js)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Append a newline to make it easier to view in terminal applications.
+		js = append(js, '\n')
+	}
 
 	// At this point, we know that we won't encounter any more errors before writing the
 	// response, so it's safe to add any headers that we want to include. We loop
@@ -57,31 +103,182 @@ func (app *application) write(w http.ResponseWriter, code int, data envelope, he
 		w.Header()[key] = value
 	}
 
-	// Add the "Content-Type: application/json" header, then write the status code and
-	// JSON response.
-	w.Header().Set("Content-Type", "application/json")
+	// Add the Content-Type and Content-Length headers, then write the status code and
+	// response. As the format depends on the Accept header, caches are told so with the
+	// Vary header. The length is set explicitly so that it is sent in response to HEAD
+	// requests too, whose body net/http drops.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(js)))
+	w.Header().Add("Vary", "Accept")
 	w.WriteHeader(code)
 	w.Write(js)
 
 	return nil
 }
 
+// wantsEnvelope reports whether the response to r is wrapped in an envelope, which is
+// the default. Clients opt out with the envelope=false query string parameter, or the
+// X-Envelope: none header.
+func wantsEnvelope(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("X-Envelope"), "none") {
+		return false
+	}
+
+	if wanted, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil {
+		return wanted
+	}
+
+	return true
+}
+
+// unwrap returns what is written in place of an envelope for the clients opting out of
+// it: the value of its only key, such as the anime itself rather than {"anime": ...}.
+// The pagination metadata is moved to the X-Total-Count header, next to the Link
+// header, so that listings are bare arrays; keyset paginated listings have no total to
+// send. Errors, and the envelopes with several keys left, are written as they are.
+func unwrap(env envelope, headers http.Header) any {
+	if _, ok := env["error"]; ok {
+		return env
+	}
+
+	if metadata, ok := env["metadata"].(data.Metadata); ok {
+		// Keyset pagination sets the page size alone.
+		if metadata.CurrentPage > 0 || metadata.PageSize == 0 {
+			headers.Set("X-Total-Count", strconv.Itoa(metadata.TotalRecords))
+		}
+
+		env = maps.Clone(env)
+		delete(env, "metadata")
+	}
+
+	if len(env) != 1 {
+		return env
+	}
+
+	for _, value := range env {
+		return value
+	}
+
+	return env
+}
+
 var (
 	ErrBadlyFormattedJSON = errors.New("body contains badly-formed JSON")
 	ErrInvalidTypeJSON    = errors.New("body contains incorrect JSON type")
 )
 
+// defaultMaxRequestBody is the size limit of JSON bodies, unless configured otherwise
+// with -max-request-body.
+const defaultMaxRequestBody = 1_048_576
+
+// bodyTooLargeError reports a request body, or a part of it such as an uploaded file,
+// going over its size limit. The badRequest() helper sends it as a 413 rather than a
+// 400.
+type bodyTooLargeError struct {
+	what  string
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("%s must not be larger than %d bytes", e.what, e.limit)
+}
+
+// bodyFieldError reports a field of a JSON body holding a value of the wrong type. The
+// badRequest() helper sends it as an object mapping the field to the problem, the same
+// way as the validation errors.
+type bodyFieldError struct {
+	field   string
+	message string
+}
+
+func (e *bodyFieldError) Error() string {
+	return fmt.Sprintf("%s: field %q %s", ErrInvalidTypeJSON, e.field, e.message)
+}
+
+func (e *bodyFieldError) Unwrap() error {
+	return ErrInvalidTypeJSON
+}
+
+// newBodyFieldError describes a type mismatch in terms of JSON rather than Go types,
+// such as "must be an integer" for a string sent in place of an int32.
+func newBodyFieldError(err *json.UnmarshalTypeError) *bodyFieldError {
+	t := err.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var message string
+	switch t.Kind() {
+	case reflect.Bool:
+		message = "must be a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		message = "must be an integer"
+		// A number which doesn't fit is reported as "number 1e+100", and one with
+		// a fraction as "number 1.5".
+		if value, ok := strings.CutPrefix(err.Value, "number "); ok && !strings.ContainsAny(value, ".eE") {
+			message = "is out of range"
+		}
+	case reflect.Float32, reflect.Float64:
+		message = "must be a number"
+	case reflect.String:
+		message = "must be a string"
+	case reflect.Slice, reflect.Array:
+		message = "must be an array"
+	default:
+		message = "must be an object"
+	}
+
+	return &bodyFieldError{field: err.Field, message: message}
+}
+
+// bodyOptions change how readBodyWith() reads the body of a request, for the endpoints
+// needing it.
+type bodyOptions struct {
+	// allowUnknownFields ignores the fields which dst has no place for, rather than
+	// rejecting the body.
+	allowUnknownFields bool
+}
+
+// readBody decodes the JSON (or MessagePack) body of a request into dst, rejecting the
+// bodies over the size limit, holding more than a single value, or fields dst doesn't
+// have.
 func (app *application) readBody(w http.ResponseWriter, r *http.Request, dst any) error {
-	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return app.readBodyWith(w, r, dst, bodyOptions{})
+}
+
+func (app *application) readBodyWith(w http.ResponseWriter, r *http.Request, dst any, opts bodyOptions) error {
+	// Use http.MaxBytesReader() to limit the size of the request body, to 1MB unless
+	// configured otherwise.
+	maxBytes := app.config.body.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBody
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// MessagePack bodies are converted to JSON first, so that they are decoded and
+	// checked exactly like JSON ones.
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); isMsgpack(mediaType) {
+		js, err := msgpackToJSON(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return &bodyTooLargeError{what: "body", limit: maxBytesError.Limit}
+			}
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
 
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
-	// before decoding. This means that if the JSON from the client now includes any
-	// field which cannot be mapped to the target destination, the decoder will return
-	// an error instead of just ignoring the field.
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	// before decoding, unless the endpoint allows them. This means that if the JSON from
+	// the client now includes any field which cannot be mapped to the target
+	// destination, the decoder will return an error instead of just ignoring the field.
+	dec := json.NewDecoder(body)
+	if !opts.allowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
 
 	// Use the Decode() method to decode the body contents into the input struct.
 	// Importantly, notice that when we call Decode() we pass a *pointer* to the input
@@ -110,11 +307,11 @@ func (app *application) readBody(w http.ResponseWriter, r *http.Request, dst any
 
 		// Likewise, catch any *json.UnmarshalTypeError errors. These occur when the
 		// JSON value is the wrong type for the target destination. If the error relates
-		// to a specific field, then we include that in our error message to make it
-		// easier for the client to debug.
+		// to a specific field, then we report which one and what it should be, to make
+		// it easier for the client to debug.
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("%w for field %q", ErrInvalidTypeJSON, unmarshalTypeError.Field)
+				return newBodyFieldError(unmarshalTypeError)
 			}
 			return fmt.Errorf("%w (at character %d)", ErrInvalidTypeJSON, unmarshalTypeError.Offset)
 
@@ -136,9 +333,9 @@ func (app *application) readBody(w http.ResponseWriter, r *http.Request, dst any
 
 		// Use the errors.As() function to check whether the error has the type
 		// *http.MaxBytesError. If it does, then it means the request body exceeded our
-		// size limit of 1MB and we return a clear error message.
+		// size limit and we return a clear error message.
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return &bodyTooLargeError{what: "body", limit: maxBytesError.Limit}
 
 		// A json.InvalidUnmarshalError error will be returned if we pass something
 		// that is not a non-nil pointer to Decode(). We catch this and panic,
@@ -246,25 +443,190 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// The background() helper accepts an arbitrary function as a parameter.
-func (app *application) background(fn func()) {
-	// Increment the WaitGroup counter.
-	app.wg.Add(1)
-
-	// Launch a background goroutine.
-	go func() {
-		// Use defer to decrement the WaitGroup counter before the goroutine returns.
-		defer app.wg.Done()
-
-		// Run a deferred function which uses recover() to catch any panic, and log an
-		// error message instead of terminating the application.
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Error(fmt.Sprintf("%v", err))
-			}
-		}()
+// The readOptionalInt64() helper reads an optional integer value from the query string.
+// It returns nil if no matching key could be found, and records an error message in the
+// provided Validator instance if the value isn't a valid integer.
+func (app *application) readOptionalInt64(qs url.Values, key string, v *validator.Validator) *int64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return nil
+	}
+
+	return &i
+}
 
-		// Execute the arbitrary function that we passed as the parameter.
-		fn()
-	}()
+// The readOptionalInt32() helper is the 32-bit counterpart of readOptionalInt64(), for
+// values that are compared against integer columns such as the anime year.
+func (app *application) readOptionalInt32(qs url.Values, key string, v *validator.Validator) *int32 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return nil
+	}
+
+	n := int32(i)
+	return &n
+}
+
+// The readBool() helper reads an optional boolean value from the query string. It returns
+// nil if no matching key could be found, and records an error message in the provided
+// Validator instance if the value isn't a valid boolean.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// The readTime() helper reads an optional RFC 3339 timestamp from the query string, such
+// as 2024-01-02T15:04:05Z. It returns nil if no matching key could be found, and records
+// an error message in the provided Validator instance if the value isn't a valid time.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	return &t
+}
+
+// The readCursor() helper reads an opaque keyset pagination cursor from the query string.
+// It returns nil if the key is absent, and a cursor marking the start of the listing if
+// the key is present but empty. Malformed cursors are recorded in the validator.
+func (app *application) readCursor(qs url.Values, key string, sort string, v *validator.Validator) *data.Cursor {
+	if !qs.Has(key) {
+		return nil
+	}
+
+	s := qs.Get(key)
+	if s == "" {
+		return &data.Cursor{Sort: sort}
+	}
+
+	cursor, err := data.DecodeCursor(s)
+	if err != nil {
+		v.AddError(key, "must be a valid cursor")
+		return nil
+	}
+
+	return cursor
+}
+
+// The background() helper runs an arbitrary function on the worker pool. The function is
+// given a context which carries the trace of ctx, so that its span shows up under the
+// request that launched it, but which isn't canceled when the request is over. It is
+// canceled once the task runs for longer than the timeout of the workers, though.
+//
+// When every worker is busy, background() waits for room in the queue of the pool, which
+// slows down the requests rather than piling up goroutines.
+func (app *application) background(ctx context.Context, name string, fn func(ctx context.Context)) {
+	ctx, span := telemetry.Start(context.WithoutCancel(ctx), "background."+name)
+
+	err := app.workers.Submit(ctx, name, func(ctx context.Context) {
+		defer span.End()
+		fn(ctx)
+	})
+	if err != nil {
+		telemetry.End(span, err)
+		app.logger.ErrorContext(ctx, "background task not run", "task", name, "error", err.Error())
+	}
+}
+
+// The recoverTask() method is the panic handler of the worker pool and the scheduler: a
+// background task or a job which panics is logged and reported, instead of terminating
+// the application.
+func (app *application) recoverTask(ctx context.Context, name string, value any) {
+	app.logger.ErrorContext(ctx, fmt.Sprintf("%v", value), "task", name)
+	app.reportError(ctx, nil, reporting.NewPanicError(value))
+}
+
+// The readBearerToken() helper extracts the token from an "Authorization: Bearer <token>"
+// header, or from the query string of a WebSocket handshake. It returns false if the
+// header is missing or malformed.
+func (app *application) readBearerToken(r *http.Request) (string, bool) {
+	if token := webSocketToken(r); token != "" {
+		return token, true
+	}
+
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+		return "", false
+	}
+
+	return headerParts[1], true
+}
+
+// The webSocketToken() helper returns the token sent in the access_token query string
+// parameter of a WebSocket handshake without an Authorization header, as RFC 6750
+// allows. Browsers can't set headers on WebSocket handshakes; the other requests must
+// send the header, so that their tokens don't end up in URLs.
+func webSocketToken(r *http.Request) string {
+	if !isWebSocket(r) || r.Header.Get("Authorization") != "" {
+		return ""
+	}
+
+	return r.URL.Query().Get("access_token")
+}
+
+// The stripWebSocketToken() helper removes the access_token query string parameter from
+// the URL of r, once read, so that the token isn't logged or reported with the request.
+// The URL is changed in place, as the middlewares in front of authenticate() log and
+// report the same request too.
+func stripWebSocketToken(r *http.Request) {
+	qs := r.URL.Query()
+	if !qs.Has("access_token") {
+		return
+	}
+
+	qs.Del("access_token")
+	r.URL.RawQuery = qs.Encode()
+	r.RequestURI = r.URL.RequestURI()
+}
+
+// The clientIP() helper returns the IP address of the client, without the port.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// The tokenIssuer() helper describes the client making the request, for recording
+// alongside newly issued tokens.
+func (app *application) tokenIssuer(r *http.Request) data.TokenIssuer {
+	return data.TokenIssuer{
+		ClientIP:  app.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// importChunkSize is the number of rows inserted per transaction.
+	importChunkSize = 100
+
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// importFailure describes a row that wasn't inserted, by its line number in the body.
+type importFailure struct {
+	Line   int               `json:"line"`
+	Reason string            `json:"reason,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importReport is the summary sent back once an import is done. Skipped rows are
+// duplicates of existing anime; failed rows couldn't be read, were invalid, or belonged
+// to a chunk that couldn't be written.
+type importReport struct {
+	Inserted int             `json:"inserted"`
+	Skipped  []importFailure `json:"skipped"`
+	Failed   []importFailure `json:"failed"`
+
+	// Aborted is set when the body couldn't be read to the end. Rows before the
+	// problem have still been processed.
+	Aborted string `json:"aborted,omitempty"`
+}
+
+type importRow struct {
+	line  int
+	anime *data.Anime
+}
+
+// rowError is returned by a rowReader for a single row that can't be read. Unlike any
+// other error, it doesn't stop the import.
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// rowReader reads anime one row at a time from an import body, returning io.EOF once
+// the body is exhausted.
+type rowReader interface {
+	next() (int, *animeRequest, error)
+}
+
+// ndjsonReader reads one JSON object per line, in the same shape as the body of
+// POST /v1/anime. Blank lines are ignored.
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1_048_576)
+
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (nr *ndjsonReader) next() (int, *animeRequest, error) {
+	for nr.scanner.Scan() {
+		nr.line++
+
+		line := bytes.TrimSpace(nr.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		// The id, slug, version and cover_url of rows produced by the export endpoint
+		// are accepted, but ignored: imported anime always get new IDs and slugs, and
+		// covers are uploaded separately.
+		var request struct {
+			animeRequest
+			ID       *int32  `json:"id"`
+			Slug     *string `json:"slug"`
+			Version  *int32  `json:"version"`
+			CoverURL *string `json:"cover_url"`
+		}
+		if err := dec.Decode(&request); err != nil {
+			return nr.line, nil, &rowError{nr.line, err}
+		}
+
+		return nr.line, &request.animeRequest, nil
+	}
+
+	if err := nr.scanner.Err(); err != nil {
+		return nr.line, nil, err
+	}
+
+	return nr.line, nil, io.EOF
+}
+
+// csvReader reads rows of a CSV body whose first line is a header naming the columns:
+// title, type, episodes, status, season, year, duration (in minutes), tags (separated
+// by "|"), studios, synopsis, alt_titles (also separated by "|"), mal_id and
+// anilist_id. Only title, type and status are required. The id and version
+// columns written by the export endpoint are accepted but ignored.
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+var csvColumns = []string{"id", "title", "type", "episodes", "status", "season", "year", "duration", "tags", "studios", "synopsis", "alt_titles", "mal_id", "anilist_id", "version"}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing csv header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, csvColumns...) {
+			return nil, fmt.Errorf("unknown csv column %q", name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "type", "status"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing csv column %q", name)
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvReader) next() (int, *animeRequest, error) {
+	record, err := cr.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.Line, nil, &rowError{parseErr.Line, err}
+		}
+		return 0, nil, err
+	}
+
+	line, _ := cr.reader.FieldPos(0)
+
+	// Turn the record into a JSON object, so that the values go through exactly the
+	// same decoding as the JSON endpoints.
+	fields := make(map[string]any)
+	for name, i := range cr.columns {
+		if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+
+		switch name {
+		case "id", "version":
+			continue
+		case "episodes", "year", "mal_id", "anilist_id":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return line, nil, &rowError{line, fmt.Errorf("%s must be an integer value", name)}
+			}
+			fields[name] = n
+		case "duration":
+			fields[name] = strings.TrimSuffix(value, " mins") + " mins"
+		case "tags", "studios", "alt_titles":
+			fields[name] = strings.Split(value, "|")
+		default:
+			fields[name] = value
+		}
+	}
+
+	js, err := json.Marshal(fields)
+	if err != nil {
+		return line, nil, err
+	}
+
+	var request animeRequest
+	if err = json.Unmarshal(js, &request); err != nil {
+		return line, nil, &rowError{line, err}
+	}
+
+	return line, &request, nil
+}
+
+// Import anime in bulk from an NDJSON or CSV body. The body is streamed and validated
+// row by row, valid rows are inserted in chunks (one transaction per chunk), and a
+// report of what happened to every row is sent back.
+func (app *application) importAnime(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	// Imports get a limit of their own, well over the one of the other endpoints. Rows
+	// are streamed, so it only bounds how long a single import can run for.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.body.maxImportBytes)
+
+	var rows rowReader
+	switch mediaType {
+	case contentTypeNDJSON:
+		rows = newNDJSONReader(r.Body)
+	case contentTypeCSV:
+		cr, err := newCSVReader(r.Body)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+		rows = cr
+	default:
+		app.unsupportedMediaType(w, r, contentTypeNDJSON, contentTypeCSV)
+		return
+	}
+
+	report := importReport{
+		Skipped: make([]importFailure, 0),
+		Failed:  make([]importFailure, 0),
+	}
+
+	chunk := make([]importRow, 0, importChunkSize)
+
+	// The rows read before the body went over its size limit are still imported, and
+	// reported along with the 413 status.
+	status := http.StatusOK
+	var maxBytesError *http.MaxBytesError
+
+	for {
+		line, request, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *rowError
+		switch {
+		case errors.As(err, &rowErr):
+			report.Failed = append(report.Failed, importFailure{Line: rowErr.line, Reason: rowErr.Error()})
+			continue
+		case errors.As(err, &maxBytesError):
+			report.Aborted = fmt.Sprintf("line %d: %s", line, &bodyTooLargeError{what: "import", limit: maxBytesError.Limit})
+			status = http.StatusRequestEntityTooLarge
+		case err != nil:
+			report.Aborted = fmt.Sprintf("line %d: %s", line, err.Error())
+		}
+
+		if report.Aborted != "" {
+			break
+		}
+
+		v := validator.New()
+
+		anime := request.toPost(v)
+		if anime != nil {
+			data.ValidateAnime(v, anime)
+		}
+
+		if !v.Valid() {
+			report.Failed = append(report.Failed, importFailure{Line: line, Errors: v.Errors})
+			continue
+		}
+
+		chunk = append(chunk, importRow{line: line, anime: anime})
+		if len(chunk) == importChunkSize {
+			app.importChunk(r, chunk, &report)
+			chunk = chunk[:0]
+		}
+	}
+
+	if len(chunk) > 0 {
+		app.importChunk(r, chunk, &report)
+	}
+
+	err := app.write(w, r, status, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// importChunk inserts a chunk of rows in one transaction. Duplicates, either by title
+// or by an external ID, such as the same MyAnimeList anime imported twice, are skipped
+// without failing the chunk; any other error rolls the whole chunk back and marks
+// every row in it as failed.
+func (app *application) importChunk(r *http.Request, chunk []importRow, report *importReport) {
+	var inserted int
+	var skipped []importFailure
+
+	err := app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		inserted, skipped = 0, nil
+
+		for _, row := range chunk {
+			// The same anime often comes under a different title from another source,
+			// so its external IDs are checked first.
+			field, err := externalDuplicate(r.Context(), repos.Anime, row.anime)
+			if err != nil {
+				return err
+			}
+			if field != "" {
+				skipped = append(skipped, importFailure{Line: row.line, Reason: fmt.Sprintf("an anime with this %s already exists", field)})
+				continue
+			}
+
+			err = repos.Anime.InsertAnime(r.Context(), row.anime)
+			switch {
+			case errors.Is(err, repository.ErrDuplicateEntry):
+				skipped = append(skipped, importFailure{Line: row.line, Reason: "an anime with this title already exists"})
+				continue
+			case err != nil:
+				return err
+			}
+
+			err = app.audit(r, repos, data.AuditActionCreate, data.AuditEntityAnime, int64(row.anime.ID), nil, row.anime)
+			if err != nil {
+				return err
+			}
+
+			inserted++
+		}
+
+		return nil
+	})
+	if err != nil {
+		app.logError(r, err)
+
+		for _, row := range chunk {
+			report.Failed = append(report.Failed, importFailure{Line: row.line, Reason: "could not be saved, please retry"})
+		}
+		return
+	}
+
+	report.Inserted += inserted
+	report.Skipped = append(report.Skipped, skipped...)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Names of the IP rule lists, as used by the admin endpoint.
+const (
+	// ipBlocklist holds the ranges that can't access the API at all.
+	ipBlocklist = "blocklist"
+	// ipAdminAllowlist holds the ranges the admin routes can be accessed from. When it
+	// is empty, they can be accessed from anywhere.
+	ipAdminAllowlist = "admin-allowlist"
+)
+
+// ipRules are the IP ranges blocked from the API, and the ones allowed to access the
+// admin routes. They're set from the configuration at startup and can be changed by an
+// admin at runtime, until the next restart. The zero value allows everything.
+type ipRules struct {
+	mu           sync.RWMutex
+	blocked      []netip.Prefix
+	adminAllowed []netip.Prefix
+}
+
+// set replaces one of the lists.
+func (rules *ipRules) set(list string, prefixes []netip.Prefix) {
+	rules.mu.Lock()
+	defer rules.mu.Unlock()
+
+	switch list {
+	case ipBlocklist:
+		rules.blocked = prefixes
+	case ipAdminAllowlist:
+		rules.adminAllowed = prefixes
+	}
+}
+
+// lists returns a copy of both lists.
+func (rules *ipRules) lists() map[string][]netip.Prefix {
+	rules.mu.RLock()
+	defer rules.mu.RUnlock()
+
+	return map[string][]netip.Prefix{
+		ipBlocklist:      append([]netip.Prefix{}, rules.blocked...),
+		ipAdminAllowlist: append([]netip.Prefix{}, rules.adminAllowed...),
+	}
+}
+
+// allows reports whether the IP address may access the given path.
+func (rules *ipRules) allows(ip netip.Addr, path string) bool {
+	rules.mu.RLock()
+	defer rules.mu.RUnlock()
+
+	if containsIP(rules.blocked, ip) {
+		return false
+	}
+
+	if strings.HasPrefix(v1Path(path), "/v1/admin/") {
+		return rules.adminAllowsLocked(ip)
+	}
+
+	return true
+}
+
+// adminAllows reports whether the IP address may access the admin resources, which
+// aren't all behind the admin routes: GraphQL serves some of them too.
+func (rules *ipRules) adminAllows(ip netip.Addr) bool {
+	rules.mu.RLock()
+	defer rules.mu.RUnlock()
+
+	return rules.adminAllowsLocked(ip)
+}
+
+func (rules *ipRules) adminAllowsLocked(ip netip.Addr) bool {
+	return len(rules.adminAllowed) == 0 || containsIP(rules.adminAllowed, ip)
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// parseCIDRs parses a list of CIDR ranges, such as 10.0.0.0/8. A single address is
+// read as a range of its own.
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR range %q", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR range %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// clientIP returns the IP address of the client, the same way the rate limiter
+// identifies it.
+func clientIP(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	// An IPv4 address may come in its IPv6 form, which the IPv4 ranges wouldn't match.
+	return addr.Unmap(), nil
+}
+
+// The filterIP() middleware rejects the requests from blocked IP addresses, and the
+// requests to the admin routes from outside the admin allowlist. It runs before the
+// rate limiter, so blocked clients don't use up any of its memory.
+func (app *application) filterIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := clientIP(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !app.ipRules.allows(ip, r.URL.Path) {
+			app.ipNotAllowed(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List the IP rules in effect.
+func (app *application) listIPRules(w http.ResponseWriter, r *http.Request) {
+	err := app.write(w, r, http.StatusOK, envelope{"ip_rules": app.ipRules.lists()}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Replace one of the IP rule lists, e.g. PUT /v1/admin/ip-rules/blocklist with
+// {"cidrs": ["203.0.113.0/24"]}. The change lasts until the next restart; the lists
+// the server starts with come from the configuration.
+func (app *application) updateIPRules(w http.ResponseWriter, r *http.Request) {
+	list := httprouter.ParamsFromContext(r.Context()).ByName("list")
+	if list != ipBlocklist && list != ipAdminAllowlist {
+		app.notFound(w, r)
+		return
+	}
+
+	var input struct {
+		CIDRs []string `json:"cidrs"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CIDRs != nil, "cidrs", "must be provided")
+
+	prefixes, err := parseCIDRs(input.CIDRs)
+	if err != nil {
+		v.AddError("cidrs", err.Error())
+	}
+
+	// Don't let admins lock themselves out of the very endpoint that would undo it.
+	ip, err := clientIP(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	switch list {
+	case ipBlocklist:
+		v.Check(!containsIP(prefixes, ip), "cidrs", "must not include your own IP address")
+	case ipAdminAllowlist:
+		v.Check(len(prefixes) == 0 || containsIP(prefixes, ip), "cidrs", "must include your own IP address")
+	}
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	app.ipRules.set(list, prefixes)
+	app.logger.InfoContext(r.Context(), "ip rules updated", "list", list, "cidrs", prefixes, "user_id", app.contextGetUser(r).ID)
+
+	err = app.write(w, r, http.StatusOK, envelope{"ip_rules": app.ipRules.lists()}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
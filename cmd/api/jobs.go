@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"github.com/ziliscite/purplelight/internal/scheduler"
+	"time"
+)
+
+// The scheduleJobs() method registers the periodic jobs with the scheduler, which runs
+// them from the start of the server until its shutdown. The jobs disabled by the config
+// are left out.
+func (app *application) scheduleJobs() {
+	cfg := app.config
+
+	if cfg.accounts.deletionGrace > 0 {
+		app.scheduler.Register(scheduler.Job{Name: "purgeDeletedAccounts", Every: cfg.accounts.purgeInterval, Run: app.purgeDeletedAccounts})
+	}
+	if cfg.accounts.unactivatedTTL > 0 {
+		app.scheduler.Register(scheduler.Job{Name: "purgeUnactivatedAccounts", Every: cfg.accounts.purgeInterval, Run: app.purgeUnactivatedAccounts})
+	}
+
+	app.scheduler.Register(scheduler.Job{Name: "cleanupExpiredTokens", Every: cfg.tokens.cleanupInterval, Run: app.cleanupExpiredTokens})
+	app.scheduler.Register(scheduler.Job{Name: "flushActivity", Every: cfg.trending.flushInterval, Run: app.writeActivity})
+	app.scheduler.Register(scheduler.Job{Name: "refreshTrending", Every: cfg.trending.refreshInterval, Run: app.refreshTrending})
+
+	if cfg.events.retention > 0 {
+		app.scheduler.Register(scheduler.Job{Name: "purgeEvents", Every: cfg.events.purgeInterval, Run: app.purgeEvents})
+	}
+	if cfg.notifications.reminderInterval > 0 {
+		app.scheduler.Register(scheduler.Job{Name: "remindActivation", Every: cfg.notifications.reminderInterval, Run: app.remindActivation})
+	}
+}
+
+// The purgeDeletedAccounts() job removes the accounts whose deletion grace period has
+// passed.
+func (app *application) purgeDeletedAccounts(ctx context.Context) error {
+	n, err := app.repos.User.PurgeDeleted(ctx, time.Now().Add(-app.config.accounts.deletionGrace))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Info("purged deleted accounts", "count", n)
+	}
+
+	return nil
+}
+
+// The purgeUnactivatedAccounts() job removes the accounts which were never activated
+// within the configured time, along with their tokens and permissions, so that the
+// users table doesn't fill up with them and their emails can be used again.
+func (app *application) purgeUnactivatedAccounts(ctx context.Context) error {
+	n, err := app.repos.User.PurgeUnactivated(ctx, time.Now().Add(-app.config.accounts.unactivatedTTL))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Info("purged unactivated accounts", "count", n)
+	}
+
+	return nil
+}
+
+// totalExpiredTokensDeleted counts the expired tokens and revoked JWT IDs deleted by the
+// cleanup.
+var totalExpiredTokensDeleted = expvar.NewInt("total_expired_tokens_deleted")
+
+// The cleanupExpiredTokens() job deletes the expired tokens and revoked JWT IDs, which
+// are otherwise never removed from the tokens and revoked_tokens tables. It deletes them batch by batch, until a batch comes
+// back short or the run is canceled, so that a large backlog doesn't hold up the
+// shutdown.
+func (app *application) cleanupExpiredTokens(ctx context.Context) error {
+	batch := app.config.tokens.cleanupBatch
+	now := time.Now()
+
+	var total int64
+	defer func() {
+		if total > 0 {
+			app.logger.Info("deleted expired tokens", "count", total)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		n, err := app.repos.Token.DeleteExpired(ctx, now, batch)
+		total += n
+		totalExpiredTokensDeleted.Add(n)
+		if err != nil || n < int64(batch) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The writeActivity() job writes the anime activity counted since the last flush to the
+// database. If that fails, the counts are put back to be written with the next flush.
+// The shutdown calls it one last time, so that the activity counted before it isn't
+// lost.
+func (app *application) writeActivity(ctx context.Context) error {
+	activity := app.activity.drain()
+	if len(activity) == 0 {
+		return nil
+	}
+
+	err := app.repos.Trending.RecordActivity(ctx, activity)
+	if err != nil {
+		app.activity.restore(activity)
+		return err
+	}
+
+	return nil
+}
+
+// The refreshTrending() job recomputes the trending anime from the activity within the
+// trending window.
+func (app *application) refreshTrending(ctx context.Context) error {
+	n, err := app.repos.Trending.Refresh(ctx, app.config.trending.window)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("refreshed trending anime", "count", n)
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// The issueJWT() helper signs a new stateless authentication token for the user.
+func (app *application) issueJWT(user *data.User, ttl time.Duration) (*data.Token, error) {
+	token, _, err := data.GenerateJWT(user, ttl, app.config.auth.jwt.issuer, []byte(app.config.auth.jwt.secret))
+	return token, err
+}
+
+// The userFromJWT() helper verifies a JWT locally and rebuilds the user from its claims.
+// The only database round-trip is the revocation check. Any verification failure, or a
+// revoked token, is reported as data.ErrInvalidJWT.
+func (app *application) userFromJWT(ctx context.Context, token string) (*data.User, error) {
+	claims, err := data.ParseJWT(token, app.config.auth.jwt.issuer, []byte(app.config.auth.jwt.secret))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := claims.User()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := app.repos.Token.IsRevoked(ctx, claims.ID, user.ID, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.Join(data.ErrInvalidJWT, errors.New("token has been revoked"))
+	}
+
+	return user, nil
+}
+
+// The revokeJWT() helper records the token ID so that it can't be used again.
+func (app *application) revokeJWT(ctx context.Context, token string) error {
+	claims, err := data.ParseJWT(token, app.config.auth.jwt.issuer, []byte(app.config.auth.jwt.secret))
+	if err != nil {
+		return err
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return data.ErrInvalidJWT
+	}
+
+	return app.repos.Token.Revoke(ctx, claims.ID, userID, claims.ExpiresAt.Time)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+func TestJWTRevocation(t *testing.T) {
+	var cfg Config
+	cfg.auth.jwt.issuer = "purplelight"
+	cfg.auth.jwt.secret = "a secret of at least thirty-two bytes"
+
+	app := newTestApplication(t, cfg)
+	ctx := context.Background()
+
+	inserted := insertUser(t, app, "alice@example.com", true)
+	user, err := app.repos.User.Get(ctx, inserted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.issueJWT(user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new name is no reason to log the user out.
+	user.Name = "Alice"
+	if err := app.repos.User.Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.userFromJWT(ctx, token.Plaintext); err != nil {
+		t.Fatalf("the token was revoked by a new name: %v", err)
+	}
+
+	// A new password is.
+	if err := user.Password.Set("an0ther pa55word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.repos.User.Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.userFromJWT(ctx, token.Plaintext); !errors.Is(err, data.ErrInvalidJWT) {
+		t.Fatalf("got error %v after a new password, want %v", err, data.ErrInvalidJWT)
+	}
+
+	// As is a revocation of the token itself.
+	token, err = app.issueJWT(user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.revokeJWT(ctx, token.Plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.userFromJWT(ctx, token.Plaintext); !errors.Is(err, data.ErrInvalidJWT) {
+		t.Fatalf("got error %v after a revocation, want %v", err, data.ErrInvalidJWT)
+	}
+}
+
+func TestCleanupExpiredTokensDeletesRevokedJWTs(t *testing.T) {
+	var cfg Config
+	cfg.tokens.cleanupBatch = 1
+
+	app := newTestApplication(t, cfg)
+	ctx := context.Background()
+
+	user := insertUser(t, app, "alice@example.com", true)
+
+	if err := app.repos.Token.Revoke(ctx, "expired", user.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.repos.Token.Revoke(ctx, "live", user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.cleanupExpiredTokens(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for jti, want := range map[string]bool{"expired": false, "live": true} {
+		revoked, err := app.repos.Token.IsRevoked(ctx, jti, user.ID, user.TokenVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != want {
+			t.Errorf("got revoked %t for the %s jti, want %t", revoked, jti, want)
+		}
+	}
+}
@@ -3,9 +3,23 @@ package main
 import (
 	"context"
 	"expvar"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ziliscite/purplelight/internal/broker"
+	"github.com/ziliscite/purplelight/internal/cache"
+	"github.com/ziliscite/purplelight/internal/catalog"
+	"github.com/ziliscite/purplelight/internal/data"
 	"github.com/ziliscite/purplelight/internal/mailer"
+	"github.com/ziliscite/purplelight/internal/notify"
+	"github.com/ziliscite/purplelight/internal/reporting"
 	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/scheduler"
+	"github.com/ziliscite/purplelight/internal/service"
+	"github.com/ziliscite/purplelight/internal/storage"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"github.com/ziliscite/purplelight/internal/vcs"
+	"github.com/ziliscite/purplelight/internal/worker"
 	"log/slog"
 	"os"
 	"runtime"
@@ -13,66 +27,391 @@ import (
 	"time"
 )
 
-// Remember, our version number is just a constant string (for now).
-const version = "1.0.0"
+// version is the version of the API, with the commit it was built from. See vcs.Info()
+// for the whole build info.
+var version = vcs.Version()
 
 // Add a models field to hold our new Models struct.
 // Include a sync.WaitGroup in the application struct. The zero-value for a
 // sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0,
 // so we don't need to do anything else to initialize it before we can use it.
 type application struct {
-	config Config
-	logger *slog.Logger
-	mailer mailer.Mailer
-	repos  repository.Repositories
-	wg     sync.WaitGroup
+	config  Config
+	logger  *slog.Logger
+	mailer  mailer.Mailer
+	repos   repository.Repositories
+	tx      service.Transactor
+	storage storage.Storage
+	// catalogs are the external catalogs anime can be imported from, by source name.
+	catalogs map[string]catalog.Source
+	// activity counts the views and watchlist additions of anime until they're flushed.
+	activity activityCounter
+	// stats caches the catalog statistics.
+	stats statsCache
+	// limiters holds the rate limiter of each rate limit policy, and the global one.
+	limiters map[string]*rateLimiter
+	// probes check the dependencies of the API for the deep healthcheck.
+	probes []probe
+	// reporter sends the unexpected errors to the error tracker.
+	reporter reporting.Reporter
+	// ipRules are the IP ranges blocked from the API or allowed on the admin routes.
+	ipRules ipRules
+	// live holds the settings which reloading the configuration changes.
+	live *liveSettings
+	// outboxWake wakes the email dispatcher up when an email is queued.
+	outboxWake chan struct{}
+	// workers run the background tasks, such as sending emails.
+	workers *worker.Pool
+	// scheduler runs the periodic jobs, such as the cleanup of the expired tokens.
+	scheduler *scheduler.Scheduler
+	// notifications holds the WebSockets of the users connected for notifications.
+	notifications *notify.Hub
+	// broker is the message broker the events are published to, nil when there is none.
+	broker broker.Publisher
+	// db and replica are the connection pools to the database and its read replica, if
+	// any, for closing them and for the commands working on the database itself.
+	db      *pgxpool.Pool
+	replica *pgxpool.Pool
+	// wg tracks the background jobs, which run on goroutines of their own.
+	wg sync.WaitGroup
 }
 
 func main() {
 	cfg := GetConfig()
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cmd, args, _ := lookupCommand(cfg.command)
 
-	// Call the openDB() helper function (see below) to create the connection pool,
-	// passing in the config struct. If this returns an error, we log it and exit the
-	// application immediately.
-	db, err := openDB(cfg)
+	// The log level is held by a LevelVar, so that reloading the configuration can
+	// change it.
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.log.level)
+	logger := newLogger(cfg, logLevel)
+
+	// Set up the tracing. The spans still buffered are flushed when main() returns.
+	shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Config{
+		Endpoint:       cfg.otel.endpoint,
+		Insecure:       cfg.otel.insecure,
+		SampleRatio:    cfg.otel.sampleRatio,
+		ServiceName:    cfg.otel.serviceName,
+		ServiceVersion: version,
+		Environment:    cfg.env,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", "error", err.Error())
+		}
+	}()
+
+	// Report the unexpected errors to Sentry, if configured. The errors not sent yet are
+	// flushed when main() returns.
+	var reporter reporting.Reporter = reporting.Nop{}
+	if cfg.sentry.dsn != "" {
+		reporter, err = reporting.NewSentry(cfg.sentry.dsn, cfg.env, version)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	defer reporter.Flush(2 * time.Second)
+
+	// Set up as much of the application as the command needs, then run it.
+	app, err := newApplication(cfg, logger, logLevel, reporter, cmd.setup)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
+	// Close the connection pools before the main() function exits.
+	defer app.close()
+
+	err = cmd.run(app, args)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// newApplication sets up the application from the config, up to the given level: the
+// commands working on the database alone, such as migrate, don't need the rest, which
+// relies on the schema being migrated.
+func newApplication(cfg Config, logger *slog.Logger, logLevel *slog.LevelVar, reporter reporting.Reporter, level setupLevel) (*application, error) {
+	app := &application{
+		config:   cfg,
+		logger:   logger,
+		catalogs: newCatalogs(cfg),
+		reporter: reporter,
+		live:     newLiveSettings(cfg, logLevel),
+		// The channel holds a single wake up, as one is enough to send every email queued.
+		outboxWake:    make(chan struct{}, 1),
+		notifications: notify.NewHub(cfg.notifications.buffer, cfg.notifications.maxConnections, cfg.notifications.maxPerUser),
+	}
+
+	// Uploaded files are stored on the local disk for now.
+	store, err := storage.NewLocal(cfg.storage.dir, cfg.storage.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	app.storage = store
+
+	if level < setupDatabase {
+		return app, nil
+	}
+
+	// Log the slow queries, on top of tracing every query.
+	tracer := repository.NewQueryTracer(logger, cfg.db.slowQueryThreshold, telemetry.QueryTracer{})
+
+	// Call the openDB() helper function (see below) to create the connection pool,
+	// passing in the config struct.
+	app.db, err = openDB(cfg, cfg.DSN(), tracer, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	// Also log a message to say that the connection pool has been successfully
 	logger.Info("database connection pool established")
 
+	if level < setupFull {
+		return app, nil
+	}
+
+	// Apply the pending migrations first with -auto-migrate, before anything relies on
+	// the schema.
+	if cfg.db.autoMigrate {
+		err = autoMigrate(context.Background(), app.db, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Make expvar to hold our metrics data.
-	initializeMetrics(db)
+	initializeMetrics(app.db, tracer)
 
-	// Defer a call to db.Close() so that the connection pool is closed before the
-	// main() function exits.
-	defer db.Close()
+	// Connect to the read replica, if any. The reads fall back to the primary while it
+	// can't be reached, so the API starts without it.
+	if cfg.db.replicaDSN != "" {
+		app.replica, err = newPool(cfg, cfg.db.replicaDSN, tracer)
+		if err != nil {
+			return nil, err
+		}
+		if err := pingDB(app.replica); err != nil {
+			logger.Warn("read replica unreachable, reading from the primary until it is back", "error", err.Error())
+		}
+	}
 
 	// Use the data.NewModels() function to initialize a Models struct, passing in the
 	// connection pool as a parameter.
-	app := &application{
-		config: cfg,
-		logger: logger,
-		repos:  repository.NewRepositories(db, logger),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+	app.repos = repository.NewRepositories(app.db, app.replica, logger, cfg.db.timeouts)
+
+	// Cache the anime reads in Redis, or in memory for a single instance, if configured,
+	// and the users of the authentication tokens and their permissions, unless disabled.
+	// The transactions invalidate the caches as well, so they have to be set up before
+	// the TxManager.
+	var cacheStore cache.Cache
+	var redis *cache.Redis
+	switch cfg.cache.driver {
+	case "redis":
+		redis, err = cache.NewRedis(cfg.cache.redisURL)
+		if err != nil {
+			return nil, err
+		}
+		cacheStore = redis
+	case "memory":
+		cacheStore = cache.NewLRU(cfg.cache.size)
 	}
 
-	// Call app.serve() to start the server.
-	err = app.serve()
+	if cacheStore != nil {
+		animeCache := repository.NewAnimeCache(cacheStore, cfg.cache.animeTTL, cfg.cache.animeListTTL)
+		app.repos.CacheAnime(animeCache)
+
+		// Publish the hits, misses and errors of the cache.
+		expvar.Publish("anime_cache", expvar.Func(func() any {
+			return animeCache.Stats()
+		}))
+	}
+
+	if cacheStore != nil && (cfg.cache.tokenTTL > 0 || cfg.cache.permissionsTTL > 0) {
+		userCache := repository.NewUserCache(cacheStore, cfg.cache.tokenTTL, cfg.cache.permissionsTTL)
+		app.repos.CacheUsers(userCache)
+
+		expvar.Publish("user_cache", expvar.Func(func() any {
+			return userCache.Stats()
+		}))
+	}
+
+	app.tx = service.NewTxManager(app.db, app.repos)
+
+	// Parse the email templates now, so that a broken one stops the application here.
+	transport, err := newMailTransport(cfg, logger)
 	if err != nil {
-		logger.Error(err.Error())
-		os.Exit(1)
+		return nil, err
+	}
+
+	app.mailer, err = mailer.New(transport, cfg.smtp.sender, cfg.smtp.retries, cfg.smtp.backoff)
+	if err != nil {
+		return nil, err
+	}
+
+	app.workers = worker.New(cfg.workers.count, cfg.workers.queueSize, cfg.workers.timeout, app.recoverTask)
+
+	// Publish the number of background tasks waiting for a worker, and being run.
+	expvar.Publish("background_tasks_queued", expvar.Func(func() any {
+		return app.workers.Queued()
+	}))
+	expvar.Publish("background_tasks_running", expvar.Func(func() any {
+		return app.workers.Running()
+	}))
+
+	// Publish the number of notification WebSockets open.
+	expvar.Publish("notification_connections", expvar.Func(func() any {
+		return app.notifications.Len()
+	}))
+
+	// Connect to the message broker the change stream is published to, if configured.
+	// The connection is only opened by the first publish.
+	if cfg.events.driver == "nats" {
+		app.broker, err = broker.NewNATS(cfg.events.natsURL, "purplelight "+version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.scheduler = scheduler.New(logger, cfg.jobTimeout, app.recoverTask)
+	app.scheduleJobs()
+
+	// Publish the metrics of every periodic job.
+	expvar.Publish("jobs", expvar.Func(func() any {
+		return app.scheduler.Stats()
+	}))
+
+	app.probes = []probe{
+		{name: "database", critical: true, check: app.db.Ping},
+		{name: "mail", check: app.mailer.Ping},
+	}
+	if app.replica != nil {
+		app.probes = append(app.probes, probe{name: "database_replica", check: app.replica.Ping})
+	}
+	if redis != nil {
+		app.probes = append(app.probes, probe{name: "cache", check: redis.Ping})
+	}
+	if app.broker != nil {
+		app.probes = append(app.probes, probe{name: "events", check: app.broker.Ping})
+	}
+
+	app.ipRules.set(ipBlocklist, cfg.ip.blocklist)
+	app.ipRules.set(ipAdminAllowlist, cfg.ip.adminAllowlist)
+
+	// Make sure every permission scope in the registry exists in the database.
+	err = app.repos.Permission.Seed(context.Background(), data.PermissionCodes()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// close closes the connection pools, and the connection to the message broker, if the
+// application has any.
+func (app *application) close() {
+	if app.broker != nil {
+		_ = app.broker.Close()
+	}
+	if app.replica != nil {
+		app.replica.Close()
+	}
+	if app.db != nil {
+		app.db.Close()
+	}
+}
+
+// The mail providers the emails can be sent with, for -mail-driver. The log and file
+// drivers don't send anything: they are for development, writing the emails to the logs
+// or to a directory.
+const (
+	mailDriverSMTP     = "smtp"
+	mailDriverSendGrid = "sendgrid"
+	mailDriverSES      = "ses"
+	mailDriverLog      = "log"
+	mailDriverFile     = "file"
+)
+
+// newMailTransport returns the transport of the mail provider picked by the config.
+func newMailTransport(cfg Config, logger *slog.Logger) (mailer.Transport, error) {
+	switch cfg.mail.driver {
+	case mailDriverSendGrid:
+		return mailer.NewSendGrid(cfg.mail.sendgridKey), nil
+	case mailDriverSES:
+		return mailer.NewSES(cfg.mail.ses.region, cfg.mail.ses.accessKeyID, cfg.mail.ses.secretAccessKey), nil
+	case mailDriverLog:
+		return mailer.NewLog(logger), nil
+	case mailDriverFile:
+		return mailer.NewFile(cfg.mail.dir)
+	default:
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password), nil
+	}
+}
+
+// newLogger returns the logger of the application, writing to the standard output in
+// the format set in the config, from level on. The handler adds the request ID to the
+// lines logged during a request.
+func newLogger(cfg Config, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch cfg.log.format {
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	return slog.New(telemetry.NewLogHandler(handler))
+}
+
+// The openDB() function returns a sql.DB connection pool. The database is given a few
+// attempts to come up, backing off exponentially between them, as it may start after
+// the API, such as with docker compose.
+func openDB(cfg Config, dsn string, tracer pgx.QueryTracer, logger *slog.Logger) (*pgxpool.Pool, error) {
+	pool, err := newPool(cfg, dsn, tracer)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := cfg.db.connectBackoff
+	for attempt := 1; ; attempt++ {
+		err = pingDB(pool)
+		if err == nil {
+			return pool, nil
+		}
+
+		// If the connection couldn't be established successfully after the last attempt,
+		// we close the connection pool and return the error.
+		if attempt > cfg.db.connectRetries {
+			pool.Close()
+			return nil, fmt.Errorf("connecting to the database failed after %d attempt(s): %w", attempt, err)
+		}
+
+		logger.Warn("database unreachable, retrying", "attempt", attempt, "retry_in", backoff.String(), "error", err.Error())
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
 }
 
-// The openDB() function returns a sql.DB connection pool.
-func openDB(cfg Config) (*pgxpool.Pool, error) {
+// maxConnectBackoff caps the wait between two attempts at connecting to the database.
+const maxConnectBackoff = 30 * time.Second
+
+// newPool creates a connection pool to the database of dsn, with the pool settings of the
+// config. The connections are opened in the background, so it doesn't tell whether the
+// database can be reached.
+func newPool(cfg Config, dsn string, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config
 	// struct.
-	config, err := pgxpool.ParseConfig(cfg.DSN())
+	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -89,33 +428,30 @@ func openDB(cfg Config) (*pgxpool.Pool, error) {
 
 	config.MinConns = 2
 
+	config.ConnConfig.Tracer = tracer
+	if cfg.db.execMode != 0 {
+		config.ConnConfig.DefaultQueryExecMode = cfg.db.execMode
+	}
+
+	return pgxpool.NewWithConfig(context.Background(), config)
+}
+
+// pingDB establishes a connection to the database, giving up after 5 seconds.
+func pingDB(pool *pgxpool.Pool) error {
 	// Create a context with a 5-second timeout deadline.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	pool, err := pgxpool.NewWithConfig(ctx, config)
-	if err != nil {
-		return nil, err
-	}
-
-	// Use PingContext() to establish a new connection to the database, passing in the
-	// context we created above as a parameter. If the connection couldn't be
-	// established successfully within the 5 second deadline, then this will return an
-	// error. If we get this error, or any other, we close the connection pool and
-	// return the error.
-	err = pool.Ping(ctx)
-	if err != nil {
-		pool.Close()
-		return nil, err
-	}
-
-	return pool, nil
+	return pool.Ping(ctx)
 }
 
-func initializeMetrics(db *pgxpool.Pool) {
+func initializeMetrics(db *pgxpool.Pool, tracer *repository.QueryTracer) {
 	// Publish a new "version" variable in the expvar handler containing our application
-	// version number (currently the constant "1.0.0").
+	// version number, and the "build" variable with the rest of the build info.
 	expvar.NewString("version").Set(version)
+	expvar.Publish("build", expvar.Func(func() any {
+		return vcs.Info()
+	}))
 
 	// Publish the number of active goroutines.
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -155,6 +491,11 @@ func initializeMetrics(db *pgxpool.Pool) {
 		return stats
 	}))
 
+	// Publish the number of queries slower than the slow query threshold.
+	expvar.Publish("total_slow_queries", expvar.Func(func() any {
+		return tracer.SlowQueries()
+	}))
+
 	// Publish the current Unix timestamp.
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"expvar"
-	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/reporting"
 	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/telemetry"
 	"github.com/ziliscite/purplelight/internal/validator"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,13 +38,18 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				w.Header().Set("Connection", "close")
 
 				// The value returned by recover() has the type any, so we use
-				// fmt.Errorf() to normalize it into an error.
+				// reporting.NewPanicError() to normalize it into an error, which keeps
+				// the stack trace of the panic for the error report.
 				// This will log the error using our custom Logger type at the ERROR level
 				// and send the client a 500 Internal Server Error response.
-				app.serverError(w, r, fmt.Errorf("%s", err))
+				app.serverError(w, r, reporting.NewPanicError(err))
 			}
 		}()
 
+		// Give the request a reporting scope, so that the user it is made by can be
+		// reported along with a panic, even though authenticate() runs further down.
+		r = r.WithContext(reporting.WithScope(r.Context()))
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -43,49 +57,15 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 // The rateLimit() middleware is a global rate limiter.
 // It ensures that all requests are not made too frequently.
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu sync.Mutex
-		// Update the map so the values are pointers to a client struct.
-		// can the in-memory database changed to redis?
-		clients = make(map[string]*client)
-	)
-
-	// Create a ticker which will tick every 60 seconds.
-	// This will be used to check whether a client has exceeded their rate limit.
-	ticker := time.NewTicker(60 * time.Second)
-
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
-		// Range over the map every minute.
-		for range ticker.C {
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-
-			// Importantly, unlock the mutex when the cleanup is complete.
-			mu.Unlock()
-		}
-	}()
+	// Keep a rate limiter for each client.
+	// can the in-memory database changed to redis?
+	limiter := app.rateLimiterFor("")
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
+		// Only carry out the check if rate limiting is enabled. The settings are read on
+		// every request, as reloading the configuration may change them.
+		live := app.live.get()
+		if live.LimiterEnabled {
 			// Get the IP address of the current request.
 			// If it's not in the map, then we know that it's a new client.
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -94,49 +74,144 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				return
 			}
 
-			// Lock the mutex to prevent this code from being executed concurrently.
-			mu.Lock()
-
-			// Check to see if the IP address already exists in the map. If it doesn't, then
-			// initialize a new rate limiter and add the IP address and limiter to the map.
-			if _, found := clients[ip]; !found {
-				// Create and add a new client struct to the map if it doesn't already exist.
-				// Initialize a new rate limiter which allows an average of 3 requests per second,
-				// with a maximum of 6 requests in a single ‘burst’.
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			// When anonymous reads are allowed, requests without credentials are counted
+			// in a separate, stricter bucket for the same IP address.
+			key, rps, burst := ip, live.LimiterRPS, live.LimiterBurst
+			if app.config.anonymous.read && r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "" {
+				key, rps, burst = "anonymous:"+ip, app.config.anonymous.rps, app.config.anonymous.burst
 			}
 
-			// Update the last seen time for the client.
-			clients[ip].lastSeen = time.Now()
-
-			// Call limiter.Allow() to see if the request is permitted, and if it's not,
-			// then we call the rateLimitExceededResponse() helper to return a 429 Too Many
-			// Requests response (we will create this helper in a minute).
-			//
-			// limiter.Allow() automatically keeps track of the rate limit for the client by incrementing a counter.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			// If the request isn't permitted, then we call the rateLimitExceededResponse()
+			// helper to return a 429 Too Many Requests response.
+			if !limiter.Allow(key, rps, burst) {
 				app.rateLimitExceeded(w, r)
 				return
 			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
 
-			// Very importantly, unlock the mutex before calling the next handler in the
-			// chain. Notice that we `DON'T` use defer to unlock the mutex, as that would mean
-			// that the mutex isn't unlocked until all the handlers downstream of this
-			// middleware have also returned.
-			mu.Unlock()
+// The limitConcurrency() middleware caps the number of requests being handled at once,
+// so that a traffic spike queues up on the clients rather than on the database
+// connection pool. Requests over the cap get a 503 response straight away, telling the
+// client when to retry.
+func (app *application) limitConcurrency(next http.Handler) http.Handler {
+	maxInFlight := app.config.concurrency.maxInFlight
+	if maxInFlight <= 0 {
+		return next
+	}
+
+	// Use a buffered channel as a semaphore: a request holds a slot for as long as it
+	// is being handled.
+	slots := make(chan struct{}, maxInFlight)
+
+	var (
+		requestsInFlight      = expvar.NewInt("requests_in_flight")
+		totalRequestsRejected = expvar.NewInt("total_requests_rejected_busy")
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSockets stay open for as long as their users are connected, so they are
+		// capped by the notification hub instead.
+		if isWebSocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			totalRequestsRejected.Add(1)
+			app.serverBusy(w, r, app.config.concurrency.retryAfter)
+			return
 		}
 
+		requestsInFlight.Add(1)
+		defer func() {
+			requestsInFlight.Add(-1)
+			<-slots
+		}()
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// longRunningRoutes are the path prefixes of the routes that may legitimately take
+// longer than the request timeout, such as streaming the whole catalog.
+var longRunningRoutes = []string{
+	"/v1/anime/export",
+	"/v1/anime/import",
+	"/v1/admin/import/",
+	"/v1/users/me/notifications",
+	pprofPrefix,
+}
+
+// The timeout() middleware gives every request a deadline, through its context, so
+// that the database calls made while handling it are canceled once it passes. The
+// handlers then respond with requestTimedOut(), through serverError(); a handler that
+// gave up without writing anything gets the same response from here.
+func (app *application) timeout(next http.Handler) http.Handler {
+	d := app.config.requestTimeout
+	if d <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range longRunningRoutes {
+			if strings.HasPrefix(v1Path(r.URL.Path), prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		if !mw.headerWritten && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			app.requestTimedOut(w, r, ctx.Err())
+		}
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// Machine clients authenticate with an api key in the X-API-Key header instead
+		// of a bearer token.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			v := validator.New()
+
+			if data.ValidateAPIKeyPlaintext(v, apiKey); !v.Valid() {
+				app.invalidAPIKey(w, r)
+				return
+			}
+
+			user, key, err := app.repos.APIKey.GetForKey(r.Context(), apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, repository.ErrRecordNotFound):
+					app.invalidAPIKey(w, r)
+				default:
+					app.serverError(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Retrieve the value of the Authorization header from the request. This will
 		// return the empty string "" if there is no such header found.
@@ -146,7 +221,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// that we just made to add the AnonymousUser to the request context. Then we
 		// call the next handler in the chain and return without executing any of the
 		// code below.
-		if authorizationHeader == "" {
+		if authorizationHeader == "" && webSocketToken(r) == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
@@ -157,14 +232,31 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// header isn't in the expected format we return a 401 Unauthorized response
 		// using the invalidAuthenticationTokenResponse() helper (which we will create
 		// in a moment).
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		token, ok := app.readBearerToken(r)
+		stripWebSocketToken(r)
+		if !ok {
 			app.invalidAuthenticationToken(w, r)
 			return
 		}
 
-		// Extract the actual authentication token from the header parts.
-		token := headerParts[1]
+		// In JWT mode the token is verified locally, and the database is only consulted
+		// to check whether the token has been revoked.
+		if app.config.auth.mode == authModeJWT {
+			user, err := app.userFromJWT(r.Context(), token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrInvalidJWT):
+					app.invalidAuthenticationToken(w, r)
+				default:
+					app.serverError(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
@@ -181,10 +273,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.repos.User.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.repos.User.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, repository.ErrRecordNotFound):
+				app.logAuthenticationFailure(r, token)
 				app.invalidAuthenticationToken(w, r)
 			default:
 				app.serverError(w, r, err)
@@ -192,6 +285,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Record the session activity. This is best effort, so a failure is only logged.
+		if err := app.repos.Token.Touch(r.Context(), token); err != nil {
+			app.logError(r, err)
+		}
+
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
@@ -268,7 +366,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		user := app.contextGetUser(r)
 
 		// Get the slice of permissions for the user.
-		permissions, err := app.repos.Permission.GetAllForUser(user.ID)
+		permissions, err := app.repos.Permission.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverError(w, r, err)
 			return
@@ -281,13 +379,45 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			return
 		}
 
+		// Requests made with an api key are further restricted to the permissions
+		// that were granted to that key.
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermitted(w, r)
+			return
+		}
+
 		// Otherwise they have the required permission so we call the next handler in
 		// the chain.
 		next.ServeHTTP(w, r)
 	}
 
-	// Wrap this with the requireActivatedUser() middleware before returning it.
-	return app.requireActivatedUser(fn)
+	// Wrap this with the requireActivatedUser() middleware.
+	protected := app.requireActivatedUser(fn)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// If anonymous reads are enabled, the read-only catalog endpoints are public.
+		// Writes always go through the full permission check.
+		if app.config.anonymous.read && isPublicRead(code, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		protected.ServeHTTP(w, r)
+	}
+}
+
+// publicReadPermissions lists the permission codes that guard read-only endpoints
+// which can be opened up to anonymous clients.
+var publicReadPermissions = []string{data.PermissionAnimeRead}
+
+// isPublicRead reports whether a request for the given permission code is a safe,
+// read-only request that may be served without authentication.
+func isPublicRead(code string, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	return slices.Contains(publicReadPermissions, code)
 }
 
 func (app *application) enableAllCORS(next http.Handler) http.Handler {
@@ -298,50 +428,62 @@ func (app *application) enableAllCORS(next http.Handler) http.Handler {
 	})
 }
 
+// The enableCORS() middleware lets the trusted origins call the API from a browser. It
+// answers the preflight requests itself, with the methods and request headers the
+// configuration allows, and exposes the configured response headers to the actual
+// requests. A trusted origin of "*" trusts any origin.
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	// The header values only depend on the configuration, so they are built once.
+	cors := app.config.cors
+	allowedMethods := strings.Join(cors.allowedMethods, ", ")
+	allowedHeaders := strings.Join(cors.allowedHeaders, ", ")
+	exposedHeaders := strings.Join(cors.exposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cors.maxAge.Seconds()))
+	anyOrigin := slices.Contains(cors.trustedOrigins, "*")
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Add the "Vary: Origin" header.
+		// The response depends on these request headers, so caches are told about them
+		// with the Vary header.
 		w.Header().Add("Vary", "Origin")
-
-		// Add the "Vary: Access-Control-Request-Method" header.
 		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
 
-		// Get the value of the request's Origin header.
+		// Get the value of the request's Origin header. Requests without one, or from an
+		// origin we don't trust, are passed on without any CORS header, which makes
+		// browsers block them.
 		origin := r.Header.Get("Origin")
+		if origin == "" || !(anyOrigin || slices.Contains(cors.trustedOrigins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Only run this if there's an Origin request header present.
-		if origin != "" {
-			// Loop through the list of trusted origins, checking to see if the request
-			// origin exactly matches one of them. If there are no trusted origins, then
-			// the loop won't be iterated.
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					// If there is a match, then set a "Access-Control-Allow-Origin"
-					// response header with the request origin as the value and break
-					// out of the loop.
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-
-					// Check if the request has the HTTP method OPTIONS and contains the
-					// "Access-Control-Request-Method" header. If it does, then we treat
-					// it as a preflight request.
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						// Set the necessary preflight response headers, as discussed
-						// previously.
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-						// Set the maximum age of the preflight request cache to 300 seconds.
-						w.Header().Set("Access-Control-Max-Age", "300")
-
-						// Write the headers along with a 200 OK status and return from
-						// the middleware with no further action.
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-
-					break
-				}
+		// The origin is sent back even when any origin is trusted, as browsers reject
+		// "*" for the requests made with credentials.
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Check if the request has the HTTP method OPTIONS and contains the
+		// "Access-Control-Request-Method" header. If it does, then we treat it as a
+		// preflight request, such as the one sent ahead of a PATCH or DELETE with an
+		// Authorization header. Browsers check the method and headers they are about to
+		// send against the lists themselves.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			if cors.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
 			}
+
+			// Write the headers along with a 200 OK status and return from the
+			// middleware with no further action.
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 		}
 
 		// Call the next handler in the chain.
@@ -384,7 +526,9 @@ func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
 	return mw.wrapped
 }
 
-func (app *application) metrics(next http.Handler) http.Handler {
+// The metrics() middleware keeps the metrics of the responses sent, as a whole and per
+// route. pattern names the route of a request.
+func (app *application) metrics(pattern func(r *http.Request) string, next http.Handler) http.Handler {
 	var (
 		totalRequestsReceived           = expvar.NewInt("total_requests_received")
 		totalResponsesSent              = expvar.NewInt("total_responses_sent")
@@ -393,8 +537,30 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		// Declare a new expvar map to hold the count of responses for each HTTP status
 		// code.
 		totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+
+		// And another one holding the metrics of each route, keyed by route pattern.
+		routes   = expvar.NewMap("routes")
+		routesMu sync.Mutex
 	)
 
+	// routeMetricsFor returns the metrics of a route, adding them on its first request.
+	routeMetricsFor := func(pattern string) *routeMetrics {
+		if m, ok := routes.Get(pattern).(*routeMetrics); ok {
+			return m
+		}
+
+		routesMu.Lock()
+		defer routesMu.Unlock()
+
+		if m, ok := routes.Get(pattern).(*routeMetrics); ok {
+			return m
+		}
+
+		m := newRouteMetrics()
+		routes.Set(pattern, m)
+		return m
+	}
+
 	// The following code will be run for every request
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Record the time that we started to process the request.
@@ -425,8 +591,10 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		// Calculate the number of microseconds since we began to process the request,
 		// then increment the total processing time by this amount.
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		routeMetricsFor(pattern(r)).observe(mw.statusCode, duration)
 	})
 }
 
@@ -435,7 +603,7 @@ func (app *application) logging(next http.Handler) http.Handler {
 		mw := newMetricsResponseWriter(w)
 
 		defer func() {
-			app.logger.Info("debugging info",
+			app.logger.InfoContext(r.Context(), "debugging info",
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", mw.statusCode,
@@ -445,3 +613,67 @@ func (app *application) logging(next http.Handler) http.Handler {
 		next.ServeHTTP(mw, r)
 	})
 }
+
+// The trace() middleware starts a span for every request, as a child of the trace the
+// caller sent in the traceparent header, if any. It comes first in the chain so that the
+// span covers the whole request, and so that the queries, mails and background tasks of
+// the request are recorded under it.
+func (app *application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := telemetry.Tracer().Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(mw.statusCode))
+		if mw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(mw.statusCode))
+		}
+	})
+}
+
+// The requestID() middleware gives every request an ID, which is put in the request
+// context and sent back in the X-Request-ID header. An ID sent by the client, such as
+// one set by a load balancer, is kept, so that the request can be followed from end to
+// end; one that isn't a reasonable header value is replaced.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !telemetry.ValidRequestID(id) {
+			id = telemetry.NewRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request.id", id))
+
+		next.ServeHTTP(w, r.WithContext(telemetry.WithRequestID(r.Context(), id)))
+	})
+}
+
+// The headAsGet() middleware serves HEAD requests with the GET route of their path, as
+// httprouter only matches the method a route was registered with (the ServeMux in front
+// of it already does this for its own routes). The handler sees a GET and writes its
+// response as usual; net/http drops the body of the response to a HEAD request, and
+// keeps the headers, such as Content-Length and ETag.
+func (app *application) headAsGet(router *httprouter.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			if handle, _, _ := router.Lookup(http.MethodHead, r.URL.Path); handle == nil {
+				r = r.Clone(r.Context())
+				r.Method = http.MethodGet
+			}
+		}
+
+		router.ServeHTTP(w, r)
+	})
+}
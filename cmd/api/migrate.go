@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ziliscite/purplelight/internal/migrate"
+	"github.com/ziliscite/purplelight/migrations"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// runMigrate runs the migrate command with its arguments: up, down [N] or status.
+func (app *application) runMigrate(args []string) error {
+	ctx, logger := context.Background(), app.logger
+
+	m, err := migrate.New(app.db, migrations.FS, logger)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New("missing migrate command, must be up, down or status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("database migrated", "applied", applied)
+
+	case "down":
+		// Revert a single migration unless told otherwise, as reverting drops data.
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to revert %q, must be a positive integer", args[1])
+			}
+		}
+
+		reverted, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("database migrations reverted", "reverted", reverted)
+
+	case "status":
+		version, statuses, err := m.Status(ctx)
+		if err != nil && !errors.Is(err, migrate.ErrDirty) {
+			return err
+		}
+
+		fmt.Printf("Database version: %d", version)
+		if err != nil {
+			fmt.Print(" (dirty)")
+		}
+		fmt.Println()
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			fmt.Fprintf(tw, "%06d\t%s\t%t\n", s.Version, s.Name, s.Applied)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, must be up, down or status", args[0])
+	}
+
+	return nil
+}
+
+// autoMigrate applies the pending migrations before the server starts, for -auto-migrate.
+// The instances started at once take turns, and the ones after the first find nothing
+// left to apply.
+func autoMigrate(ctx context.Context, db *pgxpool.Pool, logger *slog.Logger) error {
+	m, err := migrate.New(db, migrations.FS, logger)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		logger.Info("database migrated", "applied", applied)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The media types the API reads and writes. JSON is the default; MessagePack is sent to
+// the clients asking for it in their Accept header, and read from the request bodies
+// sent with its Content-Type.
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeMsgpack = "application/msgpack"
+)
+
+// isMsgpack reports whether a media type is MessagePack, under its registered name or
+// the older x- one.
+func isMsgpack(mediaType string) bool {
+	return mediaType == mediaTypeMsgpack || mediaType == "application/x-msgpack"
+}
+
+// negotiate returns the media type of the response to r: MessagePack if the Accept
+// header prefers it to JSON, JSON otherwise. A tie goes to the type listed first.
+func negotiate(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return mediaTypeJSON
+	}
+
+	msgpackQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case isMsgpack(mediaType) && msgpackQ < 0:
+			msgpackQ = q
+		case mediaType == mediaTypeJSON && jsonQ < 0:
+			jsonQ = q
+			if msgpackQ < 0 {
+				// JSON is listed first, so MessagePack has to be strictly preferred.
+				jsonQ += 1e-9
+			}
+		}
+	}
+
+	if msgpackQ > 0 && msgpackQ > jsonQ {
+		return mediaTypeMsgpack
+	}
+
+	return mediaTypeJSON
+}
+
+// jsonToMsgpack converts a JSON document to MessagePack. Going through JSON, rather than
+// encoding the values with msgpack directly, keeps both formats identical: the custom
+// JSON marshalers, such as the one of the runtime, and the sparse fieldsets apply to
+// MessagePack too. Integers stay integers rather than becoming floats.
+func jsonToMsgpack(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(fromJSONNumbers(v))
+}
+
+// fromJSONNumbers replaces the json.Numbers in a decoded JSON value by int64s, or
+// float64s for the numbers that aren't integers.
+func fromJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = fromJSONNumbers(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = fromJSONNumbers(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// errBadlyFormattedMsgpack is returned for request bodies that aren't a single valid
+// MessagePack value.
+var errBadlyFormattedMsgpack = errors.New("body contains badly-formed MessagePack")
+
+// msgpackToJSON converts a MessagePack request body to JSON, so that it is decoded and
+// checked the same way as a JSON one. Errors reading the body, such as it being too
+// large, are returned as they are.
+func msgpackToJSON(body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(raw))
+
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(raw) == 0 {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, errBadlyFormattedMsgpack
+	}
+
+	if _, err := dec.DecodeInterface(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("body must only contain a single MessagePack value")
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		// Maps with keys other than strings have no JSON equivalent.
+		return nil, errBadlyFormattedMsgpack
+	}
+
+	return js, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/notify"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notificationWriteWait is how long a write to a notification WebSocket may take before
+// the client is considered gone.
+const notificationWriteWait = 10 * time.Second
+
+// closeGoingAway is the WebSocket close code sent to the clients when the server shuts
+// down, telling them to reconnect later.
+const closeGoingAway = 1001
+
+// activationReminder is the data of an activation_reminder notification. PurgeAt is
+// when the account is purged if it still isn't activated, if ever.
+type activationReminder struct {
+	Message string     `json:"message"`
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+}
+
+// newEpisodes is the data of a new_episodes notification.
+type newEpisodes struct {
+	AnimeID          int32  `json:"anime_id"`
+	Title            string `json:"title"`
+	Episodes         int32  `json:"episodes"`
+	PreviousEpisodes int32  `json:"previous_episodes"`
+}
+
+// isWebSocket reports whether r asks for its connection to be upgraded to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// webSocketHijacker lets websocket.Server take over the connection through the response
+// writers the middlewares wrap it in, which it expects to implement http.Hijacker
+// themselves.
+type webSocketHijacker struct {
+	http.ResponseWriter
+}
+
+func (h webSocketHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// The notificationsSocket() handler upgrades the connection to a WebSocket sending the
+// user their notifications, as JSON messages, until either side closes it. Browsers
+// can't set the Authorization header of a WebSocket, so they send their token in the
+// access_token query string parameter instead; see authenticate().
+func (app *application) notificationsSocket(w http.ResponseWriter, r *http.Request) {
+	if !isWebSocket(r) {
+		w.Header().Set("Upgrade", "websocket")
+		app.error(w, r, http.StatusUpgradeRequired, "this resource must be requested as a websocket")
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	client, err := app.notifications.Register(user.ID)
+	if err != nil {
+		// The hub is full, the user has as many connections as they may, or the hub is
+		// shutting down: either way, the client should retry later.
+		app.serverBusy(w, r, app.config.notifications.pingInterval)
+		return
+	}
+	defer app.notifications.Unregister(client)
+
+	// The origin of the handshake isn't checked, as the WebSocket is authenticated with
+	// a token, which other sites can't make a browser send, rather than a cookie.
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			app.serveNotifications(ws, client, user)
+		},
+	}
+	server.ServeHTTP(webSocketHijacker{w}, r)
+}
+
+// serveNotifications sends the notifications of a client over its WebSocket, pinging it
+// in between, until the client goes away or is dropped by the hub.
+func (app *application) serveNotifications(ws *websocket.Conn, client *notify.Client, user *data.User) {
+	// Nothing is expected from the client, so its messages are discarded: reading only
+	// serves to answer its pings, and to notice when it goes away. The connection keeps
+	// the read deadline the server set for the handshake, which would drop it after
+	// ReadTimeout, so it is cleared: a client gone quietly is noticed by the pings.
+	ws.SetReadDeadline(time.Time{})
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+
+		buf := make([]byte, 512)
+		for {
+			if _, err := ws.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(n notify.Notification) error {
+		ws.SetWriteDeadline(time.Now().Add(notificationWriteWait))
+		return websocket.JSON.Send(ws, n)
+	}
+
+	ping := func() error {
+		ws.SetWriteDeadline(time.Now().Add(notificationWriteWait))
+		ws.PayloadType = websocket.PingFrame
+		_, err := ws.Write(nil)
+		return err
+	}
+
+	// The users yet to activate their account are reminded of it as soon as they connect.
+	if !user.Activated {
+		if err := send(app.activationReminder(user)); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(app.config.notifications.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case n := <-client.Notifications():
+			if err := send(n); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return
+			}
+		case <-client.Done():
+			// The client is dropped for falling behind, or the server is shutting down.
+			ws.SetWriteDeadline(time.Now().Add(notificationWriteWait))
+			ws.WriteClose(closeGoingAway)
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
+// activationReminder returns the notification reminding a user to activate their
+// account, with the date it's purged at unless they do.
+func (app *application) activationReminder(user *data.User) notify.Notification {
+	reminder := activationReminder{
+		Message: "please activate your account, using the token sent to your email address or a new one from POST /v1/tokens/activation",
+	}
+
+	if ttl := app.config.accounts.unactivatedTTL; ttl > 0 {
+		purgeAt := user.CreatedAt.Add(ttl).UTC()
+		reminder.PurgeAt = &purgeAt
+	}
+
+	return notify.New(notify.TypeActivationReminder, reminder)
+}
+
+// The remindActivation() job reminds the users connected with an account still to be
+// activated to activate it. Their accounts are read again, as they may have been
+// activated since they connected.
+func (app *application) remindActivation(ctx context.Context) error {
+	for _, id := range app.notifications.UserIDs() {
+		user, err := app.repos.User.Get(ctx, id)
+		if err != nil {
+			// The account may have been deleted since the user connected.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		if !user.Activated {
+			app.notifications.Notify(user.ID, app.activationReminder(user))
+		}
+	}
+
+	return nil
+}
+
+// notifyNewEpisodes tells the users with an anime on their watchlist, and connected,
+// when an update gives it more episodes. The watchlists are read in the background, so
+// that the update doesn't wait for it.
+func (app *application) notifyNewEpisodes(ctx context.Context, before, after *data.Anime) {
+	previous := deref(before.Episodes)
+	if after.Episodes == nil || *after.Episodes <= previous || app.notifications.Len() == 0 {
+		return
+	}
+
+	n := notify.New(notify.TypeNewEpisodes, newEpisodes{
+		AnimeID:          after.ID,
+		Title:            after.Title,
+		Episodes:         *after.Episodes,
+		PreviousEpisodes: previous,
+	})
+
+	app.background(ctx, "notifyNewEpisodes", func(ctx context.Context) {
+		ids, err := app.repos.Watchlist.GetWatcherIDs(ctx, after.ID)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "failed to notify new episodes", "anime_id", after.ID, "error", err.Error())
+			return
+		}
+
+		for _, id := range ids {
+			app.notifications.Notify(id, n)
+		}
+	})
+}
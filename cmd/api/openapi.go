@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/graphql"
+	"github.com/ziliscite/purplelight/internal/jsonpatch"
+	"github.com/ziliscite/purplelight/internal/notify"
+	"github.com/ziliscite/purplelight/internal/openapi"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The routes open to any user rather than requiring a permission, by what they require
+// of the user.
+const (
+	authAuthenticated = "authenticated"
+	authActivated     = "activated"
+)
+
+// apiOperation describes a route of the API for the OpenAPI document. The request and
+// response are samples of what the route reads and writes, from which the schemas are
+// derived; an *openapi.Schema is used as it is, for the bodies that aren't JSON.
+type apiOperation struct {
+	method string
+	path   string
+	tag    string
+	// summary is the one line description of the route.
+	summary string
+	// auth is the permission the route requires, authAuthenticated or authActivated for
+	// the ones open to any user, or empty for the public ones.
+	auth  string
+	query []*openapi.Parameter
+	// request is the body the route reads, nil if it reads none. requestTypes are its
+	// media types, JSON and MessagePack if empty.
+	request      any
+	requestTypes []string
+	// jsonPatch is set on the routes reading a JSON Patch document as well, under its
+	// own media type.
+	jsonPatch bool
+	// status is the status of a successful response, and response its body. Its media
+	// types are responseTypes, JSON and MessagePack if empty.
+	status        int
+	response      any
+	responseTypes []string
+}
+
+// messageResponse is the response of the routes which only confirm what they did.
+var messageResponse = envelope{"message": "a confirmation message"}
+
+// pageParams returns the query parameters of a paginated listing, sortable by sorts.
+func pageParams(sorts ...string) []*openapi.Parameter {
+	params := []*openapi.Parameter{
+		queryParam("page", "integer", "The page to return, starting at 1."),
+		queryParam("page_size", "integer", "The number of items per page, at most 100."),
+	}
+	if len(sorts) > 0 {
+		params = append(params, queryEnum("sort", "The sort order, descending with a leading -.", sorts...))
+	}
+
+	return params
+}
+
+func queryParam(name, typ, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+func queryEnum(name, description string, values ...string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string", Enum: values}}
+}
+
+// apiOperations lists every route of the API. A route added to routes() should be
+// described here too.
+func apiOperations() []apiOperation {
+	fields := queryParam("fields", "string", "A comma-separated list of the fields to return, out of "+strings.Join(animeFields, ", ")+".")
+
+	return []apiOperation{
+		{method: http.MethodGet, path: "/v1/healthcheck", tag: "system", summary: "Report the status of the API and, with deep=true, of its dependencies",
+			query:  []*openapi.Parameter{queryParam("deep", "boolean", "Probe the dependencies too.")},
+			status: http.StatusOK, response: envelope{"status": "", "system_info": struct {
+				Environment string `json:"environment"`
+				Version     string `json:"version"`
+			}{}, "dependencies": map[string]probeResult{}}},
+		{method: http.MethodGet, path: "/v1/openapi.json", tag: "system", summary: "Get this document",
+			status: http.StatusOK, response: &openapi.Schema{Type: "object"}, responseTypes: []string{mediaTypeJSON}},
+		{method: http.MethodPost, path: "/v1/graphql", tag: "graphql", summary: "Run a GraphQL query or mutation, whose fields require the permissions of the matching routes",
+			request: graphql.Request{}, status: http.StatusOK, response: &openapi.Schema{Type: "object"}, responseTypes: []string{mediaTypeJSON}},
+		{method: http.MethodGet, path: "/v1/graphql/schema", tag: "graphql", summary: "Get the GraphQL schema, in the schema definition language",
+			status: http.StatusOK, response: &openapi.Schema{Type: "string"}, responseTypes: []string{"text/plain"}},
+		{method: http.MethodGet, path: "/v1/metrics", tag: "system", summary: "Get the application metrics", auth: data.PermissionMetricsRead,
+			status: http.StatusOK, response: &openapi.Schema{Type: "object"}, responseTypes: []string{mediaTypeJSON}},
+
+		{method: http.MethodGet, path: "/v1/anime", tag: "anime", summary: "List and search anime", auth: data.PermissionAnimeRead,
+			query: append([]*openapi.Parameter{
+				queryParam("q", "string", "Search the titles, the alternative titles, the tags and the synopses at once, ranking the matches on the title highest. Supports \"quoted phrases\", or, and -excluded words. Sorts by relevance by default."),
+				queryParam("title", "string", "Search the titles, including the alternative ones."),
+				queryParam("synopsis", "string", "Search the synopses."),
+				queryEnum("match", "How the title is matched.", data.MatchWords, data.MatchFuzzy),
+				queryParam("tags", "string", "A comma-separated list of tags."),
+				queryEnum("tags_match", "Whether the anime must have all the tags or any of them.", data.TagsMatchAll, data.TagsMatchAny),
+				queryParam("studio", "string", "The name of a studio."),
+				queryEnum("status", "", string(data.Ongoing), string(data.Finished), string(data.Upcoming)),
+				queryEnum("season", "", string(data.Spring), string(data.Summer), string(data.Fall), string(data.Winter)),
+				queryEnum("anime_type", "", string(data.TV), string(data.Movie), string(data.OVA), string(data.ONA), string(data.Special)),
+				queryParam("year_min", "integer", ""),
+				queryParam("year_max", "integer", ""),
+				queryParam("episodes_min", "integer", ""),
+				queryParam("episodes_max", "integer", ""),
+				queryParam("after", "string", "Switch to keyset pagination, continuing from a cursor of the metadata of a previous page. Empty for the first page."),
+				{Name: "updated_since", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"},
+					Description: "Only list the anime changed after this time, with the deleted ones as tombstones. Pass the synced_at of the metadata of the previous sync; the anime changed around it may be listed again, and are merged by id and version."},
+				fields,
+			}, pageParams("id", "title", "year", "episodes", "-id", "-title", "-year", "-episodes", data.SortRelevance)...),
+			status: http.StatusOK, response: envelope{"anime": []*data.Anime{}, "metadata": data.Metadata{}}},
+		{method: http.MethodPost, path: "/v1/anime", tag: "anime", summary: "Create an anime", auth: data.PermissionAnimeWrite,
+			request: animeRequest{}, status: http.StatusCreated, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodGet, path: "/v1/anime/{id}", tag: "anime", summary: "Get an anime", auth: data.PermissionAnimeRead,
+			query: []*openapi.Parameter{fields}, status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodPut, path: "/v1/anime/{id}", tag: "anime", summary: "Replace an anime", auth: data.PermissionAnimeWrite,
+			request: animeRequest{}, status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodPatch, path: "/v1/anime/{id}", tag: "anime", summary: "Update some fields of an anime, or apply a JSON Patch to it", auth: data.PermissionAnimeWrite,
+			request: animeRequest{}, jsonPatch: true, status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodDelete, path: "/v1/anime/{id}", tag: "anime", summary: "Delete an anime, which can be restored", auth: data.PermissionAnimeWrite,
+			status: http.StatusOK, response: messageResponse},
+		{method: http.MethodPost, path: "/v1/anime/{id}/restore", tag: "anime", summary: "Restore a deleted anime", auth: data.PermissionAnimeWrite,
+			status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodPut, path: "/v1/anime/{id}/cover", tag: "anime", summary: "Upload the cover of an anime", auth: data.PermissionAnimeWrite,
+			request: &openapi.Schema{Type: "object", Required: []string{"cover"}, Properties: map[string]*openapi.Schema{
+				"cover": {Type: "string", Format: "binary", Description: "A JPEG, PNG or WebP image."},
+			}}, requestTypes: []string{"multipart/form-data"},
+			status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodGet, path: "/v1/anime/{id}/similar", tag: "anime", summary: "List the anime similar to an anime", auth: data.PermissionAnimeRead,
+			query:  []*openapi.Parameter{queryParam("limit", "integer", "The number of anime to return.")},
+			status: http.StatusOK, response: envelope{"similar": []*data.SimilarAnime{}}},
+		{method: http.MethodGet, path: "/v1/anime/{id}/revisions", tag: "anime", summary: "List the revisions of an anime", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"revisions": []*data.AnimeRevision{}}},
+		{method: http.MethodGet, path: "/v1/anime/{id}/revisions/{version}", tag: "anime", summary: "Get a revision of an anime", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"revision": &data.AnimeRevision{}}},
+		{method: http.MethodGet, path: "/v1/anime/{id}/characters", tag: "characters", summary: "List the characters of an anime", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"characters": []*data.CastMember{}}},
+		{method: http.MethodPut, path: "/v1/anime/{id}/characters/{character_id}", tag: "characters", summary: "Add a character to an anime, or update its role and voice actors", auth: data.PermissionAnimeWrite,
+			request: castRequest{}, status: http.StatusOK, response: envelope{"characters": []*data.CastMember{}}},
+		{method: http.MethodDelete, path: "/v1/anime/{id}/characters/{character_id}", tag: "characters", summary: "Remove a character from an anime", auth: data.PermissionAnimeWrite,
+			status: http.StatusOK, response: messageResponse},
+		{method: http.MethodGet, path: "/v1/anime/external/{source}/{id}", tag: "anime", summary: "Get an anime by its ID on an external source", auth: data.PermissionAnimeRead,
+			query: []*openapi.Parameter{fields}, status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodGet, path: "/v1/anime/slug/{slug}", tag: "anime", summary: "Get an anime by its slug", auth: data.PermissionAnimeRead,
+			query: []*openapi.Parameter{fields}, status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodGet, path: "/v1/anime/trending", tag: "anime", summary: "List the trending anime", auth: data.PermissionAnimeRead,
+			query: pageParams(), status: http.StatusOK, response: envelope{"trending": []*data.TrendingAnime{}, "metadata": data.Metadata{}}},
+		{method: http.MethodGet, path: "/v1/anime/stats", tag: "anime", summary: "Get statistics about the catalog", auth: data.PermissionAnimeRead,
+			query:  []*openapi.Parameter{queryParam("tags", "integer", "The number of most used tags to return.")},
+			status: http.StatusOK, response: envelope{"stats": data.CatalogStats{}}},
+		{method: http.MethodPost, path: "/v1/anime/import", tag: "anime", summary: "Import anime in bulk", auth: data.PermissionAnimeWrite,
+			request:      &openapi.Schema{Type: "string", Description: "One anime per line, in the shape of the body of POST /v1/anime, or as CSV with a header row."},
+			requestTypes: []string{contentTypeNDJSON, contentTypeCSV},
+			status:       http.StatusOK, response: envelope{"report": importReport{}}},
+		{method: http.MethodGet, path: "/v1/anime/export", tag: "anime", summary: "Export every anime", auth: data.PermissionAnimeWrite,
+			query:  []*openapi.Parameter{queryEnum("format", "", "ndjson", "csv")},
+			status: http.StatusOK, response: &openapi.Schema{Type: "string"}, responseTypes: []string{contentTypeNDJSON, contentTypeCSV}},
+
+		{method: http.MethodGet, path: "/v1/tags", tag: "tags", summary: "List the tags", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"tags": []string{}}},
+		{method: http.MethodPost, path: "/v1/tags", tag: "tags", summary: "Create a tag", auth: data.PermissionTagsWrite,
+			request: tagRequest{}, status: http.StatusCreated, response: envelope{"tag": &data.Tag{}}},
+		{method: http.MethodPost, path: "/v1/tags/merge", tag: "tags", summary: "Merge a tag into another", auth: data.PermissionTagsWrite,
+			request: struct {
+				Source string `json:"source"`
+				Target string `json:"target"`
+			}{}, status: http.StatusOK, response: envelope{"tag": &data.Tag{}, "merged": &data.Tag{}, "anime_count": int64(0)}},
+		{method: http.MethodPatch, path: "/v1/tags/{name}", tag: "tags", summary: "Rename a tag", auth: data.PermissionTagsWrite,
+			request: tagRequest{}, status: http.StatusOK, response: envelope{"tag": &data.Tag{}}},
+		{method: http.MethodDelete, path: "/v1/tags/{name}", tag: "tags", summary: "Delete a tag", auth: data.PermissionTagsWrite,
+			query:  []*openapi.Parameter{queryParam("force", "boolean", "Delete the tag even if anime are tagged with it, unless it is the only tag of one of them.")},
+			status: http.StatusOK, response: messageResponse},
+
+		{method: http.MethodGet, path: "/v1/studios", tag: "studios", summary: "List the studios", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"studios": []string{}}},
+		{method: http.MethodPost, path: "/v1/studios", tag: "studios", summary: "Create a studio", auth: data.PermissionAnimeWrite,
+			request: studioRequest{}, status: http.StatusCreated, response: envelope{"studio": &data.Studio{}}},
+		{method: http.MethodPatch, path: "/v1/studios/{name}", tag: "studios", summary: "Rename a studio", auth: data.PermissionAnimeWrite,
+			request: studioRequest{}, status: http.StatusOK, response: envelope{"studio": &data.Studio{}}},
+		{method: http.MethodDelete, path: "/v1/studios/{name}", tag: "studios", summary: "Delete a studio", auth: data.PermissionAnimeWrite,
+			query:  []*openapi.Parameter{queryParam("force", "boolean", "Delete the studio even if anime were made by it.")},
+			status: http.StatusOK, response: messageResponse},
+
+		{method: http.MethodPost, path: "/v1/characters", tag: "characters", summary: "Create a character", auth: data.PermissionAnimeWrite,
+			request: struct {
+				Name  string `json:"name"`
+				About string `json:"about"`
+			}{}, status: http.StatusCreated, response: envelope{"character": &data.Character{}}},
+		{method: http.MethodGet, path: "/v1/characters/{id}", tag: "characters", summary: "Get a character", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"character": &data.Character{}}},
+		{method: http.MethodPost, path: "/v1/people", tag: "characters", summary: "Create a person, such as a voice actor", auth: data.PermissionAnimeWrite,
+			request: struct {
+				Name string `json:"name"`
+			}{}, status: http.StatusCreated, response: envelope{"person": &data.Person{}}},
+		{method: http.MethodGet, path: "/v1/people/{id}", tag: "characters", summary: "Get a person", auth: data.PermissionAnimeRead,
+			status: http.StatusOK, response: envelope{"person": &data.Person{}}},
+
+		{method: http.MethodPost, path: "/v1/users", tag: "users", summary: "Register a user, who is sent an activation email",
+			request: struct {
+				Name     string `json:"name"`
+				Email    string `json:"email"`
+				Password string `json:"password"`
+			}{}, status: http.StatusCreated, response: envelope{"user": &data.User{}}},
+		{method: http.MethodPut, path: "/v1/users/activated", tag: "users", summary: "Activate a user with the token of the activation email",
+			request: struct {
+				Token string `json:"token"`
+			}{}, status: http.StatusOK, response: envelope{"user": &data.User{}}},
+		{method: http.MethodPut, path: "/v1/users/password", tag: "users", summary: "Reset the password of a user with the token of the password reset email",
+			request: struct {
+				Password string `json:"password"`
+				Token    string `json:"token"`
+			}{}, status: http.StatusOK, response: messageResponse},
+		{method: http.MethodPut, path: "/v1/users/email/confirmed", tag: "users", summary: "Confirm a new email address with the token sent to it",
+			request: struct {
+				Token string `json:"token"`
+			}{}, status: http.StatusOK, response: envelope{"user": &data.User{}}},
+		{method: http.MethodPatch, path: "/v1/users/me", tag: "users", summary: "Update the current user", auth: authActivated,
+			request: struct {
+				Name *string `json:"name"`
+			}{}, status: http.StatusOK, response: envelope{"user": &data.User{}}},
+		{method: http.MethodDelete, path: "/v1/users/me", tag: "users", summary: "Delete the current user", auth: authAuthenticated,
+			request: struct {
+				Password string `json:"password"`
+			}{}, status: http.StatusOK, response: messageResponse},
+		{method: http.MethodPut, path: "/v1/users/me/password", tag: "users", summary: "Change the password of the current user", auth: authActivated,
+			request: struct {
+				CurrentPassword string `json:"current_password"`
+				NewPassword     string `json:"new_password"`
+			}{}, status: http.StatusOK, response: messageResponse},
+		{method: http.MethodPut, path: "/v1/users/me/email", tag: "users", summary: "Change the email address of the current user, who is sent a confirmation email", auth: authActivated,
+			request: struct {
+				Email    string `json:"email"`
+				Password string `json:"password"`
+			}{}, status: http.StatusAccepted, response: messageResponse},
+		{method: http.MethodGet, path: "/v1/users/me/notifications", tag: "users", summary: "Open a WebSocket sending the notifications of the current user, one JSON message each", auth: authAuthenticated,
+			query:  []*openapi.Parameter{queryParam("access_token", "string", "The authentication token, for the browsers which can't send the Authorization header of a WebSocket.")},
+			status: http.StatusSwitchingProtocols, response: notify.Notification{}, responseTypes: []string{mediaTypeJSON}},
+		{method: http.MethodGet, path: "/v1/users/me/sessions", tag: "users", summary: "List the sessions of the current user", auth: authAuthenticated,
+			status: http.StatusOK, response: envelope{"sessions": []*data.Session{}}},
+		{method: http.MethodDelete, path: "/v1/users/me/sessions/{id}", tag: "users", summary: "Revoke a session of the current user", auth: authAuthenticated,
+			status: http.StatusOK, response: messageResponse},
+
+		{method: http.MethodGet, path: "/v1/users/me/watchlist", tag: "watchlist", summary: "List the watchlist of the current user", auth: authActivated,
+			query: append([]*openapi.Parameter{
+				queryEnum("status", "", data.WatchStatusWatching, data.WatchStatusCompleted, data.WatchStatusPlanToWatch, data.WatchStatusDropped),
+			}, pageParams("anime_id", "title", "updated_at", "created_at", "episodes_watched", "-anime_id", "-title", "-updated_at", "-created_at", "-episodes_watched")...),
+			status: http.StatusOK, response: envelope{"watchlist": []*data.WatchlistEntry{}, "metadata": data.Metadata{}}},
+		{method: http.MethodPost, path: "/v1/users/me/watchlist", tag: "watchlist", summary: "Add an anime to the watchlist of the current user", auth: authActivated,
+			request: struct {
+				AnimeID int32 `json:"anime_id"`
+				watchlistRequest
+			}{}, status: http.StatusCreated, response: envelope{"entry": &data.WatchlistEntry{}}},
+		{method: http.MethodGet, path: "/v1/users/me/watchlist/{anime_id}", tag: "watchlist", summary: "Get an entry of the watchlist of the current user", auth: authActivated,
+			status: http.StatusOK, response: envelope{"entry": &data.WatchlistEntry{}}},
+		{method: http.MethodPatch, path: "/v1/users/me/watchlist/{anime_id}", tag: "watchlist", summary: "Update an entry of the watchlist of the current user", auth: authActivated,
+			request: watchlistRequest{}, status: http.StatusOK, response: envelope{"entry": &data.WatchlistEntry{}}},
+		{method: http.MethodDelete, path: "/v1/users/me/watchlist/{anime_id}", tag: "watchlist", summary: "Remove an anime from the watchlist of the current user", auth: authActivated,
+			status: http.StatusOK, response: messageResponse},
+
+		{method: http.MethodGet, path: "/v1/users/me/api-keys", tag: "users", summary: "List the API keys of the current user", auth: authActivated,
+			status: http.StatusOK, response: envelope{"api_keys": []*data.APIKey{}}},
+		{method: http.MethodPost, path: "/v1/users/me/api-keys", tag: "users", summary: "Create an API key, whose plaintext is only ever sent in this response", auth: authActivated,
+			request: struct {
+				Name        string   `json:"name"`
+				Permissions []string `json:"permissions"`
+				ExpiresIn   *int     `json:"expires_in_days"`
+			}{}, status: http.StatusCreated, response: envelope{"api_key": &data.APIKey{}}},
+		{method: http.MethodDelete, path: "/v1/users/me/api-keys/{id}", tag: "users", summary: "Revoke an API key of the current user", auth: authActivated,
+			status: http.StatusOK, response: messageResponse},
+
+		{method: http.MethodPost, path: "/v1/tokens/authentication", tag: "tokens", summary: "Log in, exchanging an email address and a password for a token",
+			request: struct {
+				Email    string `json:"email"`
+				Password string `json:"password"`
+			}{}, status: http.StatusCreated, response: envelope{"authentication_token": &data.Token{}}},
+		{method: http.MethodDelete, path: "/v1/tokens/authentication", tag: "tokens", summary: "Log out, revoking the token of the request", auth: authAuthenticated,
+			status: http.StatusOK, response: messageResponse},
+		{method: http.MethodPost, path: "/v1/tokens/activation", tag: "tokens", summary: "Send a new activation email",
+			request: struct {
+				Email string `json:"email"`
+			}{}, status: http.StatusAccepted, response: messageResponse},
+		{method: http.MethodPost, path: "/v1/tokens/password-reset", tag: "tokens", summary: "Send a password reset email",
+			request: struct {
+				Email string `json:"email"`
+			}{}, status: http.StatusAccepted, response: messageResponse},
+
+		{method: http.MethodGet, path: "/v1/admin/users", tag: "admin", summary: "List the users", auth: data.PermissionUsersAdmin,
+			query: append([]*openapi.Parameter{
+				queryParam("email", "string", ""),
+				queryParam("activated", "boolean", ""),
+			}, pageParams("id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at")...),
+			status: http.StatusOK, response: envelope{"users": []*data.User{}, "metadata": data.Metadata{}}},
+		{method: http.MethodPut, path: "/v1/admin/users/{id}/role", tag: "admin", summary: "Set the permissions of a user to those of a role", auth: data.PermissionUsersAdmin,
+			request: struct {
+				Role string `json:"role"`
+			}{}, status: http.StatusOK, response: envelope{"user_id": int64(0), "role": "", "permissions": data.Permissions{}}},
+		{method: http.MethodGet, path: "/v1/admin/audit", tag: "admin", summary: "List the audit log", auth: data.PermissionUsersAdmin,
+			query: append([]*openapi.Parameter{
+				queryParam("user_id", "integer", ""),
+				queryParam("entity", "string", ""),
+				queryParam("entity_id", "integer", ""),
+				queryParam("action", "string", ""),
+			}, pageParams("id", "created_at", "-id", "-created_at")...),
+			status: http.StatusOK, response: envelope{"audit_log": []*data.AuditEntry{}, "metadata": data.Metadata{}}},
+		{method: http.MethodDelete, path: "/v1/admin/anime/{id}", tag: "admin", summary: "Permanently delete an anime", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: messageResponse},
+		{method: http.MethodGet, path: "/v1/admin/ip-rules", tag: "admin", summary: "List the IP allow and deny lists", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: envelope{"ip_rules": map[string][]netip.Prefix{}}},
+		{method: http.MethodPut, path: "/v1/admin/ip-rules/{list}", tag: "admin", summary: "Replace an IP allow or deny list", auth: data.PermissionUsersAdmin,
+			request: struct {
+				CIDRs []string `json:"cidrs"`
+			}{}, status: http.StatusOK, response: envelope{"ip_rules": map[string][]netip.Prefix{}}},
+		{method: http.MethodPost, path: "/v1/admin/config/reload", tag: "admin", summary: "Reload the log level, rate limit and maintenance mode, as on SIGHUP", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: envelope{"config": liveConfig{}}},
+		{method: http.MethodPost, path: "/v1/admin/import/{source}/{id}", tag: "admin", summary: "Import an anime from a catalog", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: envelope{"anime": &data.Anime{}}},
+		{method: http.MethodPost, path: "/v1/admin/import/{source}/seasons/{year}/{season}", tag: "admin", summary: "Import every anime of a season from a catalog", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: envelope{"report": catalogReport{}}},
+		{method: http.MethodGet, path: "/v1/admin/debug/pprof/", tag: "admin", summary: "Get the index of the runtime profiles", auth: data.PermissionUsersAdmin,
+			status: http.StatusOK, response: &openapi.Schema{Type: "string"}, responseTypes: []string{"text/html"}},
+	}
+}
+
+// pathParamTypes are the types of the path parameters which aren't strings.
+var pathParamTypes = map[string]string{
+	"id":           "integer",
+	"character_id": "integer",
+	"anime_id":     "integer",
+	"version":      "integer",
+	"year":         "integer",
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// newOpenAPIDocument builds the OpenAPI document of the API from apiOperations().
+func newOpenAPIDocument() *openapi.Document {
+	g := openapi.New(openapi.Info{
+		Title:       "Purplelight API",
+		Description: "A JSON API for retrieving and managing information about anime. Responses are wrapped in an envelope, such as {\"anime\": ...}, unless the envelope=false query string parameter or the X-Envelope: none header is sent. This document describes v1, which is deprecated; every route is also served under /v2, which sends errors as application/problem+json and paginates the anime listing with cursors by default.",
+		Version:     version,
+	})
+
+	g.AddSecurityScheme("bearerAuth", &openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "A token from POST /v1/tokens/authentication, opaque or a JWT depending on the server.",
+	})
+	g.AddSecurityScheme("apiKeyAuth", &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "X-API-Key",
+		Description: "An API key from POST /v1/users/me/api-keys, limited to the permissions it was granted.",
+	})
+	security := []openapi.SecurityRequirement{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+
+	// The enums and the types with their own JSON encoding.
+	g.Define("AnimeType", data.AnimeType(""), enumSchema(data.TV, data.Movie, data.OVA, data.ONA, data.Special))
+	g.Define("Status", data.Status(""), enumSchema(data.Ongoing, data.Finished, data.Upcoming))
+	g.Define("Season", data.Season(""), enumSchema(data.Spring, data.Summer, data.Fall, data.Winter))
+	g.Define("Duration", data.Duration(0), &openapi.Schema{Type: "string", Description: "A duration in minutes.", Example: "24 mins"})
+	errorSchema := g.Define("Error", nil, &openapi.Schema{
+		Type:     "object",
+		Required: []string{"error"},
+		Properties: map[string]*openapi.Schema{
+			"error":      {Description: "A message, or an object mapping the invalid fields to what is wrong with them."},
+			"request_id": {Type: "string"},
+		},
+	})
+
+	for _, tag := range []struct{ name, description string }{
+		{"anime", "The anime catalog."},
+		{"characters", "The characters of the anime, and the people voicing them."},
+		{"tags", "The genres and themes anime are tagged with."},
+		{"studios", "The studios making the anime."},
+		{"users", "Registration and the account of the current user."},
+		{"watchlist", "The anime the current user watches."},
+		{"tokens", "Authentication, activation and password reset tokens."},
+		{"admin", "Administration of the users and the catalog."},
+		{"graphql", "The anime, tags and users, queried and changed with GraphQL."},
+		{"system", "The status and metrics of the API."},
+	} {
+		g.AddTag(tag.name, tag.description)
+	}
+
+	errorResponse := &openapi.Response{
+		Description: "An error",
+		Content:     map[string]openapi.MediaType{mediaTypeJSON: {Schema: errorSchema}},
+	}
+
+	for _, route := range apiOperations() {
+		op := &openapi.Operation{
+			Tags:        []string{route.tag},
+			Summary:     route.summary,
+			OperationID: operationID(route.method, route.path),
+			Responses: map[string]*openapi.Response{
+				strconv.Itoa(route.status): {
+					Description: http.StatusText(route.status),
+					Content:     bodyContent(g, route.response, route.responseTypes),
+				},
+				"default": errorResponse,
+			},
+		}
+
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.path, -1) {
+			typ, ok := pathParamTypes[match[1]]
+			if !ok {
+				typ = "string"
+			}
+			op.Parameters = append(op.Parameters, &openapi.Parameter{Name: match[1], In: "path", Required: true, Schema: &openapi.Schema{Type: typ}})
+		}
+		op.Parameters = append(op.Parameters, route.query...)
+
+		if route.request != nil {
+			op.RequestBody = &openapi.RequestBody{Required: true, Content: bodyContent(g, route.request, route.requestTypes)}
+		}
+		if route.jsonPatch {
+			op.RequestBody.Content[jsonpatch.MediaType] = openapi.MediaType{Schema: g.Schema(jsonpatch.Patch{})}
+		}
+
+		switch route.auth {
+		case "":
+		case authAuthenticated:
+			op.Security = security
+			op.Description = "Requires an authenticated user."
+		case authActivated:
+			op.Security = security
+			op.Description = "Requires an activated user."
+		default:
+			op.Security = security
+			op.Description = fmt.Sprintf("Requires the %s permission.", route.auth)
+		}
+
+		g.AddOperation(route.method, route.path, op)
+	}
+
+	return g.Document()
+}
+
+// bodyContent returns the content of a request or response body for each of its media
+// types, JSON and MessagePack by default.
+func bodyContent(g *openapi.Generator, body any, mediaTypes []string) map[string]openapi.MediaType {
+	schema, ok := body.(*openapi.Schema)
+	if !ok {
+		schema = g.Schema(body)
+	}
+
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{mediaTypeJSON, mediaTypeMsgpack}
+	}
+
+	content := make(map[string]openapi.MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = openapi.MediaType{Schema: schema}
+	}
+
+	return content
+}
+
+func enumSchema[T ~string](values ...T) *openapi.Schema {
+	schema := &openapi.Schema{Type: "string"}
+	for _, value := range values {
+		schema.Enum = append(schema.Enum, string(value))
+	}
+
+	return schema
+}
+
+// operationID derives the ID of an operation from its method and path, such as
+// get_anime_id_revisions for GET /v1/anime/{id}/revisions.
+func operationID(method, path string) string {
+	id := strings.NewReplacer("/v1/", "", "/", "_", "-", "_", ".", "_", "{", "", "}", "").Replace(path)
+	return strings.ToLower(method) + "_" + strings.TrimSuffix(id, "_")
+}
+
+// The document is built on the first request, then served from memory, as the routes
+// don't change while the API runs.
+var openAPIDocument = sync.OnceValues(func() ([]byte, error) {
+	return json.Marshal(newOpenAPIDocument())
+})
+
+// Serve the OpenAPI document describing the API. It is sent as is, rather than in an
+// envelope, for the tools reading it.
+func (app *application) showOpenAPI(w http.ResponseWriter, r *http.Request) {
+	js, err := openAPIDocument()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	w.Write(js)
+}
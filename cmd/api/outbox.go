@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/mailer"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"sync"
+	"time"
+)
+
+// emailLease is how long the emails claimed by the dispatcher are left alone by the
+// other dispatchers, which is more than enough to send a batch, retries included.
+const emailLease = 10 * time.Minute
+
+// maxEmailBackoff caps the wait between two attempts at sending an email.
+const maxEmailBackoff = time.Hour
+
+// totalEmailsFailed counts the emails given up on.
+var totalEmailsFailed = expvar.NewInt("total_emails_failed")
+
+// newEmail returns an email for the outbox, rendered from the template of the mailer
+// with the given data.
+func newEmail(recipient, templateFile string, values map[string]any) *data.Email {
+	return &data.Email{Recipient: recipient, Template: templateFile, Data: values}
+}
+
+// The notifyOutbox() helper wakes the dispatcher up, so that an email enqueued by a
+// request goes out right away rather than with the next tick. It is called once the
+// transaction enqueuing the email is committed, and never blocks.
+func (app *application) notifyOutbox() {
+	select {
+	case app.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// The dispatchEmails() job sends the emails of the outbox, every interval and whenever
+// notifyOutbox() is called. It runs until the done channel is closed, and is tracked by
+// the application WaitGroup so that shutdown waits for the batch being sent.
+func (app *application) dispatchEmails(done <-chan struct{}) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.outbox.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			case <-app.outboxWake:
+			}
+
+			app.sendPendingEmails()
+		}
+	}()
+}
+
+// sendPendingEmails sends the emails due, batch after batch until none is left. The
+// emails of a batch are sent at the same time on the worker pool. Failed emails are
+// tried again later, backing off exponentially, until they fail for good or run out of
+// attempts: these are logged and counted, as there is no one left to tell.
+func (app *application) sendPendingEmails() {
+	for {
+		ctx, span := telemetry.Start(context.Background(), "job.dispatchEmails")
+		emails, err := app.repos.Email.ClaimPending(ctx, app.config.outbox.batch, emailLease)
+		telemetry.End(span, err)
+		if err != nil {
+			app.logger.Error("failed to claim pending emails", "error", err.Error())
+			return
+		}
+
+		// The next batch is only claimed once this one is sent, so that the dispatcher
+		// doesn't claim more emails than the workers can send before their lease is over.
+		var wg sync.WaitGroup
+		for _, email := range emails {
+			wg.Add(1)
+			err := app.workers.Submit(context.Background(), "sendEmail", func(ctx context.Context) {
+				defer wg.Done()
+				app.sendOutboxEmail(ctx, email)
+			})
+			if err != nil {
+				// The pool is shut down: the emails left are sent again once their lease
+				// is over.
+				wg.Done()
+				wg.Wait()
+				return
+			}
+		}
+		wg.Wait()
+
+		if len(emails) < app.config.outbox.batch {
+			return
+		}
+	}
+}
+
+// sendOutboxEmail sends an email of the outbox, and records how it went. It is recorded
+// even when ctx is canceled by the timeout of the workers.
+func (app *application) sendOutboxEmail(ctx context.Context, email *data.Email) {
+	err := app.mailer.Send(ctx, email.Recipient, email.Template, email.Data)
+
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if err := app.repos.Email.MarkSent(ctx, email.ID); err != nil {
+			// The email will be sent again once its lease is over.
+			app.logger.Error("failed to mark email as sent", "email_id", email.ID, "error", err.Error())
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if !mailer.IsPermanent(err) && int(email.Attempts) < app.config.outbox.maxAttempts {
+		backoff := min(app.config.outbox.interval<<(email.Attempts-1), maxEmailBackoff)
+		retryAt = new(time.Time)
+		*retryAt = time.Now().Add(backoff)
+	}
+
+	if retryAt != nil {
+		app.logger.Warn("email not sent, will retry", "email_id", email.ID, "template", email.Template, "attempts", email.Attempts, "retry_at", *retryAt, "error", err.Error())
+	} else {
+		totalEmailsFailed.Add(1)
+		app.logger.Error("email not sent, giving up", "email_id", email.ID, "template", email.Template, "attempts", email.Attempts, "error", err.Error())
+	}
+
+	if err := app.repos.Email.MarkFailed(ctx, email.ID, err.Error(), retryAt); err != nil {
+		app.logger.Error("failed to mark email as failed", "email_id", email.ID, "error", err.Error())
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// paginationLinks returns the Link header (RFC 8288, formerly RFC 5988) of a page of a
+// listing, pointing at its first, previous, next and last pages, so that clients can
+// paginate without reading the metadata in the body. The links keep the query string of
+// the request, with only the page (or, with keyset pagination, the after cursor)
+// changed. It returns nil when there are no pages to link to.
+//
+// The links are relative to the request's URL, so that they stay right behind a proxy
+// rewriting the host.
+func paginationLinks(r *http.Request, metadata data.Metadata) http.Header {
+	var links []string
+
+	link := func(rel, key, value string) {
+		qs := r.URL.Query()
+		qs.Set(key, value)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, qs.Encode(), rel))
+	}
+
+	switch {
+	case metadata.CurrentPage > 0:
+		page := func(rel string, n int) {
+			link(rel, "page", strconv.Itoa(n))
+		}
+
+		page("first", metadata.FirstPage)
+		if metadata.CurrentPage > metadata.FirstPage {
+			page("prev", metadata.CurrentPage-1)
+		}
+		if metadata.CurrentPage < metadata.LastPage {
+			page("next", metadata.CurrentPage+1)
+		}
+		page("last", metadata.LastPage)
+
+	case metadata.PageSize > 0:
+		// With keyset pagination the last page isn't known, and an empty cursor is the
+		// start of the listing. It is the default of v2, so it is told apart by the
+		// metadata rather than by the after parameter.
+		link("first", "after", "")
+		if metadata.PrevCursor != "" {
+			link("prev", "after", metadata.PrevCursor)
+		}
+		if metadata.NextCursor != "" {
+			link("next", "after", metadata.NextCursor)
+		}
+	}
+
+	if len(links) == 0 {
+		return nil
+	}
+
+	return http.Header{"Link": {strings.Join(links, ", ")}}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"net/http"
+	"strconv"
+)
+
+const mediaTypeProblem = "application/problem+json"
+
+// problemDetails is an error response in the format of RFC 9457, Problem Details for
+// HTTP APIs, which v2 sends in place of the {"error": ...} envelope of v1.
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors maps the invalid fields of the request to what is wrong with them.
+	Errors    any    `json:"errors,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// The problem() method is the v2 counterpart of error(). The message becomes the detail
+// of the problem when it is a string, and its errors otherwise, as the messages other
+// than strings map the fields of the request to their problem.
+//
+// The problems have no type of their own yet, which RFC 9457 spells about:blank: the
+// status alone tells what went wrong.
+func (app *application) problem(w http.ResponseWriter, r *http.Request, status int, message any) {
+	problem := problemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		RequestID: telemetry.RequestID(r.Context()),
+	}
+
+	if detail, ok := message.(string); ok {
+		problem.Detail = detail
+	} else {
+		problem.Errors = message
+	}
+
+	js, err := json.Marshal(problem)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", mediaTypeProblem)
+	w.Header().Set("Content-Length", strconv.Itoa(len(js)))
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/time/rate"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter holds a rate limiter for each client, created on the client's first
+// request and forgotten once it hasn't been seen for three minutes. The application
+// owns its rate limiters: their cleanup goroutines are tracked by the application
+// WaitGroup, and stopped with Stop() during shutdown.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// Define a client struct to hold the rate limiter and last seen time for each client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(wg *sync.WaitGroup) *rateLimiter {
+	l := &rateLimiter{
+		clients: make(map[string]*clientLimiter),
+		stop:    make(chan struct{}),
+	}
+
+	wg.Add(1)
+
+	// Launch a background goroutine which removes old entries from the clients map once
+	// every minute, until the limiter is stopped.
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(60 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				l.cleanup()
+			}
+		}
+	}()
+
+	return l
+}
+
+// cleanup deletes the clients that haven't been seen within the last three minutes.
+func (l *rateLimiter) cleanup() {
+	// Lock the mutex to prevent any rate limiter checks from happening while the
+	// cleanup is taking place.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, client := range l.clients {
+		if time.Since(client.lastSeen) > 3*time.Minute {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Stop ends the cleanup goroutine. The limiter keeps working afterwards, but forgets
+// nobody, so it's only meant to be called on shutdown. It's safe to call more than once.
+func (l *rateLimiter) Stop() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+}
+
+// Allow reports whether the client identified by key may make a request, given a limit
+// of rps requests per second with bursts of burst requests.
+func (l *rateLimiter) Allow(key string, rps float64, burst int) bool {
+	// Lock the mutex to prevent this code from being executed concurrently. Unlike in
+	// the middleware, the lock isn't held while the next handler runs.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Check to see if the key already exists in the map. If it doesn't, then initialize
+	// a new rate limiter and add it to the map.
+	client, found := l.clients[key]
+	if !found {
+		client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+		l.clients[key] = client
+	}
+
+	// The limit may have been changed by reloading the configuration since the client
+	// was first seen.
+	if client.limiter.Limit() != rate.Limit(rps) || client.limiter.Burst() != burst {
+		client.limiter.SetLimit(rate.Limit(rps))
+		client.limiter.SetBurst(burst)
+	}
+
+	// Update the last seen time for the client.
+	client.lastSeen = time.Now()
+
+	// limiter.Allow() automatically keeps track of the rate limit for the client by
+	// incrementing a counter.
+	return client.limiter.Allow()
+}
+
+// rateLimitPolicy is a named rate limit that routes declare with app.rateLimitPolicy().
+// It applies per client IP address, on top of the global rate limit.
+type rateLimitPolicy struct {
+	rps   float64
+	burst int
+}
+
+// Names of the rate limit policies used by the routes.
+const (
+	// rateLimitAuth throttles the routes that check credentials or send emails, such
+	// as logging in and registering, to make brute forcing and spamming impractical.
+	rateLimitAuth = "auth"
+)
+
+// defaultRateLimitPolicies are the policies used unless configured otherwise with
+// -limiter-policies.
+var defaultRateLimitPolicies = map[string]rateLimitPolicy{
+	rateLimitAuth: {rps: 0.1, burst: 5},
+}
+
+// parseRateLimitPolicies parses rate limit policies written as name=rps:burst and
+// separated by commas, e.g. "auth=0.1:5,search=2:4". The policies it doesn't mention
+// keep their defaults.
+func parseRateLimitPolicies(s string) (map[string]rateLimitPolicy, error) {
+	policies := maps.Clone(defaultRateLimitPolicies)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, limit, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid rate limit policy %q, must be written as name=rps:burst", part)
+		}
+
+		rpsPart, burstPart, ok := strings.Cut(limit, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit policy %q, must be written as name=rps:burst", part)
+		}
+
+		rps, err := strconv.ParseFloat(rpsPart, 64)
+		if err != nil || rps <= 0 {
+			return nil, fmt.Errorf("invalid requests per second in rate limit policy %q", part)
+		}
+
+		burst, err := strconv.Atoi(burstPart)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in rate limit policy %q", part)
+		}
+
+		policies[name] = rateLimitPolicy{rps: rps, burst: burst}
+	}
+
+	return policies, nil
+}
+
+// The rateLimitPolicy() middleware applies the named policy to a route, counting the
+// requests of each client IP address to the routes sharing that policy together. It
+// panics on a policy that doesn't exist, as that is a mistake in routes.go.
+func (app *application) rateLimitPolicy(name string, next http.HandlerFunc) http.HandlerFunc {
+	policy, ok := app.config.limiter.policies[name]
+	if !ok {
+		policy, ok = defaultRateLimitPolicies[name]
+	}
+	if !ok {
+		panic(fmt.Sprintf("unknown rate limit policy %q", name))
+	}
+
+	limiter := app.rateLimiterFor(name)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Like the global limiter, the policies follow the configuration reloads.
+		if app.live.get().LimiterEnabled {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if !limiter.Allow(ip, policy.rps, policy.burst) {
+				app.rateLimitExceeded(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// rateLimiterFor returns the rate limiter of a policy, shared by all its routes, and
+// creates it on first use. The global rate limit uses the empty name. It's only called
+// while the routes are set up, so it doesn't need a lock.
+func (app *application) rateLimiterFor(name string) *rateLimiter {
+	if app.limiters == nil {
+		app.limiters = make(map[string]*rateLimiter)
+	}
+
+	limiter, ok := app.limiters[name]
+	if !ok {
+		limiter = newRateLimiter(&app.wg)
+		app.limiters[name] = limiter
+	}
+
+	return limiter
+}
+
+// stopRateLimiters stops the cleanup goroutines of every rate limiter.
+func (app *application) stopRateLimiters() {
+	for _, limiter := range app.limiters {
+		limiter.Stop()
+	}
+}
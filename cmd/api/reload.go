@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// liveConfig holds the settings which can be changed while the server runs, by reloading
+// the configuration: the log level, the global rate limit and the maintenance mode.
+type liveConfig struct {
+	LogLevel       slog.Level `json:"log_level"`
+	LimiterRPS     float64    `json:"limiter_rps"`
+	LimiterBurst   int        `json:"limiter_burst"`
+	LimiterEnabled bool       `json:"limiter_enabled"`
+	Maintenance    bool       `json:"maintenance"`
+}
+
+// liveSettings guards the liveConfig in effect. The log level is held by the LevelVar of
+// the logger, so that changing it takes effect on the next line logged.
+type liveSettings struct {
+	mu     sync.RWMutex
+	config liveConfig
+	level  *slog.LevelVar
+}
+
+func newLiveSettings(cfg Config, level *slog.LevelVar) *liveSettings {
+	return &liveSettings{
+		config: liveConfig{
+			LogLevel:       cfg.log.level,
+			LimiterRPS:     cfg.limiter.rps,
+			LimiterBurst:   cfg.limiter.burst,
+			LimiterEnabled: cfg.limiter.enabled,
+			Maintenance:    cfg.maintenance,
+		},
+		level: level,
+	}
+}
+
+func (s *liveSettings) get() liveConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+func (s *liveSettings) set(config liveConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = config
+	s.level.Set(config.LogLevel)
+}
+
+// reloadConfig reads the settings of liveConfig again, from their PURPLELIGHT_* variables
+// in the .env file, then in the environment. The .env file comes first, unlike at
+// startup, since the environment still holds the values the file had back then. The
+// settings given on the command line, and the ones without a variable, are left as they
+// are. Nothing changes if one of the values is invalid.
+func (app *application) reloadConfig() (liveConfig, error) {
+	file, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return liveConfig{}, err
+	}
+
+	lookup := func(key string) (string, bool) {
+		if value, ok := file[key]; ok {
+			return value, true
+		}
+		return os.LookupEnv(key)
+	}
+
+	// flag.Visit() only visits the flags set on the command line.
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	// The values are parsed by flags of the same names as at startup, so that they are
+	// read the same way.
+	config := app.live.get()
+	settings := flag.NewFlagSet("reload", flag.ContinueOnError)
+	settings.Func("log-level", "", func(s string) error { return config.LogLevel.UnmarshalText([]byte(s)) })
+	settings.Float64Var(&config.LimiterRPS, "limiter-rps", config.LimiterRPS, "")
+	settings.IntVar(&config.LimiterBurst, "limiter-burst", config.LimiterBurst, "")
+	settings.BoolVar(&config.LimiterEnabled, "limiter-enabled", config.LimiterEnabled, "")
+	settings.BoolVar(&config.Maintenance, "maintenance", config.Maintenance, "")
+
+	var errs []string
+	settings.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := lookup(name)
+		if !ok || onCommandLine[f.Name] {
+			return
+		}
+
+		if err := settings.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s %q", name, value))
+		}
+	})
+	if len(errs) == 0 && config.LimiterEnabled && (config.LimiterRPS <= 0 || config.LimiterBurst < 1) {
+		errs = append(errs, "the limiter rps must be positive, and its burst at least 1")
+	}
+	if len(errs) > 0 {
+		return liveConfig{}, errors.New(strings.Join(errs, "; "))
+	}
+
+	app.live.set(config)
+	app.logger.Info("configuration reloaded", "config", config)
+
+	return config, nil
+}
+
+// The reloadOnSignal() job reloads the configuration every time the process receives
+// SIGHUP, until the done channel is closed.
+func (app *application) reloadOnSignal(done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				if _, err := app.reloadConfig(); err != nil {
+					app.logger.Error("failed to reload configuration", "error", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Reload the configuration, as on SIGHUP, and send back the settings now in effect.
+func (app *application) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := app.reloadConfig()
+	if err != nil {
+		app.error(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"config": config}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// The maintenance() middleware answers every request with a 503 Service Unavailable
+// while the maintenance mode is on, except for the healthcheck, logging in and the admin
+// routes, so that admins can still get in and turn it off.
+func (app *application) maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.live.get().Maintenance {
+			path := v1Path(r.URL.Path)
+			if path != "/v1/healthcheck" && path != "/v1/tokens/authentication" && !strings.HasPrefix(path, "/v1/admin/") {
+				app.underMaintenance(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"net/http"
+)
+
+// List every recorded revision of an anime, each with the fields that changed compared
+// to the revision before it.
+func (app *application) listAnimeRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// Make sure the anime exists (and isn't deleted), as an empty history is a valid
+	// response for anime created before revisions were recorded.
+	_, err = app.repos.Anime.GetAnime(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	revisions, err := app.repos.Anime.GetRevisions(r.Context(), id)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = data.DiffRevisions(revisions)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Show a single revision of an anime, with the fields that changed compared to the
+// previous version.
+func (app *application) showAnimeRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	version, err := app.readIntParam(r, "version")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	revision, err := app.repos.Anime.GetRevision(r.Context(), id, version)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	revisions := []*data.AnimeRevision{revision}
+
+	// The first version has nothing to be compared with, and older versions may not
+	// have been recorded, in which case the revision is shown without changes.
+	if version > 1 {
+		prev, err := app.repos.Anime.GetRevision(r.Context(), id, version-1)
+		switch {
+		case err == nil:
+			revisions = []*data.AnimeRevision{prev, revision}
+		case !errors.Is(err, repository.ErrRecordNotFound):
+			app.dbReadError(w, r, err)
+			return
+		}
+	}
+
+	err = data.DiffRevisions(revisions)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
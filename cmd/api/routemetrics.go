@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routeUnmatched labels the requests that matched no route, so that scanners probing
+// random paths can't blow up the number of routes we keep metrics for.
+const routeUnmatched = "unmatched"
+
+// routeSamples is the number of the most recent processing times kept per route to
+// compute the percentiles from.
+const routeSamples = 1024
+
+// routePattern returns a function naming the route a request matches, such as
+// "GET /v1/anime/:id", looking it up in the mux first and in the router behind it
+// then.
+func routePattern(mux *http.ServeMux, router *httprouter.Router) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" && pattern != "/" {
+			return pattern
+		}
+
+		// HEAD requests are served by the GET routes, see headAsGet().
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+
+		handle, params, _ := router.Lookup(method, r.URL.Path)
+		if handle == nil {
+			return routeUnmatched
+		}
+
+		return method + " " + routeTemplate(r.URL.Path, params)
+	}
+}
+
+// routeTemplate turns a path back into the route it matched, by putting the names of
+// the parameters back in place of their values. The path is walked from its end, where
+// the parameters usually are, so that a parameter having the value of a static segment
+// before it, as in /v1/tags/tags, doesn't take that segment's place.
+func routeTemplate(path string, params httprouter.Params) string {
+	segments := strings.Split(path, "/")
+
+	j := len(params) - 1
+	for i := len(segments) - 1; i >= 0 && j >= 0; i-- {
+		if segments[i] == params[j].Value {
+			segments[i] = ":" + params[j].Key
+			j--
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// routeMetrics are the metrics of a single route. It is an expvar.Var, published in
+// the routes map.
+type routeMetrics struct {
+	mu       sync.Mutex
+	requests int64
+	// responses counts the responses by status class, such as 2xx.
+	responses map[string]int64
+	// totalTime is the cumulative processing time.
+	totalTime time.Duration
+	// samples are the most recent processing times, next is where the next one goes.
+	samples []time.Duration
+	next    int
+}
+
+func newRouteMetrics() *routeMetrics {
+	return &routeMetrics{
+		responses: make(map[string]int64),
+		samples:   make([]time.Duration, 0, routeSamples),
+	}
+}
+
+func (m *routeMetrics) observe(status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests++
+	m.responses[statusClass(status)]++
+	m.totalTime += duration
+
+	if len(m.samples) < routeSamples {
+		m.samples = append(m.samples, duration)
+	} else {
+		m.samples[m.next] = duration
+	}
+	m.next = (m.next + 1) % routeSamples
+}
+
+// String returns the metrics as JSON, with the percentiles of the recent processing
+// times in microseconds.
+func (m *routeMetrics) String() string {
+	m.mu.Lock()
+	samples := slices.Clone(m.samples)
+	out := struct {
+		Requests              int64            `json:"requests"`
+		Responses             map[string]int64 `json:"responses_by_status_class"`
+		TotalProcessingTimeUS int64            `json:"total_processing_time_μs"`
+		P50US                 int64            `json:"p50_processing_time_μs"`
+		P90US                 int64            `json:"p90_processing_time_μs"`
+		P99US                 int64            `json:"p99_processing_time_μs"`
+	}{
+		Requests:              m.requests,
+		Responses:             make(map[string]int64, len(m.responses)),
+		TotalProcessingTimeUS: m.totalTime.Microseconds(),
+	}
+	for class, n := range m.responses {
+		out.Responses[class] = n
+	}
+	m.mu.Unlock()
+
+	slices.Sort(samples)
+	out.P50US = percentile(samples, 0.50).Microseconds()
+	out.P90US = percentile(samples, 0.90).Microseconds()
+	out.P99US = percentile(samples, 0.99).Microseconds()
+
+	js, _ := json.Marshal(out)
+	return string(js)
+}
+
+// percentile returns the p-th percentile of sorted durations, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// statusClass returns the class of a status code, such as 4xx for 404.
+func statusClass(status int) string {
+	return string(rune('0'+status/100)) + "xx"
+}
@@ -3,7 +3,22 @@ package main
 import (
 	"expvar"
 	"github.com/julienschmidt/httprouter"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/storage"
 	"net/http"
+	"strings"
+)
+
+// routeRouter and routeMux are what the routes are registered with: the httprouter
+// router and the ServeMux in front of it, or a routeList when the routes are listed.
+type (
+	routeRouter interface {
+		HandlerFunc(method, path string, handler http.HandlerFunc)
+	}
+	routeMux interface {
+		Handle(pattern string, handler http.Handler)
+		HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+	}
 )
 
 func (app *application) routes() http.Handler {
@@ -12,26 +27,13 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFound)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheck)
-
-	router.HandlerFunc(http.MethodPost, "/v1/anime", app.requirePermission("anime:write", app.createAnime))
-	router.HandlerFunc(http.MethodGet, "/v1/anime/:id", app.requirePermission("anime:read", app.showAnime))
-	router.HandlerFunc(http.MethodPut, "/v1/anime/:id", app.requirePermission("anime:write", app.updateAnime))
-	router.HandlerFunc(http.MethodPatch, "/v1/anime/:id", app.requirePermission("anime:write", app.partiallyUpdateAnime))
-	router.HandlerFunc(http.MethodDelete, "/v1/anime/:id", app.requirePermission("anime:write", app.deleteAnime))
-
-	router.HandlerFunc(http.MethodGet, "/v1/anime", app.requirePermission("anime:read", app.listAnime))
-	router.HandlerFunc(http.MethodGet, "/v1/tags", app.requirePermission("anime:read", app.listTags))
+	// httprouter doesn't allow a static path segment next to a wildcard one, so routes
+	// like POST /v1/anime/import (which clashes with /v1/anime/:id/...) are served by a
+	// ServeMux in front of the router instead. Everything else falls through to it.
+	mux := http.NewServeMux()
+	mux.Handle("/", app.headAsGet(router))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUser)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUser)
-
-	// login, in short
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationToken)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationToken)
-
-	// Register a new GET /v1/metrics endpoint pointing to the expvar handler.
-	router.Handler(http.MethodGet, "/v1/metrics", expvar.Handler())
+	app.registerRoutes(router, mux)
 
 	// the middleware chain goes -> recoverPanic -> rateLimit -> logging
 	// So it works by first calling recoverPanic, then rateLimit, and finally logging
@@ -42,5 +44,130 @@ func (app *application) routes() http.Handler {
 	// logging -> recoverPanic -> rateLimit
 	// so that if recoverPanic panics, then logging will be called
 	// and if rate limit returns 429, then logging will also be called
-	return app.metrics(app.logging(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))))
+	return app.trace(app.requestID(app.deprecateVersions(app.metrics(routePattern(mux, router), app.logging(app.recoverPanic(app.enableCORS(app.filterIP(app.rateLimit(app.limitConcurrency(app.timeout(app.authenticate(app.maintenance(mux)))))))))))))
+}
+
+// registerRoutes registers every route of the API with router, or with mux for those
+// httprouter can't serve.
+func (app *application) registerRoutes(router routeRouter, mux routeMux) {
+	// The OpenAPI document describes v1.
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.showOpenAPI)
+
+	// The GraphQL endpoint checks the permissions field by field, rather than route by
+	// route, so it isn't wrapped in requirePermission().
+	schema := app.graphqlSchema()
+	router.HandlerFunc(http.MethodPost, "/v1/graphql", app.graphql(schema))
+	router.HandlerFunc(http.MethodGet, "/v1/graphql/schema", app.showGraphQLSchema(schema))
+
+	// Every version of the API serves the same routes, with the same handlers, which
+	// check versionOf() where the versions differ.
+	for _, version := range apiVersions {
+		app.versionRoutes(version.prefix(), router, mux)
+	}
+
+	// The profiles are served by net/http/pprof, which expects them under /debug/pprof/.
+	mux.HandleFunc("GET "+pprofPrefix, app.requirePermission(data.PermissionUsersAdmin, http.StripPrefix("/v1/admin", pprofHandler()).ServeHTTP))
+
+	// The API explorer is meant for trying the API out, so it is off in production
+	// unless asked for. GET /v1/docs is redirected to it by the mux.
+	if app.config.docs {
+		mux.Handle("GET "+docsPrefix, docsHandler())
+	}
+
+	// Files uploaded to the local storage are served by the API itself. Other backends
+	// serve their files on their own.
+	if local, ok := app.storage.(*storage.Local); ok {
+		prefix := strings.TrimSuffix(app.config.storage.baseURL, "/")
+		mux.Handle("GET "+prefix+"/", http.StripPrefix(prefix, local.Handler()))
+	}
+}
+
+// versionRoutes registers the routes of a version of the API under its prefix, such as
+// /v2. The routes clashing with the wildcards of httprouter go to the mux.
+func (app *application) versionRoutes(prefix string, router routeRouter, mux routeMux) {
+	router.HandlerFunc(http.MethodGet, prefix+"/healthcheck", app.healthcheck)
+
+	router.HandlerFunc(http.MethodPost, prefix+"/anime", app.requirePermission(data.PermissionAnimeWrite, app.createAnime))
+	router.HandlerFunc(http.MethodGet, prefix+"/anime/:id", app.requirePermission(data.PermissionAnimeRead, app.showAnime))
+	router.HandlerFunc(http.MethodPut, prefix+"/anime/:id", app.requirePermission(data.PermissionAnimeWrite, app.updateAnime))
+	router.HandlerFunc(http.MethodPatch, prefix+"/anime/:id", app.requirePermission(data.PermissionAnimeWrite, app.partiallyUpdateAnime))
+	router.HandlerFunc(http.MethodDelete, prefix+"/anime/:id", app.requirePermission(data.PermissionAnimeWrite, app.deleteAnime))
+	router.HandlerFunc(http.MethodGet, prefix+"/anime/:id/revisions", app.requirePermission(data.PermissionAnimeRead, app.listAnimeRevisions))
+	router.HandlerFunc(http.MethodGet, prefix+"/anime/:id/revisions/:version", app.requirePermission(data.PermissionAnimeRead, app.showAnimeRevision))
+	router.HandlerFunc(http.MethodPost, prefix+"/anime/:id/restore", app.requirePermission(data.PermissionAnimeWrite, app.restoreAnime))
+	router.HandlerFunc(http.MethodPut, prefix+"/anime/:id/cover", app.requirePermission(data.PermissionAnimeWrite, app.uploadAnimeCover))
+	router.HandlerFunc(http.MethodGet, prefix+"/anime/:id/similar", app.requirePermission(data.PermissionAnimeRead, app.listSimilarAnime))
+	router.HandlerFunc(http.MethodGet, prefix+"/anime/:id/characters", app.requirePermission(data.PermissionAnimeRead, app.listAnimeCharacters))
+	router.HandlerFunc(http.MethodPut, prefix+"/anime/:id/characters/:character_id", app.requirePermission(data.PermissionAnimeWrite, app.setAnimeCharacter))
+	router.HandlerFunc(http.MethodDelete, prefix+"/anime/:id/characters/:character_id", app.requirePermission(data.PermissionAnimeWrite, app.deleteAnimeCharacter))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/anime", app.requirePermission(data.PermissionAnimeRead, app.listAnime))
+	router.HandlerFunc(http.MethodGet, prefix+"/tags", app.requirePermission(data.PermissionAnimeRead, app.listTags))
+	router.HandlerFunc(http.MethodPost, prefix+"/tags", app.requirePermission(data.PermissionTagsWrite, app.createTag))
+	router.HandlerFunc(http.MethodPost, prefix+"/tags/merge", app.requirePermission(data.PermissionTagsWrite, app.mergeTags))
+	router.HandlerFunc(http.MethodPatch, prefix+"/tags/:name", app.requirePermission(data.PermissionTagsWrite, app.updateTag))
+	router.HandlerFunc(http.MethodDelete, prefix+"/tags/:name", app.requirePermission(data.PermissionTagsWrite, app.deleteTag))
+
+	router.HandlerFunc(http.MethodPost, prefix+"/characters", app.requirePermission(data.PermissionAnimeWrite, app.createCharacter))
+	router.HandlerFunc(http.MethodGet, prefix+"/characters/:id", app.requirePermission(data.PermissionAnimeRead, app.showCharacter))
+	router.HandlerFunc(http.MethodPost, prefix+"/people", app.requirePermission(data.PermissionAnimeWrite, app.createPerson))
+	router.HandlerFunc(http.MethodGet, prefix+"/people/:id", app.requirePermission(data.PermissionAnimeRead, app.showPerson))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/studios", app.requirePermission(data.PermissionAnimeRead, app.listStudios))
+	router.HandlerFunc(http.MethodPost, prefix+"/studios", app.requirePermission(data.PermissionAnimeWrite, app.createStudio))
+	router.HandlerFunc(http.MethodPatch, prefix+"/studios/:name", app.requirePermission(data.PermissionAnimeWrite, app.updateStudio))
+	router.HandlerFunc(http.MethodDelete, prefix+"/studios/:name", app.requirePermission(data.PermissionAnimeWrite, app.deleteStudio))
+
+	router.HandlerFunc(http.MethodPost, prefix+"/users", app.rateLimitPolicy(rateLimitAuth, app.registerUser))
+	router.HandlerFunc(http.MethodPut, prefix+"/users/activated", app.rateLimitPolicy(rateLimitAuth, app.activateUser))
+	router.HandlerFunc(http.MethodPut, prefix+"/users/password", app.rateLimitPolicy(rateLimitAuth, app.updateUserPassword))
+	router.HandlerFunc(http.MethodPatch, prefix+"/users/me", app.requireActivatedUser(app.updateCurrentUser))
+	router.HandlerFunc(http.MethodDelete, prefix+"/users/me", app.requireAuthenticatedUser(app.deleteCurrentUser))
+	router.HandlerFunc(http.MethodPut, prefix+"/users/me/password", app.requireActivatedUser(app.changeUserPassword))
+	router.HandlerFunc(http.MethodPut, prefix+"/users/me/email", app.requireActivatedUser(app.changeUserEmail))
+	router.HandlerFunc(http.MethodPut, prefix+"/users/email/confirmed", app.confirmUserEmail)
+
+	router.HandlerFunc(http.MethodGet, prefix+"/users/me/notifications", app.requireAuthenticatedUser(app.notificationsSocket))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/users/me/sessions", app.requireAuthenticatedUser(app.listSessions))
+	router.HandlerFunc(http.MethodDelete, prefix+"/users/me/sessions/:id", app.requireAuthenticatedUser(app.deleteSession))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/users/me/watchlist", app.requireActivatedUser(app.listWatchlist))
+	router.HandlerFunc(http.MethodPost, prefix+"/users/me/watchlist", app.requireActivatedUser(app.addToWatchlist))
+	router.HandlerFunc(http.MethodGet, prefix+"/users/me/watchlist/:anime_id", app.requireActivatedUser(app.showWatchlistEntry))
+	router.HandlerFunc(http.MethodPatch, prefix+"/users/me/watchlist/:anime_id", app.requireActivatedUser(app.updateWatchlistEntry))
+	router.HandlerFunc(http.MethodDelete, prefix+"/users/me/watchlist/:anime_id", app.requireActivatedUser(app.deleteWatchlistEntry))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/users/me/api-keys", app.requireActivatedUser(app.listAPIKeys))
+	router.HandlerFunc(http.MethodPost, prefix+"/users/me/api-keys", app.requireActivatedUser(app.createAPIKey))
+	router.HandlerFunc(http.MethodDelete, prefix+"/users/me/api-keys/:id", app.requireActivatedUser(app.deleteAPIKey))
+
+	router.HandlerFunc(http.MethodGet, prefix+"/admin/users", app.requirePermission(data.PermissionUsersAdmin, app.listUsers))
+	router.HandlerFunc(http.MethodPut, prefix+"/admin/users/:id/role", app.requirePermission(data.PermissionUsersAdmin, app.updateUserRole))
+	router.HandlerFunc(http.MethodGet, prefix+"/admin/audit", app.requirePermission(data.PermissionUsersAdmin, app.listAuditLog))
+	router.HandlerFunc(http.MethodDelete, prefix+"/admin/anime/:id", app.requirePermission(data.PermissionUsersAdmin, app.purgeAnime))
+	router.HandlerFunc(http.MethodGet, prefix+"/admin/ip-rules", app.requirePermission(data.PermissionUsersAdmin, app.listIPRules))
+	router.HandlerFunc(http.MethodPut, prefix+"/admin/ip-rules/:list", app.requirePermission(data.PermissionUsersAdmin, app.updateIPRules))
+	router.HandlerFunc(http.MethodPost, prefix+"/admin/config/reload", app.requirePermission(data.PermissionUsersAdmin, app.reloadConfigHandler))
+
+	// login, in short
+	router.HandlerFunc(http.MethodPost, prefix+"/tokens/authentication", app.rateLimitPolicy(rateLimitAuth, app.createAuthenticationToken))
+	router.HandlerFunc(http.MethodDelete, prefix+"/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationToken))
+	router.HandlerFunc(http.MethodPost, prefix+"/tokens/activation", app.rateLimitPolicy(rateLimitAuth, app.createActivationToken))
+	router.HandlerFunc(http.MethodPost, prefix+"/tokens/password-reset", app.rateLimitPolicy(rateLimitAuth, app.createPasswordResetToken))
+
+	// Register a new GET /metrics endpoint pointing to the expvar handler.
+	router.HandlerFunc(http.MethodGet, prefix+"/metrics", app.requirePermission(data.PermissionMetricsRead, expvar.Handler().ServeHTTP))
+
+	mux.HandleFunc("POST "+prefix+"/anime/import", app.requirePermission(data.PermissionAnimeWrite, app.importAnime))
+	mux.HandleFunc("GET "+prefix+"/anime/export", app.requirePermission(data.PermissionAnimeWrite, app.exportAnime))
+	mux.HandleFunc("GET "+prefix+"/anime/external/{source}/{id}", app.requirePermission(data.PermissionAnimeRead, app.showAnimeByExternalID))
+	mux.HandleFunc("GET "+prefix+"/anime/slug/{slug}", app.requirePermission(data.PermissionAnimeRead, app.showAnimeBySlug))
+	mux.HandleFunc("GET "+prefix+"/anime/trending", app.requirePermission(data.PermissionAnimeRead, app.listTrending))
+	mux.HandleFunc("GET "+prefix+"/anime/stats", app.requirePermission(data.PermissionAnimeRead, app.showStats))
+
+	// The catalog imports live here as well, as the season one has a static segment
+	// where the single anime one has its ID.
+	mux.HandleFunc("POST "+prefix+"/admin/import/{source}/{id}", app.requirePermission(data.PermissionUsersAdmin, app.importCatalogAnime))
+	mux.HandleFunc("POST "+prefix+"/admin/import/{source}/seasons/{year}/{season}", app.requirePermission(data.PermissionUsersAdmin, app.importCatalogSeason))
 }
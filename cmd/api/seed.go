@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+)
+
+// seedPassword is the password of every seeded user.
+const seedPassword = "pa55word"
+
+// seedAnime is an anime of the sample data, turned into a data.Anime by anime().
+type seedAnime struct {
+	title     string
+	altTitles []string
+	typ       data.AnimeType
+	episodes  int32
+	status    data.Status
+	season    data.Season
+	year      int32
+	duration  data.Duration
+	tags      []string
+	studios   []string
+	synopsis  string
+	malID     int32
+}
+
+func (s seedAnime) anime() *data.Anime {
+	anime := &data.Anime{
+		Title:     s.title,
+		AltTitles: s.altTitles,
+		Type:      s.typ,
+		Episodes:  &s.episodes,
+		Status:    s.status,
+		Year:      &s.year,
+		Duration:  &s.duration,
+		Tags:      s.tags,
+		Studios:   s.studios,
+		Synopsis:  s.synopsis,
+		MalID:     &s.malID,
+	}
+	if s.season != "" {
+		anime.Season = &s.season
+	}
+
+	return anime
+}
+
+// seedAnimeList is the sample catalog. The anime are told apart by their MyAnimeList
+// ID, so that seeding twice doesn't add them twice.
+var seedAnimeList = []seedAnime{
+	{
+		title: "Cowboy Bebop", typ: data.TV, episodes: 26, status: data.Finished,
+		season: data.Spring, year: 1998, duration: 24, malID: 1,
+		tags: []string{"action", "sci-fi", "space"}, studios: []string{"Sunrise"},
+		synopsis: "A ragtag crew of bounty hunters chases criminals across the solar system aboard the spaceship Bebop.",
+	},
+	{
+		title: "Neon Genesis Evangelion", altTitles: []string{"Shinseiki Evangelion"}, typ: data.TV, episodes: 26, status: data.Finished,
+		season: data.Fall, year: 1995, duration: 24, malID: 30,
+		tags: []string{"action", "mecha", "psychological"}, studios: []string{"Gainax", "Tatsunoko Production"},
+		synopsis: "Teenagers pilot giant bio-machines to defend Tokyo-3 from mysterious beings called Angels.",
+	},
+	{
+		title: "Spirited Away", altTitles: []string{"Sen to Chihiro no Kamikakushi"}, typ: data.Movie, episodes: 1, status: data.Finished,
+		season: data.Summer, year: 2001, duration: 125, malID: 199,
+		tags: []string{"adventure", "fantasy", "supernatural"}, studios: []string{"Studio Ghibli"},
+		synopsis: "A girl wanders into a world of spirits and has to work in a bathhouse to free her parents.",
+	},
+	{
+		title: "Mushishi", typ: data.TV, episodes: 26, status: data.Finished,
+		season: data.Fall, year: 2005, duration: 25, malID: 457,
+		tags: []string{"adventure", "mystery", "slice of life", "supernatural"}, studios: []string{"Artland"},
+		synopsis: "A wandering Mushi master helps the people troubled by the primitive creatures known as Mushi.",
+	},
+	{
+		title: "Fullmetal Alchemist: Brotherhood", altTitles: []string{"Hagane no Renkinjutsushi: Fullmetal Alchemist"}, typ: data.TV, episodes: 64, status: data.Finished,
+		season: data.Spring, year: 2009, duration: 24, malID: 5114,
+		tags: []string{"action", "adventure", "drama", "fantasy"}, studios: []string{"Bones"},
+		synopsis: "Two brothers search for the Philosopher's Stone to restore the bodies they lost to a failed alchemy.",
+	},
+	{
+		title: "Steins;Gate", typ: data.TV, episodes: 24, status: data.Finished,
+		season: data.Spring, year: 2011, duration: 24, malID: 9253,
+		tags: []string{"drama", "psychological", "sci-fi", "thriller"}, studios: []string{"White Fox"},
+		synopsis: "A self-proclaimed mad scientist finds out how to send messages to the past, and what it costs.",
+	},
+	{
+		title: "Hyouka", typ: data.TV, episodes: 22, status: data.Finished,
+		season: data.Spring, year: 2012, duration: 24, malID: 12189,
+		tags: []string{"mystery", "school", "slice of life"}, studios: []string{"Kyoto Animation"},
+		synopsis: "An energy-saving student is dragged into solving the everyday mysteries of the Classics Club.",
+	},
+	{
+		title: "Mob Psycho 100", typ: data.TV, episodes: 12, status: data.Finished,
+		season: data.Summer, year: 2016, duration: 24, malID: 32182,
+		tags: []string{"action", "comedy", "supernatural"}, studios: []string{"Bones"},
+		synopsis: "A psychic middle schooler tries to live a normal life while working for a con man exorcist.",
+	},
+	{
+		title: "Your Name.", altTitles: []string{"Kimi no Na wa."}, typ: data.Movie, episodes: 1, status: data.Finished,
+		season: data.Summer, year: 2016, duration: 106, malID: 32281,
+		tags: []string{"drama", "romance", "supernatural"}, studios: []string{"CoMix Wave Films"},
+		synopsis: "A girl from the countryside and a boy from Tokyo wake up in each other's bodies.",
+	},
+	{
+		title: "Youjo Senki", altTitles: []string{"Saga of Tanya the Evil"}, typ: data.TV, episodes: 12, status: data.Finished,
+		season: data.Winter, year: 2017, duration: 24, malID: 32615,
+		tags: []string{"action", "fantasy", "military"}, studios: []string{"Nut"},
+		synopsis: "A ruthless salaryman is reborn as a little girl fighting in the army of an alternate Europe.",
+	},
+	{
+		title: "Violet Evergarden", typ: data.TV, episodes: 13, status: data.Finished,
+		season: data.Winter, year: 2018, duration: 24, malID: 33352,
+		tags: []string{"drama", "fantasy", "slice of life"}, studios: []string{"Kyoto Animation"},
+		synopsis: "A former child soldier becomes a ghostwriter of letters, to understand the last words of her major.",
+	},
+	{
+		title: "Sousou no Frieren", altTitles: []string{"Frieren: Beyond Journey's End"}, typ: data.TV, episodes: 28, status: data.Finished,
+		season: data.Fall, year: 2023, duration: 24, malID: 52991,
+		tags: []string{"adventure", "drama", "fantasy"}, studios: []string{"Madhouse"},
+		synopsis: "An elf mage retraces the journey of her late party, learning what those years meant to them.",
+	},
+}
+
+// seedUser is a user of the sample data, seeded activated with seedPassword.
+type seedUser struct {
+	name  string
+	email string
+	role  string
+}
+
+// seedUsers are the sample users, one for each role, to try the permissions with.
+var seedUsers = []seedUser{
+	{name: "Admin", email: "admin@example.com", role: data.RoleAdmin},
+	{name: "Moderator", email: "moderator@example.com", role: data.RoleModerator},
+	{name: "Alice", email: "alice@example.com", role: data.RoleUser},
+	{name: "Bob", email: "bob@example.com", role: data.RoleUser},
+}
+
+// runSeed fills the database with the sample anime, tags and users, for development and
+// demos. It goes through the repositories, as the API does, and skips what's already
+// there, so that it can be run again.
+func (app *application) runSeed(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	// The seeded users all have the same, well known, password.
+	if app.config.env == "production" {
+		return errors.New("refusing to seed a production database")
+	}
+
+	ctx := context.Background()
+
+	// The permission scopes are seeded at startup already, but the roles rely on them.
+	err := app.repos.Permission.Seed(ctx, data.PermissionCodes()...)
+	if err != nil {
+		return err
+	}
+
+	created, skipped := 0, 0
+	for _, s := range seedAnimeList {
+		ok, err := app.seedAnime(ctx, s.anime())
+		if err != nil {
+			return fmt.Errorf("seeding %q: %w", s.title, err)
+		}
+		if ok {
+			created++
+		} else {
+			skipped++
+		}
+	}
+	app.logger.Info("anime seeded", "created", created, "skipped", skipped)
+
+	created, skipped = 0, 0
+	for _, s := range seedUsers {
+		ok, err := app.seedUser(ctx, s)
+		if err != nil {
+			return fmt.Errorf("seeding %q: %w", s.email, err)
+		}
+		if ok {
+			created++
+		} else {
+			skipped++
+		}
+	}
+	app.logger.Info("users seeded", "created", created, "skipped", skipped, "password", seedPassword)
+
+	return nil
+}
+
+// seedAnime inserts an anime, along with its tags and studios, unless one with its
+// MyAnimeList ID exists. It reports whether it inserted it.
+func (app *application) seedAnime(ctx context.Context, anime *data.Anime) (bool, error) {
+	v := validator.New()
+	if data.ValidateAnime(v, anime); !v.Valid() {
+		return false, fmt.Errorf("invalid anime: %v", v.Errors)
+	}
+
+	_, err := app.repos.Anime.GetAnimeByExternalID(ctx, data.SourceMAL, *anime.MalID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, repository.ErrRecordNotFound) {
+		return false, err
+	}
+
+	err = app.tx.WithinTx(ctx, func(repos repository.Repositories) error {
+		err := repos.Anime.InsertAnime(ctx, anime)
+		if err != nil {
+			return err
+		}
+
+		return auditAs(ctx, repos, nil, data.AuditActionCreate, data.AuditEntityAnime, int64(anime.ID), nil, anime)
+	})
+
+	return err == nil, err
+}
+
+// seedUser inserts an activated user with the permissions of their role, unless one
+// with their email exists. It reports whether it inserted them.
+func (app *application) seedUser(ctx context.Context, s seedUser) (bool, error) {
+	_, err := app.repos.User.GetByEmail(ctx, s.email)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, repository.ErrRecordNotFound) {
+		return false, err
+	}
+
+	user := &data.User{
+		Name:      s.name,
+		Email:     s.email,
+		Activated: true,
+	}
+
+	err = user.Password.Set(seedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	err = app.tx.WithinTx(ctx, func(repos repository.Repositories) error {
+		err := repos.User.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = repos.Permission.AssignRole(ctx, user.ID, s.role)
+		if err != nil {
+			return err
+		}
+
+		return recordEvent(ctx, repos, data.AuditActionCreate, data.AuditEntityUser, user.ID, user)
+	})
+
+	return err == nil, err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"golang.org/x/crypto/acme/autocert"
 	"log/slog"
 	"net/http"
 	"os"
@@ -23,6 +24,29 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	// Shutdown() doesn't wait for the hijacked connections, so the notification
+	// WebSockets are told to close as it starts.
+	srv.RegisterOnShutdown(app.notifications.Shutdown)
+
+	// Serve over HTTPS when a certificate is configured, or obtained from Let's
+	// Encrypt, and redirect the plain HTTP requests there.
+	var manager *autocert.Manager
+	if app.tlsEnabled() {
+		srv.TLSConfig, manager = app.newTLSConfig()
+	}
+	shutdownRedirect := app.serveRedirect(manager)
+
+	// Start the periodic jobs, and the background jobs which wait on something else
+	// than a clock. Closing the done channel during shutdown stops the latter.
+	app.scheduler.Start()
+	done := make(chan struct{})
+	app.dispatchEmails(done)
+	app.relayEvents(done)
+	app.reloadOnSignal(done)
+
+	// Start the debug server, if enabled.
+	shutdownDebug := app.serveDebug()
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -48,14 +72,15 @@ func (app *application) serve() error {
 		// Update the log entry to say "shutting down server" instead of "caught signal".
 		app.logger.Info("shutting down server", "signal", s.String())
 
-		// Create a context with a 60-second timeout.
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		// Create a context with the shutdown timeout. It covers both the requests in
+		// flight and the background tasks.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel()
 
 		// Call Shutdown() on our server, passing in the context we just made.
 		// Shutdown() will return nil if the graceful shutdown was successful, or an
 		// error (which may happen because of a problem closing the listeners, or
-		// because the shutdown didn't complete before the 30-second context deadline is
+		// because the shutdown didn't complete before the shutdown deadline is
 		// hit). We relay this return value to the shutdownError channel.
 		//
 		// Call Shutdown() on the server like before, but now we only send on the
@@ -65,26 +90,71 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// The debug and redirect servers have nothing worth waiting for, so they are
+		// closed as well.
+		if err := shutdownDebug(ctx); err != nil {
+			app.logger.Error("failed to shut down debug server", "error", err.Error())
+		}
+		if err := shutdownRedirect(ctx); err != nil {
+			app.logger.Error("failed to shut down https redirect server", "error", err.Error())
+		}
+
+		// Stop the background jobs, and the cleanup of the rate limiters.
+		close(done)
+		app.stopRateLimiters()
+
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
 		// Call Wait() to block until our WaitGroup counter is zero --- essentially
-		// blocking until the background goroutines have finished. Then we return nil on
-		// the shutdownError channel, to indicate that the shutdown completed without
-		// any issues.
-		app.wg.Wait()
+		// blocking until the background jobs have finished. The jobs may still queue
+		// tasks until then, so the worker pool is only shut down after them, running the
+		// tasks it has queued. Then we return nil on the shutdownError channel, to
+		// indicate that the shutdown completed without any issues. If the shutdown
+		// deadline passes first, the tasks still queued or running are abandoned, and we
+		// say how many of them there were.
+		//
+		// The activity counted since the last flush is written once the scheduler is
+		// stopped, so that it isn't lost.
+		drained := make(chan struct{})
+		go func() {
+			_ = app.scheduler.Stop(ctx)
+			if err := app.writeActivity(ctx); err != nil {
+				app.logger.Error("failed to record anime activity", "error", err.Error())
+			}
+
+			app.wg.Wait()
+			_ = app.workers.Shutdown(ctx)
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			app.logger.Warn("shutdown deadline exceeded, abandoning background tasks", "queued", app.workers.Queued(), "running", app.workers.Running())
+		}
 		shutdownError <- nil
 	}()
 
 	// Likewise log a "starting server" message.
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "tls", app.tlsEnabled())
 
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. So we check
 	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
+	//
+	// In autocert mode, there are no certificate files: the certificates come from
+	// the GetCertificate callback of the TLS config.
+	var err error
+	if app.tlsEnabled() {
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
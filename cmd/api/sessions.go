@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+)
+
+// Sessions are backed by stateful authentication tokens, so they can't be listed or
+// revoked individually in JWT mode.
+func (app *application) sessionsUnavailable(w http.ResponseWriter, r *http.Request) {
+	message := "session management is not available when using jwt authentication"
+	app.error(w, r, http.StatusNotImplemented, message)
+}
+
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	if app.config.auth.mode == authModeJWT {
+		app.sessionsUnavailable(w, r)
+		return
+	}
+
+	sessions, err := app.repos.Token.GetSessionsForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	// Flag the session that made this request.
+	if token, ok := app.readBearerToken(r); ok {
+		hash := sha256.Sum256([]byte(token))
+		for _, session := range sessions {
+			session.Current = bytes.Equal(session.Hash, hash[:])
+		}
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) deleteSession(w http.ResponseWriter, r *http.Request) {
+	if app.config.auth.mode == authModeJWT {
+		app.sessionsUnavailable(w, r)
+		return
+	}
+
+	id, err := app.readID(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.repos.Token.DeleteSession(r.Context(), int64(id), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
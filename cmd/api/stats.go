@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsCache keeps the last catalog statistics for a while, as they're computed over
+// the whole catalog and dashboards tend to poll them. The zero value is ready to use.
+type statsCache struct {
+	mu      sync.Mutex
+	stats   *data.CatalogStats
+	expires time.Time
+}
+
+// catalogStats returns the catalog statistics with the most tags there can be, from
+// the cache if they're recent enough. The lock is held while they're computed, so that
+// concurrent requests on an expired cache wait for one computation instead of each
+// running their own.
+func (app *application) catalogStats(ctx context.Context) (*data.CatalogStats, error) {
+	ttl := app.config.stats.cacheTTL
+	if ttl <= 0 {
+		return app.repos.Anime.GetStats(ctx, data.MaxStatsTags)
+	}
+
+	app.stats.mu.Lock()
+	defer app.stats.mu.Unlock()
+
+	if app.stats.stats != nil && time.Now().Before(app.stats.expires) {
+		return app.stats.stats, nil
+	}
+
+	stats, err := app.repos.Anime.GetStats(ctx, data.MaxStatsTags)
+	if err != nil {
+		return nil, err
+	}
+
+	app.stats.stats = stats
+	app.stats.expires = stats.ComputedAt.Add(ttl)
+
+	return stats, nil
+}
+
+// Show statistics about the catalog: the number of anime by type, status, season and
+// decade, and the most used tags. The tags parameter sets how many tags are returned.
+func (app *application) showStats(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	tags := app.readInt(r.URL.Query(), "tags", 10, v)
+	v.Check(tags >= 0, "tags", "must not be negative")
+	v.Check(tags <= data.MaxStatsTags, "tags", "must be a maximum of 50")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.catalogStats(r.Context())
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	// The cached statistics are shared, so the tags are trimmed on a copy.
+	trimmed := *stats
+	trimmed.TopTags = stats.TopTags[:min(len(stats.TopTags), tags)]
+
+	err = app.write(w, r, http.StatusOK, envelope{"stats": trimmed}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"net/url"
+)
+
+type studioRequest struct {
+	Name *string `json:"name"`
+}
+
+func (app *application) listStudios(w http.ResponseWriter, r *http.Request) {
+	studios, err := app.repos.Anime.GetAllStudios(r.Context())
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"studios": studios}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Create a studio ahead of crediting any anime to it.
+func (app *application) createStudio(w http.ResponseWriter, r *http.Request) {
+	var request studioRequest
+	err := app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(request.Name != nil, "name", "must be provided"); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	studio := &data.Studio{Name: *request.Name}
+	if data.ValidateStudio(v, studio); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.InsertStudio(r.Context(), studio)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityStudio, int64(studio.ID), nil, studio)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", versionPath(r, "/studios/%s", url.PathEscape(studio.Name)))
+
+	err = app.write(w, r, http.StatusCreated, envelope{"studio": studio}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Rename a studio. The new name shows up on every anime it made.
+func (app *application) updateStudio(w http.ResponseWriter, r *http.Request) {
+	studio, err := app.repos.Anime.GetStudio(r.Context(), app.readName(r))
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	var request studioRequest
+	err = app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	before := *studio
+	if request.Name != nil {
+		studio.Name = *request.Name
+	}
+
+	v := validator.New()
+	if data.ValidateStudio(v, studio); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.UpdateStudio(r.Context(), studio)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityStudio, int64(studio.ID), &before, studio)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"studio": studio}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Delete a studio. Studios that are still linked to anime are only deleted with
+// force=true, in which case they are removed from those anime as well.
+func (app *application) deleteStudio(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	force := app.readBool(r.URL.Query(), "force", v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	studio, err := app.repos.Anime.GetStudio(r.Context(), app.readName(r))
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.DeleteStudio(r.Context(), studio.ID, force != nil && *force)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionDelete, data.AuditEntityStudio, int64(studio.ID), studio, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordInUse):
+			app.error(w, r, http.StatusConflict, "studio is still linked to some anime, use force=true to delete it anyway")
+		default:
+			app.dbReadError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "studio successfully deleted"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
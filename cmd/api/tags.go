@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"net/url"
+)
+
+type tagRequest struct {
+	Name *string `json:"name"`
+}
+
+// Create a tag ahead of tagging any anime with it.
+func (app *application) createTag(w http.ResponseWriter, r *http.Request) {
+	var request tagRequest
+	err := app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(request.Name != nil, "name", "must be provided"); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	tag := &data.Tag{Name: *request.Name}
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.InsertTag(r.Context(), tag)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionCreate, data.AuditEntityTag, int64(tag.ID), nil, tag)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", versionPath(r, "/tags/%s", url.PathEscape(tag.Name)))
+
+	err = app.write(w, r, http.StatusCreated, envelope{"tag": tag}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Rename a tag. The new name shows up on every anime tagged with it.
+func (app *application) updateTag(w http.ResponseWriter, r *http.Request) {
+	tag, err := app.repos.Anime.GetTag(r.Context(), app.readName(r))
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	var request tagRequest
+	err = app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	before := *tag
+	if request.Name != nil {
+		tag.Name = *request.Name
+	}
+
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.UpdateTag(r.Context(), tag)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionUpdate, data.AuditEntityTag, int64(tag.ID), &before, tag)
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Delete a tag. Tags that are still used by anime are only deleted with force=true, in
+// which case they are removed from those anime as well, unless one would be left with
+// no tag.
+func (app *application) deleteTag(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	force := app.readBool(r.URL.Query(), "force", v)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	tag, err := app.repos.Anime.GetTag(r.Context(), app.readName(r))
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.Anime.DeleteTag(r.Context(), tag.ID, force != nil && *force)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r, repos, data.AuditActionDelete, data.AuditEntityTag, int64(tag.ID), tag, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordInUse):
+			app.error(w, r, http.StatusConflict, "tag is still used by some anime, use force=true to delete it anyway")
+		case errors.Is(err, repository.ErrOnlyTag):
+			app.error(w, r, http.StatusConflict, "tag is the only tag of some anime, which must keep at least one")
+		default:
+			app.dbReadError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "tag successfully deleted"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Merge a duplicate tag into another one: every anime tagged with the source tag gets
+// the target tag instead, and the source tag is deleted.
+func (app *application) mergeTags(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+	}
+
+	err := app.readBody(w, r, &request)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(request.Source != "", "source", "must be provided")
+	v.Check(request.Target != "", "target", "must be provided")
+	v.Check(request.Source != request.Target, "target", "must be different from source")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	var source, target *data.Tag
+	var moved int64
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		var err error
+		if source, err = repos.Anime.GetTag(r.Context(), request.Source); err != nil {
+			return err
+		}
+		if target, err = repos.Anime.GetTag(r.Context(), request.Target); err != nil {
+			return err
+		}
+
+		moved, err = repos.Anime.MergeTags(r.Context(), source.ID, target.ID)
+		if err != nil {
+			return err
+		}
+
+		// The entry belongs to the tag that remains, with the merged tag as before.
+		return app.audit(r, repos, data.AuditActionMerge, data.AuditEntityTag, int64(target.ID), source, target)
+	})
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"tag": target, "merged": source, "anime_count": moved}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
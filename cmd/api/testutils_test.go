@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/reporting"
+	"github.com/ziliscite/purplelight/internal/repository/memory"
+)
+
+// newTestApplication returns an application backed by the in-memory repositories, which
+// discards its logs and reports.
+func newTestApplication(t *testing.T, cfg Config) *application {
+	t.Helper()
+
+	repos := memory.NewRepositories()
+
+	return &application{
+		config:   cfg,
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		repos:    repos,
+		tx:       memory.Transactor{Repos: repos},
+		reporter: reporting.Nop{},
+		live:     newLiveSettings(cfg, new(slog.LevelVar)),
+	}
+}
+
+// insertUser adds a user with the given permissions to the repositories of app.
+func insertUser(t *testing.T, app *application, email string, activated bool, codes ...string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test", Email: email, Activated: activated}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.repos.User.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.repos.Permission.AddForUser(context.Background(), user.ID, codes...); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// tlsEnabled reports whether the API is served over HTTPS, with either a certificate of
+// our own or one obtained from Let's Encrypt.
+func (app *application) tlsEnabled() bool {
+	return app.config.tls.certFile != "" || len(app.config.tls.autocertHosts) > 0
+}
+
+// newTLSConfig returns the TLS settings of the server: TLS 1.2 at least, and the curves
+// with assembly implementations first. In autocert mode, the certificates come from
+// Let's Encrypt through the returned manager; otherwise the manager is nil, and the
+// certificate is loaded from the files given on the command line.
+func (app *application) newTLSConfig() (*tls.Config, *autocert.Manager) {
+	cfg := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+
+	if len(app.config.tls.autocertHosts) == 0 {
+		return cfg, nil
+	}
+
+	// Only the hosts we serve get certificates, so that anyone pointing a domain at
+	// our IP can't make us hit the Let's Encrypt rate limits.
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(app.config.tls.autocertHosts...),
+		Cache:      autocert.DirCache(app.config.tls.autocertCacheDir),
+		Email:      app.config.tls.autocertEmail,
+	}
+
+	cfg.GetCertificate = manager.GetCertificate
+	// The tls-alpn-01 challenge is answered on the HTTPS listener itself.
+	cfg.NextProtos = append([]string{"h2", "http/1.1"}, "acme-tls/1")
+
+	return cfg, manager
+}
+
+// redirectToHTTPS redirects every request to the same URL over HTTPS, on the port the
+// API is served on.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// serveRedirect starts the secondary plain HTTP server redirecting to HTTPS, if the API
+// is served over HTTPS and a redirect port is set. In autocert mode, it answers the
+// http-01 challenges of Let's Encrypt as well.
+//
+// The returned function shuts the redirect server down.
+func (app *application) serveRedirect(manager *autocert.Manager) func(ctx context.Context) error {
+	if !app.tlsEnabled() || app.config.tls.redirectPort == 0 {
+		return func(context.Context) error { return nil }
+	}
+
+	var handler http.Handler = http.HandlerFunc(app.redirectToHTTPS)
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.config.tls.redirectPort),
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       time.Minute,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	go func() {
+		app.logger.Info("starting https redirect server", "addr", srv.Addr)
+
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("https redirect server failed", "addr", srv.Addr, "error", err.Error())
+		}
+	}()
+
+	return srv.Shutdown
+}
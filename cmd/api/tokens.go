@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/mailer"
 	"github.com/ziliscite/purplelight/internal/repository"
 	"github.com/ziliscite/purplelight/internal/validator"
 	"net/http"
@@ -30,7 +31,7 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 
 	// Try to retrieve the corresponding user record for the email address. If it can't
 	// be found, return an error message to the client.
-	user, err := app.repos.User.GetByEmail(input.Email)
+	user, err := app.repos.User.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, repository.ErrRecordNotFound):
@@ -49,30 +50,49 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Otherwise, create a new activation token.
-	token, err := app.repos.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.dbWriteError(w, r, err)
-		return
+	// Refuse to send another activation email until the cooldown is over. Registering
+	// counts too, since the welcome email carries an activation token. Two requests at
+	// the same time may both get through, which is fine: the cooldown only has to stop
+	// an inbox from being flooded.
+	if app.config.activationCooldown > 0 {
+		issuedAt, err := app.repos.Token.LastIssued(r.Context(), user.ID, data.ScopeActivation)
+		if err != nil {
+			app.dbReadError(w, r, err)
+			return
+		}
+
+		if issuedAt != nil {
+			if wait := time.Until(issuedAt.Add(app.config.activationCooldown)); wait > 0 {
+				app.emailThrottled(w, r, wait)
+				return
+			}
+		}
 	}
 
-	// Email the user with their additional activation token.
-	app.background(func() {
-		tokenData := map[string]any{
-			"activationToken": token.Plaintext,
+	// Otherwise, create a new activation token, and queue the email
+	// carrying it in the same transaction.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		token, err := repos.Token.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation, app.tokenIssuer(r))
+		if err != nil {
+			return err
 		}
 
 		// Since email addresses MAY be case sensitive, notice that we are sending this
 		// email using the address stored in our database for the user --- not to the
 		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", tokenData)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
+		return repos.Email.Enqueue(r.Context(), newEmail(user.Email, mailer.TemplateActivation, map[string]any{
+			"activationToken": token.Plaintext,
+		}))
 	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	app.notifyOutbox()
 
 	// Send a 202 Accepted response and confirmation message to the client.
-	err = app.write(w, http.StatusAccepted, envelope{"message": "an email will be sent to you containing activation instructions"}, nil)
+	err = app.write(w, r, http.StatusAccepted, envelope{"message": "an email will be sent to you containing activation instructions"}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -105,7 +125,7 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
-	user, err := app.repos.User.GetByEmail(input.Email)
+	user, err := app.repos.User.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, repository.ErrRecordNotFound):
@@ -116,6 +136,12 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 		return
 	}
 
+	// Refuse to even check the password while the account is locked.
+	if remaining, locked := user.IsLocked(); locked {
+		app.accountLocked(w, r, remaining)
+		return
+	}
+
 	// Check if the provided password matches the actual password for the user.
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
@@ -123,16 +149,39 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failure, which may lock the
+	// account, and call the app.invalidCredentialsResponse() helper again and return.
 	if !match {
+		lockedUntil, err := app.repos.User.RecordFailedLogin(r.Context(), user.ID, app.config.login.maxAttempts, app.config.login.lockout)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if lockedUntil != nil && time.Until(*lockedUntil) > 0 {
+			app.accountLocked(w, r, time.Until(*lockedUntil))
+			return
+		}
+
 		app.invalidCredentials(w, r)
 		return
 	}
 
+	err = app.repos.User.ResetFailedLogins(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.repos.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// expiry time and the scope 'authentication'. In JWT mode the token is signed
+	// rather than stored.
+	var token *data.Token
+	if app.config.auth.mode == authModeJWT {
+		token, err = app.issueJWT(user, 24*time.Hour)
+	} else {
+		token, err = app.repos.Token.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication, app.tokenIssuer(r))
+	}
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -140,7 +189,108 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
-	err = app.write(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.write(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// The deleteAuthenticationToken() handler logs the current token out. Stateful tokens are
+// deleted from the database, while JWTs are added to the revocation list.
+func (app *application) deleteAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readBearerToken(r)
+	if !ok {
+		app.invalidAuthenticationToken(w, r)
+		return
+	}
+
+	var err error
+	if app.config.auth.mode == authModeJWT {
+		err = app.revokeJWT(r.Context(), token)
+	} else {
+		err = app.repos.Token.Delete(r.Context(), data.ScopeAuthentication, token)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidJWT):
+			app.invalidAuthenticationToken(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Generate a password reset token and send it to the user's email address.
+func (app *application) createPasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. If it can't
+	// be found, return an error message to the client.
+	user, err := app.repos.User.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidation(w, r, v.Errors)
+		default:
+			app.dbReadError(w, r, err)
+		}
+		return
+	}
+
+	// Return an error message if the user is not activated.
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Otherwise, create a new password reset token with a 45-minute expiry time, and queue
+	// the email carrying it in the same transaction.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		token, err := repos.Token.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset, app.tokenIssuer(r))
+		if err != nil {
+			return err
+		}
+
+		// Since email addresses MAY be case sensitive, notice that we are sending this
+		// email using the address stored in our database for the user --- not to the
+		// input.Email address provided by the client in this request.
+		return repos.Email.Enqueue(r.Context(), newEmail(user.Email, mailer.TemplatePasswordReset, map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}))
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	app.notifyOutbox()
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	err = app.write(w, r, http.StatusAccepted, envelope{"message": "an email will be sent to you containing password reset instructions"}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
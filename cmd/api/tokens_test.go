@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecordFailedLoginWindow(t *testing.T) {
+	app := newTestApplication(t, Config{})
+	ctx := context.Background()
+
+	user := insertUser(t, app, "alice@example.com", true)
+
+	const maxAttempts = 3
+	const lockout = 100 * time.Millisecond
+
+	fail := func() bool {
+		t.Helper()
+
+		lockedUntil, err := app.repos.User.RecordFailedLogin(ctx, user.ID, maxAttempts, lockout)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return lockedUntil != nil && time.Until(*lockedUntil) > 0
+	}
+
+	// Failures further apart than the lockout window don't add up.
+	for i := 0; i < maxAttempts; i++ {
+		if fail() {
+			t.Fatalf("the account was locked after the failure %d, spread out", i+1)
+		}
+		time.Sleep(lockout + 10*time.Millisecond)
+	}
+
+	// Failures in quick succession do.
+	for i := 0; i < maxAttempts-1; i++ {
+		if fail() {
+			t.Fatalf("the account was locked after the failure %d, want it locked after %d", i+1, maxAttempts)
+		}
+	}
+	if !fail() {
+		t.Fatalf("the account wasn't locked after %d failures in quick succession", maxAttempts)
+	}
+}
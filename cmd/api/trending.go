@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// activityKey identifies the hourly bucket of an anime.
+type activityKey struct {
+	animeID int32
+	bucket  time.Time
+}
+
+// activityCounter counts the activity on anime in memory, so that counting a view
+// doesn't cost a database write. The counts are written by the flushActivity() job.
+// The zero value is ready to use.
+type activityCounter struct {
+	mu     sync.Mutex
+	counts map[activityKey]*data.AnimeActivity
+}
+
+func (c *activityCounter) add(animeID int32, views, watchlistAdds int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[activityKey]*data.AnimeActivity)
+	}
+
+	key := activityKey{animeID: animeID, bucket: time.Now().UTC().Truncate(time.Hour)}
+
+	activity, ok := c.counts[key]
+	if !ok {
+		activity = &data.AnimeActivity{AnimeID: animeID, Bucket: key.bucket}
+		c.counts[key] = activity
+	}
+
+	activity.Views += views
+	activity.WatchlistAdds += watchlistAdds
+}
+
+// recordView counts a view of an anime.
+func (c *activityCounter) recordView(animeID int32) {
+	c.add(animeID, 1, 0)
+}
+
+// recordWatchlistAdd counts an anime being added to a watchlist.
+func (c *activityCounter) recordWatchlistAdd(animeID int32) {
+	c.add(animeID, 0, 1)
+}
+
+// drain returns the counts so far, and starts counting from zero again.
+func (c *activityCounter) drain() []*data.AnimeActivity {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	activity := make([]*data.AnimeActivity, 0, len(c.counts))
+	for _, a := range c.counts {
+		activity = append(activity, a)
+	}
+	c.counts = nil
+
+	return activity
+}
+
+// restore adds drained counts back, when they couldn't be written.
+func (c *activityCounter) restore(activity []*data.AnimeActivity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[activityKey]*data.AnimeActivity)
+	}
+
+	for _, a := range activity {
+		key := activityKey{animeID: a.AnimeID, bucket: a.Bucket}
+		if existing, ok := c.counts[key]; ok {
+			existing.Views += a.Views
+			existing.WatchlistAdds += a.WatchlistAdds
+		} else {
+			c.counts[key] = a
+		}
+	}
+}
+
+// List the trending anime, ranked by their views and watchlist additions over the
+// trending window. The ranking is refreshed periodically rather than on every request,
+// see the refreshTrending() job.
+func (app *application) listTrending(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// The order is fixed, by score.
+	filters.Sort = "score"
+	filters.SortSafeList = []string{"score"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	trending, metadata, err := app.repos.Trending.GetTrending(r.Context(), filters)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"trending": trending, "metadata": metadata}, paginationLinks(r, metadata))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
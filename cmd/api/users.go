@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/mailer"
 	"github.com/ziliscite/purplelight/internal/repository"
 	"github.com/ziliscite/purplelight/internal/validator"
 	"net/http"
@@ -47,14 +49,44 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Yo chat, I might need to use transactions among these 3 operations
-	// It'll be real disaster if user is inserted, but then it fails midway
-	// token will not be sent, and the necessary permissions will not be granted...
+	// Inserting the user, granting the default permissions, creating the activation
+	// token and queuing the welcome email happen in a single transaction, so a failure
+	// midway doesn't leave behind a user who can never be activated or who has no
+	// permissions.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
 
-	// TODO: Refactor the codebase to use a service layer so we can manage transactions between these 3 repositories
-	// For other handlers as well
+		// Grant the default permissions to the new user.
+		err = repos.Permission.AddForUser(r.Context(), user.ID, data.DefaultPermissions...)
+		if err != nil {
+			return err
+		}
 
-	err = app.repos.User.Insert(user)
+		err = recordEvent(r.Context(), repos, data.AuditActionCreate, data.AuditEntityUser, user.ID, user)
+		if err != nil {
+			return err
+		}
+
+		// After the user record has been created in the database, generate a new
+		// activation token for the user.
+		token, err := repos.Token.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation, app.tokenIssuer(r))
+		if err != nil {
+			return err
+		}
+
+		// Queue the welcome email in the same transaction, so that it is sent if and
+		// only if the user is created. As there are now multiple pieces of data that we
+		// want to pass to our email templates, we create a map to act as a 'holding
+		// structure' for the data. This contains the plaintext version of the activation
+		// token for the user, along with their ID.
+		return repos.Email.Enqueue(r.Context(), newEmail(user.Email, mailer.TemplateWelcome, map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}))
+	})
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -68,51 +100,394 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.repos.Permission.AddForUser(user.ID, "anime:read")
+	// Now that the email is committed, have it sent right away.
+	app.notifyOutbox()
+
+	err = app.write(w, r, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
-		app.dbWriteError(w, r, err)
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
+	// Parse the plaintext activation token from the request body.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
 		return
 	}
 
-	// After the user record has been created in the database, generate a new activation
-	// token for the user.
-	token, err := app.repos.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// Validate the plaintext token provided by the client.
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the token using the
+	// GetForToken() method. If no matching record
+	// is found, then we let the client know that the token they provided is not valid.
+	user, err := app.repos.User.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
-		app.dbWriteError(w, r, err)
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidation(w, r, v.Errors)
+		default:
+			app.dbReadError(w, r, err)
+		}
 		return
 	}
 
-	// Launch a goroutine which runs an anonymous function that sends the welcome email.
-	app.background(func() {
-		// As there are now multiple pieces of data that we want to pass to our email
-		// templates, we create a map to act as a 'holding structure' for the data. This
-		// contains the plaintext version of the activation token for the user, along
-		// with their ID.
-		userData := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
+	// Update the user's activation status.
+	user.Activated = true
+
+	// Save the updated user record and delete all activation tokens for the user in
+	// the same transaction, checking for any edit conflicts in the same way that we
+	// did for our anime records.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.Update(r.Context(), user)
+		if err != nil {
+			return err
 		}
 
-		// Call the Send() method on our Mailer, passing in the user's email address,
-		// name of the template file, and the User struct containing the new user's data.
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", userData)
+		err = repos.Token.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 		if err != nil {
-			// Importantly, if there is an error sending the email then we use the
-			// app.logger.Error() helper to manage it, instead of the
-			// app.serverErrorResponse() helper like before.
-			app.logger.Error(err.Error())
+			return err
 		}
+
+		return app.audit(r, repos, data.AuditActionActivate, data.AuditEntityUser, user.ID, nil, user)
 	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEditConflict):
+			app.editConflict(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
 
-	err = app.write(w, http.StatusCreated, envelope{"user": user}, nil)
+	// Send the updated user details to the client in a JSON response.
+	err = app.write(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
 }
 
-func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
-	// Parse the plaintext activation token from the request body.
+// Verify the password reset token and set a new password for the user.
+func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Request) {
+	// Parse the user's new password and password reset token.
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	// Validate the input.
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset token,
+	// returning an error message if no matching record was found.
+	user, err := app.repos.User.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidation(w, r, v.Errors)
+		default:
+			app.dbReadError(w, r, err)
+		}
+		return
+	}
+
+	// Set the new password for the user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Save the updated user record and delete all password reset tokens for the user
+	// in the same transaction, so a used token can never outlive the reset.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return repos.Token.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEditConflict):
+			app.editConflict(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	// Send the user a confirmation message.
+	err = app.write(w, r, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Change the password of the currently authenticated user. The current password must be
+// provided, and on success every authentication token of the user is revoked so that
+// other sessions have to log in again.
+func (app *application) changeUserPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// The user in the request context may have been rebuilt from a JWT, which doesn't
+	// carry the password hash, so always reload the record from the database.
+	user, err := app.repos.User.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "does not match your current password")
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Update() bumps the user version, which also invalidates any JWTs issued for the
+	// previous version. The stateful sessions are revoked in the same transaction.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return repos.Token.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEditConflict):
+			app.editConflict(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "your password was successfully changed, please log in again"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Partially update the profile of the currently authenticated user. Only the fields
+// present in the request body are changed.
+func (app *application) updateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.repos.User.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	// If the request has an If-Match header, verify that the user hasn't changed since
+	// the client fetched it.
+	if !app.checkPreconditions(w, r, versionETag(user.ID, int32(user.Version), nil), user.Version) {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Update() only succeeds if the version hasn't changed since we read the record,
+	// so concurrent edits surface as an edit conflict.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return recordEvent(r.Context(), repos, data.AuditActionUpdate, data.AuditEntityUser, user.ID, user)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEditConflict):
+			app.editConflict(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(user.ID, int32(user.Version), nil))
+
+	err = app.write(w, r, http.StatusOK, envelope{"user": user}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Start changing the email address of the currently authenticated user. The new address
+// is stored as pending and a verification token is sent to it; the switch only happens
+// once that token is confirmed.
+func (app *application) changeUserEmail(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.repos.User.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "does not match your current password")
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// Fail early if the address is already taken. The UNIQUE constraint is checked
+	// again when the change is confirmed.
+	_, err = app.repos.User.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.insertConflict(w, r, v.Errors)
+		return
+	case !errors.Is(err, repository.ErrRecordNotFound):
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.SetPendingEmail(r.Context(), user.ID, input.Email)
+		if err != nil {
+			return err
+		}
+
+		// Only the most recently requested address can be confirmed.
+		err = repos.Token.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := repos.Token.New(r.Context(), user.ID, 24*time.Hour, data.ScopeEmailChange, app.tokenIssuer(r))
+		if err != nil {
+			return err
+		}
+
+		// Note that the verification email goes to the new address, not the current one.
+		return repos.Email.Enqueue(r.Context(), newEmail(input.Email, mailer.TemplateEmailChange, map[string]any{
+			"emailChangeToken": token.Plaintext,
+		}))
+	})
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	app.notifyOutbox()
+
+	err = app.write(w, r, http.StatusAccepted, envelope{"message": "an email will be sent to your new address containing confirmation instructions"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Confirm a pending email change using the token sent to the new address.
+func (app *application) confirmUserEmail(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		TokenPlaintext string `json:"token"`
 	}
@@ -123,7 +498,6 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate the plaintext token provided by the client.
 	v := validator.New()
 
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
@@ -131,14 +505,11 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the details of the user associated with the token using the
-	// GetForToken() method. If no matching record
-	// is found, then we let the client know that the token they provided is not valid.
-	user, err := app.repos.User.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.repos.User.GetForToken(r.Context(), data.ScopeEmailChange, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, repository.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			v.AddError("token", "invalid or expired email change token")
 			app.failedValidation(w, r, v.Errors)
 		default:
 			app.dbReadError(w, r, err)
@@ -146,14 +517,26 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the user's activation status.
-	user.Activated = true
+	// Switch the email address and consume the verification tokens together, so the
+	// same token can't be confirmed twice.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := repos.User.ConfirmPendingEmail(r.Context(), user)
+		if err != nil {
+			return err
+		}
 
-	// Save the updated user record in our database, checking for any edit conflicts in
-	// the same way that we did for our movie records.
-	err = app.repos.User.Update(user)
+		err = repos.Token.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordEvent(r.Context(), repos, data.AuditActionUpdate, data.AuditEntityUser, user.ID, user)
+	})
 	if err != nil {
 		switch {
+		case errors.Is(err, repository.ErrDuplicateEntry):
+			v.AddError("email", "a user with this email address already exists")
+			app.insertConflict(w, r, v.Errors)
 		case errors.Is(err, repository.ErrEditConflict):
 			app.editConflict(w, r)
 		default:
@@ -162,18 +545,80 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// don't we usually want to use a transaction for this?
+	err = app.write(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
 
-	// If everything went successfully, then we delete all activation tokens for the
-	// user.
-	err = app.repos.Token.DeleteAllForUser(data.ScopeActivation, user.ID) // what if this fails?
+// Delete the account of the currently authenticated user. Depending on the configured
+// grace period the account is either purged straight away, or soft deleted and purged
+// later by the background job.
+func (app *application) deleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.repos.User.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	// Send the updated user details to the client in a JSON response.
-	err = app.write(w, http.StatusOK, envelope{"user": user}, nil)
+	if !match {
+		v.AddError("password", "does not match your current password")
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	message := "your account and all of its data have been deleted"
+	if app.config.accounts.deletionGrace > 0 {
+		message = fmt.Sprintf("your account has been deleted and its data will be purged in %s", app.config.accounts.deletionGrace)
+	}
+
+	// The event is recorded first, so that purging the account clears the user out of it
+	// along with the earlier ones.
+	err = app.tx.WithinTx(r.Context(), func(repos repository.Repositories) error {
+		err := recordEvent(r.Context(), repos, data.AuditActionDelete, data.AuditEntityUser, user.ID, user)
+		if err != nil {
+			return err
+		}
+
+		if app.config.accounts.deletionGrace > 0 {
+			return repos.User.SoftDelete(r.Context(), user.ID)
+		}
+		return repos.User.Delete(r.Context(), user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			app.notFound(w, r)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": message}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/url"
+)
+
+type userQuery struct {
+	Email     string
+	Activated *bool
+	data.Filters
+}
+
+func (uq *userQuery) readQuery(qs url.Values, app *application, v *validator.Validator) {
+	// Email is matched partially, while activated is an optional true/false filter.
+	uq.Email = app.readString(qs, "email", "")
+	uq.Activated = app.readBool(qs, "activated", v)
+
+	uq.Filters.Page = app.readInt(qs, "page", 1, v)
+	uq.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	uq.Filters.Sort = app.readString(qs, "sort", "id")
+	uq.Filters.SortSafeList = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+}
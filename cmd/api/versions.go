@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiVersion is a major version of the API, served under its own path prefix. The
+// versions share their routes and handlers, so that a breaking change only takes a
+// check of versionOf() in the handlers it touches. So far v2:
+//
+//   - sends errors as problem details (RFC 9457), see problem().
+//   - paginates the anime listing with cursors unless a page is asked for.
+type apiVersion int
+
+const (
+	apiV1 apiVersion = 1
+	apiV2 apiVersion = 2
+)
+
+// apiVersions lists the versions served, oldest first.
+var apiVersions = []apiVersion{apiV1, apiV2}
+
+func (v apiVersion) prefix() string {
+	return "/v" + strconv.Itoa(int(v))
+}
+
+// versionOf returns the version of the API a request was made to, read from its path.
+// Requests outside of every version, such as those for the uploaded files, are taken as
+// made to v1.
+func versionOf(r *http.Request) apiVersion {
+	for _, v := range apiVersions {
+		if strings.HasPrefix(r.URL.Path, v.prefix()+"/") {
+			return v
+		}
+	}
+
+	return apiV1
+}
+
+// v1Path returns path with its version prefix, if any, replaced by /v1, so that the
+// checks written against the paths of v1, such as the long running routes, hold for
+// every version.
+func v1Path(path string) string {
+	for _, v := range apiVersions[1:] {
+		if rest, ok := strings.CutPrefix(path, v.prefix()+"/"); ok {
+			return apiV1.prefix() + "/" + rest
+		}
+	}
+
+	return path
+}
+
+// versionPath formats a path and puts it under the version of the API the request was
+// made to, for the links sent back such as the Location header:
+//
+//	versionPath(r, "/anime/%d", anime.ID) // /v1/anime/1, or /v2/anime/1
+func versionPath(r *http.Request, format string, args ...any) string {
+	return versionOf(r).prefix() + fmt.Sprintf(format, args...)
+}
+
+// The deprecateVersions() middleware flags the responses of v1 as deprecated, along with
+// the date it may stop being served (RFC 8594) when it is set. Both are sent on errors
+// too, so that every client of v1 gets to see them.
+func (app *application) deprecateVersions(next http.Handler) http.Handler {
+	var sunset string
+	if !app.config.v1Sunset.IsZero() {
+		sunset = app.config.v1Sunset.UTC().Format(http.TimeFormat)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiV1.prefix()+"/") {
+			w.Header().Set("Deprecation", "true")
+			if sunset != "" {
+				w.Header().Set("Sunset", sunset)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"net/http"
+	"net/url"
+)
+
+type watchlistRequest struct {
+	Status          *string `json:"status"`
+	EpisodesWatched *int32  `json:"episodes_watched"`
+}
+
+// apply copies the fields present in the request onto the entry. Marking an anime as
+// completed without saying how many episodes were watched means all of them.
+func (wr watchlistRequest) apply(entry *data.WatchlistEntry, anime *data.Anime) {
+	if wr.Status != nil {
+		entry.Status = *wr.Status
+	}
+
+	if wr.EpisodesWatched != nil {
+		entry.EpisodesWatched = *wr.EpisodesWatched
+	} else if entry.Status == data.WatchStatusCompleted && anime.Episodes != nil {
+		entry.EpisodesWatched = *anime.Episodes
+	}
+}
+
+type watchlistQuery struct {
+	Status string
+	data.Filters
+}
+
+func (wq *watchlistQuery) readQuery(qs url.Values, app *application, v *validator.Validator) {
+	wq.Status = app.readString(qs, "status", "")
+	if wq.Status != "" {
+		v.Check(validator.PermittedValue(wq.Status, data.WatchStatuses...), "status", "must be one of [watching completed plan_to_watch dropped]")
+	}
+
+	wq.Filters.Page = app.readInt(qs, "page", 1, v)
+	wq.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Most recently updated first, so that the anime being watched come up on top.
+	wq.Filters.Sort = app.readString(qs, "sort", "-updated_at")
+	wq.Filters.SortSafeList = []string{"anime_id", "title", "updated_at", "created_at", "episodes_watched", "-anime_id", "-title", "-updated_at", "-created_at", "-episodes_watched"}
+}
+
+// List the watchlist of the current user, optionally only the anime with a status.
+func (app *application) listWatchlist(w http.ResponseWriter, r *http.Request) {
+	var input watchlistQuery
+
+	v := validator.New()
+
+	input.readQuery(r.URL.Query(), app, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.repos.Watchlist.GetAll(r.Context(), app.contextGetUser(r).ID, input.Status, input.Filters)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"watchlist": entries, "metadata": metadata}, paginationLinks(r, metadata))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Add an anime to the watchlist of the current user.
+func (app *application) addToWatchlist(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AnimeID int32 `json:"anime_id"`
+		watchlistRequest
+	}
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.AnimeID > 0, "anime_id", "must be provided")
+	v.Check(input.Status != nil, "status", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnime(r.Context(), input.AnimeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			v.AddError("anime_id", "must be an existing anime")
+			app.failedValidation(w, r, v.Errors)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	entry := &data.WatchlistEntry{
+		UserID:   app.contextGetUser(r).ID,
+		AnimeID:  anime.ID,
+		Title:    anime.Title,
+		Episodes: anime.Episodes,
+	}
+	input.apply(entry, anime)
+
+	if data.ValidateWatchlistEntry(v, entry, anime); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.Watchlist.Insert(r.Context(), entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEntry):
+			v.AddError("anime_id", "this anime is already on your watchlist")
+			app.insertConflict(w, r, v.Errors)
+		default:
+			app.dbWriteError(w, r, err)
+		}
+		return
+	}
+
+	app.activity.recordWatchlistAdd(entry.AnimeID)
+
+	headers := make(http.Header)
+	headers.Set("Location", versionPath(r, "/users/me/watchlist/%d", entry.AnimeID))
+
+	err = app.write(w, r, http.StatusCreated, envelope{"entry": entry}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) showWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	animeID, err := app.readIntParam(r, "anime_id")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	entry, err := app.repos.Watchlist.Get(r.Context(), app.contextGetUser(r).ID, animeID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Update the status and/or progress of an anime on the watchlist of the current user.
+func (app *application) updateWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	animeID, err := app.readIntParam(r, "anime_id")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	entry, err := app.repos.Watchlist.Get(r.Context(), app.contextGetUser(r).ID, animeID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	var input watchlistRequest
+	err = app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	anime, err := app.repos.Anime.GetAnime(r.Context(), animeID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	input.apply(entry, anime)
+
+	v := validator.New()
+	if data.ValidateWatchlistEntry(v, entry, anime); !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.Watchlist.Update(r.Context(), entry)
+	if err != nil {
+		app.dbWriteError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Remove an anime from the watchlist of the current user.
+func (app *application) deleteWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	animeID, err := app.readIntParam(r, "anime_id")
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	err = app.repos.Watchlist.Delete(r.Context(), app.contextGetUser(r).ID, animeID)
+	if err != nil {
+		app.dbReadError(w, r, err)
+		return
+	}
+
+	err = app.write(w, r, http.StatusOK, envelope{"message": "anime successfully removed from your watchlist"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
@@ -0,0 +1,48 @@
+// Package broker publishes the change stream of the API, the events recorded with every
+// change to the catalog and the users, to a message broker, from which the services
+// downstream, such as a search indexer or the analytics, consume it.
+//
+// Only NATS is spoken for now. Other brokers, such as Kafka, only need to implement
+// Publisher.
+package broker
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a message to publish on a subject. ID identifies the message, so that the
+// broker, or the consumers, can tell when the same one is published again.
+type Message struct {
+	ID      string
+	Subject string
+	Data    []byte
+}
+
+// Publisher is a connection to a message broker.
+type Publisher interface {
+	// Publish publishes the messages, in order, and only returns once the broker has
+	// taken all of them. On error, some of them may have been published nonetheless.
+	// A message which can never be published is reported with a *MessageError.
+	Publish(ctx context.Context, msgs []Message) error
+	// Ping checks that the broker can be reached, for the healthcheck.
+	Ping(ctx context.Context) error
+	// Close closes the connection.
+	Close() error
+}
+
+// MessageError is the error of a message which the broker will never take as it is,
+// such as one larger than it allows, unlike the other errors which may go away once
+// the message is published again.
+type MessageError struct {
+	ID  string
+	Err error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("message %s: %v", e.ID, e.Err)
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// jetStreamServer is a NATS server with JetStream for the tests, which stores the
+// messages under stream. The subjects starting with missing. aren't captured by any
+// stream, and those starting with large. are over the max message size of the stream.
+type jetStreamServer struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	stored []string
+}
+
+func newJetStreamServer(t *testing.T, maxPayload int) *jetStreamServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &jetStreamServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, maxPayload)
+		}
+	}()
+
+	return s
+}
+
+func (s *jetStreamServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *jetStreamServer) serve(conn net.Conn, maxPayload int) {
+	defer conn.Close()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	fmt.Fprintf(w, "INFO {\"headers\":true,\"jetstream\":true,\"max_payload\":%d}\r\n", maxPayload)
+	w.Flush()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		op, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch op {
+		case "PING":
+			w.WriteString("PONG\r\n")
+		case "HPUB":
+			fields := strings.Fields(args)
+			subject, reply := fields[0], fields[1]
+			total, _ := strconv.Atoi(fields[3])
+			if _, err := io.ReadFull(r, make([]byte, total+2)); err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(subject, "missing."):
+				fmt.Fprintf(w, "HMSG %s 1 16 16\r\nNATS/1.0 503\r\n\r\n\r\n", reply)
+			case strings.HasPrefix(subject, "large."):
+				ack := `{"error":{"code":400,"err_code":10054,"description":"message size exceeds maximum allowed"}}`
+				fmt.Fprintf(w, "MSG %s 1 %d\r\n%s\r\n", reply, len(ack), ack)
+			default:
+				s.mu.Lock()
+				s.stored = append(s.stored, subject)
+				ack := fmt.Sprintf(`{"stream":"EVENTS","seq":%d}`, len(s.stored))
+				s.mu.Unlock()
+				fmt.Fprintf(w, "MSG %s 1 %d\r\n%s\r\n", reply, len(ack), ack)
+			}
+		}
+		w.Flush()
+	}
+}
+
+func (s *jetStreamServer) storedSubjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.stored...)
+}
+
+func TestNATSPublish(t *testing.T) {
+	server := newJetStreamServer(t, 1024)
+
+	n, err := NewNATS(server.url(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	ctx := context.Background()
+
+	msgs := []Message{
+		{ID: "1", Subject: "events.anime.create", Data: []byte(`{}`)},
+		{ID: "2", Subject: "events.anime.update", Data: []byte(`{}`)},
+	}
+	if err := n.Publish(ctx, msgs); err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	if got := strings.Join(server.storedSubjects(), ","); got != "events.anime.create,events.anime.update" {
+		t.Fatalf("stored %s", got)
+	}
+
+	// Without a stream, the message isn't published.
+	err = n.Publish(ctx, []Message{{ID: "3", Subject: "missing.anime.create", Data: []byte(`{}`)}})
+	if err == nil || !strings.Contains(err.Error(), "no JetStream stream") {
+		t.Errorf("got error %v, want no stream", err)
+	}
+
+	// The messages the stream refuses as too large, and those the server would, can't
+	// ever be published.
+	var msgErr *MessageError
+	err = n.Publish(ctx, []Message{{ID: "4", Subject: "large.anime.create", Data: []byte(`{}`)}})
+	if !errors.As(err, &msgErr) || msgErr.ID != "4" || !errors.Is(err, ErrMaxPayload) {
+		t.Errorf("got error %v, want message 4 too large", err)
+	}
+
+	err = n.Publish(ctx, []Message{{ID: "5", Subject: "events.anime.create", Data: make([]byte, 2048)}})
+	if !errors.As(err, &msgErr) || msgErr.ID != "5" || !errors.Is(err, ErrMaxPayload) {
+		t.Errorf("got error %v, want message 5 too large", err)
+	}
+
+	// The connection recovers from the failures.
+	if err := n.Publish(ctx, []Message{{ID: "6", Subject: "events.anime.delete", Data: []byte(`{}`)}}); err != nil {
+		t.Fatalf("publishing after the failures: %v", err)
+	}
+	if err := n.Ping(ctx); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+	if got := len(server.storedSubjects()); got != 3 {
+		t.Errorf("stored %d messages, want 3", got)
+	}
+}
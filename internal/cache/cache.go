@@ -0,0 +1,21 @@
+// Package cache holds the caches the repositories can keep their reads in, so that the
+// hot ones don't go to the database every time. The values are opaque bytes: what they
+// hold, and when they go stale, is up to the repositories.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a key-value store whose entries expire.
+type Cache interface {
+	// Get returns the value of key, and whether there is one.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set sets the value of key, expiring after ttl, or never if ttl is zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys, ignoring those which don't exist.
+	Delete(ctx context.Context, keys ...string) error
+	// Ping checks that the cache can be reached, for the healthcheck.
+	Ping(ctx context.Context) error
+}
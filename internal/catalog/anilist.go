@@ -0,0 +1,219 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// aniListURL is the AniList GraphQL endpoint.
+const aniListURL = "https://graphql.anilist.co"
+
+// AniList fetches anime from the AniList GraphQL API, which allows 90 requests per
+// minute.
+type AniList struct {
+	client  *Client
+	baseURL string
+}
+
+func NewAniList(client *Client) *AniList {
+	return &AniList{client: client, baseURL: aniListURL}
+}
+
+func (a *AniList) Name() string {
+	return data.SourceAniList
+}
+
+// aniListMediaFields selects the fields of a Media object that we import.
+const aniListMediaFields = `
+	id idMal format episodes status season seasonYear duration genres synonyms
+	description(asHtml: false)
+	title { romaji english native }
+	startDate { year }
+	tags { name rank isMediaSpoiler }
+	studios(isMain: true) { nodes { name } }
+`
+
+type aniListMedia struct {
+	ID         int32    `json:"id"`
+	IDMal      *int32   `json:"idMal"`
+	Format     string   `json:"format"`
+	Episodes   *int32   `json:"episodes"`
+	Status     string   `json:"status"`
+	Season     string   `json:"season"`
+	SeasonYear *int32   `json:"seasonYear"`
+	Duration   *int32   `json:"duration"`
+	Genres     []string `json:"genres"`
+	Synonyms   []string `json:"synonyms"`
+
+	Description string `json:"description"`
+	Title       struct {
+		Romaji  string `json:"romaji"`
+		English string `json:"english"`
+		Native  string `json:"native"`
+	} `json:"title"`
+	StartDate struct {
+		Year *int32 `json:"year"`
+	} `json:"startDate"`
+	Tags []struct {
+		Name    string `json:"name"`
+		Rank    int    `json:"rank"`
+		Spoiler bool   `json:"isMediaSpoiler"`
+	} `json:"tags"`
+	Studios struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"studios"`
+}
+
+// aniListResponse is a GraphQL response. AniList reports missing media as an error
+// with a 404 status, and the HTTP response has the same status.
+type aniListResponse[T any] struct {
+	Data   T `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (r aniListResponse[T]) err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		messages[i] = e.Message
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnavailable, strings.Join(messages, "; "))
+}
+
+func (a *AniList) query(ctx context.Context, query string, variables map[string]any, dst interface{ err() error }) error {
+	body := map[string]any{"query": query, "variables": variables}
+	if err := a.client.postJSON(ctx, a.baseURL, body, dst); err != nil {
+		return err
+	}
+
+	return dst.err()
+}
+
+func (a *AniList) Anime(ctx context.Context, id int32) (*data.Anime, error) {
+	var res aniListResponse[struct {
+		Media aniListMedia `json:"Media"`
+	}]
+
+	err := a.query(ctx, `query ($id: Int) { Media(id: $id, type: ANIME) {`+aniListMediaFields+`} }`, map[string]any{"id": id}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Data.Media.toAnime(), nil
+}
+
+func (a *AniList) Season(ctx context.Context, year int32, season data.Season) ([]*data.Anime, error) {
+	query := `query ($page: Int, $season: MediaSeason, $year: Int) {
+		Page(page: $page, perPage: 50) {
+			pageInfo { hasNextPage }
+			media(season: $season, seasonYear: $year, type: ANIME, isAdult: false) {` + aniListMediaFields + `}
+		}
+	}`
+
+	anime := make([]*data.Anime, 0)
+
+	for page := 1; ; page++ {
+		var res aniListResponse[struct {
+			Page struct {
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+				Media []aniListMedia `json:"media"`
+			} `json:"Page"`
+		}]
+
+		variables := map[string]any{"page": page, "season": strings.ToUpper(string(season)), "year": year}
+		if err := a.query(ctx, query, variables, &res); err != nil {
+			return nil, err
+		}
+
+		for _, media := range res.Data.Page.Media {
+			anime = append(anime, media.toAnime())
+		}
+
+		if !res.Data.Page.PageInfo.HasNextPage {
+			return anime, nil
+		}
+	}
+}
+
+func (m aniListMedia) toAnime() *data.Anime {
+	anime := &data.Anime{
+		Title:     m.Title.Romaji,
+		Type:      aniListFormats[m.Format],
+		Episodes:  m.Episodes,
+		Status:    aniListStatuses[m.Status],
+		Year:      m.SeasonYear,
+		Synopsis:  aniListDescription(m.Description),
+		AltTitles: limit(alternativeTitles(m.Title.Romaji, append([]string{m.Title.English, m.Title.Native}, m.Synonyms...)), 10),
+		MalID:     m.IDMal,
+		AniListID: &m.ID,
+		Tags:      appendUnique(nil, m.Genres...),
+		Studios:   []string{},
+	}
+
+	if anime.Year == nil {
+		anime.Year = m.StartDate.Year
+	}
+
+	if m.Duration != nil {
+		d := data.Duration(*m.Duration)
+		anime.Duration = &d
+	}
+
+	if m.Season != "" {
+		season := data.Season(strings.ToUpper(m.Season[:1]) + strings.ToLower(m.Season[1:]))
+		anime.Season = &season
+	}
+
+	// AniList tags are much finer grained than genres, so only the relevant ones that
+	// don't spoil anything are kept.
+	for _, tag := range m.Tags {
+		if tag.Rank >= 80 && !tag.Spoiler {
+			anime.Tags = appendUnique(anime.Tags, tag.Name)
+		}
+	}
+	anime.Tags = limit(anime.Tags, 15)
+
+	for _, studio := range m.Studios.Nodes {
+		anime.Studios = appendUnique(anime.Studios, studio.Name)
+	}
+	anime.Studios = limit(anime.Studios, 10)
+
+	return anime
+}
+
+var aniListFormats = map[string]data.AnimeType{
+	"TV":       data.TV,
+	"TV_SHORT": data.TV,
+	"MOVIE":    data.Movie,
+	"OVA":      data.OVA,
+	"ONA":      data.ONA,
+	"SPECIAL":  data.Special,
+}
+
+var aniListStatuses = map[string]data.Status{
+	"RELEASING":        data.Ongoing,
+	"FINISHED":         data.Finished,
+	"NOT_YET_RELEASED": data.Upcoming,
+}
+
+// aniListTagRx matches the HTML tags AniList leaves in descriptions even when asked not
+// to, such as <br> and <i>.
+var aniListTagRx = regexp.MustCompile(`<[^>]*>`)
+
+func aniListDescription(s string) string {
+	return strings.TrimSpace(html.UnescapeString(aniListTagRx.ReplaceAllString(s, "")))
+}
@@ -0,0 +1,81 @@
+// Package catalog fetches anime metadata from public catalogs, such as Jikan (an
+// unofficial MyAnimeList API) and AniList, and maps it onto data.Anime.
+package catalog
+
+import (
+	"context"
+	"errors"
+	"github.com/ziliscite/purplelight/internal/data"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when the catalog has no anime with the requested ID.
+	ErrNotFound = errors.New("anime not found in the catalog")
+
+	// ErrUnavailable is returned when the catalog couldn't be reached, or kept failing
+	// after every retry.
+	ErrUnavailable = errors.New("catalog unavailable")
+)
+
+// Source is a catalog anime can be imported from.
+type Source interface {
+	// Name is the external source the anime are linked to, such as data.SourceMAL.
+	Name() string
+
+	// Anime fetches a single anime by its ID in the catalog.
+	Anime(ctx context.Context, id int32) (*data.Anime, error)
+
+	// Season fetches every anime airing in a season.
+	Season(ctx context.Context, year int32, season data.Season) ([]*data.Anime, error)
+}
+
+// NewSource returns the Source for an external source name, or false if there is none.
+func NewSource(name string, client *Client) (Source, bool) {
+	switch name {
+	case data.SourceMAL:
+		return NewJikan(client), true
+	case data.SourceAniList:
+		return NewAniList(client), true
+	default:
+		return nil, false
+	}
+}
+
+// appendUnique appends the non-empty values that aren't in s yet.
+func appendUnique(s []string, values ...string) []string {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		seen := false
+		for _, v := range s {
+			if strings.EqualFold(v, value) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			s = append(s, value)
+		}
+	}
+
+	return s
+}
+
+// alternativeTitles returns the titles that differ from the main title, without
+// duplicates.
+func alternativeTitles(title string, titles []string) []string {
+	return appendUnique([]string{title}, titles...)[1:]
+}
+
+// limit truncates s to at most n values, as catalogs can have more tags or titles than
+// data.ValidateAnime accepts.
+func limit(s []string, n int) []string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
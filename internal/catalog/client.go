@@ -0,0 +1,134 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Client is an HTTP client for the public catalog APIs. Every request waits for the
+// rate limiter first, so that imports stay under the limits the catalogs publish, and
+// failed requests (network errors, 429 and 5xx responses) are retried with an
+// exponential backoff. Requests that still fail are reported as ErrUnavailable.
+type Client struct {
+	http    *http.Client
+	limiter *rate.Limiter
+	retries int
+	backoff time.Duration
+}
+
+// NewClient returns a client making at most rps requests per second (with bursts of
+// burst requests), and retrying failed requests up to retries times.
+func NewClient(rps float64, burst, retries int) *Client {
+	return &Client{
+		http:    &http.Client{Timeout: 10 * time.Second},
+		limiter: rate.NewLimiter(rate.Limit(rps), burst),
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+	}
+}
+
+// getJSON sends a GET request and decodes the JSON response into dst.
+func (c *Client) getJSON(ctx context.Context, url string, dst any) error {
+	return c.doJSON(ctx, http.MethodGet, url, nil, dst)
+}
+
+// postJSON sends body as JSON in a POST request and decodes the JSON response into dst.
+func (c *Client) postJSON(ctx context.Context, url string, body, dst any) error {
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return c.doJSON(ctx, http.MethodPost, url, js, dst)
+}
+
+func (c *Client) doJSON(ctx context.Context, method, url string, body []byte, dst any) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			// Back off exponentially, unless the catalog told us how long to wait.
+			wait := c.backoff << (attempt - 1)
+			if retryAfter, ok := lastErr.(*retryAfterError); ok && retryAfter.wait > wait {
+				wait = retryAfter.wait
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		retry, err := c.do(ctx, method, url, body, dst)
+		if err == nil || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			return err
+		}
+
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return fmt.Errorf("%w: %s %s: %s", ErrUnavailable, method, url, lastErr)
+}
+
+// retryAfterError is a 429 or 503 response that said when to try again.
+type retryAfterError struct {
+	status int
+	wait   time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("unexpected status %d, retry after %s", e.status, e.wait)
+}
+
+// do sends a single request. The boolean reports whether the request is worth retrying.
+func (c *Client) do(ctx context.Context, method, url string, body []byte, dst any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		// The caller's context being done is final; any other error is likely to be a
+		// network error, and worth another try.
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, ErrNotFound
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			return true, &retryAfterError{status: res.StatusCode, wait: time.Duration(seconds) * time.Second}
+		}
+		return true, fmt.Errorf("unexpected status %d", res.StatusCode)
+	case res.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return false, fmt.Errorf("unexpected status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return false, json.NewDecoder(res.Body).Decode(dst)
+}
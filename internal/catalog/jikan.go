@@ -0,0 +1,174 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jikanURL is the base URL of the Jikan v4 API.
+const jikanURL = "https://api.jikan.moe/v4"
+
+// Jikan fetches anime from MyAnimeList through the Jikan API. Jikan allows 3 requests
+// per second and 60 per minute, which the client should be configured to respect.
+type Jikan struct {
+	client  *Client
+	baseURL string
+}
+
+func NewJikan(client *Client) *Jikan {
+	return &Jikan{client: client, baseURL: jikanURL}
+}
+
+func (j *Jikan) Name() string {
+	return data.SourceMAL
+}
+
+// jikanAnime is the part of a Jikan anime resource that we import.
+type jikanAnime struct {
+	MalID         int32    `json:"mal_id"`
+	Title         string   `json:"title"`
+	TitleEnglish  string   `json:"title_english"`
+	TitleJapanese string   `json:"title_japanese"`
+	TitleSynonyms []string `json:"title_synonyms"`
+	Type          string   `json:"type"`
+	Episodes      *int32   `json:"episodes"`
+	Status        string   `json:"status"`
+	Duration      string   `json:"duration"`
+	Season        string   `json:"season"`
+	Year          *int32   `json:"year"`
+	Synopsis      string   `json:"synopsis"`
+	Aired         struct {
+		Prop struct {
+			From struct {
+				Year *int32 `json:"year"`
+			} `json:"from"`
+		} `json:"prop"`
+	} `json:"aired"`
+	Genres       []jikanName `json:"genres"`
+	Themes       []jikanName `json:"themes"`
+	Demographics []jikanName `json:"demographics"`
+	Studios      []jikanName `json:"studios"`
+}
+
+type jikanName struct {
+	Name string `json:"name"`
+}
+
+func (j *Jikan) Anime(ctx context.Context, id int32) (*data.Anime, error) {
+	var res struct {
+		Data jikanAnime `json:"data"`
+	}
+
+	err := j.client.getJSON(ctx, fmt.Sprintf("%s/anime/%d", j.baseURL, id), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Data.toAnime(), nil
+}
+
+func (j *Jikan) Season(ctx context.Context, year int32, season data.Season) ([]*data.Anime, error) {
+	anime := make([]*data.Anime, 0)
+
+	for page := 1; ; page++ {
+		var res struct {
+			Pagination struct {
+				HasNextPage bool `json:"has_next_page"`
+			} `json:"pagination"`
+			Data []jikanAnime `json:"data"`
+		}
+
+		url := fmt.Sprintf("%s/seasons/%d/%s?page=%d", j.baseURL, year, strings.ToLower(string(season)), page)
+		if err := j.client.getJSON(ctx, url, &res); err != nil {
+			return nil, err
+		}
+
+		for _, a := range res.Data {
+			anime = append(anime, a.toAnime())
+		}
+
+		if !res.Pagination.HasNextPage {
+			return anime, nil
+		}
+	}
+}
+
+func (ja jikanAnime) toAnime() *data.Anime {
+	anime := &data.Anime{
+		Title:     ja.Title,
+		Type:      jikanTypes[ja.Type],
+		Episodes:  ja.Episodes,
+		Status:    jikanStatuses[ja.Status],
+		Year:      ja.Year,
+		Duration:  jikanDuration(ja.Duration),
+		Synopsis:  strings.TrimSpace(strings.TrimSuffix(ja.Synopsis, "[Written by MAL Rewrite]")),
+		AltTitles: limit(alternativeTitles(ja.Title, append([]string{ja.TitleEnglish, ja.TitleJapanese}, ja.TitleSynonyms...)), 10),
+		MalID:     &ja.MalID,
+		Tags:      []string{},
+		Studios:   []string{},
+	}
+
+	// Jikan only sets the year of TV series, but every anime has an airing date.
+	if anime.Year == nil {
+		anime.Year = ja.Aired.Prop.From.Year
+	}
+
+	if ja.Season != "" {
+		season := data.Season(strings.ToUpper(ja.Season[:1]) + ja.Season[1:])
+		anime.Season = &season
+	}
+
+	for _, names := range [][]jikanName{ja.Genres, ja.Themes, ja.Demographics} {
+		for _, name := range names {
+			anime.Tags = appendUnique(anime.Tags, name.Name)
+		}
+	}
+	anime.Tags = limit(anime.Tags, 15)
+
+	for _, studio := range ja.Studios {
+		anime.Studios = appendUnique(anime.Studios, studio.Name)
+	}
+	anime.Studios = limit(anime.Studios, 10)
+
+	return anime
+}
+
+var jikanTypes = map[string]data.AnimeType{
+	"TV":         data.TV,
+	"Movie":      data.Movie,
+	"OVA":        data.OVA,
+	"ONA":        data.ONA,
+	"Special":    data.Special,
+	"TV Special": data.Special,
+}
+
+var jikanStatuses = map[string]data.Status{
+	"Currently Airing": data.Ongoing,
+	"Finished Airing":  data.Finished,
+	"Not yet aired":    data.Upcoming,
+}
+
+// jikanDurationRx matches the hours and minutes of durations such as "24 min per ep"
+// or "1 hr 50 min".
+var jikanDurationRx = regexp.MustCompile(`(?:(\d+) hr)?\s*(?:(\d+) min)?`)
+
+// jikanDuration converts a Jikan duration to minutes, or nil if it is unknown.
+func jikanDuration(s string) *data.Duration {
+	match := jikanDurationRx.FindStringSubmatch(s)
+	if match == nil {
+		return nil
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	if hours == 0 && minutes == 0 {
+		return nil
+	}
+
+	d := data.Duration(hours*60 + minutes)
+	return &d
+}
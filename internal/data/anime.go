@@ -6,20 +6,51 @@ import (
 )
 
 type Anime struct {
-	ID       int32     `json:"id"`                 // Unique integer ID for the anime
-	Title    string    `json:"title"`              // Anime title
-	Type     AnimeType `json:"type,omitempty"`     // Anime type
-	Episodes *int32    `json:"episodes"`           // Number of episodes in the anime
-	Status   Status    `json:"status,omitempty"`   // Status of the anime
-	Season   *Season   `json:"season,omitempty"`   // Season of the anime
-	Year     *int32    `json:"year"`               // Year the anime was released
-	Duration *Duration `json:"duration,omitempty"` // Anime duration in minutes
-	Tags     []string  `json:"tags,omitempty"`     // Slice of genres for the anime (romance, comedy, etc.)
+	ID        int32     `json:"id"`                   // Unique integer ID for the anime
+	Title     string    `json:"title"`                // Anime title
+	Slug      string    `json:"slug,omitempty"`       // Unique URL friendly form of the title, e.g. youjo-senki
+	Type      AnimeType `json:"type,omitempty"`       // Anime type
+	Episodes  *int32    `json:"episodes"`             // Number of episodes in the anime
+	Status    Status    `json:"status,omitempty"`     // Status of the anime
+	Season    *Season   `json:"season,omitempty"`     // Season of the anime
+	Year      *int32    `json:"year"`                 // Year the anime was released
+	Duration  *Duration `json:"duration,omitempty"`   // Anime duration in minutes
+	Tags      []string  `json:"tags,omitempty"`       // Slice of genres for the anime (romance, comedy, etc.)
+	Studios   []string  `json:"studios,omitempty"`    // Names of the studios that made the anime
+	Synopsis  string    `json:"synopsis,omitempty"`   // Plot summary of the anime
+	AltTitles []string  `json:"alt_titles,omitempty"` // Alternative titles, such as the romaji, english and native ones
+	CoverURL  string    `json:"cover_url,omitempty"`  // URL of the cover image, set through the cover upload endpoint
+	MalID     *int32    `json:"mal_id,omitempty"`     // ID of the anime on MyAnimeList
+	AniListID *int32    `json:"anilist_id,omitempty"` // ID of the anime on AniList
+	Rank      *float32  `json:"rank,omitempty"`       // Relevance of the anime to a search, only set in search results
+	Deleted   bool      `json:"deleted,omitempty"`    // Marks the tombstone of a deleted anime, only listed in delta syncs
 
 	CreatedAt time.Time `json:"-"`       // Timestamp for when the anime is added to our database
+	UpdatedAt time.Time `json:"-"`       // Timestamp for when the anime was last changed, sent as Last-Modified
 	Version   int32     `json:"version"` // The version number starts at 1 and will be incremented each time the anime information is updated
 }
 
+// External sources that anime can be linked to, by their ID on that source.
+const (
+	SourceMAL     = "mal"
+	SourceAniList = "anilist"
+)
+
+// ExternalSources lists every external source, in the order they are checked.
+var ExternalSources = []string{SourceMAL, SourceAniList}
+
+// ExternalID returns the ID of the anime on the source, or nil if it isn't linked to it.
+func (a *Anime) ExternalID(source string) *int32 {
+	switch source {
+	case SourceMAL:
+		return a.MalID
+	case SourceAniList:
+		return a.AniListID
+	default:
+		return nil
+	}
+}
+
 func ValidateAnime(v *validator.Validator, a *Anime) {
 	v.Check(a.Title != "", "title", "must be provided")
 	v.Check(len(a.Title) <= 500, "title", "must not be more than 500 bytes long")
@@ -69,4 +100,25 @@ func ValidateAnime(v *validator.Validator, a *Anime) {
 	v.Check(len(a.Tags) <= 15, "tags", "must not contain more than 15 tags")
 
 	v.Check(validator.Unique(a.Tags), "tags", "must not contain duplicate values")
+
+	v.Check(len(a.Studios) <= 10, "studios", "must not contain more than 10 studios")
+	v.Check(validator.Unique(a.Studios), "studios", "must not contain duplicate values")
+	for _, studio := range a.Studios {
+		ValidateStudio(v, &Studio{Name: studio})
+	}
+
+	v.Check(len(a.Synopsis) <= 10_000, "synopsis", "must not be more than 10000 bytes long")
+
+	v.Check(len(a.AltTitles) <= 10, "alt_titles", "must not contain more than 10 titles")
+	v.Check(validator.Unique(a.AltTitles), "alt_titles", "must not contain duplicate values")
+	for _, title := range a.AltTitles {
+		v.Check(title != "", "alt_titles", "must not contain empty titles")
+		v.Check(len(title) <= 500, "alt_titles", "must not contain titles more than 500 bytes long")
+	}
+
+	for _, source := range ExternalSources {
+		if id := a.ExternalID(source); id != nil {
+			v.Check(*id > 0, source+"_id", "must be a positive integer")
+		}
+	}
 }
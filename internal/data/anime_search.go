@@ -0,0 +1,94 @@
+package data
+
+import (
+	"github.com/ziliscite/purplelight/internal/validator"
+	"time"
+)
+
+// Title match modes of the anime listing.
+const (
+	// MatchWords matches titles containing every word of the search, using full-text search.
+	MatchWords = "words"
+	// MatchFuzzy matches titles similar to the search using trigrams, which tolerates
+	// typos and partial words ("fulmetal" finds "Fullmetal Alchemist").
+	MatchFuzzy = "fuzzy"
+)
+
+// Tag match modes of the anime listing.
+const (
+	// TagsMatchAll lists the anime that have every one of the tags.
+	TagsMatchAll = "all"
+	// TagsMatchAny lists the anime that have at least one of the tags.
+	TagsMatchAny = "any"
+)
+
+// SortRelevance orders a search by how well the anime match, best first.
+const SortRelevance = "relevance"
+
+// AnimeSearch holds the criteria used to filter the anime listing. Empty fields are
+// ignored.
+type AnimeSearch struct {
+	// Query searches the titles, the alternative titles, the synopsis and the tags at
+	// once, in the syntax of web search engines: "quoted phrases", or, and -excluded
+	// words. The anime rank higher for the words found in their title than in their
+	// alternative titles, then their tags, then their synopsis.
+	Query string
+
+	Title     string
+	Match     string
+	Synopsis  string
+	Status    string
+	Season    string
+	AnimeType string
+	Tags      []string
+	TagsMatch string
+	Studio    string
+
+	// YearMin and YearMax bound the release year, inclusively. Anime without a year
+	// are excluded as soon as either bound is set.
+	YearMin *int32
+	YearMax *int32
+
+	// EpisodesMin and EpisodesMax bound the episode count in the same way.
+	EpisodesMin *int32
+	EpisodesMax *int32
+
+	// UpdatedSince turns the listing into a delta sync: only the anime changed after it
+	// are listed, along with the tombstones of those deleted after it.
+	UpdatedSince *time.Time
+}
+
+// ValidateAnimeSearch checks the search criteria that are not already checked while
+// reading the query string, and how they combine with the filters.
+func ValidateAnimeSearch(v *validator.Validator, s AnimeSearch, f Filters) {
+	v.Check(validator.PermittedValue(s.Match, MatchWords, MatchFuzzy), "match", "must be one of [words fuzzy]")
+
+	v.Check(validator.PermittedValue(s.TagsMatch, TagsMatchAll, TagsMatchAny), "tags_match", "must be one of [all any]")
+
+	if s.YearMin != nil {
+		v.Check(*s.YearMin >= 1917, "year_min", "must be greater than 1917")
+	}
+	if s.YearMax != nil {
+		v.Check(*s.YearMax >= 1917, "year_max", "must be greater than 1917")
+	}
+	if s.YearMin != nil && s.YearMax != nil {
+		v.Check(*s.YearMin <= *s.YearMax, "year_max", "must not be less than year_min")
+	}
+
+	if s.EpisodesMin != nil {
+		v.Check(*s.EpisodesMin > 0, "episodes_min", "must be a positive integer")
+	}
+	if s.EpisodesMax != nil {
+		v.Check(*s.EpisodesMax > 0, "episodes_max", "must be a positive integer")
+	}
+	if s.EpisodesMin != nil && s.EpisodesMax != nil {
+		v.Check(*s.EpisodesMin <= *s.EpisodesMax, "episodes_max", "must not be less than episodes_min")
+	}
+
+	// The rank is a float computed per query, so it can neither be ordered without a
+	// title or q, nor reliably serve as a keyset cursor.
+	if f.Sort == SortRelevance {
+		v.Check(s.Title != "" || s.Query != "", "sort", "relevance requires a title or q")
+		v.Check(f.Cursor == nil, "after", "cursor pagination is not supported when sorting by relevance")
+	}
+}
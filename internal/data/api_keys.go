@@ -0,0 +1,75 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"github.com/ziliscite/purplelight/internal/validator"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix is prepended to every generated key so that they are easy to recognise
+// (and to grep for in leaked logs or repositories).
+const APIKeyPrefix = "pl_"
+
+// APIKey holds a long-lived credential for machine clients. Like tokens, only the
+// SHA-256 hash is stored, and the plaintext is shown exactly once when the key is
+// created. A key can only be used for the permissions it was granted.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// GenerateAPIKey creates a new random key for the user. A zero ttl means the key never
+// expires.
+func GenerateAPIKey(userID int64, name string, permissions Permissions, ttl time.Duration) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+	}
+
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		key.Expiry = &expiry
+	}
+
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	key.Hash = HashAPIKey(key.Plaintext)
+
+	return key, nil
+}
+
+// HashAPIKey returns the SHA-256 hash of a plaintext key, as stored in the database.
+func HashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// ValidateAPIKeyPlaintext Check that the plaintext key has the expected prefix and length.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided")
+	v.Check(strings.HasPrefix(plaintext, APIKeyPrefix), "key", "must be a valid api key")
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be a valid api key")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+}
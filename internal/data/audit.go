@@ -0,0 +1,68 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audited actions.
+const (
+	AuditActionCreate     = "create"
+	AuditActionUpdate     = "update"
+	AuditActionDelete     = "delete"
+	AuditActionRestore    = "restore"
+	AuditActionPurge      = "purge"
+	AuditActionActivate   = "activate"
+	AuditActionAssignRole = "assign_role"
+	AuditActionMerge      = "merge"
+)
+
+// Audited entities.
+const (
+	AuditEntityAnime     = "anime"
+	AuditEntityUser      = "user"
+	AuditEntityTag       = "tag"
+	AuditEntityStudio    = "studio"
+	AuditEntityCharacter = "character"
+	AuditEntityPerson    = "person"
+)
+
+// AuditEntry records who did what to which record, with JSON snapshots of the record
+// before and after the change. UserID is nil when the change wasn't made by a signed
+// in user.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	UserID    *int64          `json:"user_id"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  int64           `json:"entity_id"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// NewAuditEntry builds an entry, encoding the snapshots as JSON. Pass a nil (untyped)
+// snapshot when there is nothing to record, e.g. no before for a create.
+func NewAuditEntry(userID *int64, action, entity string, entityID int64, before, after any) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		UserID:   userID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+	}
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return nil, err
+		}
+	}
+
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
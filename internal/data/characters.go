@@ -0,0 +1,64 @@
+package data
+
+import (
+	"github.com/ziliscite/purplelight/internal/validator"
+	"strings"
+)
+
+// Character roles in an anime.
+const (
+	CharacterRoleMain       = "main"
+	CharacterRoleSupporting = "supporting"
+)
+
+// Character is a fictional character, who may appear in several anime.
+type Character struct {
+	ID    int32  `json:"id"`
+	Name  string `json:"name"`
+	About string `json:"about,omitempty"`
+}
+
+// Person is a real person, such as a voice actor.
+type Person struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+// VoiceActor is a person voicing a character in a given language (e.g. "japanese").
+type VoiceActor struct {
+	Person
+	Language string `json:"language"`
+}
+
+// CastMember is a character of an anime, with the people voicing them in that anime.
+type CastMember struct {
+	Character   Character    `json:"character"`
+	Role        string       `json:"role"`
+	VoiceActors []VoiceActor `json:"voice_actors"`
+}
+
+func ValidateCharacter(v *validator.Validator, c *Character) {
+	v.Check(strings.TrimSpace(c.Name) != "", "name", "must be provided")
+	v.Check(len(c.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(len(c.About) <= 10_000, "about", "must not be more than 10000 bytes long")
+}
+
+func ValidatePerson(v *validator.Validator, p *Person) {
+	v.Check(strings.TrimSpace(p.Name) != "", "name", "must be provided")
+	v.Check(len(p.Name) <= 255, "name", "must not be more than 255 bytes long")
+}
+
+func ValidateCastMember(v *validator.Validator, m *CastMember) {
+	v.Check(validator.PermittedValue(m.Role, CharacterRoleMain, CharacterRoleSupporting), "role", "must be one of [main supporting]")
+
+	v.Check(len(m.VoiceActors) <= 20, "voice_actors", "must not contain more than 20 voice actors")
+	for i, actor := range m.VoiceActors {
+		v.Check(actor.ID > 0, "voice_actors", "must all have a person id")
+		v.Check(strings.TrimSpace(actor.Language) != "", "voice_actors", "must all have a language")
+		v.Check(len(actor.Language) <= 50, "voice_actors", "must not have languages more than 50 bytes long")
+
+		for _, other := range m.VoiceActors[:i] {
+			v.Check(other.ID != actor.ID || other.Language != actor.Language, "voice_actors", "must not contain duplicate values")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a sorted listing for keyset pagination: the value of the
+// sort column and the ID of the last row seen. Backward cursors page towards the start
+// of the listing (they are handed out as the "previous" cursor).
+//
+// Clients only ever see cursors as opaque strings, produced by Encode().
+type Cursor struct {
+	Sort     string `json:"s"`
+	Value    any    `json:"v"`
+	ID       int64  `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// IsStart reports whether the cursor marks the beginning of the listing, which is what
+// clients ask for with an empty cursor.
+func (c Cursor) IsStart() bool {
+	return c.ID == 0
+}
+
+// Encode returns the opaque, URL safe representation of the cursor.
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor parses a cursor produced by Encode(). Numeric sort values are decoded as
+// int64 so that they can be passed straight back to the database.
+func DecodeCursor(s string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var c Cursor
+	if err = dec.Decode(&c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	switch value := c.Value.(type) {
+	case nil, string:
+	case json.Number:
+		n, err := value.Int64()
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.Value = n
+	default:
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
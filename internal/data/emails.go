@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// Email is an email waiting in the outbox to be sent. It is rendered from the template
+// of the mailer named Template, executed with Data.
+type Email struct {
+	ID        int64
+	Recipient string
+	Template  string
+	Data      map[string]any
+	// Attempts counts the attempts at sending the email so far, the current one
+	// included once it has been claimed.
+	Attempts  int32
+	CreatedAt time.Time
+}
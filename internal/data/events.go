@@ -0,0 +1,38 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a change to a record, written to the events table in the same transaction
+// as the change itself, and published from there to the message broker for the
+// services downstream, such as a search indexer. Entity and Action take the same values
+// as those of the audit log, and Data is a JSON snapshot of the record after the
+// change, or before it when it was deleted.
+type Event struct {
+	ID        int64           `json:"id"`
+	Entity    string          `json:"entity"`
+	EntityID  int64           `json:"entity_id"`
+	Action    string          `json:"action"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Attempts counts the failed attempts at publishing the event so far.
+	Attempts int32 `json:"-"`
+}
+
+// NewEvent builds an event, encoding the record as JSON.
+func NewEvent(action, entity string, entityID int64, record any) (*Event, error) {
+	js, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{Entity: entity, EntityID: entityID, Action: action, Data: js}, nil
+}
+
+// Type is the type of the event, such as anime.update, which the subject it is
+// published under ends with.
+func (e *Event) Type() string {
+	return e.Entity + "." + e.Action
+}
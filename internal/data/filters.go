@@ -10,6 +10,10 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+
+	// Cursor switches the listing to keyset pagination, continuing after (or, for a
+	// backward cursor, before) the position it marks. Page is ignored when it is set.
+	Cursor *Cursor
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -21,6 +25,11 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// A cursor is only meaningful for the sort order it was produced with.
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "after", "cursor does not match the sort order")
+	}
 }
 
 // SortColumn Check that the client-provided Sort field matches one of the entries in our safelist
@@ -1,11 +1,21 @@
 package data
 
+import "time"
+
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
 	PageSize     int `json:"page_size,omitempty"`
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+
+	// With keyset pagination only the cursors of the neighbouring pages are known.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+
+	// SyncedAt is the time a delta sync was taken at, to send as the updated_since of
+	// the next one. The API moves it back by the sync overlap.
+	SyncedAt *time.Time `json:"synced_at,omitempty"`
 }
 
 // CalculateMetadata function calculates the appropriate pagination metadata
@@ -27,3 +37,27 @@ func (m *Metadata) CalculateMetadata(totalRecords, page, pageSize int) {
 	m.LastPage = (totalRecords + pageSize - 1) / pageSize
 	m.TotalRecords = totalRecords
 }
+
+// CalculateCursors fills in the metadata of a keyset paginated page. first and last are
+// the cursors of the first and last row of the page (nil when the page is empty),
+// current is the cursor the page was requested with, and hasMore reports whether rows
+// exist past the page in the direction of the request.
+func (m *Metadata) CalculateCursors(pageSize int, current, first, last *Cursor, hasMore bool) {
+	m.PageSize = pageSize
+
+	if first == nil || last == nil {
+		return
+	}
+
+	// A backward page was reached from a row after it, so there is always a next page;
+	// a forward page has a previous one unless it started at the very beginning.
+	if hasMore || current.Backward {
+		m.NextCursor = last.Encode()
+	}
+
+	if (hasMore && current.Backward) || (!current.Backward && !current.IsStart()) {
+		prev := *first
+		prev.Backward = true
+		m.PrevCursor = prev.Encode()
+	}
+}
@@ -2,6 +2,49 @@ package data
 
 import "slices"
 
+// Permission codes. Every code that a route can require must be listed in the
+// PermissionRegistry below, which is also what gets seeded into the permissions table.
+const (
+	PermissionAnimeRead   = "anime:read"
+	PermissionAnimeWrite  = "anime:write"
+	PermissionTagsWrite   = "tags:write"
+	PermissionMetricsRead = "metrics:read"
+	PermissionUsersAdmin  = "users:admin"
+)
+
+// PermissionScope describes a single permission code.
+type PermissionScope struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// PermissionRegistry is the list of all known permission scopes.
+var PermissionRegistry = []PermissionScope{
+	{Code: PermissionAnimeRead, Description: "Read anime and tags"},
+	{Code: PermissionAnimeWrite, Description: "Create, update and delete anime"},
+	{Code: PermissionTagsWrite, Description: "Create, rename, merge and delete tags"},
+	{Code: PermissionMetricsRead, Description: "Read application metrics and diagnostics"},
+	{Code: PermissionUsersAdmin, Description: "Manage users and their permissions"},
+}
+
+// DefaultPermissions are granted to every newly registered user.
+var DefaultPermissions = []string{PermissionAnimeRead}
+
+// PermissionCodes returns the codes of every registered permission scope.
+func PermissionCodes() []string {
+	codes := make([]string, len(PermissionRegistry))
+	for i, scope := range PermissionRegistry {
+		codes[i] = scope.Code
+	}
+
+	return codes
+}
+
+// IsPermission reports whether the code is a registered permission scope.
+func IsPermission(code string) bool {
+	return slices.Contains(PermissionCodes(), code)
+}
+
 // Permissions slice, which we will use to hold the permission codes (like
 // "movies:read" and "movies:write") for a single user.
 type Permissions []string
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// AnimeRevision is a full snapshot of an anime as it was at a given version.
+type AnimeRevision struct {
+	AnimeID   int32     `json:"anime_id"`
+	Version   int32     `json:"version"`
+	Anime     *Anime    `json:"anime"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Changes lists the fields that differ from the previous revision. It is empty for
+	// the first revision.
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange describes how a single field changed between two revisions. The values
+// are kept in their JSON form, as they appear in the API.
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// DiffAnime returns the fields whose JSON representation differs between two versions
+// of an anime, in alphabetical order. The id and version fields are bookkeeping and are
+// never reported.
+func DiffAnime(from, to *Anime) ([]FieldChange, error) {
+	fromFields, err := jsonFields(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toFields, err := jsonFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range fromFields {
+		names = append(names, name)
+	}
+	for name := range toFields {
+		if _, ok := fromFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := make([]FieldChange, 0)
+	for _, name := range names {
+		if name == "id" || name == "version" {
+			continue
+		}
+
+		// Fields left out by omitempty are reported as null.
+		before, after := orNull(fromFields[name]), orNull(toFields[name])
+		if !bytes.Equal(before, after) {
+			changes = append(changes, FieldChange{Field: name, From: before, To: after})
+		}
+	}
+
+	return changes, nil
+}
+
+// DiffRevisions fills in the Changes of each revision, comparing it with the revision
+// before it. The revisions must be ordered by version; a revision whose predecessor is
+// missing is left without changes.
+func DiffRevisions(revisions []*AnimeRevision) error {
+	for i := 1; i < len(revisions); i++ {
+		prev, cur := revisions[i-1], revisions[i]
+		if prev.Version != cur.Version-1 {
+			continue
+		}
+
+		changes, err := DiffAnime(prev.Anime, cur.Anime)
+		if err != nil {
+			return err
+		}
+
+		cur.Changes = changes
+	}
+
+	return nil
+}
+
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(js, &fields)
+	return fields, err
+}
+
+func orNull(js json.RawMessage) json.RawMessage {
+	if js == nil {
+		return json.RawMessage("null")
+	}
+
+	return js
+}
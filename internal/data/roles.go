@@ -0,0 +1,19 @@
+package data
+
+import "slices"
+
+// Role presets. A role maps to a set of permissions (stored in the roles_permissions
+// table); assigning a role to a user replaces their permissions with that set.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleUser      = "user"
+)
+
+// Roles lists every known role preset.
+var Roles = []string{RoleAdmin, RoleModerator, RoleUser}
+
+// IsRole reports whether the name is a known role preset.
+func IsRole(name string) bool {
+	return slices.Contains(Roles, name)
+}
@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// Session describes an authentication token from the point of view of its owner: where
+// and when it was issued and when it was last used. The token itself is never exposed.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	ClientIP   string     `json:"client_ip"`
+	UserAgent  string     `json:"user_agent"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Current    bool       `json:"current"`
+	Hash       []byte     `json:"-"`
+}
@@ -0,0 +1,18 @@
+package data
+
+// SimilarAnime is an anime sharing tags with another one. Each shared tag adds to the
+// score, rare tags adding more than common ones, so that sharing a niche tag counts for
+// more than both anime being, say, comedies.
+type SimilarAnime struct {
+	ID         int32     `json:"id"`
+	Title      string    `json:"title"`
+	Type       AnimeType `json:"type,omitempty"`
+	Status     Status    `json:"status,omitempty"`
+	Year       *int32    `json:"year"`
+	CoverURL   string    `json:"cover_url,omitempty"`
+	Score      float64   `json:"score"`
+	SharedTags []string  `json:"shared_tags"`
+}
+
+// MaxSimilarAnime is the largest number of similar anime returned at once.
+const MaxSimilarAnime = 50
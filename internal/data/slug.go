@@ -0,0 +1,61 @@
+package data
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// MaxSlugLength bounds the length of a slug, before any suffix telling it apart from the
+// slug of another anime.
+const MaxSlugLength = 80
+
+// Slugify turns a title into the lowercase, hyphen separated form used in URLs, so that
+// "Youjo Senki: Saga of Tanya the Evil" becomes "youjo-senki-saga-of-tanya-the-evil".
+// Accents are stripped, and every run of characters other than ASCII letters and digits
+// becomes a single hyphen. Titles without any of them, such as the Japanese ones, have
+// an empty slug.
+func Slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+
+	// NFKD splits the accented letters into the letter and its accent, which is dropped.
+	for _, r := range norm.NFKD.String(title) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			hyphen = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > MaxSlugLength {
+		// Cut at the last word that fits, unless the first word alone is too long.
+		slug = slug[:MaxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+
+	return slug
+}
+
+// AnimeSlug returns the slug an anime would have without any other anime around: the one
+// of its title or, when the title has none, of the first alternative title that has one.
+// Anime with none at all fall back to "anime".
+func AnimeSlug(anime *Anime) string {
+	for _, title := range append([]string{anime.Title}, anime.AltTitles...) {
+		if slug := Slugify(title); slug != "" {
+			return slug
+		}
+	}
+
+	return "anime"
+}
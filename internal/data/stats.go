@@ -0,0 +1,35 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// StatCount is the number of anime sharing a value, such as a type or a tag.
+type StatCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// CatalogStats summarizes the catalog. Each breakdown is ordered by count, largest
+// first; anime without a season or a year are counted under StatUnknown.
+type CatalogStats struct {
+	Total      int64       `json:"total"`
+	ByType     []StatCount `json:"by_type"`
+	ByStatus   []StatCount `json:"by_status"`
+	BySeason   []StatCount `json:"by_season"`
+	ByDecade   []StatCount `json:"by_decade"`
+	TopTags    []StatCount `json:"top_tags"`
+	ComputedAt time.Time   `json:"computed_at"`
+}
+
+// StatUnknown is the key under which anime missing the value are counted.
+const StatUnknown = "unknown"
+
+// MaxStatsTags is the largest number of top tags the statistics can include.
+const MaxStatsTags = 50
+
+// Decade returns the decade of a year, such as "1990s".
+func Decade(year int32) string {
+	return fmt.Sprintf("%ds", year/10*10)
+}
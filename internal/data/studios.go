@@ -0,0 +1,20 @@
+package data
+
+import (
+	"github.com/ziliscite/purplelight/internal/validator"
+	"strings"
+)
+
+// Studio is an animation studio. Like tags, studios are addressed by name in the API.
+type Studio struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+func ValidateStudio(v *validator.Validator, s *Studio) {
+	v.Check(strings.TrimSpace(s.Name) != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 255, "name", "must not be more than 255 bytes long")
+
+	// Slashes would split the path of the studio routes.
+	v.Check(!strings.Contains(s.Name, "/"), "name", "must not contain slashes")
+}
@@ -0,0 +1,22 @@
+package data
+
+import (
+	"github.com/ziliscite/purplelight/internal/validator"
+	"strings"
+)
+
+// Tag is a genre, theme or other label that anime are tagged with. Tags are addressed
+// by name in the API, just as they are listed on the anime.
+type Tag struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+func ValidateTag(v *validator.Validator, t *Tag) {
+	v.Check(strings.TrimSpace(t.Name) != "", "name", "must be provided")
+	v.Check(len(t.Name) <= 255, "name", "must not be more than 255 bytes long")
+
+	// Commas separate the tags of the tags filter, and slashes would split the path of
+	// the tag routes.
+	v.Check(!strings.ContainsAny(t.Name, ",/"), "name", "must not contain commas or slashes")
+}
@@ -13,6 +13,8 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
 // Token is a struct to hold the data for an individual token. This includes the
@@ -26,6 +28,17 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+
+	// Issuance metadata: when, and to which client, the token was issued.
+	CreatedAt time.Time `json:"-"`
+	ClientIP  string    `json:"-"`
+	UserAgent string    `json:"-"`
+}
+
+// TokenIssuer identifies the client a token is issued to.
+type TokenIssuer struct {
+	ClientIP  string
+	UserAgent string
 }
 
 func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"math"
+	"time"
+)
+
+// AnimeActivity counts what happened to an anime during the hour starting at Bucket.
+type AnimeActivity struct {
+	AnimeID       int32
+	Bucket        time.Time
+	Views         int32
+	WatchlistAdds int32
+}
+
+// TrendingAnime is an anime ranked by its recent activity. Views and WatchlistAdds are
+// the totals over the trending window, and Score weighs them, the recent activity
+// counting for more than the older one.
+type TrendingAnime struct {
+	Rank          int       `json:"rank"`
+	ID            int32     `json:"id"`
+	Title         string    `json:"title"`
+	Type          AnimeType `json:"type,omitempty"`
+	Status        Status    `json:"status,omitempty"`
+	Year          *int32    `json:"year"`
+	CoverURL      string    `json:"cover_url,omitempty"`
+	Score         float64   `json:"score"`
+	Views         int64     `json:"views"`
+	WatchlistAdds int64     `json:"watchlist_adds"`
+}
+
+// Weights of each kind of activity in the trending score. Adding an anime to a
+// watchlist says a lot more about interest in it than a view does.
+const (
+	TrendingViewWeight         = 1
+	TrendingWatchlistAddWeight = 10
+)
+
+// TrendingHalfLife is the age at which activity counts for half as much in the score.
+const TrendingHalfLife = 24 * time.Hour
+
+// TrendingWeight returns how much activity of the given age counts in the score.
+func TrendingWeight(age time.Duration) float64 {
+	return math.Pow(0.5, age.Hours()/TrendingHalfLife.Hours())
+}
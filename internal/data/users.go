@@ -22,6 +22,13 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+	// TokenVersion only moves on when the user must be logged out everywhere: a new
+	// password, a new email address, or a deactivation. The JWTs are tied to it.
+	TokenVersion int `json:"-"`
+
+	// LockedUntil is set when the account is temporarily locked after too many failed
+	// login attempts.
+	LockedUntil *time.Time `json:"-"`
 }
 
 // IsAnonymous Check if a User instance is the AnonymousUser.
@@ -29,6 +36,16 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// IsLocked Check if the account is currently locked, returning the time remaining.
+func (u *User) IsLocked() (time.Duration, bool) {
+	if u.LockedUntil == nil {
+		return 0, false
+	}
+
+	remaining := time.Until(*u.LockedUntil)
+	return remaining, remaining > 0
+}
+
 // Hash method returns the bcrypt hash of the user's plaintext password.
 func (u *User) Hash() []byte {
 	return u.Password.hash
@@ -0,0 +1,47 @@
+package data
+
+import (
+	"github.com/ziliscite/purplelight/internal/validator"
+	"time"
+)
+
+// Statuses of an anime on a watchlist.
+const (
+	WatchStatusWatching    = "watching"
+	WatchStatusCompleted   = "completed"
+	WatchStatusPlanToWatch = "plan_to_watch"
+	WatchStatusDropped     = "dropped"
+)
+
+// WatchStatuses lists every watchlist status.
+var WatchStatuses = []string{WatchStatusWatching, WatchStatusCompleted, WatchStatusPlanToWatch, WatchStatusDropped}
+
+// WatchlistEntry is an anime on the watchlist of a user, with how far they are into it.
+// The title and episode count of the anime come along, so that a watchlist can be shown
+// without fetching every anime.
+type WatchlistEntry struct {
+	UserID          int64     `json:"-"`
+	AnimeID         int32     `json:"anime_id"`
+	Title           string    `json:"title"`
+	Episodes        *int32    `json:"episodes"`
+	Status          string    `json:"status"`
+	EpisodesWatched int32     `json:"episodes_watched"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Version         int32     `json:"version"`
+}
+
+// ValidateWatchlistEntry checks the entry against the anime it is for: progress can't
+// go past the last episode, and a completed anime has been watched to the end.
+func ValidateWatchlistEntry(v *validator.Validator, e *WatchlistEntry, anime *Anime) {
+	v.Check(validator.PermittedValue(e.Status, WatchStatuses...), "status", "must be one of [watching completed plan_to_watch dropped]")
+
+	v.Check(e.EpisodesWatched >= 0, "episodes_watched", "must not be negative")
+	if anime.Episodes != nil {
+		v.Check(e.EpisodesWatched <= *anime.Episodes, "episodes_watched", "must not be more than the number of episodes")
+
+		if e.Status == WatchStatusCompleted {
+			v.Check(e.EpisodesWatched == *anime.Episodes, "episodes_watched", "must be the number of episodes for completed anime")
+		}
+	}
+}
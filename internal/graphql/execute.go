@@ -0,0 +1,754 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Execute runs the request against the schema. Errors are reported in the response:
+// those found before the operation runs, such as syntax errors or a document over the
+// limits, leave its data null, while those of the fields null the fields out.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{withCode(asError(err), "GRAPHQL_PARSE_FAILED")}}
+	}
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{withCode(asError(err), "GRAPHQL_VALIDATION_FAILED")}}
+	}
+
+	var root *Object
+	switch op.kind {
+	case "query":
+		root = s.Query
+	case "mutation":
+		root = s.Mutation
+	}
+	if root == nil {
+		return &Response{Errors: []*Error{Errorf("GRAPHQL_VALIDATION_FAILED", "The schema does not support %s operations.", op.kind)}}
+	}
+
+	s.typesOnce.Do(func() { s.typeMap = s.types() })
+
+	variables, errs := coerceVariables(s.typeMap, op, req.Variables)
+	if len(errs) > 0 {
+		return &Response{Errors: withCodes(errs, "BAD_USER_INPUT")}
+	}
+
+	e := &executor{doc: doc, variables: variables, args: map[*field]Args{}}
+
+	if err := doc.fragmentCycle(); err != nil {
+		return &Response{Errors: []*Error{withCode(err, "GRAPHQL_VALIDATION_FAILED")}}
+	}
+
+	complexity := e.validate(root, op.selections, 1)
+	if len(e.errors) > 0 {
+		return &Response{Errors: withCodes(e.errors, "GRAPHQL_VALIDATION_FAILED")}
+	}
+	if s.MaxDepth > 0 && e.depth > s.MaxDepth {
+		return &Response{Errors: []*Error{Errorf("DEPTH_LIMIT_EXCEEDED", "The query has a depth of %d, over the maximum of %d.", e.depth, s.MaxDepth)}}
+	}
+	if s.MaxComplexity > 0 && complexity > s.MaxComplexity {
+		return &Response{Errors: []*Error{Errorf("COMPLEXITY_LIMIT_EXCEEDED", "The query has a complexity of %d, over the maximum of %d.", complexity, s.MaxComplexity)}}
+	}
+
+	// The root fields of a mutation run one after the other, so that each one sees what
+	// the ones before it changed.
+	e.serial = op.kind == "mutation"
+
+	data, failed := e.executeObjects(ctx, root, []any{nil}, op.selections, []path{nil})
+
+	resp := &Response{Errors: e.errors}
+	if !failed[0] {
+		resp.Data = data[0]
+	}
+
+	return resp
+}
+
+// operation returns the operation of the document to run: the one named name, or the
+// only one there is.
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("Must provide operation name if query contains multiple operations.")
+		}
+		return d.operations[0], nil
+	}
+
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown operation named %q.", name)
+}
+
+// fragmentCycle returns an error if a fragment spreads itself, directly or through other
+// fragments, which would make the document infinitely deep.
+func (d *document) fragmentCycle() *Error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+
+	var visit func(f *fragment) *Error
+	var spreads func(selections []selection) *Error
+	spreads = func(selections []selection) *Error {
+		for _, sel := range selections {
+			switch {
+			case sel.field != nil:
+				if err := spreads(sel.field.selections); err != nil {
+					return err
+				}
+			case sel.inline != nil:
+				if err := spreads(sel.inline.selections); err != nil {
+					return err
+				}
+			case sel.spread != nil:
+				f, ok := d.fragments[sel.spread.name]
+				if !ok {
+					continue
+				}
+				if state[f.name] == visiting {
+					return &Error{Message: fmt.Sprintf("Cannot spread fragment %q within itself.", f.name), Locations: []Location{sel.spread.loc}}
+				}
+				if err := visit(f); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	visit = func(f *fragment) *Error {
+		if state[f.name] == done {
+			return nil
+		}
+		state[f.name] = visiting
+		if err := spreads(f.selections); err != nil {
+			return err
+		}
+		state[f.name] = done
+		return nil
+	}
+
+	for _, f := range d.fragments {
+		if err := visit(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// path is the path to a field in the response, made of the keys of the fields and the
+// indexes of the list items.
+type path []any
+
+func (p path) with(key any) path {
+	return append(slices.Clip(p), key)
+}
+
+// fieldGroup is the fields of a selection set sharing the same key in the response,
+// which are resolved once, with the selections of all of them.
+type fieldGroup struct {
+	key    string
+	fields []*field
+}
+
+func (g *fieldGroup) selections() []selection {
+	if len(g.fields) == 1 {
+		return g.fields[0].selections
+	}
+
+	var selections []selection
+	for _, f := range g.fields {
+		selections = append(selections, f.selections...)
+	}
+
+	return selections
+}
+
+func (g *fieldGroup) locations() []Location {
+	return []Location{g.fields[0].loc}
+}
+
+type executor struct {
+	doc       *document
+	variables map[string]any
+	serial    bool
+
+	// args holds the arguments of the fields, coerced while validating the document.
+	args map[*field]Args
+
+	depth  int
+	errors []*Error
+}
+
+func (e *executor) errorf(loc Location, format string, args ...any) {
+	e.errors = append(e.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}})
+}
+
+// collect groups the fields of a selection set by their key, in the order they first
+// appear, expanding the fragments for t and leaving out the fields skipped by their
+// directives.
+func (e *executor) collect(t *Object, selections []selection, groups []*fieldGroup, visited map[string]bool) []*fieldGroup {
+	for _, sel := range selections {
+		switch {
+		case sel.field != nil:
+			if !e.included(sel.field.directives) {
+				continue
+			}
+
+			key := sel.field.key()
+			i := slices.IndexFunc(groups, func(g *fieldGroup) bool { return g.key == key })
+			if i < 0 {
+				groups = append(groups, &fieldGroup{key: key, fields: []*field{sel.field}})
+				continue
+			}
+
+			if groups[i].fields[0].name != sel.field.name {
+				e.errorf(sel.field.loc, "Fields %q conflict because %s and %s are different fields. Use different aliases on the fields to fetch both if this was intentional.", key, groups[i].fields[0].name, sel.field.name)
+				continue
+			}
+			groups[i].fields = append(groups[i].fields, sel.field)
+		case sel.inline != nil:
+			if !e.included(sel.inline.directives) {
+				continue
+			}
+			if sel.inline.on != "" && sel.inline.on != t.Name {
+				e.errorf(sel.inline.loc, "Fragment cannot be spread here as objects of type %q can never be of type %q.", t.Name, sel.inline.on)
+				continue
+			}
+
+			groups = e.collect(t, sel.inline.selections, groups, visited)
+		case sel.spread != nil:
+			if !e.included(sel.spread.directives) || visited[sel.spread.name] {
+				continue
+			}
+			visited[sel.spread.name] = true
+
+			f, ok := e.doc.fragments[sel.spread.name]
+			if !ok {
+				e.errorf(sel.spread.loc, "Unknown fragment %q.", sel.spread.name)
+				continue
+			}
+			if f.on != t.Name {
+				e.errorf(sel.spread.loc, "Fragment %q cannot be spread here as objects of type %q can never be of type %q.", f.name, t.Name, f.on)
+				continue
+			}
+
+			groups = e.collect(t, f.selections, groups, visited)
+		}
+	}
+
+	return groups
+}
+
+// included evaluates the @skip and @include directives of a selection.
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			e.errorf(d.loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+
+		args := e.coerceArgs(map[string]*Argument{"if": {Type: NonNullOf(Boolean)}}, d.args, d.loc)
+		if args.Bool("if") == (d.name == "skip") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coerceArgs coerces the arguments given to a field or a directive to those it takes.
+func (e *executor) coerceArgs(defs map[string]*Argument, given []*argument, loc Location) Args {
+	args := Args{}
+
+	for _, arg := range given {
+		if _, ok := defs[arg.name]; !ok {
+			e.errorf(arg.loc, "Unknown argument %q.", arg.name)
+		}
+	}
+
+	for name, def := range defs {
+		i := slices.IndexFunc(given, func(arg *argument) bool { return arg.name == name })
+		if i >= 0 {
+			v, present, err := valueFromAST(def.Type, given[i].value, e.variables)
+			if err != nil {
+				e.errorf(given[i].loc, "Argument %q has an invalid value: %s.", name, err)
+				continue
+			}
+			if present {
+				args[name] = v
+				continue
+			}
+		}
+
+		if err := setDefault(args, name, def); err != nil {
+			e.errorf(loc, "Argument %q of type %q is required, but it was not provided.", name, def.Type)
+		}
+	}
+
+	return args
+}
+
+// validate checks the fields selected on t against the schema, coercing their
+// arguments, and returns the complexity of the selection. depth is the depth of the
+// selection, 1 for the root fields.
+func (e *executor) validate(t *Object, selections []selection, depth int) int {
+	e.depth = max(e.depth, depth)
+
+	complexity := 0
+	for _, g := range e.collect(t, selections, nil, map[string]bool{}) {
+		f := g.fields[0]
+
+		if f.name == "__typename" {
+			if len(f.selections) > 0 {
+				e.errorf(f.loc, "Field \"__typename\" must not have a selection since type \"String!\" has no subfields.")
+			}
+			continue
+		}
+
+		def, ok := t.Fields[f.name]
+		if !ok {
+			e.errorf(f.loc, "Cannot query field %q on type %q.", f.name, t.Name)
+			continue
+		}
+
+		args := e.coerceArgs(def.Args, f.args, f.loc)
+		e.args[f] = args
+
+		child := 0
+		if obj, ok := named(def.Type).(*Object); ok {
+			if len(f.selections) == 0 {
+				e.errorf(f.loc, "Field %q of type %q must have a selection of subfields.", f.name, def.Type)
+				continue
+			}
+			child = e.validate(obj, g.selections(), depth+1)
+		} else if len(f.selections) > 0 {
+			e.errorf(f.loc, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+			continue
+		}
+
+		if def.Complexity != nil {
+			complexity += def.Complexity(args, child)
+		} else {
+			complexity += 1 + child
+		}
+	}
+
+	return complexity
+}
+
+// executeObjects resolves the fields selected on the objects of type t, which come from
+// the same field: a single object, or the items of a list. Each field is resolved for
+// every object before the thunks are forced, and the values are completed together,
+// so that the loaders get the keys of the whole list at once.
+//
+// It returns the objects, and which of them failed because one of their non-null
+// fields is null, which makes them null in turn.
+func (e *executor) executeObjects(ctx context.Context, t *Object, sources []any, selections []selection, paths []path) ([]any, []bool) {
+	groups := e.collect(t, selections, nil, map[string]bool{})
+
+	objects := make([]*object, len(sources))
+	for i := range objects {
+		objects[i] = &object{}
+	}
+	failed := make([]bool, len(sources))
+
+	if !e.serial {
+		e.executeFields(ctx, t, sources, groups, paths, objects, failed)
+	} else {
+		// Only the root fields of a mutation run serially.
+		e.serial = false
+		for _, g := range groups {
+			e.executeFields(ctx, t, sources, []*fieldGroup{g}, paths, objects, failed)
+		}
+	}
+
+	results := make([]any, len(objects))
+	for i, obj := range objects {
+		results[i] = obj
+	}
+
+	return results, failed
+}
+
+// failedValue marks the value of a field whose resolver failed.
+type failedValue struct{}
+
+func (e *executor) executeFields(ctx context.Context, t *Object, sources []any, groups []*fieldGroup, paths []path, objects []*object, failed []bool) {
+	values := make([][]any, len(groups))
+
+	for gi, g := range groups {
+		if g.fields[0].name == "__typename" {
+			continue
+		}
+
+		def := t.Fields[g.fields[0].name]
+		args := e.args[g.fields[0]]
+
+		values[gi] = make([]any, len(sources))
+		for i, source := range sources {
+			values[gi][i] = e.resolve(ctx, def, source, args, g, paths[i].with(g.key))
+		}
+	}
+
+	for gi, g := range groups {
+		for i, v := range values[gi] {
+			if thunk, ok := v.(Thunk); ok {
+				values[gi][i] = e.force(thunk, g, paths[i].with(g.key))
+			}
+		}
+	}
+
+	for gi, g := range groups {
+		if g.fields[0].name == "__typename" {
+			for _, obj := range objects {
+				obj.set(g.key, t.Name)
+			}
+			continue
+		}
+
+		def := t.Fields[g.fields[0].name]
+
+		// The values which failed are null, and their error is already reported.
+		var ok []int
+		for i, v := range values[gi] {
+			if _, isFailed := v.(failedValue); isFailed {
+				objects[i].set(g.key, nil)
+				failed[i] = failed[i] || isNonNull(def.Type)
+				continue
+			}
+			ok = append(ok, i)
+		}
+
+		resolved := make([]any, len(ok))
+		fieldPaths := make([]path, len(ok))
+		for j, i := range ok {
+			resolved[j] = values[gi][i]
+			fieldPaths[j] = paths[i].with(g.key)
+		}
+
+		completed, completeFailed := e.complete(ctx, def.Type, resolved, g, fieldPaths)
+		for j, i := range ok {
+			objects[i].set(g.key, completed[j])
+			failed[i] = failed[i] || completeFailed[j]
+		}
+	}
+}
+
+// resolve calls the resolver of a field, recovering its errors as field errors.
+func (e *executor) resolve(ctx context.Context, def *Field, source any, args Args, g *fieldGroup, p path) any {
+	if def.Resolve == nil {
+		return defaultResolve(source, g.fields[0].name)
+	}
+
+	v, err := def.Resolve(ResolveParams{Context: ctx, Source: source, Args: args})
+	if err != nil {
+		e.fieldError(err, g, p)
+		return failedValue{}
+	}
+
+	return v
+}
+
+func (e *executor) force(thunk Thunk, g *fieldGroup, p path) any {
+	v, err := thunk()
+	if err != nil {
+		e.fieldError(err, g, p)
+		return failedValue{}
+	}
+
+	return v
+}
+
+func (e *executor) fieldError(err error, g *fieldGroup, p path) {
+	var gqlErr *Error
+	if errors.As(err, &gqlErr) {
+		c := *gqlErr
+		gqlErr = &c
+	} else {
+		gqlErr = &Error{Message: err.Error()}
+	}
+
+	gqlErr.Locations = g.locations()
+	gqlErr.Path = p
+	e.errors = append(e.errors, gqlErr)
+}
+
+// complete turns the values resolved for a field into their response form, given the
+// type of the field. It returns which of them failed to, being null where the type is
+// non-null.
+func (e *executor) complete(ctx context.Context, t Type, values []any, g *fieldGroup, paths []path) ([]any, []bool) {
+	if nonNull, ok := t.(*NonNull); ok {
+		completed, failed := e.completeNullable(ctx, nonNull.Of, values, g, paths)
+		for i, v := range completed {
+			if v == nil && !failed[i] {
+				e.fieldError(fmt.Errorf("Cannot return null for non-nullable field %s.", g.fields[0].name), g, paths[i])
+				failed[i] = true
+			}
+		}
+		return completed, failed
+	}
+
+	// A nullable value absorbs the failure of its content by being null.
+	completed, failed := e.completeNullable(ctx, t, values, g, paths)
+	for i := range failed {
+		if failed[i] {
+			completed[i], failed[i] = nil, false
+		}
+	}
+
+	return completed, failed
+}
+
+func (e *executor) completeNullable(ctx context.Context, t Type, values []any, g *fieldGroup, paths []path) ([]any, []bool) {
+	completed := make([]any, len(values))
+	failed := make([]bool, len(values))
+
+	// The values which aren't null, along with where they go.
+	var present []int
+	for i, v := range values {
+		if !isNil(v) {
+			present = append(present, i)
+		}
+	}
+	if len(present) == 0 {
+		return completed, failed
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		for _, i := range present {
+			v, err := t.serialize(indirect(values[i]))
+			if err != nil {
+				e.fieldError(err, g, paths[i])
+				failed[i] = true
+				continue
+			}
+			completed[i] = v
+		}
+	case *Enum:
+		for _, i := range present {
+			rv := reflect.ValueOf(indirect(values[i]))
+			if rv.Kind() != reflect.String || !slices.Contains(t.Values, rv.String()) {
+				e.fieldError(fmt.Errorf("Enum %q cannot represent value: %s", t.Name, describe(indirect(values[i]))), g, paths[i])
+				failed[i] = true
+				continue
+			}
+			completed[i] = rv.String()
+		}
+	case *Object:
+		sources := make([]any, len(present))
+		objectPaths := make([]path, len(present))
+		for j, i := range present {
+			sources[j], objectPaths[j] = values[i], paths[i]
+		}
+
+		objects, objectsFailed := e.executeObjects(ctx, t, sources, g.selections(), objectPaths)
+		for j, i := range present {
+			completed[i], failed[i] = objects[j], objectsFailed[j]
+		}
+	case *List:
+		// The items of every list are completed together, and then put back in their
+		// lists.
+		var items []any
+		var itemPaths []path
+		var owners []int
+		for _, i := range present {
+			rv := reflect.ValueOf(indirect(values[i]))
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				e.fieldError(fmt.Errorf("Expected a list for field %s, got %T.", g.fields[0].name, values[i]), g, paths[i])
+				failed[i] = true
+				continue
+			}
+
+			completed[i] = make([]any, 0, rv.Len())
+			for k := range rv.Len() {
+				items = append(items, rv.Index(k).Interface())
+				itemPaths = append(itemPaths, paths[i].with(k))
+				owners = append(owners, i)
+			}
+		}
+
+		completedItems, itemsFailed := e.complete(ctx, t.Of, items, g, itemPaths)
+		for k, i := range owners {
+			completed[i] = append(completed[i].([]any), completedItems[k])
+			failed[i] = failed[i] || itemsFailed[k]
+		}
+	}
+
+	return completed, failed
+}
+
+// isNil reports whether v is nil, or a nil pointer, map or slice.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+
+	return false
+}
+
+// indirect dereferences the pointers to v.
+func indirect(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	return rv.Interface()
+}
+
+// structFields caches the index of the fields of the structs by their GraphQL name.
+var structFields sync.Map // map[reflect.Type]map[string]int
+
+// defaultResolve reads the field name of a source which is a struct, by its json tag or
+// its Go name, or a map.
+func defaultResolve(source any, name string) any {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil
+		}
+		return v.Interface()
+	case reflect.Struct:
+		fields, ok := structFields.Load(rv.Type())
+		if !ok {
+			fields, _ = structFields.LoadOrStore(rv.Type(), fieldNames(rv.Type()))
+		}
+
+		i, ok := fields.(map[string]int)[name]
+		if !ok {
+			return nil
+		}
+		return rv.Field(i).Interface()
+	}
+
+	return nil
+}
+
+func fieldNames(t reflect.Type) map[string]int {
+	names := map[string]int{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		names[name] = i
+	}
+
+	return names
+}
+
+// object is an object of the response, whose fields keep the order they were selected
+// in.
+type object struct {
+	keys   []string
+	values []any
+}
+
+func (o *object) set(key string, v any) {
+	if i := slices.Index(o.keys, key); i >= 0 {
+		o.values[i] = v
+		return
+	}
+
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, v)
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+func asError(err error) *Error {
+	var gqlErr *Error
+	if errors.As(err, &gqlErr) {
+		return gqlErr
+	}
+
+	return &Error{Message: err.Error()}
+}
+
+// withCode sets the code of an error which doesn't have one.
+func withCode(err *Error, code string) *Error {
+	if _, ok := err.Extensions["code"]; !ok {
+		if err.Extensions == nil {
+			err.Extensions = map[string]any{}
+		}
+		err.Extensions["code"] = code
+	}
+
+	return err
+}
+
+func withCodes(errs []*Error, code string) []*Error {
+	for _, err := range errs {
+		withCode(err, code)
+	}
+
+	return errs
+}
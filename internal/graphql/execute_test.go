@@ -0,0 +1,355 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testSchema returns a schema of nodes which nest indefinitely, with a list whose
+// complexity is its size times that of its items, and a mutation recording the order
+// its fields run in.
+func testSchema(calls *[]string) *Schema {
+	node := &Object{Name: "Node"}
+	node.Fields = Fields{
+		"id":   {Type: NonNullOf(Int)},
+		"name": {Type: String},
+		"child": {Type: node, Resolve: func(p ResolveParams) (any, error) {
+			return map[string]any{"id": p.Source.(map[string]any)["id"].(int) + 1}, nil
+		}},
+		"fail": {Type: String, Resolve: func(p ResolveParams) (any, error) {
+			return nil, Errorf("BROKEN", "field failed")
+		}},
+	}
+
+	query := &Object{Name: "Query", Fields: Fields{
+		"hello": {Type: String, Args: map[string]*Argument{
+			"name": {Type: String, Default: "world"},
+		}, Resolve: func(p ResolveParams) (any, error) {
+			return "hello " + p.Args.String("name"), nil
+		}},
+		"node": {Type: node, Args: map[string]*Argument{
+			"id": {Type: NonNullOf(Int)},
+		}, Resolve: func(p ResolveParams) (any, error) {
+			return map[string]any{"id": p.Args.Int("id"), "name": "node"}, nil
+		}},
+		"nodes": {Type: ListOf(node), Args: map[string]*Argument{
+			"first": {Type: Int, Default: 10},
+		}, Resolve: func(p ResolveParams) (any, error) {
+			nodes := make([]any, p.Args.Int("first"))
+			for i := range nodes {
+				nodes[i] = map[string]any{"id": i}
+			}
+			return nodes, nil
+		}, Complexity: func(args Args, childComplexity int) int {
+			return args.Int("first") * childComplexity
+		}},
+	}}
+
+	record := func(name string) *Field {
+		return &Field{Type: String, Resolve: func(p ResolveParams) (any, error) {
+			*calls = append(*calls, name)
+			return name, nil
+		}}
+	}
+	mutation := &Object{Name: "Mutation", Fields: Fields{
+		"first":  record("first"),
+		"second": record("second"),
+	}}
+
+	return &Schema{Query: query, Mutation: mutation, MaxDepth: 4, MaxComplexity: 50}
+}
+
+// run executes query and returns the response encoded as JSON, along with the codes of
+// its errors.
+func run(t *testing.T, s *Schema, query string, variables map[string]any) (string, []string) {
+	t.Helper()
+
+	resp := s.Execute(context.Background(), Request{Query: query, Variables: variables})
+
+	js, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("encoding the data: %v", err)
+	}
+
+	var codes []string
+	for _, err := range resp.Errors {
+		code, _ := err.Extensions["code"].(string)
+		codes = append(codes, code)
+	}
+
+	return string(js), codes
+}
+
+// executeTests are the cases of TestExecute, and the seeds of FuzzExecute.
+var executeTests = []struct {
+	name      string
+	query     string
+	variables map[string]any
+	// data is the expected data, as JSON; it is not compared when empty.
+	data  string
+	codes []string
+}{
+	{
+		name:  "default argument",
+		query: `{ hello }`,
+		data:  `{"hello":"hello world"}`,
+	},
+	{
+		name:  "aliases",
+		query: `{ a: hello(name: "a") b: hello(name: "b") }`,
+		data:  `{"a":"hello a","b":"hello b"}`,
+	},
+	{
+		name:      "variables",
+		query:     `query ($id: Int!) { node(id: $id) { id name } }`,
+		variables: map[string]any{"id": 3},
+		data:      `{"node":{"id":3,"name":"node"}}`,
+	},
+	{
+		name:  "missing variable",
+		query: `query ($id: Int!) { node(id: $id) { id } }`,
+		data:  `null`,
+		codes: []string{"BAD_USER_INPUT"},
+	},
+	{
+		name:  "missing argument",
+		query: `{ node { id } }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+	{
+		name:  "fragments",
+		query: `{ node(id: 1) { ...ids ... on Node { name } } } fragment ids on Node { id }`,
+		data:  `{"node":{"id":1,"name":"node"}}`,
+	},
+	{
+		name:  "skip and include",
+		query: `{ node(id: 1) { id @include(if: false) name @skip(if: false) } }`,
+		data:  `{"node":{"name":"node"}}`,
+	},
+	{
+		name:  "typename",
+		query: `{ node(id: 1) { __typename } }`,
+		data:  `{"node":{"__typename":"Node"}}`,
+	},
+	{
+		name:  "unknown field",
+		query: `{ node(id: 1) { nope } }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+	{
+		name:  "field error",
+		query: `{ node(id: 1) { id fail } }`,
+		data:  `{"node":{"fail":null,"id":1}}`,
+		codes: []string{"BROKEN"},
+	},
+	{
+		name:  "syntax error",
+		query: `{ node(id: 1) { id }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_PARSE_FAILED"},
+	},
+	{
+		name:  "unterminated string",
+		query: `{ hello(name: "a) }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_PARSE_FAILED"},
+	},
+	{
+		name:  "several operations without a name",
+		query: `query a { hello } query b { hello }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+
+	// The limits: the schema allows a depth of 4, and a complexity of 50.
+	{
+		name:  "depth at the limit",
+		query: `{ node(id: 1) { child { child { id } } } }`,
+		data:  `{"node":{"child":{"child":{"id":3}}}}`,
+	},
+	{
+		name:  "depth over the limit",
+		query: `{ node(id: 1) { child { child { child { id } } } } }`,
+		data:  `null`,
+		codes: []string{"DEPTH_LIMIT_EXCEEDED"},
+	},
+	{
+		name:  "depth through fragments",
+		query: `{ node(id: 1) { ...a } } fragment a on Node { child { ...b } } fragment b on Node { child { child { id } } }`,
+		data:  `null`,
+		codes: []string{"DEPTH_LIMIT_EXCEEDED"},
+	},
+	{
+		name:  "complexity at the limit",
+		query: `{ nodes(first: 25) { id name } }`,
+	},
+	{
+		name:  "complexity over the limit",
+		query: `{ nodes(first: 26) { id name } }`,
+		data:  `null`,
+		codes: []string{"COMPLEXITY_LIMIT_EXCEEDED"},
+	},
+	{
+		name:  "complexity of the default arguments",
+		query: `{ nodes { id name child { id name child { id } } } }`,
+		data:  `null`,
+		codes: []string{"COMPLEXITY_LIMIT_EXCEEDED"},
+	},
+
+	// Fragment cycles.
+	{
+		name:  "fragment spreading itself",
+		query: `{ node(id: 1) { ...a } } fragment a on Node { id ...a }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+	{
+		name:  "fragments spreading each other",
+		query: `{ node(id: 1) { ...a } } fragment a on Node { child { ...b } } fragment b on Node { child { ...a } }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+	{
+		name:  "unused fragment cycle",
+		query: `{ hello } fragment a on Node { ...b } fragment b on Node { ...a }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+	{
+		name:  "fragment spread twice",
+		query: `{ node(id: 1) { ...a ...a } } fragment a on Node { id }`,
+		data:  `{"node":{"id":1}}`,
+	},
+	{
+		name:  "unknown fragment",
+		query: `{ node(id: 1) { ...a } }`,
+		data:  `null`,
+		codes: []string{"GRAPHQL_VALIDATION_FAILED"},
+	},
+
+	// Documents nesting deeper than the parser goes.
+	{
+		name:  "nesting past the parser",
+		query: strings.Repeat("{ node(id: 1) ", maxNesting+1) + strings.Repeat("}", maxNesting+1),
+		data:  `null`,
+		codes: []string{"GRAPHQL_PARSE_FAILED"},
+	},
+	{
+		name:  "list nesting past the parser",
+		query: "{ hello(name: " + strings.Repeat("[", maxNesting+1) + strings.Repeat("]", maxNesting+1) + ") }",
+		data:  `null`,
+		codes: []string{"GRAPHQL_PARSE_FAILED"},
+	},
+}
+
+func TestExecute(t *testing.T) {
+	for _, tt := range executeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, codes := run(t, testSchema(new([]string)), tt.query, tt.variables)
+
+			if tt.data != "" && !jsonEqual(t, data, tt.data) {
+				t.Errorf("got data %s, want %s", data, tt.data)
+			}
+			if strings.Join(codes, ",") != strings.Join(tt.codes, ",") {
+				t.Errorf("got errors %v, want %v", codes, tt.codes)
+			}
+		})
+	}
+}
+
+// FuzzExecute runs arbitrary documents against the test schema: they may fail, but must
+// never panic, nor get past the limits. Run it with go test -fuzz FuzzExecute.
+func FuzzExecute(f *testing.F) {
+	for _, tt := range executeTests {
+		f.Add(tt.query)
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		resp := testSchema(new([]string)).Execute(context.Background(), Request{Query: query})
+		if resp.Data == nil && len(resp.Errors) == 0 {
+			t.Errorf("%q: no data and no errors", query)
+		}
+	})
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("decoding %s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("decoding %s: %v", b, err)
+	}
+
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+func TestExecuteMutationSerially(t *testing.T) {
+	var calls []string
+	s := testSchema(&calls)
+
+	for range 5 {
+		calls = calls[:0]
+		_, codes := run(t, s, `mutation { b: second a: first c: second }`, nil)
+		if len(codes) > 0 {
+			t.Fatalf("unexpected errors %v", codes)
+		}
+
+		if got := strings.Join(calls, ","); got != "second,first,second" {
+			t.Fatalf("the fields ran in the order %s", got)
+		}
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var fetches atomic.Int32
+	loader := NewLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		fetches.Add(1)
+
+		values := make(map[int]string, len(keys))
+		for _, k := range keys {
+			if k != 0 {
+				values[k] = strings.Repeat("x", k)
+			}
+		}
+		return values, nil
+	})
+
+	ctx := context.Background()
+	thunks := []Thunk{loader.Load(ctx, 1), loader.Load(ctx, 2), loader.Load(ctx, 1), loader.Load(ctx, 0)}
+
+	for i, want := range []any{"x", "xx", "x"} {
+		got, err := thunks[i]()
+		if err != nil || got != want {
+			t.Errorf("thunk %d: got %v, %v, want %v", i, got, err, want)
+		}
+	}
+	if got, _ := thunks[3](); got != "" {
+		t.Errorf("missing key: got %v, want the zero value", got)
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want once", n)
+	}
+}
+
+func TestLoaderError(t *testing.T) {
+	failure := errors.New("failure")
+	loader := NewLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		return nil, failure
+	})
+
+	if _, err := loader.Load(context.Background(), 1)(); !errors.Is(err, failure) {
+		t.Errorf("got error %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,399 @@
+// Package graphql executes GraphQL queries and mutations against a schema built in Go.
+// It covers the part of the spec the API needs: objects, input objects, enums, the
+// built-in scalars, variables, fragments, aliases and the @skip and @include
+// directives. Interfaces, unions, subscriptions and introspection, beyond __typename,
+// are left out; the schema can be printed instead, see Schema.SDL.
+//
+// Documents are checked against the schema before they run, which includes limits on
+// how deep they nest and how much they would cost, so that a single request can't ask
+// for the whole database. Resolvers returning a Thunk are forced once every sibling has
+// been resolved, which lets a Loader fetch the values of a whole list in one go.
+//
+// The package is kept in the tree rather than taken from gqlgen or graphql-go, whose
+// generated resolvers and reflection-based schemas would have been a second way of
+// describing the API next to the REST handlers the resolvers reuse. It only has to
+// follow the subset of the spec above; anything past it is rejected when the document
+// is parsed or validated, never run. Documents are bounded before they are executed:
+// the body size of the route, how deep they nest (maxNesting) while they're parsed,
+// and the depth and complexity limits of the schema. Its tests, and the fuzz target
+// which runs over every document of them, are what a change to it has to pass.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Type is the type of a field, an argument or a variable: a *Scalar, an *Enum, an
+// *Object, an *InputObject, or one of them wrapped in a *List or a *NonNull.
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Its values are serialized to JSON as they are, once the
+// pointers are dereferenced.
+type Scalar struct {
+	Name        string
+	Description string
+
+	// coerce turns a value read from the variables, or a literal, into the Go value
+	// handed to the resolvers.
+	coerce func(v any) (any, error)
+	// serialize turns a value returned by a resolver into its JSON form.
+	serialize func(v any) (any, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Enum is a leaf type whose values are one of a set of names. The resolvers get and
+// return them as strings, or types of kind string.
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+func (e *Enum) String() string { return e.Name }
+
+// Object is a type made of fields, each with its own resolver.
+type Object struct {
+	Name        string
+	Description string
+	Fields      Fields
+}
+
+func (o *Object) String() string { return o.Name }
+
+// Fields are the fields of an object, by name.
+type Fields map[string]*Field
+
+// InputObject is the type of an argument made of several fields, such as the input of
+// a mutation. The resolvers get it as Args.
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      map[string]*Argument
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+// List is a list of values of a type.
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NonNull is a type whose values can't be null.
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ListOf and NonNullOf wrap a type, for schemas which read better without the struct
+// literals.
+func ListOf(t Type) *List       { return &List{Of: t} }
+func NonNullOf(t Type) *NonNull { return &NonNull{Of: t} }
+
+// Field is a field of an object.
+type Field struct {
+	Description string
+	Type        Type
+	Args        map[string]*Argument
+
+	// Resolve returns the value of the field for p.Source, which is nil for the root
+	// fields. It may return a Thunk to be forced once the siblings of the field are
+	// resolved. A nil Resolve reads the field from the source, by its json tag for a
+	// struct, or its key for a map.
+	Resolve ResolveFunc
+
+	// Complexity returns the cost of the field given its arguments and the cost of its
+	// selection. It defaults to 1 plus the cost of the selection, which lists should
+	// multiply by the number of items they may return.
+	Complexity func(args Args, childComplexity int) int
+}
+
+// Argument is an argument of a field, or a field of an input object. A nil Default
+// leaves it out when it isn't given.
+type Argument struct {
+	Description string
+	Type        Type
+	Default     any
+}
+
+// ResolveFunc resolves the value of a field.
+type ResolveFunc func(p ResolveParams) (any, error)
+
+// ResolveParams are what a field is resolved with.
+type ResolveParams struct {
+	Context context.Context
+	Source  any
+	Args    Args
+}
+
+// Thunk is a value to be resolved later, see Loader.
+type Thunk func() (any, error)
+
+// Schema holds the root types of the API and the limits the documents are checked
+// against. A zero limit is no limit.
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+
+	MaxDepth      int
+	MaxComplexity int
+
+	typesOnce sync.Once
+	typeMap   map[string]Type
+}
+
+// Request is a GraphQL request, as sent in the body of a POST.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Response is the result of a request. Data is nil when the request couldn't be run at
+// all, in which case Errors says why.
+type Response struct {
+	Data   any      `json:"data"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Location is a position in the document of a request, counted from 1.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is an error in the response to a request. Resolvers return one to set its
+// extensions, such as a code for the clients to check; any other error is sent with
+// its message alone.
+type Error struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error with a code, set as its "code" extension.
+func Errorf(code, format string, args ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Extensions: map[string]any{"code": code}}
+}
+
+// Args are the arguments of a field, coerced to their types: an int for Int, a float64
+// for Float, a string for String, ID and the enums, a bool for Boolean, a []any for
+// lists and Args for input objects. The arguments not given, without a default, are
+// missing; those given as null are nil.
+type Args map[string]any
+
+// Has reports whether the argument was given, even as null.
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// String returns the argument name, or "" if it's missing or null.
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns the argument name, or 0 if it's missing or null.
+func (a Args) Int(name string) int {
+	n, _ := a[name].(int)
+	return n
+}
+
+// Bool returns the argument name, or false if it's missing or null.
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Strings returns the list of strings name, or nil if it's missing or null.
+func (a Args) Strings(name string) []string {
+	list, _ := a[name].([]any)
+	if list == nil {
+		return nil
+	}
+
+	s := make([]string, 0, len(list))
+	for _, v := range list {
+		if v, ok := v.(string); ok {
+			s = append(s, v)
+		}
+	}
+
+	return s
+}
+
+// Input returns the input object name, or nil if it's missing or null.
+func (a Args) Input(name string) Args {
+	input, _ := a[name].(Args)
+	return input
+}
+
+// named returns the type t wraps, if any.
+func named(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *NonNull:
+			t = w.Of
+		case *List:
+			t = w.Of
+		default:
+			return t
+		}
+	}
+}
+
+// types returns every named type reachable from the root types, by name.
+func (s *Schema) types() map[string]Type {
+	types := map[string]Type{}
+	for _, scalar := range []*Scalar{Int, Float, String, Boolean, ID} {
+		types[scalar.Name] = scalar
+	}
+
+	var visit func(t Type)
+	visit = func(t Type) {
+		t = named(t)
+		name := t.String()
+		if _, ok := types[name]; ok {
+			return
+		}
+		types[name] = t
+
+		switch t := t.(type) {
+		case *Object:
+			for _, field := range t.Fields {
+				visit(field.Type)
+				for _, arg := range field.Args {
+					visit(arg.Type)
+				}
+			}
+		case *InputObject:
+			for _, field := range t.Fields {
+				visit(field.Type)
+			}
+		}
+	}
+
+	if s.Query != nil {
+		visit(s.Query)
+	}
+	if s.Mutation != nil {
+		visit(s.Mutation)
+	}
+
+	return types
+}
+
+// SDL prints the schema in the GraphQL schema definition language, for the clients to
+// generate their types from.
+func (s *Schema) SDL() string {
+	types := s.types()
+
+	names := make([]string, 0, len(types))
+	for name, t := range types {
+		if _, builtin := t.(*Scalar); builtin && isBuiltin(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		switch t := types[name].(type) {
+		case *Scalar:
+			printDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "scalar %s\n\n", t.Name)
+		case *Enum:
+			printDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "enum %s {\n", t.Name)
+			for _, value := range t.Values {
+				fmt.Fprintf(&b, "  %s\n", value)
+			}
+			b.WriteString("}\n\n")
+		case *Object:
+			printDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "type %s {\n", t.Name)
+			for _, fieldName := range sortedKeys(t.Fields) {
+				field := t.Fields[fieldName]
+				printDescription(&b, "  ", field.Description)
+				fmt.Fprintf(&b, "  %s%s: %s\n", fieldName, printArgs(field.Args), field.Type)
+			}
+			b.WriteString("}\n\n")
+		case *InputObject:
+			printDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "input %s {\n", t.Name)
+			for _, fieldName := range sortedKeys(t.Fields) {
+				field := t.Fields[fieldName]
+				printDescription(&b, "  ", field.Description)
+				fmt.Fprintf(&b, "  %s: %s%s\n", fieldName, field.Type, printDefault(field.Default))
+			}
+			b.WriteString("}\n\n")
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func isBuiltin(name string) bool {
+	switch name {
+	case "Int", "Float", "String", "Boolean", "ID":
+		return true
+	}
+
+	return false
+}
+
+func printDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%q\n", indent, description)
+	}
+}
+
+func printArgs(args map[string]*Argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(args))
+	for _, name := range sortedKeys(args) {
+		parts = append(parts, fmt.Sprintf("%s: %s%s", name, args[name].Type, printDefault(args[name].Default)))
+	}
+
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+func printDefault(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return fmt.Sprintf(" = %q", v)
+	default:
+		return fmt.Sprintf(" = %v", v)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,79 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// Loader batches the reads of values by key, such as the anime of a list of watchlist
+// entries, into one fetch. Load only queues the key, and returns a Thunk for the
+// resolver to return; the first thunk forced fetches every key queued by then, which
+// the executor makes the keys of all the siblings of the field.
+//
+// The values are cached for the life of the loader, which should be a single request.
+type Loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	values  map[K]V
+	errs    map[K]error
+}
+
+// NewLoader returns a loader fetching the values with fetch, which returns them by key.
+// The keys missing from its result have the zero value, such as a nil pointer.
+func NewLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:  fetch,
+		queued: map[K]bool{},
+		values: map[K]V{},
+		errs:   map[K]error{},
+	}
+}
+
+// Load queues key to be fetched, and returns a thunk resolving to its value.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) Thunk {
+	l.mu.Lock()
+	if !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		return l.get(ctx, key)
+	}
+}
+
+func (l *Loader[K, V]) get(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := l.values[key]; ok {
+		return v, nil
+	}
+	if err, ok := l.errs[key]; ok {
+		var zero V
+		return zero, err
+	}
+
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.fetch(ctx, keys)
+	for _, k := range keys {
+		if err != nil {
+			l.errs[k] = err
+			continue
+		}
+		l.values[k] = values[k]
+	}
+
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	return l.values[key], nil
+}
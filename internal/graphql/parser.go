@@ -0,0 +1,745 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The parser reads an executable document: operations and fragments. Schema
+// definitions aren't accepted, as the schema is built in Go.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []*variableDefinition
+	selections []selection
+	loc        Location
+}
+
+type variableDefinition struct {
+	name  string
+	typ   *typeRef
+	value *value // the default, if any
+	loc   Location
+}
+
+// typeRef is a type as written in a variable definition: a name, or a list of a type,
+// either of which can be non-null.
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.list != nil {
+		s = "[" + t.list.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+
+	return s
+}
+
+// selection is one of a field, a fragment spread or an inline fragment.
+type selection struct {
+	field  *field
+	spread *fragmentSpread
+	inline *inlineFragment
+}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+// key returns the name of the field in the response.
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+
+	return f.name
+}
+
+type argument struct {
+	name  string
+	value *value
+	loc   Location
+}
+
+type directive struct {
+	name string
+	args []*argument
+	loc  Location
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	on         string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type fragment struct {
+	name       string
+	on         string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type valueKind int
+
+const (
+	valueVariable valueKind = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBoolean
+	valueNull
+	valueEnum
+	valueList
+	valueObject
+)
+
+// value is a literal, or a variable, in the document.
+type value struct {
+	kind   valueKind
+	raw    string // the name of a variable or an enum value, or the literal itself
+	list   []*value
+	fields []*objectField
+	loc    Location
+}
+
+type objectField struct {
+	name  string
+	value *value
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+// lexer splits a document into tokens, skipping the whitespace, the commas and the
+// comments.
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	// lineStart is the offset the current line starts at, for the columns.
+	lineStart int
+}
+
+func (l *lexer) loc() Location {
+	return Location{Line: l.line, Column: utf8.RuneCountInString(l.src[l.lineStart:l.pos]) + 1}
+}
+
+func (l *lexer) errorf(loc Location, format string, args ...any) *Error {
+	return &Error{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+func (l *lexer) newline() {
+	l.line++
+	l.lineStart = l.pos
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case ' ', '\t', ',':
+			l.pos++
+		case '\n':
+			l.pos++
+			l.newline()
+		case '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newline()
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				l.pos += len("\uFEFF")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	loc := l.loc()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.ContainsRune("!$&()[]{}:=@|", rune(c)):
+		l.pos++
+		return token{kind: tokenPunct, value: string(c), loc: loc}, nil
+	case c == '.':
+		if strings.HasPrefix(l.src[l.pos:], "...") {
+			l.pos += 3
+			return token{kind: tokenPunct, value: "...", loc: loc}, nil
+		}
+		return token{}, l.errorf(loc, "unexpected %q", c)
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString(loc)
+		}
+		return l.string(loc)
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, l.errorf(loc, "unexpected character %q", r)
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	digits := func() bool {
+		from := l.pos
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.pos > from
+	}
+
+	intStart := l.pos
+	if !digits() {
+		return token{}, l.errorf(loc, "invalid number, expected a digit")
+	}
+	if l.src[intStart] == '0' && l.pos-intStart > 1 {
+		return token{}, l.errorf(loc, "invalid number, unexpected digit after 0")
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if !digits() {
+			return token{}, l.errorf(loc, "invalid number, expected a digit after the dot")
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !digits() {
+			return token{}, l.errorf(loc, "invalid number, expected a digit in the exponent")
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == '.' || isNameStart(l.src[l.pos])) {
+		return token{}, l.errorf(loc, "invalid number, unexpected %q", l.src[l.pos])
+	}
+
+	return token{kind: kind, value: l.src[start:l.pos], loc: loc}, nil
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	l.pos++ // the opening quote
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: b.String(), loc: loc}, nil
+		case c == '\n' || c == '\r':
+			return token{}, l.errorf(loc, "unterminated string")
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(loc, "unterminated string")
+			}
+
+			escape := l.src[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, l.errorf(loc, "invalid unicode escape sequence")
+				}
+				r, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(loc, "invalid unicode escape sequence")
+				}
+				l.pos += 4
+				b.WriteRune(rune(r))
+			default:
+				return token{}, l.errorf(loc, "invalid escape sequence \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+
+	return token{}, l.errorf(loc, "unterminated string")
+}
+
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.pos += 3 // the opening quotes
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return token{kind: tokenString, value: dedent(b.String()), loc: loc}, nil
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			b.WriteString(`"""`)
+			l.pos += 4
+		case l.src[l.pos] == '\n':
+			b.WriteByte('\n')
+			l.pos++
+			l.newline()
+		default:
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+
+	return token{}, l.errorf(loc, "unterminated string")
+}
+
+// dedent removes the indentation common to the lines of a block string but its first,
+// and its leading and trailing blank lines.
+func dedent(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// maxNesting bounds how deep the selection sets, values and types of a document nest,
+// so that parsing it can't exhaust the stack. The depth limit of the schema, which is
+// far lower, is only checked once the document is parsed.
+const maxNesting = 256
+
+// parser builds a document from the tokens of the lexer, looking one token ahead.
+type parser struct {
+	lexer lexer
+	tok   token
+	// nesting is how deep the parser currently is in the document.
+	nesting int
+}
+
+// parse parses a document, returning the first syntax error in it.
+func parse(src string) (doc *document, err error) {
+	p := &parser{lexer: lexer{src: src, line: 1}}
+
+	// The parser panics with its errors, so that every rule doesn't have to check them.
+	defer func() {
+		if v := recover(); v != nil {
+			e, ok := v.(*Error)
+			if !ok {
+				panic(v)
+			}
+			doc, err = nil, e
+		}
+	}()
+
+	p.advance()
+
+	doc = &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", loc: p.tok.loc, selections: p.selectionSet()})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peek(tokenName, "fragment"):
+			f := p.fragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				panic(&Error{Message: fmt.Sprintf("There can be only one fragment named %q.", f.name), Locations: []Location{f.loc}})
+			}
+			doc.fragments[f.name] = f
+		default:
+			p.unexpected()
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "The document must contain an operation."}
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() {
+	tok, err := p.lexer.next()
+	if err != nil {
+		panic(err)
+	}
+	p.tok = tok
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+// skip consumes the token if it's the punctuator or keyword value.
+func (p *parser) skip(kind tokenKind, value string) bool {
+	if p.peek(kind, value) {
+		p.advance()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, value string) {
+	if !p.skip(kind, value) {
+		p.unexpected()
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.unexpected()
+	}
+
+	name := p.tok.value
+	p.advance()
+	return name
+}
+
+// enter goes one level deeper in the document, which leave goes back up from.
+func (p *parser) enter() {
+	p.nesting++
+	if p.nesting > maxNesting {
+		panic(p.lexer.errorf(p.tok.loc, "the document nests more than %d levels deep", maxNesting))
+	}
+}
+
+func (p *parser) leave() {
+	p.nesting--
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == tokenEOF {
+		panic(p.lexer.errorf(p.tok.loc, "unexpected end of document"))
+	}
+
+	panic(p.lexer.errorf(p.tok.loc, "unexpected %q", p.tok.value))
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+	p.advance()
+
+	if p.tok.kind == tokenName {
+		op.name = p.name()
+	}
+
+	if p.skip(tokenPunct, "(") {
+		for !p.skip(tokenPunct, ")") {
+			op.variables = append(op.variables, p.variableDefinition())
+		}
+	}
+
+	if p.peek(tokenPunct, "@") {
+		panic(&Error{Message: "Directives on operations are not supported.", Locations: []Location{p.tok.loc}})
+	}
+
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *parser) variableDefinition() *variableDefinition {
+	def := &variableDefinition{loc: p.tok.loc}
+
+	p.expect(tokenPunct, "$")
+	def.name = p.name()
+	p.expect(tokenPunct, ":")
+	def.typ = p.typeRef()
+
+	if p.skip(tokenPunct, "=") {
+		def.value = p.value(true)
+	}
+
+	return def
+}
+
+func (p *parser) typeRef() *typeRef {
+	var t *typeRef
+	if p.skip(tokenPunct, "[") {
+		p.enter()
+		t = &typeRef{list: p.typeRef()}
+		p.expect(tokenPunct, "]")
+		p.leave()
+	} else {
+		t = &typeRef{name: p.name()}
+	}
+
+	t.nonNull = p.skip(tokenPunct, "!")
+	return t
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect(tokenPunct, "{")
+	p.enter()
+	defer p.leave()
+
+	var selections []selection
+	for !p.skip(tokenPunct, "}") {
+		selections = append(selections, p.selection())
+	}
+
+	if len(selections) == 0 {
+		panic(p.lexer.errorf(p.tok.loc, "a selection set must not be empty"))
+	}
+
+	return selections
+}
+
+func (p *parser) selection() selection {
+	loc := p.tok.loc
+
+	if !p.skip(tokenPunct, "...") {
+		return selection{field: p.field()}
+	}
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		return selection{spread: &fragmentSpread{name: p.name(), directives: p.directives(), loc: loc}}
+	}
+
+	inline := &inlineFragment{loc: loc}
+	if p.skip(tokenName, "on") {
+		inline.on = p.name()
+	}
+	inline.directives = p.directives()
+	inline.selections = p.selectionSet()
+
+	return selection{inline: inline}
+}
+
+func (p *parser) field() *field {
+	f := &field{loc: p.tok.loc, name: p.name()}
+
+	if p.skip(tokenPunct, ":") {
+		f.alias, f.name = f.name, p.name()
+	}
+
+	f.args = p.arguments()
+	f.directives = p.directives()
+
+	if p.peek(tokenPunct, "{") {
+		f.selections = p.selectionSet()
+	}
+
+	return f
+}
+
+func (p *parser) arguments() []*argument {
+	if !p.skip(tokenPunct, "(") {
+		return nil
+	}
+
+	var args []*argument
+	for !p.skip(tokenPunct, ")") {
+		arg := &argument{loc: p.tok.loc, name: p.name()}
+		p.expect(tokenPunct, ":")
+		arg.value = p.value(false)
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var directives []*directive
+	for p.peek(tokenPunct, "@") {
+		d := &directive{loc: p.tok.loc}
+		p.advance()
+		d.name = p.name()
+		d.args = p.arguments()
+		directives = append(directives, d)
+	}
+
+	return directives
+}
+
+func (p *parser) fragment() *fragment {
+	f := &fragment{loc: p.tok.loc}
+	p.advance()
+
+	if p.peek(tokenName, "on") {
+		p.unexpected()
+	}
+	f.name = p.name()
+
+	p.expect(tokenName, "on")
+	f.on = p.name()
+	f.directives = p.directives()
+	f.selections = p.selectionSet()
+
+	return f
+}
+
+// value parses a value. The defaults of the variables are constant, so they can't hold
+// variables themselves.
+func (p *parser) value(constant bool) *value {
+	v := &value{loc: p.tok.loc, raw: p.tok.value}
+
+	switch p.tok.kind {
+	case tokenPunct:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				p.unexpected()
+			}
+			p.advance()
+			v.kind, v.raw = valueVariable, p.name()
+			return v
+		case "[":
+			p.advance()
+			p.enter()
+			defer p.leave()
+			v.kind = valueList
+			for !p.skip(tokenPunct, "]") {
+				v.list = append(v.list, p.value(constant))
+			}
+			return v
+		case "{":
+			p.advance()
+			p.enter()
+			defer p.leave()
+			v.kind = valueObject
+			for !p.skip(tokenPunct, "}") {
+				name := p.name()
+				p.expect(tokenPunct, ":")
+				v.fields = append(v.fields, &objectField{name: name, value: p.value(constant)})
+			}
+			return v
+		}
+		p.unexpected()
+	case tokenInt:
+		v.kind = valueInt
+	case tokenFloat:
+		v.kind = valueFloat
+	case tokenString:
+		v.kind = valueString
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = valueBoolean
+		case "null":
+			v.kind = valueNull
+		default:
+			v.kind = valueEnum
+		}
+	default:
+		p.unexpected()
+	}
+
+	p.advance()
+	return v
+}
@@ -0,0 +1,166 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// The built-in scalars. Int is a 32-bit integer, as per the spec.
+var (
+	Int = &Scalar{
+		Name: "Int",
+		coerce: func(v any) (any, error) {
+			n, ok := toInt(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %s", describe(v))
+			}
+			return int(n), nil
+		},
+		serialize: func(v any) (any, error) {
+			n, ok := toInt(v)
+			if !ok {
+				return nil, fmt.Errorf("Int cannot represent %s", describe(v))
+			}
+			return n, nil
+		},
+	}
+
+	Float = &Scalar{
+		Name: "Float",
+		coerce: func(v any) (any, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %s", describe(v))
+			}
+			return f, nil
+		},
+		serialize: func(v any) (any, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %s", describe(v))
+			}
+			return f, nil
+		},
+	}
+
+	String = &Scalar{
+		Name: "String",
+		coerce: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("String cannot represent %s", describe(v))
+			}
+			return s, nil
+		},
+		serialize: func(v any) (any, error) {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.String {
+				return nil, fmt.Errorf("String cannot represent %s", describe(v))
+			}
+			return rv.String(), nil
+		},
+	}
+
+	Boolean = &Scalar{
+		Name: "Boolean",
+		coerce: func(v any) (any, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean cannot represent %s", describe(v))
+			}
+			return b, nil
+		},
+		serialize: func(v any) (any, error) {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Bool {
+				return nil, fmt.Errorf("Boolean cannot represent %s", describe(v))
+			}
+			return rv.Bool(), nil
+		},
+	}
+
+	// ID is read and written as a string, but takes integers as well.
+	ID = &Scalar{
+		Name: "ID",
+		coerce: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", describe(v))
+		},
+		serialize: func(v any) (any, error) {
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+				return rv.String(), nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", describe(v))
+		},
+	}
+)
+
+// toInt converts the integers of any kind, and the numbers read from JSON, to an int64.
+func toInt(v any) (int64, bool) {
+	if n, ok := v.(json.Number); ok {
+		i, err := n.Int64()
+		return i, err == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+// toFloat converts the numbers of any kind to a float64.
+func toFloat(v any) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+
+	return 0, false
+}
+
+// describe formats a value for the error messages.
+func describe(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
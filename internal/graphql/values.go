@@ -0,0 +1,296 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// coerceVariables coerces the variables sent with a request to the types the operation
+// declares them with, falling back to their defaults.
+func coerceVariables(types map[string]Type, op *operation, input map[string]any) (map[string]any, []*Error) {
+	variables := make(map[string]any, len(op.variables))
+	var errs []*Error
+
+	for _, def := range op.variables {
+		t, err := resolveTypeRef(types, def.typ)
+		if err != nil {
+			errs = append(errs, &Error{Message: err.Error(), Locations: []Location{def.loc}})
+			continue
+		}
+
+		raw, given := input[def.name]
+		if !given {
+			switch {
+			case def.value != nil:
+				v, _, err := valueFromAST(t, def.value, nil)
+				if err != nil {
+					errs = append(errs, &Error{Message: fmt.Sprintf("Variable \"$%s\" has an invalid default value: %s", def.name, err), Locations: []Location{def.loc}})
+					continue
+				}
+				variables[def.name] = v
+			case isNonNull(t):
+				errs = append(errs, &Error{Message: fmt.Sprintf("Variable \"$%s\" of required type \"%s\" was not provided.", def.name, t), Locations: []Location{def.loc}})
+			}
+			continue
+		}
+
+		v, err := coerceInput(t, raw)
+		if err != nil {
+			errs = append(errs, &Error{Message: fmt.Sprintf("Variable \"$%s\" got invalid value: %s", def.name, err), Locations: []Location{def.loc}})
+			continue
+		}
+		variables[def.name] = v
+	}
+
+	return variables, errs
+}
+
+// resolveTypeRef returns the type of a variable, which has to be an input type.
+func resolveTypeRef(types map[string]Type, ref *typeRef) (Type, error) {
+	var t Type
+	if ref.list != nil {
+		of, err := resolveTypeRef(types, ref.list)
+		if err != nil {
+			return nil, err
+		}
+		t = &List{Of: of}
+	} else {
+		t = types[ref.name]
+		switch t.(type) {
+		case *Scalar, *Enum, *InputObject:
+		case nil:
+			return nil, fmt.Errorf("Unknown type %q.", ref.name)
+		default:
+			return nil, fmt.Errorf("Variable type %q is not an input type.", ref.name)
+		}
+	}
+
+	if ref.nonNull {
+		t = &NonNull{Of: t}
+	}
+
+	return t, nil
+}
+
+func isNonNull(t Type) bool {
+	_, ok := t.(*NonNull)
+	return ok
+}
+
+// coerceInput coerces a value read from the JSON of the variables, or a value coerced
+// already, to the input type t.
+func coerceInput(t Type, v any) (any, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected a non-null %s", nonNull.Of)
+		}
+		return coerceInput(nonNull.Of, v)
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]any)
+		if !ok {
+			// A single value is taken as a list of one.
+			item, err := coerceInput(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+
+		list := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if list[i], err = coerceInput(t.Of, item); err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+		}
+		return list, nil
+	case *Scalar:
+		return t.coerce(v)
+	case *Enum:
+		s, ok := v.(string)
+		if !ok || !slices.Contains(t.Values, s) {
+			return nil, fmt.Errorf("%s is not a value of %s", describe(v), t.Name)
+		}
+		return s, nil
+	case *InputObject:
+		var fields map[string]any
+		switch v := v.(type) {
+		case map[string]any:
+			fields = v
+		case Args:
+			fields = v
+		default:
+			return nil, fmt.Errorf("expected an object for %s", t.Name)
+		}
+
+		for name := range fields {
+			if _, ok := t.Fields[name]; !ok {
+				return nil, fmt.Errorf("field %q is not defined by %s", name, t.Name)
+			}
+		}
+
+		input := Args{}
+		for name, def := range t.Fields {
+			raw, given := fields[name]
+			if !given {
+				if err := setDefault(input, name, def); err != nil {
+					return nil, fmt.Errorf("field %q: %w", name, err)
+				}
+				continue
+			}
+
+			var err error
+			if input[name], err = coerceInput(def.Type, raw); err != nil {
+				return nil, fmt.Errorf("field %q: %w", name, err)
+			}
+		}
+		return input, nil
+	}
+
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// setDefault sets the argument name to its default, if it has one, when it isn't given.
+func setDefault(args Args, name string, def *Argument) error {
+	switch {
+	case def.Default != nil:
+		v, err := coerceInput(def.Type, def.Default)
+		if err != nil {
+			return err
+		}
+		args[name] = v
+	case isNonNull(def.Type):
+		return fmt.Errorf("a value of type %s must be provided", def.Type)
+	}
+
+	return nil
+}
+
+// valueFromAST coerces a literal of the document to the input type t. The variables in
+// it are taken from variables; present is false for a variable which wasn't given, so
+// that the argument it's for falls back to its default.
+func valueFromAST(t Type, v *value, variables map[string]any) (result any, present bool, err error) {
+	if v.kind == valueVariable {
+		raw, ok := variables[v.raw]
+		if !ok {
+			if isNonNull(t) {
+				return nil, false, fmt.Errorf("variable \"$%s\" of type %s must be provided", v.raw, t)
+			}
+			return nil, false, nil
+		}
+
+		// The variable was coerced to its own type, which has to fit the one it's used
+		// for.
+		result, err := coerceInput(t, raw)
+		if err != nil {
+			return nil, false, fmt.Errorf("variable \"$%s\": %w", v.raw, err)
+		}
+		return result, true, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.kind == valueNull {
+			return nil, false, fmt.Errorf("expected a non-null %s", nonNull.Of)
+		}
+		return valueFromAST(nonNull.Of, v, variables)
+	}
+
+	if v.kind == valueNull {
+		return nil, true, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if v.kind != valueList {
+			item, _, err := valueFromAST(t.Of, v, variables)
+			if err != nil {
+				return nil, false, err
+			}
+			return []any{item}, true, nil
+		}
+
+		list := make([]any, len(v.list))
+		for i, item := range v.list {
+			if list[i], _, err = valueFromAST(t.Of, item, variables); err != nil {
+				return nil, false, fmt.Errorf("at index %d: %w", i, err)
+			}
+		}
+		return list, true, nil
+	case *Scalar:
+		var raw any
+		switch v.kind {
+		case valueInt, valueFloat:
+			raw = json.Number(v.raw)
+		case valueString:
+			raw = v.raw
+		case valueBoolean:
+			raw = v.raw == "true"
+		default:
+			return nil, false, fmt.Errorf("%s cannot represent %s", t.Name, literal(v))
+		}
+
+		result, err := t.coerce(raw)
+		return result, err == nil, err
+	case *Enum:
+		if v.kind != valueEnum || !slices.Contains(t.Values, v.raw) {
+			return nil, false, fmt.Errorf("%s is not a value of %s", literal(v), t.Name)
+		}
+		return v.raw, true, nil
+	case *InputObject:
+		if v.kind != valueObject {
+			return nil, false, fmt.Errorf("expected an object for %s", t.Name)
+		}
+
+		fields := make(map[string]*value, len(v.fields))
+		for _, field := range v.fields {
+			if _, ok := t.Fields[field.name]; !ok {
+				return nil, false, fmt.Errorf("field %q is not defined by %s", field.name, t.Name)
+			}
+			fields[field.name] = field.value
+		}
+
+		input := Args{}
+		for name, def := range t.Fields {
+			if field, ok := fields[name]; ok {
+				result, present, err := valueFromAST(def.Type, field, variables)
+				if err != nil {
+					return nil, false, fmt.Errorf("field %q: %w", name, err)
+				}
+				if present {
+					input[name] = result
+					continue
+				}
+			}
+
+			if err := setDefault(input, name, def); err != nil {
+				return nil, false, fmt.Errorf("field %q: %w", name, err)
+			}
+		}
+		return input, true, nil
+	}
+
+	return nil, false, fmt.Errorf("%s is not an input type", t)
+}
+
+// literal formats a literal for the error messages.
+func literal(v *value) string {
+	switch v.kind {
+	case valueString:
+		return strconv.Quote(v.raw)
+	case valueList:
+		return "a list"
+	case valueObject:
+		return "an object"
+	}
+
+	return v.raw
+}
@@ -0,0 +1,321 @@
+// Package jsonpatch applies JSON Patch documents (RFC 6902) to JSON values, as decoded
+// by encoding/json into an any: maps of strings to values, slices, strings, float64s,
+// booleans and nil.
+//
+// Every operation of the RFC is supported: add, remove, replace, move, copy and test.
+// A patch is applied in full or not at all: Apply works on a copy of the document.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MediaType is the media type of a JSON Patch document.
+const MediaType = "application/json-patch+json"
+
+var (
+	// ErrInvalid is wrapped by the errors of a malformed patch, such as an unknown
+	// operation or a path which isn't a JSON pointer.
+	ErrInvalid = errors.New("invalid patch")
+	// ErrPathNotFound is wrapped by the errors of an operation on a path which doesn't
+	// exist in the document.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrTestFailed is wrapped by the error of a test operation whose value doesn't
+	// match the document.
+	ErrTestFailed = errors.New("test failed")
+)
+
+// Operation is an operation of a patch. Value is left nil when the operation has no
+// value, which tells it apart from a null one.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a JSON Patch document: operations applied one after the other.
+type Patch []Operation
+
+// Error is the error of an operation of a patch, with its position in the patch.
+type Error struct {
+	Index int
+	Op    Operation
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %v", e.Index, e.Op.Op, e.Op.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Apply returns the document with the patch applied to it. The document itself is left
+// as it is. Errors are *Error, wrapping ErrInvalid, ErrPathNotFound or ErrTestFailed.
+func (p Patch) Apply(doc any) (any, error) {
+	doc = clone(doc)
+
+	for i, op := range p {
+		var err error
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, &Error{Index: i, Op: op, Err: err}
+		}
+	}
+
+	return doc, nil
+}
+
+func (op Operation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalid)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		return update(doc, path, func(any) (any, error) { return value, nil })
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: the value is %s", ErrTestFailed, encode(current))
+		}
+		return doc, nil
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			return add(doc, path, clone(value))
+		}
+
+		// A value can't be moved into one of its own children.
+		if len(from) < len(path) && slices.Equal(from, path[:len(from)]) {
+			return nil, fmt.Errorf("%w: cannot move %s into one of its children", ErrInvalid, op.From)
+		}
+
+		doc, err = remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalid, op.Op)
+	}
+}
+
+// unescape decodes the ~1 and ~0 escapes of the reference tokens in a single pass, so
+// that ~01 becomes ~1 rather than /.
+var unescape = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parsePointer splits a JSON pointer (RFC 6901) into its reference tokens, unescaped.
+// The empty pointer, the whole document, has none.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalid, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescape.Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// get returns the value at path.
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch d := doc.(type) {
+		case map[string]any:
+			value, ok := d[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: no member %q", ErrPathNotFound, token)
+			}
+			doc = value
+		case []any:
+			i, err := index(token, len(d)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("%w: %q is not in an object or an array", ErrPathNotFound, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// update returns the document with the value at path, which must exist, replaced by
+// what fn returns for it.
+func update(doc any, path []string, fn func(value any) (any, error)) (any, error) {
+	if len(path) == 0 {
+		return fn(doc)
+	}
+
+	token, rest := path[0], path[1:]
+	switch d := doc.(type) {
+	case map[string]any:
+		value, ok := d[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: no member %q", ErrPathNotFound, token)
+		}
+
+		value, err := update(value, rest, fn)
+		if err != nil {
+			return nil, err
+		}
+		d[token] = value
+		return d, nil
+	case []any:
+		i, err := index(token, len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := update(d[i], rest, fn)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = value
+		return d, nil
+	default:
+		return nil, fmt.Errorf("%w: %q is not in an object or an array", ErrPathNotFound, token)
+	}
+}
+
+// add returns the document with value added at path: set as a member of an object, or
+// inserted in an array, at its end for the - index.
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, token := path[:len(path)-1], path[len(path)-1]
+	return update(doc, parent, func(container any) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			if token == "-" {
+				return append(c, value), nil
+			}
+
+			i, err := index(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			return slices.Insert(c, i, value), nil
+		default:
+			return nil, fmt.Errorf("%w: %q is not in an object or an array", ErrPathNotFound, token)
+		}
+	})
+}
+
+// remove returns the document without the value at path, which must exist.
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalid)
+	}
+
+	parent, token := path[:len(path)-1], path[len(path)-1]
+	return update(doc, parent, func(container any) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("%w: no member %q", ErrPathNotFound, token)
+			}
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := index(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Delete(c, i, i+1), nil
+		default:
+			return nil, fmt.Errorf("%w: %q is not in an object or an array", ErrPathNotFound, token)
+		}
+	})
+}
+
+// index parses the token of an array index, which must be at most last. Indexes are
+// written in decimal, without leading zeros.
+func index(token string, last int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalid, token)
+	}
+	if i > last {
+		return 0, fmt.Errorf("%w: array index %d is out of range", ErrPathNotFound, i)
+	}
+
+	return i, nil
+}
+
+// clone returns a deep copy of a JSON value.
+func clone(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, value := range v {
+			c[key] = clone(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, value := range v {
+			c[i] = clone(value)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func encode(value any) string {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(js)
+}
@@ -0,0 +1,327 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, js string) any {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(js), &v); err != nil {
+		t.Fatalf("decoding %s: %v", js, err)
+	}
+
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		// The examples of the appendix A of RFC 6902.
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz": "qux"}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:  `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+
+		// Pointers.
+		{
+			name:  "escaped slash",
+			doc:   `{"a/b": 1}`,
+			patch: `[{"op": "replace", "path": "/a~1b", "value": 2}]`,
+			want:  `{"a/b": 2}`,
+		},
+		{
+			name:  "empty member name",
+			doc:   `{"": 1}`,
+			patch: `[{"op": "remove", "path": "/"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "path without a leading slash",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "remove", "path": "foo"}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "replacing the whole document",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "replace", "path": "", "value": [1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:  "removing the whole document",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "remove", "path": ""}]`,
+			err:   ErrInvalid,
+		},
+
+		// Array indexes.
+		{
+			name:  "adding at the end by index",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "add", "path": "/2", "value": 3}]`,
+			want:  `[1, 2, 3]`,
+		},
+		{
+			name:  "adding past the end",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "add", "path": "/3", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "removing past the end",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "remove", "path": "/2"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "removing the - index",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "remove", "path": "/-"}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "leading zero",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/01", "value": 3}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "negative index",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/-1", "value": 3}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "index zero",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/0", "value": 3}]`,
+			want:  `[3, 2]`,
+		},
+
+		// Values.
+		{
+			name:  "null value",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "replace", "path": "/foo", "value": null}]`,
+			want:  `{"foo": null}`,
+		},
+		{
+			name:  "testing a null value",
+			doc:   `{"foo": null}`,
+			patch: `[{"op": "test", "path": "/foo", "value": null}]`,
+			want:  `{"foo": null}`,
+		},
+		{
+			name:  "missing value",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "replace", "path": "/foo"}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "replacing a missing member",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "replace", "path": "/bar", "value": 2}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "unknown operation",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "increment", "path": "/foo"}]`,
+			err:   ErrInvalid,
+		},
+
+		// Move and copy.
+		{
+			name:  "moving into a child",
+			doc:   `{"foo": {"bar": 1}}`,
+			patch: `[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`,
+			err:   ErrInvalid,
+		},
+		{
+			name:  "moving to itself",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "move", "from": "/foo", "path": "/foo"}]`,
+			want:  `{"foo": 1}`,
+		},
+		{
+			name:  "moving to a sibling with a common prefix",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "move", "from": "/foo", "path": "/foobar"}]`,
+			want:  `{"foobar": 1}`,
+		},
+		{
+			name:  "copying",
+			doc:   `{"foo": {"bar": 1}}`,
+			patch: `[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "replace", "path": "/baz/bar", "value": 2}]`,
+			want:  `{"foo": {"bar": 1}, "baz": {"bar": 2}}`,
+		},
+		{
+			name:  "copying a missing value",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "copy", "from": "/bar", "path": "/baz"}]`,
+			err:   ErrPathNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch Patch
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatalf("decoding the patch: %v", err)
+			}
+
+			got, err := patch.Apply(decode(t, tt.doc))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", encode(got), encode(want))
+			}
+		})
+	}
+}
+
+func TestApplyAllOrNothing(t *testing.T) {
+	doc := decode(t, `{"foo": [1, 2], "bar": {"baz": 1}}`)
+	before := encode(doc)
+
+	patch := Patch{
+		{Op: "add", Path: "/foo/-", Value: json.RawMessage(`3`)},
+		{Op: "remove", Path: "/bar/baz"},
+		{Op: "test", Path: "/foo/0", Value: json.RawMessage(`2`)},
+	}
+
+	got, err := patch.Apply(doc)
+	if got != nil {
+		t.Errorf("got %s, want nothing", encode(got))
+	}
+
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want an *Error", err)
+	}
+	if perr.Index != 2 || !errors.Is(err, ErrTestFailed) {
+		t.Errorf("got error %v at %d, want the test failure of operation 2", err, perr.Index)
+	}
+
+	if after := encode(doc); after != before {
+		t.Errorf("the document was changed to %s", after)
+	}
+}
+
+func TestApplyLeavesDocument(t *testing.T) {
+	doc := decode(t, `{"foo": {"bar": [1]}}`)
+	before := encode(doc)
+
+	patch := Patch{{Op: "add", Path: "/foo/bar/-", Value: json.RawMessage(`2`)}}
+	if _, err := patch.Apply(doc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if after := encode(doc); after != before {
+		t.Errorf("the document was changed to %s", after)
+	}
+}
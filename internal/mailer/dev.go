@@ -0,0 +1,131 @@
+package mailer
+
+import (
+	"context"
+	"github.com/go-mail/mail/v2"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Log is a Transport for development, which writes the messages to the logger instead
+// of sending them, so that the links and tokens they carry can be read from the logs.
+// Only the plain text body is logged.
+type Log struct {
+	logger *slog.Logger
+}
+
+func NewLog(logger *slog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (l *Log) Name() string {
+	return "log"
+}
+
+func (l *Log) Send(ctx context.Context, msg *Message) error {
+	l.logger.InfoContext(ctx, "email", "from", msg.From, "to", msg.To, "subject", msg.Subject, "body", msg.PlainBody)
+	return nil
+}
+
+func (l *Log) Ping(context.Context) error {
+	return nil
+}
+
+// File is a Transport for development, which writes each message to a file of its own
+// in a directory, as a .eml file that mail clients can open.
+type File struct {
+	dir string
+}
+
+// NewFile returns a File transport writing to dir, which is created if it doesn't exist.
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{dir: dir}, nil
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+func (f *File) Send(_ context.Context, msg *Message) error {
+	// The files are named after the recipient, with a random suffix keeping them apart.
+	recipient := strings.Map(func(r rune) rune {
+		if r == filepath.Separator || r == '*' {
+			return '_'
+		}
+		return r
+	}, msg.To)
+
+	file, err := os.CreateTemp(f.dir, recipient+"-*.eml")
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.mime().WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Ping checks that the directory is still there.
+func (f *File) Ping(context.Context) error {
+	_, err := os.Stat(f.dir)
+	return err
+}
+
+// Recorder is a Transport keeping the messages in memory, for the tests to check what
+// was sent.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *Recorder) Name() string {
+	return "recorder"
+}
+
+func (r *Recorder) Send(_ context.Context, msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, *msg)
+	return nil
+}
+
+func (r *Recorder) Ping(context.Context) error {
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+// mime returns the message in the form it is sent over SMTP.
+func (msg *Message) mime() *mail.Message {
+	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
+	// Then we use the SetHeader() method to set the email recipient, sender and subject
+	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
+	// method to set the HTML body. It's important to note that AddAlternative() should
+	// always be called *after* SetBody().
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+
+	return m
+}
@@ -2,9 +2,18 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
-	"github.com/go-mail/mail/v2"
+	"errors"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 	"html/template"
+	"io/fs"
+	"math/rand/v2"
+	"path"
+	"strings"
+	texttemplate "text/template"
 	"time"
 )
 
@@ -17,93 +26,194 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
+// The templates of the emails sent by the API. Each one defines the "subject",
+// "plainBody" and "htmlBody" templates, which become the subject and the two parts of
+// a multipart/alternative message: mail clients show the HTML one when they can, and
+// the plain text one otherwise.
+const (
+	TemplateWelcome       = "user_welcome.tmpl"
+	TemplateActivation    = "token_activation.tmpl"
+	TemplatePasswordReset = "token_password_reset.tmpl"
+	TemplateEmailChange   = "token_email_change.tmpl"
+)
+
+// templateNames are the templates each template file must define.
+var templateNames = []string{"subject", "plainBody", "htmlBody"}
+
+// ErrTemplate is wrapped by the errors of the templates: an unknown template file, or a
+// template failing to execute with the data it was given. These aren't worth retrying.
+var ErrTemplate = errors.New("mailer: template error")
+
+// Mailer struct which contains the Transport delivering the emails to the provider, and
+// the sender information for your emails (the name and address you want the email to be
+// from, such as "Alice Smith <alice@example.com>").
+//
+// Sends failing for a reason that may go away, such as a network error or a 4xx reply
+// of the server, are retried up to retries times, waiting about backoff, then twice as
+// long after each attempt.
+//
+// The templates are parsed once, by New, keyed by the name of their file.
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    string
+	retries   int
+	backoff   time.Duration
+	templates map[string]emailTemplate
+}
+
+// emailTemplate is a template file parsed twice: as plain text for the subject and the
+// plain text body, so that they aren't HTML escaped, and as HTML for the HTML body.
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *template.Template
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// New returns a Mailer, after parsing every template of the templates directory. It fails
+// if one of them doesn't parse or lacks one of the subject, plainBody and htmlBody
+// templates, or if one of the templates the API sends is missing, so that a broken
+// template stops the application from starting rather than the first user from getting
+// their email.
+func New(transport Transport, sender string, retries int, backoff time.Duration) (Mailer, error) {
+	templates, err := parseTemplates()
+	if err != nil {
+		return Mailer{}, err
+	}
 
-	// Return a Mailer instance containing the dialer and sender information.
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport: transport,
+		sender:    sender,
+		retries:   retries,
+		backoff:   backoff,
+		templates: templates,
+	}, nil
+}
+
+func parseTemplates() (map[string]emailTemplate, error) {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]emailTemplate, len(files))
+	for _, file := range files {
+		// Use the ParseFS() method to parse the template file from the embedded file
+		// system.
+		var tmpl emailTemplate
+		if tmpl.text, err = texttemplate.New("email").ParseFS(templateFS, file); err != nil {
+			return nil, fmt.Errorf("mailer: parsing %s: %w", file, err)
+		}
+		if tmpl.html, err = template.New("email").ParseFS(templateFS, file); err != nil {
+			return nil, fmt.Errorf("mailer: parsing %s: %w", file, err)
+		}
+
+		for _, name := range templateNames {
+			if tmpl.text.Lookup(name) == nil {
+				return nil, fmt.Errorf("mailer: %s doesn't define the %q template", file, name)
+			}
+		}
+
+		templates[path.Base(file)] = tmpl
 	}
+
+	for _, file := range []string{TemplateWelcome, TemplateActivation, TemplatePasswordReset, TemplateEmailChange} {
+		if _, ok := templates[file]; !ok {
+			return nil, fmt.Errorf("mailer: template %s is missing", file)
+		}
+	}
+
+	return templates, nil
 }
 
 // Send method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
-	if err != nil {
-		return err
+// dynamic data for the templates as an any parameter. The send is traced as a span
+// of the trace in ctx, retries included.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data interface{}) (err error) {
+	ctx, span := telemetry.Start(ctx, "mailer.Send",
+		attribute.String("mail.template", templateFile),
+		attribute.String("mail.transport", m.transport.Name()),
+	)
+	defer func() { telemetry.End(span, err) }()
+
+	// Look up the template file, parsed by New.
+	tmpl, ok := m.templates[templateFile]
+	if !ok {
+		return fmt.Errorf("%w: unknown template %s", ErrTemplate, templateFile)
 	}
 
 	// Execute the named template "subject", passing in the dynamic data and storing the
 	// result in a bytes.Buffer variable.
 	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	err = tmpl.text.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrTemplate, err)
 	}
 
 	// Follow the same pattern to execute the "plainBody" template and store the result
 	// in the plainBody variable.
 	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	err = tmpl.text.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrTemplate, err)
 	}
 
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	err = tmpl.html.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrTemplate, err)
+	}
+
+	// The subject goes in a header, so it's kept to a single line. The blocks of the
+	// templates start and end with newlines, which are dropped from the bodies.
+	msg := &Message{
+		From:      m.sender,
+		To:        recipient,
+		Subject:   strings.Join(strings.Fields(subject.String()), " "),
+		PlainBody: strings.TrimSpace(plainBody.String()) + "\n",
+		HTMLBody:  strings.TrimSpace(htmlBody.String()) + "\n",
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Try sending the email up to three times before aborting and returning the final
-	// error. We sleep for 500 milliseconds between each attempt.
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for i := 1; i <= 3; i++ {
-		// Call the DialAndSend() method on the dialer, passing in the message to send. This
-		// opens a connection to the SMTP server, sends the message, then closes the
-		// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-		// error.
-		err = m.dialer.DialAndSend(msg)
+	// Try sending the email, retrying the failures that may be transient before giving
+	// up and returning the last error.
+	attempt := 0
+	defer func() { span.SetAttributes(attribute.Int("mail.attempts", attempt)) }()
+
+	for attempt = 1; ; attempt++ {
+		err = m.transport.Send(ctx, msg)
 
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
 		}
 
-		// If it didn't work, wait for the next tick and retry.
-		<-ticker.C
+		if attempt > m.retries || IsPermanent(err) {
+			return fmt.Errorf("mailer: sending %s failed after %d attempt(s): %w", templateFile, attempt, err)
+		}
+
+		// If it didn't work, wait before retrying, unless the context is done first.
+		timer := time.NewTimer(jitter(m.backoff << (attempt - 1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("mailer: sending %s gave up after %d attempt(s): %w", templateFile, attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// jitter returns a random duration between half of d and d, so that emails failing at
+// the same time, during an outage of the server, aren't all retried at the same time.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
 	}
 
-	return nil
+	return d/2 + rand.N(d/2)
+}
+
+// Ping checks that the provider can be reached and accepts our credentials. It gives up
+// when ctx is done.
+func (m Mailer) Ping(ctx context.Context) error {
+	return m.transport.Ping(ctx)
 }
@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+const sendGridURL = "https://api.sendgrid.com/v3"
+
+// SendGrid is a Transport sending the messages with the v3 Mail Send API of SendGrid.
+type SendGrid struct {
+	apiKey string
+	client *http.Client
+}
+
+func NewSendGrid(apiKey string) *SendGrid {
+	return &SendGrid{apiKey: apiKey, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *SendGrid) Name() string {
+	return "sendgrid"
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (s *SendGrid) Send(ctx context.Context, msg *Message) error {
+	// SendGrid wants the name of the sender apart from its address.
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+
+	// The plain text content must come before the HTML one.
+	body := map[string]any{
+		"personalizations": []map[string]any{{"to": []sendGridAddress{{Email: msg.To}}}},
+		"from":             sendGridAddress{Email: from.Address, Name: from.Name},
+		"subject":          msg.Subject,
+		"content": []sendGridContent{
+			{Type: "text/plain", Value: msg.PlainBody},
+			{Type: "text/html", Value: msg.HTMLBody},
+		},
+	}
+
+	req, _, err := newJSONRequest(ctx, http.MethodPost, sendGridURL+"/mail/send", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	return do(s.client, s.Name(), req)
+}
+
+// Ping lists the scopes of the API key, which only needs the key to be valid.
+func (s *SendGrid) Ping(ctx context.Context) error {
+	req, _, err := newJSONRequest(ctx, http.MethodGet, sendGridURL+"/scopes", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	return do(s.client, s.Name(), req)
+}
@@ -0,0 +1,131 @@
+package mailer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SES is a Transport sending the messages with the v2 API of Amazon SES. The requests
+// are signed with Signature Version 4, using the access key of an IAM user allowed to
+// call ses:SendEmail.
+type SES struct {
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+}
+
+func NewSES(region, accessKeyID, secretAccessKey string) *SES {
+	return &SES{
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SES) Name() string {
+	return "ses"
+}
+
+func (s *SES) endpoint() string {
+	return fmt.Sprintf("https://email.%s.amazonaws.com/v2/email", s.region)
+}
+
+func (s *SES) Send(ctx context.Context, msg *Message) error {
+	utf8 := func(data string) map[string]string {
+		return map[string]string{"Data": data, "Charset": "UTF-8"}
+	}
+
+	// SES takes the sender with its name, the way it goes in the From header.
+	body := map[string]any{
+		"FromEmailAddress": msg.From,
+		"Destination":      map[string]any{"ToAddresses": []string{msg.To}},
+		"Content": map[string]any{
+			"Simple": map[string]any{
+				"Subject": utf8(msg.Subject),
+				"Body": map[string]any{
+					"Text": utf8(msg.PlainBody),
+					"Html": utf8(msg.HTMLBody),
+				},
+			},
+		},
+	}
+
+	req, payload, err := newJSONRequest(ctx, http.MethodPost, s.endpoint()+"/outbound-emails", body)
+	if err != nil {
+		return err
+	}
+	s.sign(req, payload, time.Now())
+
+	return do(s.client, s.Name(), req)
+}
+
+// Ping fetches the details of the account, which every key allowed to send can do.
+func (s *SES) Ping(ctx context.Context) error {
+	req, payload, err := newJSONRequest(ctx, http.MethodGet, s.endpoint()+"/account", nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, payload, time.Now())
+
+	return do(s.client, s.Name(), req)
+}
+
+// sign adds the Authorization header of Signature Version 4 to req, signing its method,
+// path, Host, Content-Type and X-Amz-Date headers, and its payload. The requests to SES
+// have no query string.
+func (s *SES) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := []string{"host:" + req.URL.Host}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers = append([]string{"content-type:" + contentType}, headers...)
+	}
+	headers = append(headers, "x-amz-date:"+amzDate)
+
+	var names []string
+	for _, header := range headers {
+		name, _, _ := strings.Cut(header, ":")
+		names = append(names, name)
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/ses/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range []string{date, s.region, "ses", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
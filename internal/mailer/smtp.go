@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"github.com/go-mail/mail/v2"
+	"time"
+)
+
+// SMTP is a Transport sending the messages to an SMTP server, such as Mailtrap.
+type SMTP struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTP(host string, port int, username, password string) *SMTP {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTP{dialer: dialer}
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Send(_ context.Context, msg *Message) error {
+	// Call the DialAndSend() method on the dialer, passing in the message to send. This
+	// opens a connection to the SMTP server, sends the message, then closes the
+	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
+	// error.
+	return s.dialer.DialAndSend(msg.mime())
+}
+
+// Ping opens a connection to the SMTP server and closes it straight away. It gives up
+// when ctx is done, or after the dialer timeout.
+func (s *SMTP) Ping(ctx context.Context) error {
+	errc := make(chan error, 1)
+
+	go func() {
+		conn, err := s.dialer.Dial()
+		if err == nil {
+			err = conn.Close()
+		}
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+)
+
+// Message is an email rendered from its template, ready to be sent. It has both a plain
+// text and an HTML body, which the transports send as the parts of a
+// multipart/alternative message, the plain text one first.
+type Message struct {
+	// From is the sender, either an address or a name and an address such as
+	// "Alice Smith <alice@example.com>".
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Transport delivers the messages to a mail provider, over SMTP or the HTTP API of the
+// provider. The Mailer renders the messages and retries the failed sends, so a Transport
+// makes a single attempt.
+type Transport interface {
+	// Name is the name of the provider, for the traces.
+	Name() string
+	Send(ctx context.Context, msg *Message) error
+	// Ping checks that the provider can be reached and accepts our credentials.
+	Ping(ctx context.Context) error
+}
+
+// APIError is the error of an HTTP API of a provider which answered with a status other
+// than a success.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mailer: %s answered %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// IsPermanent reports whether the message can't be sent for good: its template failed, or
+// the provider rejected it, such as for an unknown recipient or bad credentials, with a
+// 5xx reply of an SMTP server or a 4xx status of an HTTP API other than Request Timeout
+// and Too Many Requests. Sending it again wouldn't change a thing.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrTemplate) {
+		return true
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 500
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+			apiErr.StatusCode != http.StatusRequestTimeout && apiErr.StatusCode != http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// newJSONRequest returns a request with the JSON encoding of body, or no body if it's nil.
+func newJSONRequest(ctx context.Context, method, url string, body any) (*http.Request, []byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, payload, nil
+}
+
+// do sends a request to the API of provider, and turns the answers other than a success
+// into an APIError. The body of the answer is dropped.
+func do(client *http.Client, provider string, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	// The errors of the APIs are short JSON documents, which are worth logging.
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return &APIError{Provider: provider, StatusCode: res.StatusCode, Body: string(bytes.TrimSpace(body))}
+}
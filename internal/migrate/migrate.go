@@ -0,0 +1,279 @@
+// Package migrate applies the schema migrations to the database. It keeps track of them
+// in the schema_migrations table the way golang-migrate does, so that the databases
+// migrated with its CLI carry on with this package, and the other way around.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// ErrDirty is returned when a migration failed half way through, which golang-migrate
+// flags as dirty, as the schema has to be fixed by hand before going on.
+var ErrDirty = errors.New("migrate: database is dirty, fix the failed migration by hand and reset schema_migrations.dirty")
+
+// lockKey is the key of the advisory lock held while migrating, so that instances
+// started together with -auto-migrate take turns.
+const lockKey = 7_246_314_801
+
+// fileName matches the names of the migration files, such as
+// 000001_create_anime_table.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a change to the schema, with the SQL applying and reverting it.
+type Migration struct {
+	Version uint64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status is a migration, and whether it's applied to the database.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies the migrations read from a file system to a database.
+type Migrator struct {
+	db         *pgxpool.Pool
+	logger     *slog.Logger
+	migrations []Migration
+}
+
+// New reads the migrations from the root of fsys, ordered by version. Every migration
+// must have both its up and down files.
+func New(db *pgxpool.Pool, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version of %s: %w", entry.Name(), err)
+		}
+
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.up = string(sql)
+		} else {
+			m.down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrate: migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return &Migrator{db: db, logger: logger, migrations: migrations}, nil
+}
+
+// Up applies every migration newer than the version of the database, and returns how
+// many it applied. A database newer than the migrations is left as it is, for an older
+// build starting during a rolling deploy.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn, version uint64) error {
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration.up, &migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: applying %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			m.logger.Info("migration applied", "version", migration.Version, "name", migration.Name)
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps migrations applied, or as many as there are, and returns
+// how many it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn, version uint64) error {
+		i := slices.IndexFunc(m.migrations, func(migration Migration) bool {
+			return migration.Version == version
+		})
+		if version != 0 && i < 0 {
+			return fmt.Errorf("migrate: the database is at version %d, which this build doesn't know of", version)
+		}
+
+		for ; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+
+			// Reverting the first migration leaves the table empty, as golang-migrate
+			// does.
+			var previous *uint64
+			if i > 0 {
+				previous = &m.migrations[i-1].Version
+			}
+
+			err := m.apply(ctx, conn, migration.down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: reverting %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			m.logger.Info("migration reverted", "version", migration.Version, "name", migration.Name)
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns the version of the database, and every migration along with whether
+// it's applied.
+func (m *Migrator) Status(ctx context.Context) (uint64, []Status, error) {
+	version, dirty, err := m.version(ctx, m.db)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration, Applied: migration.Version <= version}
+	}
+
+	if dirty {
+		return version, statuses, ErrDirty
+	}
+
+	return version, statuses, nil
+}
+
+// locked calls fn with a connection holding the migration lock, and the version of the
+// database.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn, version uint64) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// The lock is held by the session, which is why it takes a connection of its own.
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey)
+		if err != nil {
+			m.logger.Error("failed to release the migration lock", "error", err.Error())
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.version(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+
+	return fn(conn, version)
+}
+
+// version returns the version of the database, 0 when no migration is applied, and
+// whether it's dirty.
+func (m *Migrator) version(ctx context.Context, db interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}) (uint64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		// The table doesn't exist until the first migration.
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	return uint64(version), dirty, nil
+}
+
+// apply runs the SQL of a migration and moves the database to version, or to no version
+// at all when it's nil, in a single transaction. A failing migration is rolled back as a
+// whole, so it never leaves the database dirty.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, sql string, version *uint64) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		// Without arguments, the statements are sent with the simple protocol, which
+		// takes several of them at once.
+		_, err := tx.Exec(ctx, sql)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `TRUNCATE schema_migrations`)
+		if err != nil {
+			return err
+		}
+
+		if version == nil {
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, int64(*version))
+		return err
+	})
+}
@@ -0,0 +1,197 @@
+// Package notify delivers notifications to the users connected to the API, such as a
+// reminder to activate their account or the new episodes of an anime they watch. The
+// Hub keeps track of the connections of every user, and the transport, a WebSocket for
+// the API, reads the notifications of a connection from its Client.
+//
+// Notifications are only delivered to the users connected when they're sent: they
+// aren't stored, and a client too slow to keep up is disconnected rather than holding
+// the others back.
+package notify
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Register once the hub is shut down.
+	ErrClosed = errors.New("notify: hub is shut down")
+	// ErrFull is returned by Register when the hub, or the user, has as many clients as
+	// it takes.
+	ErrFull = errors.New("notify: too many clients")
+)
+
+// The types of notifications.
+const (
+	TypeActivationReminder = "activation_reminder"
+	TypeNewEpisodes        = "new_episodes"
+)
+
+// Notification is a message for a user. Data depends on its Type.
+type Notification struct {
+	Type      string    `json:"type"`
+	Data      any       `json:"data,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// New returns a notification of the given type, created now.
+func New(typ string, data any) Notification {
+	return Notification{Type: typ, Data: data, CreatedAt: time.Now().UTC()}
+}
+
+// Client is a connection of a user, which the transport sends the notifications of to
+// the user until it's done.
+type Client struct {
+	UserID int64
+
+	send      chan Notification
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Notifications returns the notifications to send to the user.
+func (c *Client) Notifications() <-chan Notification {
+	return c.send
+}
+
+// Done is closed when the client is unregistered, dropped for being too slow, or the
+// hub shuts down, after which the transport should close the connection.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Hub holds the clients connected, by user.
+type Hub struct {
+	// buffer is the number of notifications a client may fall behind by before it is
+	// dropped, maxClients the number of clients connected at once, and maxPerUser the
+	// number of them a single user may hold, so that one user can't take up the whole
+	// hub. Both limits are unlimited when zero.
+	buffer     int
+	maxClients int
+	maxPerUser int
+
+	mu      sync.Mutex
+	clients map[int64]map[*Client]struct{}
+	count   int
+	closed  bool
+}
+
+// NewHub returns a hub taking up to maxClients clients, and up to maxPerUser of them
+// for each user, which may fall behind by buffer notifications.
+func NewHub(buffer, maxClients, maxPerUser int) *Hub {
+	return &Hub{
+		buffer:     buffer,
+		maxClients: maxClients,
+		maxPerUser: maxPerUser,
+		clients:    make(map[int64]map[*Client]struct{}),
+	}
+}
+
+// Register adds a connection of a user. The transport must call Unregister once the
+// connection is over.
+func (h *Hub) Register(userID int64) (*Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if h.maxClients > 0 && h.count >= h.maxClients {
+		return nil, ErrFull
+	}
+	if h.maxPerUser > 0 && len(h.clients[userID]) >= h.maxPerUser {
+		return nil, ErrFull
+	}
+
+	c := &Client{
+		UserID: userID,
+		send:   make(chan Notification, h.buffer),
+		done:   make(chan struct{}),
+	}
+
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	h.count++
+
+	return c, nil
+}
+
+// Unregister removes a client, closing its Done channel.
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(c)
+}
+
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c.UserID][c]; ok {
+		delete(h.clients[c.UserID], c)
+		if len(h.clients[c.UserID]) == 0 {
+			delete(h.clients, c.UserID)
+		}
+		h.count--
+	}
+
+	c.close()
+}
+
+// Notify sends a notification to every connection of a user, and returns how many
+// there were. A client whose buffer is full is dropped.
+func (h *Hub) Notify(userID int64, n Notification) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sent := 0
+	for c := range h.clients[userID] {
+		select {
+		case c.send <- n:
+			sent++
+		default:
+			h.remove(c)
+		}
+	}
+
+	return sent
+}
+
+// UserIDs returns the IDs of the users connected.
+func (h *Hub) UserIDs() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ids := make([]int64, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Len returns the number of clients connected.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.count
+}
+
+// Shutdown drops every client, and refuses the new ones.
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, clients := range h.clients {
+		for c := range clients {
+			h.remove(c)
+		}
+	}
+}
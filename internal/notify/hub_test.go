@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterPerUserLimit(t *testing.T) {
+	h := NewHub(1, 0, 2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.Register(1); err != nil {
+			t.Fatalf("registering the client %d of the user: %v", i+1, err)
+		}
+	}
+
+	if _, err := h.Register(1); !errors.Is(err, ErrFull) {
+		t.Fatalf("got error %v for a client past the limit of the user, want %v", err, ErrFull)
+	}
+
+	// The limit is per user: the others can still connect.
+	if _, err := h.Register(2); err != nil {
+		t.Fatalf("registering a client of another user: %v", err)
+	}
+
+	// And a user is let back in once one of their clients goes away.
+	for c := range h.clients[1] {
+		h.Unregister(c)
+		break
+	}
+	if _, err := h.Register(1); err != nil {
+		t.Fatalf("registering a client after one was unregistered: %v", err)
+	}
+}
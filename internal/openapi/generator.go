@@ -0,0 +1,242 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// Generator builds a Document. It isn't safe for concurrent use; documents are meant to
+// be built once, then served as they are.
+type Generator struct {
+	doc *Document
+	// names holds the name of the component of each type added to the components.
+	names map[reflect.Type]string
+}
+
+func New(info Info) *Generator {
+	return &Generator{
+		doc: &Document{
+			OpenAPI: Version,
+			Info:    info,
+			Paths:   make(map[string]PathItem),
+			Components: Components{
+				Schemas:         make(map[string]*Schema),
+				SecuritySchemes: make(map[string]*SecurityScheme),
+			},
+		},
+		names: make(map[reflect.Type]string),
+	}
+}
+
+// Document returns the document built so far.
+func (g *Generator) Document() *Document {
+	return g.doc
+}
+
+// AddTag adds a tag, used to group the operations, with its description.
+func (g *Generator) AddTag(name, description string) {
+	g.doc.Tags = append(g.doc.Tags, Tag{Name: name, Description: description})
+}
+
+// AddSecurityScheme adds a way of authenticating, for the operations to refer to by name.
+func (g *Generator) AddSecurityScheme(name string, scheme *SecurityScheme) {
+	g.doc.Components.SecuritySchemes[name] = scheme
+}
+
+// AddOperation adds an operation to a path, such as /v1/anime/{id}.
+func (g *Generator) AddOperation(method, path string, op *Operation) {
+	item, ok := g.doc.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		g.doc.Paths[path] = item
+	}
+
+	item[strings.ToLower(method)] = op
+}
+
+// Define adds a schema to the components under name, and returns a reference to it.
+// Unless v is nil, the schema becomes the one of the type of v, rather than deriving it
+// from the type. It is needed for the types encoded by their own MarshalJSON method, and
+// for enums, whose values Go doesn't know of.
+func (g *Generator) Define(name string, v any, schema *Schema) *Schema {
+	if v != nil {
+		g.names[reflect.TypeOf(v)] = name
+	}
+	g.doc.Components.Schemas[name] = schema
+
+	return ref(name)
+}
+
+// Schema returns the schema of the JSON encoding of v. Named structs are added to the
+// components, and referenced. Maps of strings to interfaces, such as the envelopes of
+// the responses, are described by the values they hold, so v should be a sample of what
+// is encoded rather than a zero value.
+func (g *Generator) Schema(v any) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+
+	rv := reflect.ValueOf(v)
+	if t := rv.Type(); t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Interface {
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for _, key := range rv.MapKeys() {
+			schema.Properties[key.String()] = g.Schema(rv.MapIndex(key).Interface())
+			schema.Required = append(schema.Required, key.String())
+		}
+		slices.Sort(schema.Required)
+
+		return schema
+	}
+
+	return g.schemaOf(rv.Type())
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	if name, ok := g.names[t]; ok {
+		return ref(name)
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		// Raw JSON can be any value, rather than the base64 of a byte slice.
+		return &Schema{}
+	case t.Kind() == reflect.Pointer:
+		schema := g.schemaOf(t.Elem())
+		// A reference can't have siblings in OpenAPI 3.0, so the nullable is dropped.
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case t.Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// Byte slices are encoded in base64.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		// Interfaces can hold anything.
+		return &Schema{}
+	}
+}
+
+// structSchema returns the schema of a struct. Anonymous structs are described inline,
+// named ones are added to the components first.
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return g.objectSchema(t)
+	}
+
+	name := g.componentName(t)
+	// The name is recorded before the fields are walked, so that recursive types refer
+	// to themselves rather than recursing forever.
+	g.names[t] = name
+	g.doc.Components.Schemas[name] = g.objectSchema(t)
+
+	return ref(name)
+}
+
+func (g *Generator) objectSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(schema, t)
+
+	return schema
+}
+
+// addFields adds the fields of a struct to schema the way encoding/json encodes them:
+// under the name of their json tag, with the fields of embedded structs promoted. The
+// fields left out when empty, and the pointers, are optional.
+func (g *Generator) addFields(schema *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(schema, ft)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = g.schemaOf(field.Type)
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// componentName returns the name of the component of a named type: its name, starting
+// with a capital, or prefixed with its package if another type already has that name.
+func (g *Generator) componentName(t reflect.Type) string {
+	name := capitalize(t.Name())
+	if _, taken := g.doc.Components.Schemas[name]; taken {
+		name = capitalize(path.Base(t.PkgPath())) + name
+	}
+
+	return name
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+
+	return string(r)
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
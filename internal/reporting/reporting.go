@@ -0,0 +1,94 @@
+// Package reporting sends the unexpected errors of the API, along with the request they
+// happened in, to an error tracker. The tracker is behind the Reporter interface, so
+// that it can be swapped, or turned off with Nop.
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Event is an error to report, with what is known of the request it happened in.
+type Event struct {
+	Err error
+	// Request is the request being handled, if any.
+	Request *http.Request
+	// RequestID is the ID the request was given, if any.
+	RequestID string
+	// UserID is the ID of the user making the request, or 0 for anonymous users and
+	// errors happening outside of a request.
+	UserID int64
+}
+
+// Reporter sends errors to an error tracker.
+type Reporter interface {
+	// Report sends an error. It mustn't block for long, as it is called while handling
+	// requests.
+	Report(ctx context.Context, e Event)
+	// Flush waits for the errors not sent yet, for at most timeout.
+	Flush(timeout time.Duration) bool
+}
+
+// Nop is a Reporter dropping every error.
+type Nop struct{}
+
+func (Nop) Report(context.Context, Event) {}
+
+func (Nop) Flush(time.Duration) bool { return true }
+
+// PanicError is the error of a recovered panic, along with the stack trace of the
+// goroutine that panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// NewPanicError returns the error of a recovered panic. It must be called from the
+// deferred function which recovered the panic, so that the stack trace is the one of
+// the panic.
+func NewPanicError(value any) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v", e.Value)
+}
+
+// Unwrap returns the value of the panic if it was an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Scope holds what is learned about a request while it's handled, such as the user
+// making it, for the errors reported further up the middleware chain.
+type Scope struct {
+	userID atomic.Int64
+}
+
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx carrying a new, empty scope.
+func WithScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &Scope{})
+}
+
+// SetUser records the user making the request in the scope of ctx, if it has one.
+func SetUser(ctx context.Context, id int64) {
+	if scope, ok := ctx.Value(scopeKey{}).(*Scope); ok {
+		scope.userID.Store(id)
+	}
+}
+
+// UserID returns the user recorded in the scope of ctx, or 0.
+func UserID(ctx context.Context) int64 {
+	if scope, ok := ctx.Value(scopeKey{}).(*Scope); ok {
+		return scope.userID.Load()
+	}
+
+	return 0
+}
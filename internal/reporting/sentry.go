@@ -0,0 +1,61 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"github.com/getsentry/sentry-go"
+	"strconv"
+	"time"
+)
+
+// Sentry is a Reporter sending the errors to Sentry. Events are sent in the background,
+// so Report doesn't wait for Sentry to answer.
+type Sentry struct {
+	hub *sentry.Hub
+}
+
+// NewSentry returns a Reporter sending the errors to the Sentry project of dsn, tagged
+// with the environment and the release they happened in.
+func NewSentry(dsn, environment, release string) (*Sentry, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      environment,
+		Release:          release,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sentry{hub: sentry.NewHub(client, sentry.NewScope())}, nil
+}
+
+func (s *Sentry) Report(_ context.Context, e Event) {
+	hub := s.hub.Clone()
+
+	hub.WithScope(func(scope *sentry.Scope) {
+		if e.Request != nil {
+			// The request is copied without its body. Sentry leaves out the headers
+			// holding credentials, such as Authorization.
+			scope.SetRequest(e.Request)
+		}
+		if e.RequestID != "" {
+			scope.SetTag("request_id", e.RequestID)
+		}
+		if e.UserID != 0 {
+			scope.SetUser(sentry.User{ID: strconv.FormatInt(e.UserID, 10)})
+		}
+
+		var panicErr *PanicError
+		if errors.As(e.Err, &panicErr) {
+			scope.SetLevel(sentry.LevelFatal)
+			scope.SetContext("panic", sentry.Context{"stack": string(panicErr.Stack)})
+		}
+
+		hub.CaptureException(e.Err)
+	})
+}
+
+func (s *Sentry) Flush(timeout time.Duration) bool {
+	return s.hub.Flush(timeout)
+}
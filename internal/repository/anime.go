@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ziliscite/purplelight/internal/data"
 	"strings"
 	"time"
@@ -12,121 +11,285 @@ import (
 
 // AnimeRepository Define a AnimeRepository struct type which wraps a sql.DB connection pool.
 type AnimeRepository struct {
-	db     *pgxpool.Pool
-	logger *dbLogger
+	db DBTX
+	// read is where the read-only methods query, such as a read replica. They use db
+	// when it's nil.
+	read     DBTX
+	logger   *dbLogger
+	timeouts Timeouts
 }
 
-func NewAnimeRepository(db *pgxpool.Pool, logger *dbLogger) AnimeRepository {
+func NewAnimeRepository(db DBTX, logger *dbLogger, timeouts Timeouts) AnimeRepository {
 	return AnimeRepository{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
 	}
 }
 
+// reader returns where the read-only methods query.
+func (a AnimeRepository) reader() DBTX {
+	if a.read != nil {
+		return a.read
+	}
+
+	return a.db
+}
+
 // InsertAnime Add a placeholder method for inserting a new record in the movies table.
-func (a AnimeRepository) InsertAnime(anime *data.Anime) error {
+func (a AnimeRepository) InsertAnime(ctx context.Context, anime *data.Anime) error {
 	opts := pgx.TxOptions{
 		IsoLevel:   pgx.ReadCommitted, // Set isolation level
 		AccessMode: pgx.ReadWrite,     // Specify read-write mode
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
 	defer cancel()
 
-	tx, err := a.db.BeginTx(ctx, opts)
+	tx, err := beginTx(ctx, a.db, opts)
 	if err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	defer func() {
 		if err != nil {
 			// Rollback if an error occurs during the transaction
 			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				a.logger.Error(ErrTransaction.Error(), "error", rbErr)
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
 			}
 		}
 	}()
 
-	// Insert anime through the main transaction
-	animeStmt, err := tx.Prepare(ctx, "insert anime", `
-		INSERT INTO anime (title, type, episodes, status, season, year, duration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, created_at, version
-	`)
+	// Insert anime through the main transaction. The statement isn't prepared by name,
+	// which wouldn't survive a transaction pooler such as PgBouncer: pgx prepares and
+	// caches it on its own, as the query exec mode of the pool allows.
+	query := `
+		INSERT INTO anime (title, slug, type, episodes, status, season, year, duration, synopsis, alt_titles, mal_id, anilist_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::text[], '{}'), $11, $12)
+		RETURNING id, created_at, updated_at, version
+	`
+
+	// Pick a slug no other anime has
+	anime.Slug, err = a.slugFor(ctx, anime, tx)
 	if err != nil {
-		a.logger.Error(ErrQueryPrepare.Error(), "error", err)
-		return ErrQueryPrepare
+		return a.logger.handleError(ctx, err)
 	}
 
-	args := []interface{}{anime.Title, anime.Type, anime.Episodes, anime.Status, anime.Season, anime.Year, anime.Duration}
+	args := []interface{}{anime.Title, anime.Slug, anime.Type, anime.Episodes, anime.Status, anime.Season, anime.Year, anime.Duration, anime.Synopsis, anime.AltTitles, anime.MalID, anime.AniListID}
 
-	err = tx.QueryRow(ctx, animeStmt.SQL, args...).
-		Scan(&anime.ID, &anime.CreatedAt, &anime.Version) // value passed through a pointer
+	err = tx.QueryRow(ctx, query, args...).
+		Scan(&anime.ID, &anime.CreatedAt, &anime.UpdatedAt, &anime.Version) // value passed through a pointer
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Get or insert new tags
 	tags, err := a.upsertTags(ctx, anime.Tags, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Insert new anime tags
 	err = a.insertAnimeTags(ctx, anime.ID, tags, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
+	}
+
+	// Get or insert the studios, and link them
+	studios, err := a.upsertStudios(ctx, anime.Studios, tx)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	err = a.replaceAnimeStudios(ctx, anime.ID, studios, tx)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	// Record the first revision of the anime
+	err = a.insertRevision(ctx, anime, tx)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	return nil
 }
 
 // GetAnime Add a placeholder method for fetching a specific record from the movies table.
-func (a AnimeRepository) GetAnime(id int32) (*data.Anime, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (a AnimeRepository) GetAnime(ctx context.Context, id int32) (*data.Anime, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
 	defer cancel()
 
 	query := `		
 		SELECT
-			a.id, a.title, a.type, a.episodes,
+			a.id, a.title, a.slug, a.type, a.episodes,
 			a.status, a.season, a.year, a.duration,
+			a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id,
+			ARRAY(
+				SELECT s.name FROM anime_studios ast JOIN studio s ON ast.studio_id = s.id
+				WHERE ast.anime_id = a.id ORDER BY s.name
+			) AS studios,
 			ARRAY_AGG(t.name ORDER BY t.name) AS tags,
-			a.created_at, a.version
+			a.created_at, a.updated_at, a.version
 		FROM anime a
 		JOIN anime_tags at ON a.id = at.anime_id
 		JOIN tag t ON at.tag_id = t.id
-		WHERE a.id = $1
-		GROUP BY a.id, a.title, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.created_at, a.version;
+		WHERE a.id = $1 AND a.deleted_at IS NULL
+		GROUP BY a.id, a.title, a.slug, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id, a.created_at, a.updated_at, a.version;
 	`
 
 	var anime data.Anime
-	err := a.db.QueryRow(ctx, query, id).
-		Scan(&anime.ID, &anime.Title, &anime.Type, &anime.Episodes, &anime.Status, &anime.Season, &anime.Year, &anime.Duration, &anime.Tags, &anime.CreatedAt, &anime.Version)
+	err := a.reader().QueryRow(ctx, query, id).
+		Scan(&anime.ID, &anime.Title, &anime.Slug, &anime.Type, &anime.Episodes, &anime.Status, &anime.Season, &anime.Year, &anime.Duration, &anime.Synopsis, &anime.AltTitles, &anime.CoverURL, &anime.MalID, &anime.AniListID, &anime.Studios, &anime.Tags, &anime.CreatedAt, &anime.UpdatedAt, &anime.Version)
 	if err != nil {
-		return nil, a.logger.handleError(err)
+		return nil, a.logger.handleError(ctx, err)
 	}
 
 	return &anime, nil
 }
 
-func (a AnimeRepository) GetAll(title string, status string, season string, animeType string, tags []string, filters data.Filters) ([]*data.Anime, data.Metadata, error) {
+// GetAnimeByIDs fetches the anime with the IDs in one query, for the loaders batching
+// the anime reads of a GraphQL request. The anime are returned in no particular order,
+// and the IDs without an anime are left out.
+func (a AnimeRepository) GetAnimeByIDs(ctx context.Context, ids []int32) ([]*data.Anime, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+		SELECT
+			a.id, a.title, a.slug, a.type, a.episodes,
+			a.status, a.season, a.year, a.duration,
+			a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id,
+			ARRAY(
+				SELECT s.name FROM anime_studios ast JOIN studio s ON ast.studio_id = s.id
+				WHERE ast.anime_id = a.id ORDER BY s.name
+			) AS studios,
+			ARRAY_AGG(t.name ORDER BY t.name) AS tags,
+			a.created_at, a.updated_at, a.version
+		FROM anime a
+		JOIN anime_tags at ON a.id = at.anime_id
+		JOIN tag t ON at.tag_id = t.id
+		WHERE a.id = ANY($1) AND a.deleted_at IS NULL
+		GROUP BY a.id, a.title, a.slug, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id, a.created_at, a.updated_at, a.version;
+	`
+
+	rows, err := a.reader().Query(ctx, query, ids)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	var anime []*data.Anime
+	for rows.Next() {
+		var an data.Anime
+		err = rows.Scan(&an.ID, &an.Title, &an.Slug, &an.Type, &an.Episodes, &an.Status, &an.Season, &an.Year, &an.Duration, &an.Synopsis, &an.AltTitles, &an.CoverURL, &an.MalID, &an.AniListID, &an.Studios, &an.Tags, &an.CreatedAt, &an.UpdatedAt, &an.Version)
+		if err != nil {
+			return nil, a.logger.handleError(ctx, err)
+		}
+
+		anime = append(anime, &an)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return anime, nil
+}
+
+// GetAnimeByExternalID fetches the anime linked to the ID on an external source, such
+// as MyAnimeList (data.SourceMAL).
+func (a AnimeRepository) GetAnimeByExternalID(ctx context.Context, source string, externalID int32) (*data.Anime, error) {
+	// The column name can't be a placeholder, so it comes from this switch rather than
+	// from the source itself.
+	var column string
+	switch source {
+	case data.SourceMAL:
+		column = "mal_id"
+	case data.SourceAniList:
+		column = "anilist_id"
+	default:
+		return nil, ErrRecordNotFound
+	}
+
+	if externalID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	var id int32
+	err := a.db.QueryRow(queryCtx, fmt.Sprintf(`
+		SELECT id FROM anime WHERE %s = $1 AND deleted_at IS NULL
+	`, column), externalID).Scan(&id)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return a.GetAnime(ctx, id)
+}
+
+// animeSearchVector is the document searched by the q parameter: the search column of
+// the anime, to which the names of its tags are added with the weight C.
+const animeSearchVector = `(a.search || setweight(to_tsvector('simple', coalesce((
+	SELECT string_agg(st.name, ' ') FROM anime_tags sat JOIN tag st ON sat.tag_id = st.id
+	WHERE sat.anime_id = a.id
+), '')), 'C'))`
+
+func (a AnimeRepository) GetAll(ctx context.Context, search data.AnimeSearch, filters data.Filters) ([]*data.Anime, data.Metadata, error) {
 	baseQuery := `
 		SELECT count(*) OVER(),
-			a.id, a.title, a.type, a.episodes,
+			a.id, a.title, a.slug, a.type, a.episodes,
 			a.status, a.season, a.year, a.duration,
+			a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id,
+			ARRAY(
+				SELECT s.name FROM anime_studios ast JOIN studio s ON ast.studio_id = s.id
+				WHERE ast.anime_id = a.id ORDER BY s.name
+			) AS studios,
 			ARRAY_AGG(t.name ORDER BY t.name) AS tags,
-			a.created_at, a.version
+			a.created_at, a.updated_at, a.version, %s AS rank,
+			a.deleted_at IS NOT NULL AS deleted
 		FROM anime a
 		JOIN anime_tags at ON a.id = at.anime_id
 		JOIN tag t ON at.tag_id = t.id
 	`
 
 	var args []interface{}
-	var conditions []string
+
+	// Rank how well each title matches the search, with the measure matching the way
+	// titles are compared: ts_rank for full-text search, and the trigram similarity
+	// for fuzzy search. Without a title there is nothing to rank.
+	rank := "NULL::real"
+	if search.Title != "" {
+		args = append(args, search.Title)
+		if search.Match == data.MatchFuzzy {
+			rank = "word_similarity($1, a.title)"
+		} else {
+			rank = "ts_rank(to_tsvector('simple', a.title), plainto_tsquery('simple', $1))"
+		}
+	}
+
+	// A q search ranks the anime on every field it searches, with the weights of the
+	// search column, rather than on the title alone.
+	queryArg := 0
+	if search.Query != "" {
+		args = append(args, search.Query)
+		queryArg = len(args)
+		rank = fmt.Sprintf("ts_rank(%s, websearch_to_tsquery('simple', $%d))", animeSearchVector, queryArg)
+	}
+	baseQuery = fmt.Sprintf(baseQuery, rank)
+	conditions := []string{"a.deleted_at IS NULL"}
+
+	// A delta sync lists what changed since the last one, deletions included: deleting
+	// an anime bumps its updated_at, so it comes back as a tombstone.
+	if search.UpdatedSince != nil {
+		conditions = []string{fmt.Sprintf("a.updated_at > $%d", len(args)+1)}
+		args = append(args, *search.UpdatedSince)
+	}
 
 	var metadata data.Metadata
 
@@ -135,92 +298,169 @@ func (a AnimeRepository) GetAll(title string, status string, season string, anim
 		AccessMode: pgx.ReadOnly,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
 	defer cancel()
 
-	tx, err := a.db.BeginTx(ctx, opts)
+	tx, err := beginTx(ctx, a.reader(), opts)
 	if err != nil {
 		// return an empty Metadata struct.
-		return nil, metadata, a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return nil, metadata, a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	defer func() {
 		if err != nil {
 			// Rollback if an error occurs during the transaction
 			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				a.logger.Error(ErrTransaction.Error(), "error", rbErr)
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
 			}
 		}
 	}()
 
-	if title != "" {
+	if search.Title != "" {
 		// Add wildcards in Go, use $n placeholder
 		//conditions = append(conditions, fmt.Sprintf("a.title ILIKE $%d", len(args)+1))
 		//args = append(args, "%"+title+"%") // Wildcard added here
 
-		conditions = append(conditions, fmt.Sprintf(`to_tsvector('simple', a.title) @@ plainto_tsquery('simple', $%d)`, len(args)+1))
-		args = append(args, title)
+		// The title is already bound to $1 for the rank.
+		if search.Match == data.MatchFuzzy {
+			// <% is true when the search is similar enough to some part of the title
+			// (pg_trgm.word_similarity_threshold, 0.6 by default), and is served by
+			// the trigram index on title.
+			conditions = append(conditions, `$1 <% a.title`)
+		} else {
+			conditions = append(conditions, `to_tsvector('simple', a.title) @@ plainto_tsquery('simple', $1)`)
+		}
 	}
 
-	if status != "" {
+	if search.Query != "" {
+		// The query is already bound for the rank.
+		conditions = append(conditions, fmt.Sprintf("%s @@ websearch_to_tsquery('simple', $%d)", animeSearchVector, queryArg))
+	}
+
+	if search.Status != "" {
 		conditions = append(conditions, fmt.Sprintf("a.status = $%d", len(args)+1))
-		args = append(args, status)
+		args = append(args, search.Status)
 	}
 
-	if season != "" {
+	if search.Season != "" {
 		conditions = append(conditions, fmt.Sprintf("a.season = $%d", len(args)+1))
-		args = append(args, season)
+		args = append(args, search.Season)
 	}
 
-	if animeType != "" {
+	if search.AnimeType != "" {
 		conditions = append(conditions, fmt.Sprintf("a.type = $%d", len(args)+1))
-		args = append(args, animeType)
+		args = append(args, search.AnimeType)
 	}
 
-	// Combine query parts
-	query := baseQuery
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if search.YearMin != nil {
+		conditions = append(conditions, fmt.Sprintf("a.year >= $%d", len(args)+1))
+		args = append(args, *search.YearMin)
+	}
+
+	if search.YearMax != nil {
+		conditions = append(conditions, fmt.Sprintf("a.year <= $%d", len(args)+1))
+		args = append(args, *search.YearMax)
+	}
+
+	if search.Synopsis != "" {
+		conditions = append(conditions, fmt.Sprintf(`to_tsvector('simple', a.synopsis) @@ plainto_tsquery('simple', $%d)`, len(args)+1))
+		args = append(args, search.Synopsis)
 	}
 
-	if len(tags) > 0 {
-		placeholders := make([]string, len(tags))
-		for i := range tags {
+	if search.Studio != "" {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM anime_studios ast JOIN studio s ON ast.studio_id = s.id
+			WHERE ast.anime_id = a.id AND s.name = $%d
+		)`, len(args)+1))
+		args = append(args, search.Studio)
+	}
+
+	if search.EpisodesMin != nil {
+		conditions = append(conditions, fmt.Sprintf("a.episodes >= $%d", len(args)+1))
+		args = append(args, *search.EpisodesMin)
+	}
+
+	if search.EpisodesMax != nil {
+		conditions = append(conditions, fmt.Sprintf("a.episodes <= $%d", len(args)+1))
+		args = append(args, *search.EpisodesMax)
+	}
+
+	if len(search.Tags) > 0 {
+		placeholders := make([]string, len(search.Tags))
+		for i := range search.Tags {
 			placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
 		}
 
-		query = fmt.Sprintf(`
+		// Having any of the tags is enough to be in the group, so only matching all of
+		// them needs the HAVING clause counting them.
+		having := fmt.Sprintf("HAVING COUNT(DISTINCT t.name) = %d", len(search.Tags))
+		if search.TagsMatch == data.TagsMatchAny {
+			having = ""
+		}
+
+		baseQuery = fmt.Sprintf(`
 			WITH valid_anime AS (
 			SELECT at.anime_id
 			FROM anime_tags at
 			JOIN tag t ON at.tag_id = t.id
 			WHERE t.name IN (%s)
 			GROUP BY at.anime_id
-			HAVING COUNT(DISTINCT t.name) = %d
-		)`, strings.Join(placeholders, ", "), len(tags)) + query
+			%s
+		)`, strings.Join(placeholders, ", "), having) + baseQuery
 
-		for _, t := range tags {
+		for _, t := range search.Tags {
 			args = append(args, strings.Title(t))
 		}
 
-		// could just do normal concat, but this way is prettier
-		query += fmt.Sprintf(" AND a.id IN (SELECT v.anime_id FROM valid_anime v)")
+		conditions = append(conditions, "a.id IN (SELECT v.anime_id FROM valid_anime v)")
+	}
+
+	// In keyset mode, only the rows past the cursor are selected, and the order is
+	// flipped when paging backwards so that the LIMIT keeps the rows closest to it.
+	cursor := filters.Cursor
+	desc := filters.SortDirection() == "DESC"
+	if cursor != nil {
+		desc = desc != cursor.Backward
+
+		if !cursor.IsStart() {
+			condition, cursorArgs := keysetCondition("a."+filters.SortColumn(), "a.id", cursor, desc, len(args)+1)
+			conditions = append(conditions, condition)
+			args = append(args, cursorArgs...)
+		}
+	}
+
+	// Combine query parts
+	query := baseQuery
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	query += fmt.Sprintf(" GROUP BY a.id, a.title, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.created_at, a.version")
+	query += fmt.Sprintf(" GROUP BY a.id, a.title, a.slug, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id, a.created_at, a.updated_at, a.version, a.deleted_at")
 
 	// Add an ORDER BY clause and interpolate the sort column and direction. Importantly
 	// notice that we also include a secondary sort on the movie ID to ensure a consistent ordering.
-	query += fmt.Sprintf(" ORDER BY a.%s %s, a.id", filters.SortColumn(), filters.SortDirection())
+	if cursor != nil {
+		query += fmt.Sprintf(" ORDER BY a.%s %s, a.id %s", filters.SortColumn(), sqlDirection(desc), sqlDirection(cursor.Backward))
+
+		// Fetch one extra row to find out whether there is another page.
+		query += fmt.Sprintf(" LIMIT $%d;", len(args)+1)
+		args = append(args, filters.Limit()+1)
+	} else {
+		if filters.Sort == data.SortRelevance {
+			query += " ORDER BY rank DESC, a.id"
+		} else {
+			query += fmt.Sprintf(" ORDER BY a.%s %s, a.id", filters.SortColumn(), filters.SortDirection())
+		}
 
-	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
-	// parameter values.
-	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d;", len(args)+1, len(args)+2)
-	args = append(args, filters.Limit(), filters.Offset())
+		// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
+		// parameter values.
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d;", len(args)+1, len(args)+2)
+		args = append(args, filters.Limit(), filters.Offset())
+	}
 
 	rows, err := tx.Query(ctx, query, args...)
 	if err != nil {
-		return nil, metadata, a.logger.handleError(err)
+		return nil, metadata, a.logger.handleError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -230,22 +470,55 @@ func (a AnimeRepository) GetAll(title string, status string, season string, anim
 		var an data.Anime
 		if err = rows.Scan(
 			&records, // Scan the count from the window function into records.
-			&an.ID, &an.Title, &an.Type, &an.Episodes,
+			&an.ID, &an.Title, &an.Slug, &an.Type, &an.Episodes,
 			&an.Status, &an.Season, &an.Year, &an.Duration,
-			&an.Tags, &an.CreatedAt, &an.Version,
+			&an.Synopsis, &an.AltTitles, &an.CoverURL, &an.MalID, &an.AniListID, &an.Studios,
+			&an.Tags, &an.CreatedAt, &an.UpdatedAt, &an.Version, &an.Rank,
+			&an.Deleted,
 		); err != nil {
-			return nil, metadata, a.logger.handleError(err)
+			return nil, metadata, a.logger.handleError(ctx, err)
 		}
 
 		anime = append(anime, &an)
 	}
 
-	// Generate a Metadata struct, passing in the total record count and pagination
-	// parameters from the client.
-	metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+	if cursor != nil {
+		var hasMore bool
+		anime, hasMore = AnimePage(anime, filters)
+
+		var first, last *data.Cursor
+		if len(anime) > 0 {
+			first = AnimeCursor(anime[0], filters.Sort)
+			last = AnimeCursor(anime[len(anime)-1], filters.Sort)
+		}
+
+		metadata.CalculateCursors(filters.PageSize, cursor, first, last, hasMore)
+	} else {
+		// Generate a Metadata struct, passing in the total record count and pagination
+		// parameters from the client.
+		metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+	}
+
+	if search.UpdatedSince != nil {
+		// NOW() is the start of the transaction. The writes in flight then may still
+		// commit with an earlier updated_at, which the caller has to allow for.
+		var syncedAt time.Time
+		if err = tx.QueryRow(ctx, "SELECT NOW()").Scan(&syncedAt); err != nil {
+			return nil, metadata, a.logger.handleError(ctx, err)
+		}
+		metadata.SyncedAt = &syncedAt
+
+		// The cursors are taken from the full rows above; the tombstones keep no more
+		// than what clients need to drop their copy.
+		for i, an := range anime {
+			if an.Deleted {
+				anime[i] = &data.Anime{ID: an.ID, UpdatedAt: an.UpdatedAt, Version: an.Version, Deleted: true}
+			}
+		}
+	}
 
 	if err = tx.Commit(ctx); err != nil {
-		return nil, metadata, a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return nil, metadata, a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	// Include the metadata struct when returning.
@@ -253,39 +526,44 @@ func (a AnimeRepository) GetAll(title string, status string, season string, anim
 }
 
 // UpdateAnime Add a placeholder method for updating a specific record in the movies table.
-func (a AnimeRepository) UpdateAnime(anime *data.Anime) error {
+func (a AnimeRepository) UpdateAnime(ctx context.Context, anime *data.Anime) error {
 	opts := pgx.TxOptions{
 		IsoLevel:   pgx.ReadCommitted,
 		AccessMode: pgx.ReadWrite,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
 	defer cancel()
 
-	tx, err := a.db.BeginTx(ctx, opts)
+	tx, err := beginTx(ctx, a.db, opts)
 	if err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	defer func() {
 		if err != nil {
 			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				a.logger.Error(ErrTransaction.Error(), "error", rbErr)
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
 			}
 		}
 	}()
 
 	// Add the 'AND version = $6' clause to the SQL query
-	animeStmt, err := tx.Prepare(ctx, "update anime", `
+	query := `
 		UPDATE anime 
 		SET title = $1, type = $2, episodes = $3, 
 		    status = $4, season = $5, year = $6, 
-		    duration = $7, version = version + 1
-		WHERE id = $8 AND version = $9
-		RETURNING version
-	`)
+		    duration = $7, synopsis = $8, alt_titles = COALESCE($9::text[], '{}'),
+		    mal_id = $10, anilist_id = $11, slug = $14,
+		    updated_at = NOW(), version = version + 1
+		WHERE id = $12 AND version = $13 AND deleted_at IS NULL
+		RETURNING updated_at, version
+	`
+
+	// The slug follows the title, but only changes when it no longer fits it
+	slug, err := a.slugFor(ctx, anime, tx)
 	if err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrQueryPrepare, err.Error()))
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Update anime record
@@ -293,45 +571,133 @@ func (a AnimeRepository) UpdateAnime(anime *data.Anime) error {
 	// version has changed (or the record has been deleted) and we return our custom
 	// ErrEditConflict error.
 	err = tx.QueryRow(ctx,
-		animeStmt.SQL, anime.Title, anime.Type, anime.Episodes, anime.Status,
-		anime.Season, anime.Year, anime.Duration, anime.ID, anime.Version,
+		query, anime.Title, anime.Type, anime.Episodes, anime.Status,
+		anime.Season, anime.Year, anime.Duration, anime.Synopsis, anime.AltTitles,
+		anime.MalID, anime.AniListID, anime.ID, anime.Version, slug,
 	).
-		Scan(&anime.Version)
+		Scan(&anime.UpdatedAt, &anime.Version)
 	if err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrEditConflict, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrEditConflict, err.Error()))
 	}
+	anime.Slug = slug
 
-	// Delete current anime tags
-	err = a.deleteAnimeTags(ctx, anime.ID, tx)
+	// Get or insert new tags
+	tags, err := a.upsertTags(ctx, anime.Tags, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
-	// Get or insert new tags
-	tags, err := a.upsertTags(ctx, anime.Tags, tx)
+	// Rebuild the anime tag links
+	err = a.replaceAnimeTags(ctx, anime.ID, tags, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
-	// Insert new anime tags
-	err = a.insertAnimeTags(ctx, anime.ID, tags, tx)
+	// Same for the studios
+	studios, err := a.upsertStudios(ctx, anime.Studios, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
+	}
+
+	err = a.replaceAnimeStudios(ctx, anime.ID, studios, tx)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	// Snapshot the new version
+	err = a.insertRevision(ctx, anime, tx)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Commit transaction
 	if err = tx.Commit(ctx); err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return nil
+}
+
+// SetCover records the URL of the cover image of an anime, and bumps its version.
+func (a AnimeRepository) SetCover(ctx context.Context, id int32, url string) (int32, error) {
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	var version int32
+	err := a.db.QueryRow(ctx, `
+		UPDATE anime SET cover_url = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND deleted_at IS NULL
+		RETURNING version
+	`, url, id).Scan(&version)
+	if err != nil {
+		return 0, a.logger.handleError(ctx, err)
+	}
+
+	return version, nil
+}
+
+// DeleteAnime soft deletes an anime: the row is kept, but excluded from every read until
+// it is restored with RestoreAnime, or removed for good with PurgeAnime. Its updated_at
+// is bumped, so that delta syncs list its tombstone.
+func (a AnimeRepository) DeleteAnime(ctx context.Context, id int32) error {
+	// Return an ErrRecordNotFound error if the anime ID is less than 1.
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	res, err := a.db.Exec(ctx, `UPDATE anime SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RestoreAnime brings back a soft deleted anime. The version is bumped, so that clients
+// holding the version from before the deletion can't overwrite the restored record.
+func (a AnimeRepository) RestoreAnime(ctx context.Context, id int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+		UPDATE anime
+		SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+
+	res, err := a.db.Exec(ctx, query, id)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-// DeleteAnime Add a placeholder method for deleting a specific record from the movies table.
-func (a AnimeRepository) DeleteAnime(id int32) error {
+// PurgeAnime permanently deletes an anime and its tags, whether or not it has been soft
+// deleted.
+func (a AnimeRepository) PurgeAnime(ctx context.Context, id int32) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
-		a.logger.Error(ErrRecordNotFound.Error(), "error", "id must be greater than 0")
+		a.logger.Error(ctx, ErrRecordNotFound.Error(), "error", "id must be greater than 0")
 		return ErrRecordNotFound
 	}
 
@@ -340,18 +706,18 @@ func (a AnimeRepository) DeleteAnime(id int32) error {
 		AccessMode: pgx.ReadWrite,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
 	defer cancel()
 
-	tx, err := a.db.BeginTx(ctx, opts)
+	tx, err := beginTx(ctx, a.db, opts)
 	if err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	defer func() {
 		if err != nil {
 			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				a.logger.Error(ErrTransaction.Error(), "error", rbErr)
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
 			}
 		}
 	}()
@@ -360,7 +726,7 @@ func (a AnimeRepository) DeleteAnime(id int32) error {
 	// the value for the placeholder parameter. The Exec() method returns a sql.Result
 	res, err := tx.Exec(ctx, `DELETE FROM anime WHERE id = $1`, id)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Call the RowsAffected() method on the sql.Result object to get the number of rows
@@ -371,17 +737,17 @@ func (a AnimeRepository) DeleteAnime(id int32) error {
 	// with the provided ID at the moment we tried to delete it. In that case we
 	// return an ErrRecordNotFound error.
 	if rowsAffected == 0 {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrRecordNotFound, "no rows affected"))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrRecordNotFound, "no rows affected"))
 	}
 
 	err = a.deleteAnimeTags(ctx, id, tx)
 	if err != nil {
-		return a.logger.handleError(err)
+		return a.logger.handleError(ctx, err)
 	}
 
 	// Commit transaction
 	if err = tx.Commit(ctx); err != nil {
-		return a.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	return nil
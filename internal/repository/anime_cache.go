@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/cache"
+	"github.com/ziliscite/purplelight/internal/data"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// animeGenerationKey holds the generation of the cached anime. Every cached entry has
+// the generation it was read at in its key, so that a write only has to move to a new
+// generation to invalidate them all: an anime, but also the listings it appears in,
+// which can't be told apart, or every anime of a renamed tag.
+const animeGenerationKey = "anime:generation"
+
+// AnimeCache keeps GetAnime and GetAll in a cache, in front of the anime repository.
+// The entries expire after their TTL, and are invalidated as soon as an anime, a tag or
+// a studio is written.
+type AnimeCache struct {
+	cache   cache.Cache
+	ttl     time.Duration
+	listTTL time.Duration
+	logger  *dbLogger
+
+	hits, misses, errors atomic.Int64
+}
+
+// AnimeCacheStats are the metrics of an AnimeCache.
+type AnimeCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// NewAnimeCache returns an AnimeCache keeping the anime for ttl, and the listings for
+// listTTL. Use it with Repositories.CacheAnime.
+func NewAnimeCache(c cache.Cache, ttl, listTTL time.Duration) *AnimeCache {
+	return &AnimeCache{cache: c, ttl: ttl, listTTL: listTTL}
+}
+
+// Stats returns the number of cache hits, misses and errors so far.
+func (c *AnimeCache) Stats() AnimeCacheStats {
+	return AnimeCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+}
+
+// CacheAnime puts the cache in front of the anime reads. The repositories bound to a
+// transaction by WithTx read from the transaction, and invalidate the cache as they
+// write, and once more when the transaction is committed: see Committed.
+func (r *Repositories) CacheAnime(c *AnimeCache) {
+	c.logger = r.logger
+	r.animeCache = c
+	r.Anime = cachedAnime{AnimeStore: r.Anime, cache: c}
+}
+
+// Committed is called once the transaction the repositories are bound to is committed.
+// It invalidates the cached anime again if the transaction wrote any, as a read made
+// between the first invalidation and the commit may have cached what was there before,
+// and likewise the cached users the transaction invalidated.
+func (r Repositories) Committed(ctx context.Context) {
+	if r.animeCache != nil && r.animeWritten != nil && r.animeWritten.Load() {
+		r.animeCache.invalidate(ctx)
+	}
+	if r.userCache != nil && r.usersWritten != nil {
+		r.usersWritten.invalidateAgain(ctx, r.userCache)
+	}
+}
+
+// cachedAnime is the AnimeStore reading through an AnimeCache. Bound to a transaction,
+// with written set, it doesn't read through the cache, which can't see what the
+// transaction wrote.
+//
+// The misses are read from the primary, even with a read replica: a lagging replica
+// read right after an invalidation would cache what was there before the write, at the
+// new generation, for the whole TTL.
+type cachedAnime struct {
+	AnimeStore
+	cache   *AnimeCache
+	written *atomic.Bool
+}
+
+func (a cachedAnime) GetAnime(ctx context.Context, id int32) (*data.Anime, error) {
+	// The reads under ReadPrimary, such as the one a write starts from, want the latest
+	// commit, which even the cache may not have yet.
+	if a.written != nil || readsPrimary(ctx) {
+		return a.AnimeStore.GetAnime(ctx, id)
+	}
+
+	anime := new(data.Anime)
+	key := "anime:" + strconv.Itoa(int(id))
+	generation, ok := a.cache.get(ctx, key, anime)
+	if ok {
+		return anime, nil
+	}
+
+	anime, err := a.AnimeStore.GetAnime(ReadPrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cache.set(ctx, generation, key, anime, a.cache.ttl)
+	return anime, nil
+}
+
+// cachedListing is what GetAll caches.
+type cachedListing struct {
+	Anime    []*data.Anime
+	Metadata data.Metadata
+}
+
+func (a cachedAnime) GetAll(ctx context.Context, search data.AnimeSearch, filters data.Filters) ([]*data.Anime, data.Metadata, error) {
+	// The delta syncs are each taken at their own time, so they are never asked for
+	// twice.
+	if a.written != nil || search.UpdatedSince != nil {
+		return a.AnimeStore.GetAll(ctx, search, filters)
+	}
+
+	criteria, err := json.Marshal(struct {
+		Search  data.AnimeSearch
+		Filters data.Filters
+	}{search, filters})
+	if err != nil {
+		return a.AnimeStore.GetAll(ctx, search, filters)
+	}
+	sum := sha256.Sum256(criteria)
+
+	var listing cachedListing
+	key := "anime:list:" + hex.EncodeToString(sum[:])
+	generation, ok := a.cache.get(ctx, key, &listing)
+	if ok {
+		return listing.Anime, listing.Metadata, nil
+	}
+
+	anime, metadata, err := a.AnimeStore.GetAll(ReadPrimary(ctx), search, filters)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	a.cache.set(ctx, generation, key, cachedListing{Anime: anime, Metadata: metadata}, a.cache.listTTL)
+	return anime, metadata, nil
+}
+
+// The writes invalidate the cache once they succeed.
+
+func (a cachedAnime) InsertAnime(ctx context.Context, anime *data.Anime) error {
+	return a.invalidate(ctx, a.AnimeStore.InsertAnime(ctx, anime))
+}
+
+func (a cachedAnime) UpdateAnime(ctx context.Context, anime *data.Anime) error {
+	return a.invalidate(ctx, a.AnimeStore.UpdateAnime(ctx, anime))
+}
+
+func (a cachedAnime) DeleteAnime(ctx context.Context, id int32) error {
+	return a.invalidate(ctx, a.AnimeStore.DeleteAnime(ctx, id))
+}
+
+func (a cachedAnime) RestoreAnime(ctx context.Context, id int32) error {
+	return a.invalidate(ctx, a.AnimeStore.RestoreAnime(ctx, id))
+}
+
+func (a cachedAnime) PurgeAnime(ctx context.Context, id int32) error {
+	return a.invalidate(ctx, a.AnimeStore.PurgeAnime(ctx, id))
+}
+
+func (a cachedAnime) SetCover(ctx context.Context, id int32, url string) (int32, error) {
+	version, err := a.AnimeStore.SetCover(ctx, id, url)
+	return version, a.invalidate(ctx, err)
+}
+
+func (a cachedAnime) UpdateTag(ctx context.Context, tag *data.Tag) error {
+	return a.invalidate(ctx, a.AnimeStore.UpdateTag(ctx, tag))
+}
+
+func (a cachedAnime) DeleteTag(ctx context.Context, id int32, force bool) error {
+	return a.invalidate(ctx, a.AnimeStore.DeleteTag(ctx, id, force))
+}
+
+func (a cachedAnime) MergeTags(ctx context.Context, sourceID, targetID int32) (int64, error) {
+	n, err := a.AnimeStore.MergeTags(ctx, sourceID, targetID)
+	return n, a.invalidate(ctx, err)
+}
+
+func (a cachedAnime) UpdateStudio(ctx context.Context, studio *data.Studio) error {
+	return a.invalidate(ctx, a.AnimeStore.UpdateStudio(ctx, studio))
+}
+
+func (a cachedAnime) DeleteStudio(ctx context.Context, id int32, force bool) error {
+	return a.invalidate(ctx, a.AnimeStore.DeleteStudio(ctx, id, force))
+}
+
+// invalidate invalidates the cache unless err is set, and returns err.
+func (a cachedAnime) invalidate(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if a.written != nil {
+		a.written.Store(true)
+	}
+	a.cache.invalidate(ctx)
+
+	return nil
+}
+
+// get reads the entry of key at the current generation into value, and returns the
+// generation, to cache the value at on a miss. A cache which can't be reached is a miss.
+//
+// A cache without a generation, new or which evicted it, starts a new one: going back to
+// a fixed one would bring the entries cached at it back to life.
+func (c *AnimeCache) get(ctx context.Context, key string, value any) (string, bool) {
+	generation, ok, err := c.cache.Get(ctx, animeGenerationKey)
+	if err != nil {
+		c.failed(ctx, err)
+		return "", false
+	}
+	if !ok {
+		generation = []byte(c.invalidate(ctx))
+	}
+
+	entry, ok, err := c.cache.Get(ctx, key+":"+string(generation))
+	if err != nil {
+		c.failed(ctx, err)
+		return "", false
+	}
+
+	if ok && gob.NewDecoder(bytes.NewReader(entry)).Decode(value) == nil {
+		c.hits.Add(1)
+		return string(generation), true
+	}
+
+	c.misses.Add(1)
+	return string(generation), false
+}
+
+// set caches value under key at the generation it was read at. Nothing is cached when
+// the generation couldn't be read.
+func (c *AnimeCache) set(ctx context.Context, generation, key string, value any, ttl time.Duration) {
+	if generation == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		c.failed(ctx, err)
+		return
+	}
+
+	if err := c.cache.Set(ctx, key+":"+generation, buf.Bytes(), ttl); err != nil {
+		c.failed(ctx, err)
+	}
+}
+
+// invalidate moves the cache to a new generation, leaving the entries of the previous
+// ones to expire, and returns it. It returns an empty generation, which isn't cached
+// at, if the cache can't be reached.
+func (c *AnimeCache) invalidate(ctx context.Context) string {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := c.cache.Set(ctx, animeGenerationKey, []byte(generation), 0); err != nil {
+		c.failed(ctx, fmt.Errorf("invalidating the anime cache: %w", err))
+		return ""
+	}
+
+	return generation
+}
+
+func (c *AnimeCache) failed(ctx context.Context, err error) {
+	c.errors.Add(1)
+	c.logger.Warn(ctx, "anime cache error", "error", err.Error())
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+type APIKeyRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewAPIKeyRepository(db DBTX, logger *dbLogger, timeouts Timeouts) APIKeyRepository {
+	return APIKeyRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// Insert adds a new api key, reading the generated id and creation time back into it.
+func (k APIKeyRepository) Insert(ctx context.Context, key *data.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, k.timeouts.Query)
+	defer cancel()
+
+	query := `
+        INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+	`
+
+	args := []any{key.UserID, key.Name, key.Hash, []string(key.Permissions), key.Expiry}
+
+	err := k.db.QueryRow(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return k.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// GetAllForUser lists the api keys owned by a user. The plaintext is never available.
+func (k APIKeyRepository) GetAllForUser(ctx context.Context, userID int64) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT id, user_id, name, permissions, expiry, created_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id
+	`
+
+	rows, err := k.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, k.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := make([]*data.APIKey, 0)
+	for rows.Next() {
+		var key data.APIKey
+		if err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Permissions, &key.Expiry, &key.CreatedAt); err != nil {
+			return nil, k.logger.handleError(ctx, err)
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, k.logger.handleError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+// GetForKey retrieves the owner of a plaintext api key together with the key itself.
+// Expired keys are treated as if they don't exist.
+func (k APIKeyRepository) GetForKey(ctx context.Context, plaintext string) (*data.User, *data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version,
+               k.id, k.name, k.permissions, k.expiry, k.created_at
+        FROM users u
+        INNER JOIN api_keys k
+        ON u.id = k.user_id
+        WHERE k.hash = $1 AND (k.expiry IS NULL OR k.expiry > $2) AND u.deleted_at IS NULL
+	`
+
+	var user data.User
+	var key data.APIKey
+
+	var hash []byte
+	err := k.db.QueryRow(ctx, query, data.HashAPIKey(plaintext), time.Now()).Scan(
+		&user.ID, &user.CreatedAt, &user.Name, &user.Email, &hash, &user.Activated, &user.Version,
+		&key.ID, &key.Name, &key.Permissions, &key.Expiry, &key.CreatedAt,
+	)
+	if err != nil {
+		return nil, nil, k.logger.handleError(ctx, err)
+	}
+
+	user.Password.InsertHash(hash)
+	key.UserID = user.ID
+
+	return &user, &key, nil
+}
+
+// Delete revokes an api key. The user ID is part of the predicate so that users can
+// only revoke their own keys.
+func (k APIKeyRepository) Delete(ctx context.Context, id, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, k.timeouts.Query)
+	defer cancel()
+
+	res, err := k.db.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return k.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return k.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrRecordNotFound, "no rows affected"))
+	}
+
+	return nil
+}
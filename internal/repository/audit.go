@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"strings"
+)
+
+type AuditRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewAuditRepository(db DBTX, logger *dbLogger, timeouts Timeouts) AuditRepository {
+	return AuditRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// Insert records an audit entry. To be trustworthy the entry has to be written in the
+// same transaction as the change it describes, so this is meant to be called on
+// repositories obtained from WithTx.
+func (l AuditRepository) Insert(ctx context.Context, entry *data.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	query := `
+        INSERT INTO audit_log (user_id, action, entity, entity_id, before, after)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+	`
+
+	args := []any{entry.UserID, entry.Action, entry.Entity, entry.EntityID, nullJSON(entry.Before), nullJSON(entry.After)}
+
+	err := l.db.QueryRow(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return l.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// GetAll returns a page of audit entries, optionally filtered by the acting user, the
+// entity (and entity ID) and the action.
+func (l AuditRepository) GetAll(ctx context.Context, userID *int64, entity string, entityID *int64, action string, filters data.Filters) ([]*data.AuditEntry, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	var args []any
+	conditions := []string{"TRUE"}
+
+	var metadata data.Metadata
+
+	if userID != nil {
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)+1))
+		args = append(args, *userID)
+	}
+
+	if entity != "" {
+		conditions = append(conditions, fmt.Sprintf("entity = $%d", len(args)+1))
+		args = append(args, entity)
+	}
+
+	if entityID != nil {
+		conditions = append(conditions, fmt.Sprintf("entity_id = $%d", len(args)+1))
+		args = append(args, *entityID)
+	}
+
+	if action != "" {
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)+1))
+		args = append(args, action)
+	}
+
+	query := `
+        SELECT count(*) OVER(), id, user_id, action, entity, entity_id, before, after, created_at
+        FROM audit_log
+	`
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, id", filters.SortColumn(), filters.SortDirection())
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, filters.Limit(), filters.Offset())
+
+	rows, err := l.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, metadata, l.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	records := 0
+	entries := make([]*data.AuditEntry, 0)
+	for rows.Next() {
+		var entry data.AuditEntry
+		if err = rows.Scan(
+			&records,
+			&entry.ID, &entry.UserID, &entry.Action, &entry.Entity,
+			&entry.EntityID, &entry.Before, &entry.After, &entry.CreatedAt,
+		); err != nil {
+			return nil, metadata, l.logger.handleError(ctx, err)
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, metadata, l.logger.handleError(ctx, err)
+	}
+
+	metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// nullJSON turns an empty snapshot into SQL NULL rather than invalid JSON.
+func nullJSON(js []byte) any {
+	if len(js) == 0 {
+		return nil
+	}
+
+	return string(js)
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+type CharacterRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewCharacterRepository(db DBTX, logger *dbLogger, timeouts Timeouts) CharacterRepository {
+	return CharacterRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+func (c CharacterRepository) InsertCharacter(ctx context.Context, character *data.Character) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	err := c.db.QueryRow(ctx, `
+		INSERT INTO characters (name, about) VALUES ($1, $2) RETURNING id
+	`, character.Name, character.About).Scan(&character.ID)
+	if err != nil {
+		return c.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+func (c CharacterRepository) GetCharacter(ctx context.Context, id int32) (*data.Character, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	var character data.Character
+	err := c.db.QueryRow(ctx, `SELECT id, name, about FROM characters WHERE id = $1`, id).
+		Scan(&character.ID, &character.Name, &character.About)
+	if err != nil {
+		return nil, c.logger.handleError(ctx, err)
+	}
+
+	return &character, nil
+}
+
+func (c CharacterRepository) InsertPerson(ctx context.Context, person *data.Person) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	err := c.db.QueryRow(ctx, `INSERT INTO people (name) VALUES ($1) RETURNING id`, person.Name).Scan(&person.ID)
+	if err != nil {
+		return c.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+func (c CharacterRepository) GetPerson(ctx context.Context, id int32) (*data.Person, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	var person data.Person
+	err := c.db.QueryRow(ctx, `SELECT id, name FROM people WHERE id = $1`, id).Scan(&person.ID, &person.Name)
+	if err != nil {
+		return nil, c.logger.handleError(ctx, err)
+	}
+
+	return &person, nil
+}
+
+// GetCast returns the characters of an anime, main characters first, each with their
+// voice actors.
+func (c CharacterRepository) GetCast(ctx context.Context, animeID int32) ([]*data.CastMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	// The voice actors are aggregated as JSON, so that the whole cast comes back in one
+	// query without repeating the character for every actor.
+	query := `
+		SELECT ch.id, ch.name, ch.about, ac.role,
+			COALESCE(
+				jsonb_agg(
+					jsonb_build_object('id', p.id, 'name', p.name, 'language', va.language)
+					ORDER BY va.language, p.name
+				) FILTER (WHERE p.id IS NOT NULL),
+				'[]'
+			) AS voice_actors
+		FROM anime_characters ac
+		JOIN characters ch ON ac.character_id = ch.id
+		LEFT JOIN anime_voice_actors va ON va.anime_id = ac.anime_id AND va.character_id = ac.character_id
+		LEFT JOIN people p ON va.person_id = p.id
+		WHERE ac.anime_id = $1
+		GROUP BY ch.id, ch.name, ch.about, ac.role
+		ORDER BY ac.role = 'main' DESC, ch.name, ch.id
+	`
+
+	rows, err := c.db.Query(ctx, query, animeID)
+	if err != nil {
+		return nil, c.logger.handleError(ctx, err)
+	}
+
+	cast, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*data.CastMember, error) {
+		var member data.CastMember
+		err := row.Scan(
+			&member.Character.ID, &member.Character.Name, &member.Character.About,
+			&member.Role, &member.VoiceActors,
+		)
+		return &member, err
+	})
+	if err != nil {
+		return nil, c.logger.handleError(ctx, err)
+	}
+
+	return cast, nil
+}
+
+// SetCastMember adds a character to the cast of an anime, or updates their role and
+// replaces their voice actors if they already are in it. Unknown anime, character or
+// people IDs fail with ErrForeignKeyViolation.
+func (c CharacterRepository) SetCastMember(ctx context.Context, animeID int32, member *data.CastMember) error {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, c.db, opts)
+	if err != nil {
+		return c.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				c.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO anime_characters (anime_id, character_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (anime_id, character_id) DO UPDATE SET role = excluded.role
+	`, animeID, member.Character.ID, member.Role)
+	if err != nil {
+		return c.logger.handleError(ctx, err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM anime_voice_actors WHERE anime_id = $1 AND character_id = $2
+	`, animeID, member.Character.ID)
+	if err != nil {
+		return c.logger.handleError(ctx, err)
+	}
+
+	if len(member.VoiceActors) > 0 {
+		people := make([]int32, len(member.VoiceActors))
+		languages := make([]string, len(member.VoiceActors))
+		for i, actor := range member.VoiceActors {
+			people[i], languages[i] = actor.ID, actor.Language
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO anime_voice_actors (anime_id, character_id, person_id, language)
+			SELECT $1, $2, person_id, language
+			FROM unnest($3::integer[], $4::text[]) AS va (person_id, language)
+		`, animeID, member.Character.ID, people, languages)
+		if err != nil {
+			return c.logger.handleError(ctx, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return c.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return nil
+}
+
+// DeleteCastMember removes a character, and their voice actors, from the cast of an
+// anime.
+func (c CharacterRepository) DeleteCastMember(ctx context.Context, animeID, characterID int32) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
+	defer cancel()
+
+	result, err := c.db.Exec(ctx, `
+		DELETE FROM anime_characters WHERE anime_id = $1 AND character_id = $2
+	`, animeID, characterID)
+	if err != nil {
+		return c.logger.handleError(ctx, err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"runtime"
@@ -11,7 +12,9 @@ type dbLogger struct {
 	sl *slog.Logger
 }
 
-func (l *dbLogger) Error(msg string, args ...any) {
+// The logging methods take the context of the query, so that the log lines carry the
+// ID of the request that made it.
+func (l *dbLogger) Error(ctx context.Context, msg string, args ...any) {
 	_, file, line, _ := runtime.Caller(2)
 	shortFile := file
 	if strings.Contains(file, "GolandProjects/purplelight") {
@@ -19,10 +22,10 @@ func (l *dbLogger) Error(msg string, args ...any) {
 	}
 	trace := fmt.Sprintf("%s:%d", shortFile, line)
 	args = append(args, "trace", trace)
-	l.sl.Error(msg, args...)
+	l.sl.ErrorContext(ctx, msg, args...)
 }
 
-func (l *dbLogger) Debug(msg string, args ...any) {
+func (l *dbLogger) Debug(ctx context.Context, msg string, args ...any) {
 	_, file, line, _ := runtime.Caller(1)
 	shortFile := file
 	if strings.Contains(file, "GolandProjects/purplelight") {
@@ -30,5 +33,9 @@ func (l *dbLogger) Debug(msg string, args ...any) {
 	}
 	trace := fmt.Sprintf("%s:%d", shortFile, line)
 	args = append(args, "trace", trace)
-	l.sl.Info(msg, args...)
+	l.sl.InfoContext(ctx, msg, args...)
+}
+
+func (l *dbLogger) Warn(ctx context.Context, msg string, args ...any) {
+	l.sl.WarnContext(ctx, msg, args...)
 }
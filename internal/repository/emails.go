@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// EmailRepository is the email outbox. Emails are enqueued in the same transaction as
+// the change they are about, such as a new user, so that either both happen or neither
+// does, and the dispatcher sends them afterwards. An email is only marked as sent once
+// the SMTP server took it, so each one is sent at least once, and possibly more than
+// once if the dispatcher dies in between.
+//
+// The data of the emails holds the plaintext of tokens, so it is cleared as soon as the
+// email is sent, or given up on.
+type EmailRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewEmailRepository(db DBTX, logger *dbLogger, timeouts Timeouts) EmailRepository {
+	return EmailRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// Enqueue adds an email to the outbox, to be sent right away.
+func (e EmailRepository) Enqueue(ctx context.Context, email *data.Email) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO email_outbox (recipient, template, data)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+	`
+
+	err = e.db.QueryRow(ctx, query, email.Recipient, email.Template, js).Scan(&email.ID, &email.CreatedAt)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// ClaimPending claims up to limit emails due to be sent, oldest first, and counts the
+// attempt. Claimed emails aren't due again until the lease is over, so that concurrent
+// dispatchers don't send the same emails, while those of a dispatcher that died midway
+// are picked up again once it has passed.
+func (e EmailRepository) ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]*data.Email, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE email_outbox
+        SET attempts = attempts + 1, next_attempt_at = NOW() + $2 * interval '1 second'
+        WHERE id IN (
+            SELECT id FROM email_outbox
+            WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+            ORDER BY next_attempt_at, id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, recipient, template, data, attempts, created_at
+	`
+
+	rows, err := e.db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, e.logger.handleError(ctx, err)
+	}
+
+	emails, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*data.Email, error) {
+		var email data.Email
+		var js []byte
+		if err := row.Scan(&email.ID, &email.Recipient, &email.Template, &js, &email.Attempts, &email.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		// Numbers are kept as they were written, rather than turned into floats which
+		// the templates would print in exponent form.
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+		return &email, dec.Decode(&email.Data)
+	})
+	if err != nil {
+		return nil, e.logger.handleError(ctx, err)
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that an email was sent.
+func (e EmailRepository) MarkSent(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	_, err := e.db.Exec(ctx, `UPDATE email_outbox SET sent_at = NOW(), data = '{}' WHERE id = $1`, id)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// MarkFailed records a failed attempt at sending an email. The email is tried again at
+// retryAt, or given up on when retryAt is nil.
+func (e EmailRepository) MarkFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE email_outbox SET last_error = $2, next_attempt_at = $3
+        WHERE id = $1
+	`
+	args := []any{id, reason, retryAt}
+
+	if retryAt == nil {
+		query = `
+            UPDATE email_outbox SET last_error = $2, failed_at = NOW(), data = '{}'
+            WHERE id = $1
+		`
+		args = args[:2]
+	}
+
+	_, err := e.db.Exec(ctx, query, args...)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
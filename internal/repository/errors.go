@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/jackc/pgx/v5"
@@ -11,6 +12,8 @@ var (
 	ErrEditConflict         = errors.New("edit conflict")
 	ErrTooManyRows          = errors.New("too many rows returned")
 	ErrRecordNotFound       = errors.New("record not found")
+	ErrRecordInUse          = errors.New("record is still referenced")
+	ErrOnlyTag              = errors.New("only tag of an anime")
 	ErrDuplicateEntry       = errors.New("duplicate entry")
 	ErrForeignKeyViolation  = errors.New("foreign key violation")
 	ErrNotNullViolation     = errors.New("null value not allowed")
@@ -29,14 +32,16 @@ var (
 	ErrTransaction          = errors.New("transaction failed")
 	ErrQueryPrepare         = errors.New("failed preparing query")
 	ErrInternalDatabase     = errors.New("internal database error")
+	ErrTimeout              = errors.New("database operation timed out")
+	ErrCanceled             = errors.New("database operation canceled")
 )
 
 // handleError will handle potential database execution errors, returning a generic error and message.
-func (l *dbLogger) handleError(err error) error {
+func (l *dbLogger) handleError(ctx context.Context, err error) error {
 	var pgErr *pgconn.PgError
 	// check for postgresql specific errors
 	if errors.As(err, &pgErr) {
-		l.Error(ErrDatabaseUnknown.Error(), "error", pgErr.Message)
+		l.Error(ctx, ErrDatabaseUnknown.Error(), "error", pgErr.Message)
 
 		// Return corresponding error code
 		switch pgErr.Code {
@@ -71,8 +76,17 @@ func (l *dbLogger) handleError(err error) error {
 		}
 	}
 
+	// A deadline or cancellation coming from the caller's context isn't an internal
+	// error, so report it as such rather than logging it as one.
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	}
+
 	// Log the generic database error
-	l.Error(ErrInternalDatabase.Error(), "error", err.Error())
+	l.Error(ctx, ErrInternalDatabase.Error(), "error", err.Error())
 
 	// check for database generic errors
 	switch {
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// eventsLockKey is the key of the advisory lock held by the relay publishing the
+// events, so that a single instance publishes them at a time, in order.
+const eventsLockKey = 7_246_314_802
+
+// EventRepository is the outbox of the change stream. Events are inserted in the same
+// transaction as the change they record, so that none is lost or published for a change
+// rolled back, and the relay publishes them to the message broker afterwards. An event
+// is only marked as published once the broker took it, so each one is published at
+// least once, and possibly more than once if the relay dies in between.
+type EventRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewEventRepository(db DBTX, logger *dbLogger, timeouts Timeouts) EventRepository {
+	return EventRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// Insert adds an event to the outbox.
+func (e EventRepository) Insert(ctx context.Context, event *data.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	query := `
+        INSERT INTO events (entity, entity_id, action, data)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+	`
+
+	err := e.db.QueryRow(ctx, query, event.Entity, event.EntityID, event.Action, event.Data).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// TryLock takes the lock of the relay for the rest of the transaction, and reports
+// whether it got it: when it didn't, another instance is publishing the events. It must
+// be called within a transaction, as the lock is released with it.
+func (e EventRepository) TryLock(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	var locked bool
+	err := e.db.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, eventsLockKey).Scan(&locked)
+	if err != nil {
+		return false, e.logger.handleError(ctx, err)
+	}
+
+	return locked, nil
+}
+
+// GetPending returns up to limit events yet to be published, in the order they were
+// inserted.
+//
+// The IDs are taken from a sequence, which doesn't hand them out in the order the
+// transactions commit, so an event may be inserted with an ID lower than one already
+// published. Such an event is still published by the next call, right after the others.
+func (e EventRepository) GetPending(ctx context.Context, limit int) ([]*data.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT id, entity, entity_id, action, data, attempts, created_at
+        FROM events
+        WHERE published_at IS NULL AND failed_at IS NULL
+        ORDER BY id
+        LIMIT $1
+	`
+
+	rows, err := e.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, e.logger.handleError(ctx, err)
+	}
+
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*data.Event, error) {
+		var event data.Event
+		err := row.Scan(&event.ID, &event.Entity, &event.EntityID, &event.Action, &event.Data, &event.Attempts, &event.CreatedAt)
+		return &event, err
+	})
+	if err != nil {
+		return nil, e.logger.handleError(ctx, err)
+	}
+
+	return events, nil
+}
+
+// MarkPublished records that the events were published.
+func (e EventRepository) MarkPublished(ctx context.Context, ids []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	_, err := e.db.Exec(ctx, `UPDATE events SET published_at = NOW(), last_error = '' WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// MarkFailed records a failed attempt at publishing an event. The event stays pending,
+// and is tried again by the next run of the relay.
+func (e EventRepository) MarkFailed(ctx context.Context, id int64, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	_, err := e.db.Exec(ctx, `UPDATE events SET attempts = attempts + 1, last_error = $2 WHERE id = $1`, id, reason)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// MarkDead gives up on an event which can never be published, such as one larger than
+// the broker takes, so that it doesn't hold back the events after it. It is kept, with
+// the reason, until it is purged.
+func (e EventRepository) MarkDead(ctx context.Context, id int64, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	_, err := e.db.Exec(ctx, `UPDATE events SET attempts = attempts + 1, last_error = $2, failed_at = NOW() WHERE id = $1`, id, reason)
+	if err != nil {
+		return e.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// Purge deletes the events published, or given up on, before the given time, and
+// returns how many there were. The events never published are deleted as well when
+// unpublished is true, which is for when no relay publishes them.
+func (e EventRepository) Purge(ctx context.Context, before time.Time, unpublished bool) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Query)
+	defer cancel()
+
+	query := `
+        DELETE FROM events
+        WHERE created_at < $1 AND (published_at IS NOT NULL OR failed_at IS NOT NULL OR $2)
+	`
+
+	result, err := e.db.Exec(ctx, query, before, unpublished)
+	if err != nil {
+		return 0, e.logger.handleError(ctx, err)
+	}
+
+	return result.RowsAffected(), nil
+}
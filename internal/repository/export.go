@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+// exportBatchSize is the number of rows fetched from the export cursor at a time.
+const exportBatchSize = 500
+
+// Export calls fn for every anime in the catalog, ordered by ID. The rows are read
+// through a server-side cursor in batches, so neither the database nor the application
+// ever holds the whole catalog in memory. The export runs in a single repeatable read
+// transaction, so it is a consistent snapshot even while the catalog is being edited.
+//
+// Exports can take a while, so only the context bounds how long this runs; if fn
+// returns an error the export stops and the error is returned.
+func (a AnimeRepository) Export(ctx context.Context, fn func(anime *data.Anime) error) error {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	}
+
+	tx, err := beginTx(ctx, a.db, opts)
+	if err != nil {
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	// The transaction is read only, so it is always rolled back.
+	defer func() {
+		if rbErr := tx.Rollback(ctx); rbErr != nil && ctx.Err() == nil {
+			a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+		}
+	}()
+
+	_, err = tx.Exec(ctx, `
+		DECLARE anime_export NO SCROLL CURSOR FOR
+		SELECT
+			a.id, a.title, a.slug, a.type, a.episodes,
+			a.status, a.season, a.year, a.duration,
+			a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id,
+			ARRAY(
+				SELECT s.name FROM anime_studios ast JOIN studio s ON ast.studio_id = s.id
+				WHERE ast.anime_id = a.id ORDER BY s.name
+			) AS studios,
+			ARRAY_AGG(t.name ORDER BY t.name) AS tags,
+			a.created_at, a.updated_at, a.version
+		FROM anime a
+		JOIN anime_tags at ON a.id = at.anime_id
+		JOIN tag t ON at.tag_id = t.id
+		WHERE a.deleted_at IS NULL
+		GROUP BY a.id, a.title, a.slug, a.type, a.episodes, a.status, a.season, a.year, a.duration, a.synopsis, a.alt_titles, a.cover_url, a.mal_id, a.anilist_id, a.created_at, a.updated_at, a.version
+		ORDER BY a.id
+	`)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM anime_export", exportBatchSize))
+		if err != nil {
+			return a.logger.handleError(ctx, err)
+		}
+
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*data.Anime, error) {
+			var an data.Anime
+			err := row.Scan(
+				&an.ID, &an.Title, &an.Slug, &an.Type, &an.Episodes,
+				&an.Status, &an.Season, &an.Year, &an.Duration,
+				&an.Synopsis, &an.AltTitles, &an.CoverURL, &an.MalID, &an.AniListID, &an.Studios,
+				&an.Tags, &an.CreatedAt, &an.UpdatedAt, &an.Version,
+			)
+			return &an, err
+		})
+		if err != nil {
+			return a.logger.handleError(ctx, err)
+		}
+
+		for _, anime := range batch {
+			if err = fn(anime); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+	}
+}
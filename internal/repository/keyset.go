@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"slices"
+	"strings"
+)
+
+// keysetCondition returns the predicate selecting the rows that come after the cursor
+// in an ORDER BY column <desc>, idColumn ordering, together with its arguments numbered
+// from argPos. The id tie-breaker descends only when paging backwards.
+//
+// NULLs sort as if they were larger than any value, which is how Postgres orders them
+// by default (NULLS LAST for ASC, NULLS FIRST for DESC).
+func keysetCondition(column, idColumn string, cursor *data.Cursor, desc bool, argPos int) (string, []any) {
+	idOp := ">"
+	if cursor.Backward {
+		idOp = "<"
+	}
+
+	if column == idColumn {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		return fmt.Sprintf("%s %s $%d", column, op, argPos), []any{cursor.ID}
+	}
+
+	if cursor.Value == nil {
+		idCond := fmt.Sprintf("%s %s $%d", idColumn, idOp, argPos)
+		if desc {
+			return fmt.Sprintf("(%s IS NOT NULL OR (%s IS NULL AND %s))", column, column, idCond), []any{cursor.ID}
+		}
+		return fmt.Sprintf("(%s IS NULL AND %s)", column, idCond), []any{cursor.ID}
+	}
+
+	idCond := fmt.Sprintf("%s %s $%d", idColumn, idOp, argPos+1)
+	if desc {
+		return fmt.Sprintf("(%s < $%d OR (%s = $%d AND %s))", column, argPos, column, argPos, idCond), []any{cursor.Value, cursor.ID}
+	}
+
+	return fmt.Sprintf("(%s > $%d OR %s IS NULL OR (%s = $%d AND %s))", column, argPos, column, column, argPos, idCond), []any{cursor.Value, cursor.ID}
+}
+
+// sqlDirection returns the ORDER BY direction keyword.
+func sqlDirection(desc bool) string {
+	if desc {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+// AnimePage trims a keyset query result, fetched with one extra row, down to the page
+// size, and puts backward pages back into the requested order. It reports whether more
+// rows exist past the page.
+func AnimePage(anime []*data.Anime, filters data.Filters) ([]*data.Anime, bool) {
+	hasMore := len(anime) > filters.Limit()
+	if hasMore {
+		anime = anime[:filters.Limit()]
+	}
+
+	if filters.Cursor != nil && filters.Cursor.Backward {
+		slices.Reverse(anime)
+	}
+
+	return anime, hasMore
+}
+
+// AnimeCursor returns the cursor marking the position of the anime in a listing with
+// the given sort.
+func AnimeCursor(anime *data.Anime, sort string) *data.Cursor {
+	cursor := &data.Cursor{Sort: sort, ID: int64(anime.ID)}
+
+	switch strings.TrimPrefix(sort, "-") {
+	case "title":
+		cursor.Value = anime.Title
+	case "year":
+		if anime.Year != nil {
+			cursor.Value = int64(*anime.Year)
+		}
+	case "episodes":
+		if anime.Episodes != nil {
+			cursor.Value = int64(*anime.Episodes)
+		}
+	}
+
+	return cursor
+}
@@ -0,0 +1,646 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AnimeStore is the in-memory repository.AnimeStore.
+type AnimeStore struct {
+	s *store
+}
+
+func (a *AnimeStore) InsertAnime(_ context.Context, anime *data.Anime) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if a.s.titleTaken(anime.Title, 0) || a.s.externalIDTaken(anime, 0) {
+		return repository.ErrDuplicateEntry
+	}
+
+	a.s.nextAnimeID++
+	anime.ID = a.s.nextAnimeID
+	anime.Slug = a.s.slugFor(anime, "")
+	anime.CreatedAt = time.Now()
+	anime.UpdatedAt = anime.CreatedAt
+	anime.Version = 1
+
+	a.s.upsertTags(anime.Tags)
+	a.s.upsertStudios(anime.Studios)
+	a.s.anime[anime.ID] = cloneAnime(anime)
+	a.s.addRevision(anime)
+
+	return nil
+}
+
+func (a *AnimeStore) GetAnime(_ context.Context, id int32) (*data.Anime, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	anime, ok := a.s.anime[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	return cloneAnime(anime), nil
+}
+
+func (a *AnimeStore) GetAnimeByIDs(_ context.Context, ids []int32) ([]*data.Anime, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	var found []*data.Anime
+	for _, id := range ids {
+		if anime, ok := a.s.anime[id]; ok {
+			found = append(found, cloneAnime(anime))
+		}
+	}
+
+	return found, nil
+}
+
+func (a *AnimeStore) GetAnimeByExternalID(_ context.Context, source string, externalID int32) (*data.Anime, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	for _, anime := range a.s.anime {
+		if id := anime.ExternalID(source); id != nil && *id == externalID {
+			return cloneAnime(anime), nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (a *AnimeStore) GetAnimeBySlug(_ context.Context, slug string) (*data.Anime, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	for _, anime := range a.s.anime {
+		if anime.Slug == slug {
+			return cloneAnime(anime), nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (a *AnimeStore) GetAll(_ context.Context, search data.AnimeSearch, filters data.Filters) ([]*data.Anime, data.Metadata, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	// A delta sync lists the deleted anime too, as tombstones.
+	sets := []map[int32]*data.Anime{a.s.anime}
+	if search.UpdatedSince != nil {
+		sets = append(sets, a.s.deletedAnime)
+	}
+
+	var matched []*data.Anime
+	for _, anime := range allAnime(sets) {
+		if search.UpdatedSince != nil && !anime.UpdatedAt.After(*search.UpdatedSince) {
+			continue
+		}
+		if search.Query != "" && queryRank(anime, search.Query) == 0 {
+			continue
+		}
+		if search.Title != "" && !matchTitle(anime.Title, search.Title, search.Match) {
+			continue
+		}
+		if search.Synopsis != "" && !matchWords(anime.Synopsis, search.Synopsis) {
+			continue
+		}
+		if search.Status != "" && string(anime.Status) != search.Status {
+			continue
+		}
+		if search.Season != "" && (anime.Season == nil || string(*anime.Season) != search.Season) {
+			continue
+		}
+		if search.AnimeType != "" && string(anime.Type) != search.AnimeType {
+			continue
+		}
+		if !inRange(anime.Year, search.YearMin, search.YearMax) {
+			continue
+		}
+		if search.Studio != "" && !slices.Contains(anime.Studios, search.Studio) {
+			continue
+		}
+		if !inRange(anime.Episodes, search.EpisodesMin, search.EpisodesMax) {
+			continue
+		}
+		if !matchTags(anime.Tags, search.Tags, search.TagsMatch) {
+			continue
+		}
+
+		match := cloneAnime(anime)
+		_, match.Deleted = a.s.deletedAnime[anime.ID]
+		if search.Title != "" {
+			// ts_rank has no cheap equivalent here, so both modes rank by similarity.
+			rank := float32(wordSimilarity(search.Title, anime.Title))
+			match.Rank = &rank
+		}
+		if search.Query != "" {
+			rank := queryRank(anime, search.Query)
+			match.Rank = &rank
+		}
+
+		matched = append(matched, match)
+	}
+
+	animeID := func(a *data.Anime) int64 { return int64(a.ID) }
+	sortBy(matched, filters, compareAnime, animeID)
+
+	var page []*data.Anime
+	var metadata data.Metadata
+	if filters.Cursor == nil {
+		page, metadata = paginate(matched, filters)
+	} else {
+		rows := keyset(matched, filters, cursorAnime(filters.Cursor), lessBy(filters, compareAnime, animeID))
+
+		var hasMore bool
+		page, hasMore = repository.AnimePage(rows, filters)
+
+		var first, last *data.Cursor
+		if len(page) > 0 {
+			first = repository.AnimeCursor(page[0], filters.Sort)
+			last = repository.AnimeCursor(page[len(page)-1], filters.Sort)
+		}
+
+		metadata.CalculateCursors(filters.PageSize, filters.Cursor, first, last, hasMore)
+	}
+
+	if search.UpdatedSince != nil {
+		syncedAt := time.Now()
+		metadata.SyncedAt = &syncedAt
+
+		for i, an := range page {
+			if an.Deleted {
+				page[i] = &data.Anime{ID: an.ID, UpdatedAt: an.UpdatedAt, Version: an.Version, Deleted: true}
+			}
+		}
+	}
+
+	return page, metadata, nil
+}
+
+// allAnime flattens sets of anime into a single slice.
+func allAnime(sets []map[int32]*data.Anime) []*data.Anime {
+	var all []*data.Anime
+	for _, set := range sets {
+		for _, anime := range set {
+			all = append(all, anime)
+		}
+	}
+
+	return all
+}
+
+func (a *AnimeStore) UpdateAnime(_ context.Context, anime *data.Anime) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	current, ok := a.s.anime[anime.ID]
+	if !ok || current.Version != anime.Version {
+		return repository.ErrEditConflict
+	}
+
+	if a.s.titleTaken(anime.Title, anime.ID) || a.s.externalIDTaken(anime, anime.ID) {
+		return repository.ErrDuplicateEntry
+	}
+
+	anime.Slug = a.s.slugFor(anime, current.Slug)
+	anime.UpdatedAt = time.Now()
+	anime.Version++
+	a.s.upsertTags(anime.Tags)
+	a.s.upsertStudios(anime.Studios)
+	a.s.anime[anime.ID] = cloneAnime(anime)
+	a.s.addRevision(anime)
+
+	return nil
+}
+
+func (a *AnimeStore) DeleteAnime(_ context.Context, id int32) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, ok := a.s.anime[id]; !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	a.s.anime[id].UpdatedAt = time.Now()
+	a.s.deletedAnime[id] = a.s.anime[id]
+	delete(a.s.anime, id)
+
+	return nil
+}
+
+func (a *AnimeStore) SetCover(_ context.Context, id int32, url string) (int32, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	anime, ok := a.s.anime[id]
+	if !ok {
+		return 0, repository.ErrRecordNotFound
+	}
+
+	anime.CoverURL = url
+	anime.UpdatedAt = time.Now()
+	anime.Version++
+
+	return anime.Version, nil
+}
+
+func (a *AnimeStore) RestoreAnime(_ context.Context, id int32) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	anime, ok := a.s.deletedAnime[id]
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	anime.UpdatedAt = time.Now()
+	anime.Version++
+	a.s.anime[id] = anime
+	delete(a.s.deletedAnime, id)
+
+	return nil
+}
+
+func (a *AnimeStore) PurgeAnime(_ context.Context, id int32) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	_, live := a.s.anime[id]
+	_, deleted := a.s.deletedAnime[id]
+	if !live && !deleted {
+		return repository.ErrRecordNotFound
+	}
+
+	delete(a.s.anime, id)
+	delete(a.s.deletedAnime, id)
+	delete(a.s.revisions, id)
+	delete(a.s.cast, id)
+	a.s.deleteWatchlist(func(e *data.WatchlistEntry) bool { return e.AnimeID == id })
+	a.s.activity = slices.DeleteFunc(a.s.activity, func(a *data.AnimeActivity) bool { return a.AnimeID == id })
+	a.s.trending = slices.DeleteFunc(a.s.trending, func(t *data.TrendingAnime) bool { return t.ID == id })
+
+	return nil
+}
+
+func (a *AnimeStore) GetAllTags(_ context.Context) ([]string, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	names := make([]string, len(a.s.tags))
+	for i, tag := range a.s.tags {
+		names[i] = tag.Name
+	}
+
+	return names, nil
+}
+
+// Export calls fn for a snapshot of the catalog, ordered by ID. The lock is not held
+// while fn runs, as fn typically writes to the network.
+func (a *AnimeStore) Export(ctx context.Context, fn func(anime *data.Anime) error) error {
+	a.s.mu.Lock()
+	snapshot := make([]*data.Anime, 0, len(a.s.anime))
+	for _, anime := range a.s.anime {
+		snapshot = append(snapshot, cloneAnime(anime))
+	}
+	a.s.mu.Unlock()
+
+	slices.SortFunc(snapshot, func(a, b *data.Anime) int { return cmp.Compare(a.ID, b.ID) })
+
+	for _, anime := range snapshot {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(anime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *AnimeStore) GetRevisions(_ context.Context, animeID int32) ([]*data.AnimeRevision, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	revisions := make([]*data.AnimeRevision, 0)
+	if _, ok := a.s.anime[animeID]; !ok {
+		return revisions, nil
+	}
+
+	for _, revision := range a.s.revisions[animeID] {
+		revisions = append(revisions, cloneRevision(revision))
+	}
+
+	return revisions, nil
+}
+
+func (a *AnimeStore) GetRevision(_ context.Context, animeID, version int32) (*data.AnimeRevision, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, ok := a.s.anime[animeID]; ok {
+		for _, revision := range a.s.revisions[animeID] {
+			if revision.Version == version {
+				return cloneRevision(revision), nil
+			}
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (s *store) addRevision(anime *data.Anime) {
+	s.revisions[anime.ID] = append(s.revisions[anime.ID], &data.AnimeRevision{
+		AnimeID:   anime.ID,
+		Version:   anime.Version,
+		Anime:     cloneAnime(anime),
+		CreatedAt: time.Now(),
+	})
+}
+
+func cloneRevision(revision *data.AnimeRevision) *data.AnimeRevision {
+	c := *revision
+	c.Anime = cloneAnime(revision.Anime)
+	return &c
+}
+
+// titleTaken mimics the UNIQUE constraint on anime.title, which also covers soft
+// deleted anime.
+func (s *store) titleTaken(title string, exceptID int32) bool {
+	for _, set := range []map[int32]*data.Anime{s.anime, s.deletedAnime} {
+		for id, anime := range set {
+			if id != exceptID && anime.Title == title {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// externalIDTaken reports whether another anime, deleted or not, is linked to one of the
+// external IDs of the anime, like the unique indexes on them would.
+func (s *store) externalIDTaken(anime *data.Anime, exceptID int32) bool {
+	for _, set := range []map[int32]*data.Anime{s.anime, s.deletedAnime} {
+		for id, other := range set {
+			if id == exceptID {
+				continue
+			}
+			for _, source := range data.ExternalSources {
+				mine, theirs := anime.ExternalID(source), other.ExternalID(source)
+				if mine != nil && theirs != nil && *mine == *theirs {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// slugFor mirrors AnimeRepository.slugFor: the current slug of the anime is kept while
+// it fits its title, and new slugs are made unique among every anime, deleted or not.
+func (s *store) slugFor(anime *data.Anime, current string) string {
+	base := data.AnimeSlug(anime)
+	if current != "" && repository.SlugFits(current, base) {
+		return current
+	}
+
+	return repository.UniqueSlug(base, func(slug string) bool {
+		for _, set := range []map[int32]*data.Anime{s.anime, s.deletedAnime} {
+			for id, other := range set {
+				if id != anime.ID && other.Slug == slug {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+func (s *store) upsertTags(tags []string) {
+	for _, name := range tags {
+		if s.tagIndex(name) < 0 {
+			s.nextTagID++
+			s.tags = append(s.tags, &data.Tag{ID: s.nextTagID, Name: name})
+		}
+	}
+}
+
+// cursorAnime builds an anime sitting at the position marked by the cursor, so that it
+// can be compared with the stored anime.
+func cursorAnime(cursor *data.Cursor) *data.Anime {
+	anime := &data.Anime{ID: int32(cursor.ID)}
+
+	switch value := cursor.Value.(type) {
+	case string:
+		anime.Title = value
+	case int64:
+		n := int32(value)
+		anime.Year, anime.Episodes = &n, &n
+	}
+
+	return anime
+}
+
+func cloneAnime(anime *data.Anime) *data.Anime {
+	c := *anime
+	c.Tags = slices.Clone(anime.Tags)
+	slices.Sort(c.Tags)
+	c.Studios = slices.Clone(anime.Studios)
+	slices.Sort(c.Studios)
+	c.AltTitles = slices.Clone(anime.AltTitles)
+	return &c
+}
+
+// matchTitle compares the title with the query the way the match mode does.
+func matchTitle(title, query, match string) bool {
+	if match == data.MatchFuzzy {
+		return wordSimilarity(query, title) >= wordSimilarityThreshold
+	}
+
+	return matchWords(title, query)
+}
+
+// matchWords approximates plainto_tsquery: every word of the query must appear in the
+// title.
+func matchWords(title, query string) bool {
+	words := strings.Fields(strings.ToLower(title))
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !slices.Contains(words, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queryWeights are the weights ts_rank gives to the fields the q parameter searches,
+// in the order queryRank looks at them.
+var queryWeights = []float32{1, 0.4, 0.2, 0.1}
+
+// queryRank approximates the rank of a q search, or returns zero when the anime doesn't
+// match: every word of the query must appear in one of the fields, and the words with
+// a leading - in none. Each word counts the weight of the best field it is found in.
+// Quotes are ignored, and so is or.
+func queryRank(anime *data.Anime, query string) float32 {
+	fields := [][]string{
+		strings.Fields(strings.ToLower(anime.Title)),
+		strings.Fields(strings.ToLower(strings.Join(anime.AltTitles, " "))),
+		strings.Fields(strings.ToLower(strings.Join(anime.Tags, " "))),
+		strings.Fields(strings.ToLower(anime.Synopsis)),
+	}
+
+	var rank float32
+	words := 0
+	for _, word := range strings.Fields(strings.ToLower(strings.ReplaceAll(query, `"`, ""))) {
+		if word == "or" {
+			continue
+		}
+
+		excluded, ok := strings.CutPrefix(word, "-")
+		if ok {
+			for _, field := range fields {
+				if slices.Contains(field, excluded) {
+					return 0
+				}
+			}
+			continue
+		}
+
+		found := false
+		for i, field := range fields {
+			if slices.Contains(field, word) {
+				rank += queryWeights[i]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+		words++
+	}
+
+	if words == 0 {
+		return 0
+	}
+
+	return rank / float32(words)
+}
+
+// wordSimilarityThreshold is the default of pg_trgm.word_similarity_threshold.
+const wordSimilarityThreshold = 0.6
+
+// wordSimilarity approximates pg_trgm's word_similarity: the share of the query's
+// trigrams that are found in the title. Postgres only looks at the best matching
+// extent of the title, which matters little for short searches.
+func wordSimilarity(query, title string) float64 {
+	want := trigrams(query)
+	if len(want) == 0 {
+		return 0
+	}
+
+	have := trigrams(title)
+
+	shared := 0
+	for t := range want {
+		if _, ok := have[t]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(want))
+}
+
+// trigrams returns the set of trigrams of s the way pg_trgm builds them: every word is
+// lowercased and padded with two spaces in front and one behind.
+func trigrams(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+// inRange reports whether n is within the optional, inclusive bounds. Like a NULL in a
+// SQL comparison, a nil n is never in range once a bound is set.
+func inRange(n, lo, hi *int32) bool {
+	if lo == nil && hi == nil {
+		return true
+	}
+	if n == nil {
+		return false
+	}
+
+	return (lo == nil || *n >= *lo) && (hi == nil || *n <= *hi)
+}
+
+func matchTags(animeTags, tags []string, match string) bool {
+	if match == data.TagsMatchAny && len(tags) > 0 {
+		return slices.ContainsFunc(tags, func(tag string) bool {
+			return hasAllTags(animeTags, []string{tag})
+		})
+	}
+
+	return hasAllTags(animeTags, tags)
+}
+
+func hasAllTags(animeTags, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.ContainsFunc(animeTags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareAnime(a, b *data.Anime, column string) int {
+	switch column {
+	case "title":
+		return cmp.Compare(a.Title, b.Title)
+	case "year":
+		return comparePtr(a.Year, b.Year)
+	case "episodes":
+		return comparePtr(a.Episodes, b.Episodes)
+	case data.SortRelevance:
+		// Best match first.
+		return comparePtr(b.Rank, a.Rank)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
+
+// comparePtr orders nil values last, like NULLs in an ascending Postgres sort.
+func comparePtr[T cmp.Ordered](a, b *T) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	default:
+		return cmp.Compare(*a, *b)
+	}
+}
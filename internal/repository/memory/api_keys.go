@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"time"
+)
+
+// APIKeyStore is the in-memory repository.APIKeyStore.
+type APIKeyStore struct {
+	s *store
+}
+
+func (k *APIKeyStore) Insert(_ context.Context, key *data.APIKey) error {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+
+	k.s.nextAPIKeyID++
+	key.ID = k.s.nextAPIKeyID
+	key.CreatedAt = time.Now()
+
+	stored := *key
+	stored.Plaintext = ""
+	stored.Permissions = slices.Clone(key.Permissions)
+	k.s.apiKeys[key.ID] = &stored
+
+	return nil
+}
+
+func (k *APIKeyStore) GetAllForUser(_ context.Context, userID int64) ([]*data.APIKey, error) {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+
+	keys := make([]*data.APIKey, 0)
+	for _, key := range k.s.apiKeys {
+		if key.UserID == userID {
+			c := *key
+			c.Hash = nil
+			keys = append(keys, &c)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b *data.APIKey) int { return cmp.Compare(a.ID, b.ID) })
+
+	return keys, nil
+}
+
+func (k *APIKeyStore) GetForKey(_ context.Context, plaintext string) (*data.User, *data.APIKey, error) {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+
+	hash := data.HashAPIKey(plaintext)
+
+	for _, key := range k.s.apiKeys {
+		if !slices.Equal(key.Hash, hash) || (key.Expiry != nil && !key.Expiry.After(time.Now())) {
+			continue
+		}
+
+		record, ok := k.s.activeUser(key.UserID)
+		if !ok {
+			break
+		}
+
+		user := record.user
+		user.LockedUntil = nil
+		c := *key
+		c.Hash = nil
+
+		return &user, &c, nil
+	}
+
+	return nil, nil, repository.ErrRecordNotFound
+}
+
+func (k *APIKeyStore) Delete(_ context.Context, id, userID int64) error {
+	k.s.mu.Lock()
+	defer k.s.mu.Unlock()
+
+	key, ok := k.s.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return repository.ErrRecordNotFound
+	}
+
+	delete(k.s.apiKeys, id)
+
+	return nil
+}
+
+func (s *store) deleteAPIKeys(userID int64) {
+	for id, key := range s.apiKeys {
+		if key.UserID == userID {
+			delete(s.apiKeys, id)
+		}
+	}
+}
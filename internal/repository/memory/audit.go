@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// AuditStore is the in-memory repository.AuditStore.
+type AuditStore struct {
+	s *store
+}
+
+func (l *AuditStore) Insert(_ context.Context, entry *data.AuditEntry) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	entry.ID = int64(len(l.s.audit) + 1)
+	entry.CreatedAt = time.Now()
+
+	stored := *entry
+	l.s.audit = append(l.s.audit, &stored)
+
+	return nil
+}
+
+func (l *AuditStore) GetAll(_ context.Context, userID *int64, entity string, entityID *int64, action string, filters data.Filters) ([]*data.AuditEntry, data.Metadata, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	var matched []*data.AuditEntry
+	for _, entry := range l.s.audit {
+		if userID != nil && (entry.UserID == nil || *entry.UserID != *userID) {
+			continue
+		}
+		if entity != "" && entry.Entity != entity {
+			continue
+		}
+		if entityID != nil && entry.EntityID != *entityID {
+			continue
+		}
+		if action != "" && entry.Action != action {
+			continue
+		}
+
+		c := *entry
+		matched = append(matched, &c)
+	}
+
+	sortBy(matched, filters, compareAudit, func(e *data.AuditEntry) int64 { return e.ID })
+	page, metadata := paginate(matched, filters)
+
+	return page, metadata, nil
+}
+
+func compareAudit(a, b *data.AuditEntry, column string) int {
+	switch column {
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"strings"
+)
+
+// CharacterStore is the in-memory repository.CharacterStore.
+type CharacterStore struct {
+	s *store
+}
+
+func (c *CharacterStore) InsertCharacter(_ context.Context, character *data.Character) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	character.ID = int32(len(c.s.characters) + 1)
+
+	stored := *character
+	c.s.characters[character.ID] = &stored
+
+	return nil
+}
+
+func (c *CharacterStore) GetCharacter(_ context.Context, id int32) (*data.Character, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	character, ok := c.s.characters[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	found := *character
+	return &found, nil
+}
+
+func (c *CharacterStore) InsertPerson(_ context.Context, person *data.Person) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	person.ID = int32(len(c.s.people) + 1)
+
+	stored := *person
+	c.s.people[person.ID] = &stored
+
+	return nil
+}
+
+func (c *CharacterStore) GetPerson(_ context.Context, id int32) (*data.Person, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	person, ok := c.s.people[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	found := *person
+	return &found, nil
+}
+
+// GetCast returns the cast in the same order as the query: main characters first, then
+// by name.
+func (c *CharacterStore) GetCast(_ context.Context, animeID int32) ([]*data.CastMember, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	cast := make([]*data.CastMember, 0, len(c.s.cast[animeID]))
+	for _, member := range c.s.cast[animeID] {
+		found := *member
+		found.Character = *c.s.characters[member.Character.ID]
+		found.VoiceActors = make([]data.VoiceActor, len(member.VoiceActors))
+		for i, actor := range member.VoiceActors {
+			found.VoiceActors[i] = data.VoiceActor{Person: *c.s.people[actor.ID], Language: actor.Language}
+		}
+
+		cast = append(cast, &found)
+	}
+
+	slices.SortFunc(cast, func(a, b *data.CastMember) int {
+		if a.Role != b.Role {
+			if a.Role == data.CharacterRoleMain {
+				return -1
+			}
+			if b.Role == data.CharacterRoleMain {
+				return 1
+			}
+		}
+
+		return cmp.Or(strings.Compare(a.Character.Name, b.Character.Name), cmp.Compare(a.Character.ID, b.Character.ID))
+	})
+
+	return cast, nil
+}
+
+func (c *CharacterStore) SetCastMember(_ context.Context, animeID int32, member *data.CastMember) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	// Mimic the foreign keys of the join tables.
+	_, live := c.s.anime[animeID]
+	_, deleted := c.s.deletedAnime[animeID]
+	if _, ok := c.s.characters[member.Character.ID]; !ok || (!live && !deleted) {
+		return repository.ErrForeignKeyViolation
+	}
+	for _, actor := range member.VoiceActors {
+		if _, ok := c.s.people[actor.ID]; !ok {
+			return repository.ErrForeignKeyViolation
+		}
+	}
+
+	stored := &data.CastMember{
+		Character:   data.Character{ID: member.Character.ID},
+		Role:        member.Role,
+		VoiceActors: slices.Clone(member.VoiceActors),
+	}
+
+	cast := c.s.cast[animeID]
+	i := slices.IndexFunc(cast, func(m *data.CastMember) bool { return m.Character.ID == member.Character.ID })
+	if i >= 0 {
+		cast[i] = stored
+	} else {
+		c.s.cast[animeID] = append(cast, stored)
+	}
+
+	return nil
+}
+
+func (c *CharacterStore) DeleteCastMember(_ context.Context, animeID, characterID int32) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	cast := c.s.cast[animeID]
+	i := slices.IndexFunc(cast, func(m *data.CastMember) bool { return m.Character.ID == characterID })
+	if i < 0 {
+		return repository.ErrRecordNotFound
+	}
+
+	c.s.cast[animeID] = slices.Delete(cast, i, i+1)
+
+	return nil
+}
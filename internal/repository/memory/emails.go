@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"maps"
+	"time"
+)
+
+// emailRecord is a row of the email outbox.
+type emailRecord struct {
+	email         data.Email
+	nextAttemptAt time.Time
+	lastError     string
+	sent          bool
+	failed        bool
+}
+
+// EmailStore is the in-memory repository.EmailStore.
+type EmailStore struct {
+	s *store
+}
+
+func (e *EmailStore) Enqueue(_ context.Context, email *data.Email) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	e.s.nextEmailID++
+	email.ID = e.s.nextEmailID
+	email.CreatedAt = time.Now()
+
+	stored := *email
+	stored.Data = maps.Clone(email.Data)
+	e.s.emails = append(e.s.emails, &emailRecord{email: stored, nextAttemptAt: email.CreatedAt})
+
+	return nil
+}
+
+func (e *EmailStore) ClaimPending(_ context.Context, limit int, lease time.Duration) ([]*data.Email, error) {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	now := time.Now()
+
+	// The records are kept in the order they were enqueued, which is close enough to
+	// the order of their next attempt.
+	emails := make([]*data.Email, 0)
+	for _, record := range e.s.emails {
+		if len(emails) == limit {
+			break
+		}
+		if record.sent || record.failed || record.nextAttemptAt.After(now) {
+			continue
+		}
+
+		record.email.Attempts++
+		record.nextAttemptAt = now.Add(lease)
+
+		email := record.email
+		email.Data = maps.Clone(record.email.Data)
+		emails = append(emails, &email)
+	}
+
+	return emails, nil
+}
+
+func (e *EmailStore) MarkSent(_ context.Context, id int64) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	if record := e.s.email(id); record != nil {
+		record.sent = true
+		record.email.Data = map[string]any{}
+	}
+
+	return nil
+}
+
+func (e *EmailStore) MarkFailed(_ context.Context, id int64, reason string, retryAt *time.Time) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	record := e.s.email(id)
+	if record == nil {
+		return nil
+	}
+
+	record.lastError = reason
+	if retryAt == nil {
+		record.failed = true
+		record.email.Data = map[string]any{}
+	} else {
+		record.nextAttemptAt = *retryAt
+	}
+
+	return nil
+}
+
+func (s *store) email(id int64) *emailRecord {
+	for _, record := range s.emails {
+		if record.email.ID == id {
+			return record
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"slices"
+	"time"
+)
+
+// eventRecord is a row of the events table.
+type eventRecord struct {
+	event       data.Event
+	lastError   string
+	publishedAt *time.Time
+	failedAt    *time.Time
+}
+
+// EventStore is the in-memory repository.EventStore.
+type EventStore struct {
+	s *store
+}
+
+func (e *EventStore) Insert(_ context.Context, event *data.Event) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	e.s.nextEventID++
+	event.ID = e.s.nextEventID
+	event.CreatedAt = time.Now()
+
+	stored := *event
+	stored.Data = slices.Clone(event.Data)
+	e.s.events = append(e.s.events, &eventRecord{event: stored})
+
+	return nil
+}
+
+// TryLock always gets the lock, as there is no other instance to share the store with.
+func (e *EventStore) TryLock(_ context.Context) (bool, error) {
+	return true, nil
+}
+
+func (e *EventStore) GetPending(_ context.Context, limit int) ([]*data.Event, error) {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	events := make([]*data.Event, 0)
+	for _, record := range e.s.events {
+		if len(events) == limit {
+			break
+		}
+		if record.publishedAt != nil || record.failedAt != nil {
+			continue
+		}
+
+		event := record.event
+		event.Data = slices.Clone(record.event.Data)
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+func (e *EventStore) MarkPublished(_ context.Context, ids []int64) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	now := time.Now()
+	for _, record := range e.s.events {
+		if slices.Contains(ids, record.event.ID) {
+			record.publishedAt = &now
+			record.lastError = ""
+		}
+	}
+
+	return nil
+}
+
+func (e *EventStore) MarkFailed(_ context.Context, id int64, reason string) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	for _, record := range e.s.events {
+		if record.event.ID == id {
+			record.event.Attempts++
+			record.lastError = reason
+		}
+	}
+
+	return nil
+}
+
+func (e *EventStore) MarkDead(_ context.Context, id int64, reason string) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	now := time.Now()
+	for _, record := range e.s.events {
+		if record.event.ID == id {
+			record.event.Attempts++
+			record.lastError = reason
+			record.failedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (e *EventStore) Purge(_ context.Context, before time.Time, unpublished bool) (int64, error) {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	n := len(e.s.events)
+	e.s.events = slices.DeleteFunc(e.s.events, func(record *eventRecord) bool {
+		return record.event.CreatedAt.Before(before) && (record.publishedAt != nil || record.failedAt != nil || unpublished)
+	})
+
+	return int64(n - len(e.s.events)), nil
+}
@@ -0,0 +1,199 @@
+// Package memory provides in-memory implementations of the repository store
+// interfaces. They keep just enough of the behaviour of the Postgres repositories
+// (sentinel errors, optimistic locking, expiry checks) for handlers to be exercised
+// without a database, and are not meant for production use.
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Make sure the fakes keep satisfying the store interfaces.
+var (
+	_ repository.AnimeStore      = (*AnimeStore)(nil)
+	_ repository.UserStore       = (*UserStore)(nil)
+	_ repository.TokenStore      = (*TokenStore)(nil)
+	_ repository.PermissionStore = (*PermissionStore)(nil)
+	_ repository.APIKeyStore     = (*APIKeyStore)(nil)
+	_ repository.AuditStore      = (*AuditStore)(nil)
+	_ repository.CharacterStore  = (*CharacterStore)(nil)
+	_ repository.WatchlistStore  = (*WatchlistStore)(nil)
+	_ repository.TrendingStore   = (*TrendingStore)(nil)
+	_ repository.EmailStore      = (*EmailStore)(nil)
+	_ repository.EventStore      = (*EventStore)(nil)
+)
+
+// rolePermissions mirrors the roles_permissions rows seeded by the migrations.
+var rolePermissions = map[string][]string{
+	data.RoleAdmin:     data.PermissionCodes(),
+	data.RoleModerator: {data.PermissionAnimeRead, data.PermissionAnimeWrite, data.PermissionTagsWrite},
+	data.RoleUser:      {data.PermissionAnimeRead},
+}
+
+type userRecord struct {
+	user           data.User
+	role           string
+	permissions    []string
+	failedAttempts int
+	lastFailedAt   time.Time
+	pendingEmail   string
+	deletedAt      *time.Time
+}
+
+type tokenRecord struct {
+	token      data.Token
+	id         int64
+	lastUsedAt *time.Time
+}
+
+// store holds the state shared by the fakes, guarded by a single mutex, so that the
+// fakes see each other's writes the same way the tables would.
+type store struct {
+	mu sync.Mutex
+
+	anime map[int32]*data.Anime
+	// deletedAnime holds the soft deleted anime, out of sight of every read.
+	deletedAnime map[int32]*data.Anime
+	revisions    map[int32][]*data.AnimeRevision
+	tags         []*data.Tag
+	studios      []*data.Studio
+	users        map[int64]*userRecord
+	tokens       []*tokenRecord
+	revoked      map[string]time.Time
+	permissions  []string
+	apiKeys      map[int64]*data.APIKey
+	audit        []*data.AuditEntry
+	characters   map[int32]*data.Character
+	people       map[int32]*data.Person
+	cast         map[int32][]*data.CastMember
+	watchlist    []*data.WatchlistEntry
+	activity     []*data.AnimeActivity
+	trending     []*data.TrendingAnime
+	emails       []*emailRecord
+	events       []*eventRecord
+
+	nextAnimeID  int32
+	nextTagID    int32
+	nextStudioID int32
+	nextUserID   int64
+	nextTokenID  int64
+	nextAPIKeyID int64
+	nextEmailID  int64
+	nextEventID  int64
+}
+
+// NewRepositories returns a set of stores backed by one shared in-memory state. The
+// permission registry is seeded, as main() would do against the database.
+func NewRepositories() repository.Repositories {
+	s := &store{
+		anime:        make(map[int32]*data.Anime),
+		deletedAnime: make(map[int32]*data.Anime),
+		revisions:    make(map[int32][]*data.AnimeRevision),
+		users:        make(map[int64]*userRecord),
+		revoked:      make(map[string]time.Time),
+		permissions:  data.PermissionCodes(),
+		apiKeys:      make(map[int64]*data.APIKey),
+		characters:   make(map[int32]*data.Character),
+		people:       make(map[int32]*data.Person),
+		cast:         make(map[int32][]*data.CastMember),
+	}
+
+	return repository.Repositories{
+		Anime:      &AnimeStore{s},
+		User:       &UserStore{s},
+		Token:      &TokenStore{s},
+		Permission: &PermissionStore{s},
+		APIKey:     &APIKeyStore{s},
+		Audit:      &AuditStore{s},
+		Character:  &CharacterStore{s},
+		Watchlist:  &WatchlistStore{s},
+		Trending:   &TrendingStore{s},
+		Email:      &EmailStore{s},
+		Event:      &EventStore{s},
+	}
+}
+
+// Transactor runs functions against the given repositories without any isolation. It
+// satisfies service.Transactor so that handlers using transactions work with the fakes.
+type Transactor struct {
+	Repos repository.Repositories
+}
+
+// WithinTx calls fn with the repositories. Writes are not rolled back on error.
+func (t Transactor) WithinTx(_ context.Context, fn func(repos repository.Repositories) error) error {
+	return fn(t.Repos)
+}
+
+// sortBy orders items on the column named by the filters, falling back on the ID, in
+// the same way as the ORDER BY clauses of the repositories.
+func sortBy[T any](items []T, filters data.Filters, compare func(a, b T, column string) int, id func(T) int64) {
+	less := lessBy(filters, compare, id)
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i], items[j])
+	})
+}
+
+// lessBy returns the ordering used by sortBy.
+func lessBy[T any](filters data.Filters, compare func(a, b T, column string) int, id func(T) int64) func(a, b T) bool {
+	column := filters.SortColumn()
+	desc := filters.SortDirection() == "DESC"
+
+	return func(a, b T) bool {
+		c := compare(a, b, column)
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			return id(a) < id(b)
+		}
+		return c < 0
+	}
+}
+
+// keyset returns the rows past the cursor of the filters, from items sorted by sortBy,
+// in the shape the keyset queries of the repositories return them: closest to the
+// cursor first, with one extra row to detect further pages. at is the row at the
+// cursor position.
+func keyset[T any](items []T, filters data.Filters, at T, less func(a, b T) bool) []T {
+	cursor := filters.Cursor
+
+	var rows []T
+	if cursor.Backward {
+		for _, item := range slices.Backward(items) {
+			if less(item, at) {
+				rows = append(rows, item)
+			}
+		}
+	} else {
+		for _, item := range items {
+			if cursor.IsStart() || less(at, item) {
+				rows = append(rows, item)
+			}
+		}
+	}
+
+	return rows[:min(len(rows), filters.Limit()+1)]
+}
+
+// paginate returns the page of items selected by the filters, with its metadata.
+func paginate[T any](items []T, filters data.Filters) ([]T, data.Metadata) {
+	var metadata data.Metadata
+	metadata.CalculateMetadata(len(items), filters.Page, filters.PageSize)
+
+	start := min(filters.Offset(), len(items))
+	end := min(start+filters.Limit(), len(items))
+
+	return slices.Clone(items[start:end]), metadata
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+)
+
+// PermissionStore is the in-memory repository.PermissionStore.
+type PermissionStore struct {
+	s *store
+}
+
+func (p *PermissionStore) GetAllForUser(_ context.Context, userID int64) (data.Permissions, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	record, ok := p.s.users[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	return slices.Clone(record.permissions), nil
+}
+
+func (p *PermissionStore) GetAllForUsers(_ context.Context, userIDs []int64) (map[int64]data.Permissions, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	permissions := make(map[int64]data.Permissions)
+	for _, id := range userIDs {
+		if record, ok := p.s.users[id]; ok && len(record.permissions) > 0 {
+			permissions[id] = slices.Clone(record.permissions)
+		}
+	}
+
+	return permissions, nil
+}
+
+func (p *PermissionStore) AddForUser(_ context.Context, userID int64, codes ...string) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	record, ok := p.s.users[userID]
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	for _, code := range codes {
+		if !slices.Contains(p.s.permissions, code) {
+			continue
+		}
+		if slices.Contains(record.permissions, code) {
+			return repository.ErrDuplicateEntry
+		}
+
+		record.permissions = append(record.permissions, code)
+	}
+
+	return nil
+}
+
+func (p *PermissionStore) Seed(_ context.Context, codes ...string) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	for _, code := range codes {
+		if !slices.Contains(p.s.permissions, code) {
+			p.s.permissions = append(p.s.permissions, code)
+		}
+	}
+
+	return nil
+}
+
+func (p *PermissionStore) AssignRole(_ context.Context, userID int64, role string) (data.Permissions, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	record, ok := p.s.activeUser(userID)
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	record.role = role
+	record.permissions = slices.Clone(rolePermissions[role])
+
+	return slices.Clone(record.permissions), nil
+}
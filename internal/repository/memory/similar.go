@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"math"
+	"slices"
+)
+
+func (a *AnimeStore) GetSimilar(_ context.Context, id int32, limit int) ([]*data.SimilarAnime, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	similar := make([]*data.SimilarAnime, 0)
+
+	source, ok := a.s.anime[id]
+	if !ok {
+		return similar, nil
+	}
+
+	counts := make(map[string]int)
+	for _, anime := range a.s.anime {
+		for _, tag := range anime.Tags {
+			counts[tag]++
+		}
+	}
+
+	weight := func(tag string) float64 {
+		return math.Log(1 + float64(len(a.s.anime))/float64(counts[tag]))
+	}
+
+	for _, anime := range a.s.anime {
+		if anime.ID == id {
+			continue
+		}
+
+		match := &data.SimilarAnime{
+			ID:       anime.ID,
+			Title:    anime.Title,
+			Type:     anime.Type,
+			Status:   anime.Status,
+			Year:     anime.Year,
+			CoverURL: anime.CoverURL,
+		}
+		for _, tag := range source.Tags {
+			if slices.Contains(anime.Tags, tag) {
+				match.Score += weight(tag)
+				match.SharedTags = append(match.SharedTags, tag)
+			}
+		}
+		if len(match.SharedTags) == 0 {
+			continue
+		}
+
+		slices.SortFunc(match.SharedTags, func(x, y string) int {
+			if c := cmp.Compare(weight(y), weight(x)); c != 0 {
+				return c
+			}
+			return cmp.Compare(x, y)
+		})
+
+		similar = append(similar, match)
+	}
+
+	slices.SortFunc(similar, func(x, y *data.SimilarAnime) int {
+		if c := cmp.Compare(y.Score, x.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.ID, y.ID)
+	})
+
+	return similar[:min(len(similar), limit)], nil
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"slices"
+	"time"
+)
+
+func (a *AnimeStore) GetStats(_ context.Context, topTags int) (*data.CatalogStats, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	byType := make(map[string]int64)
+	byStatus := make(map[string]int64)
+	bySeason := make(map[string]int64)
+	byDecade := make(map[string]int64)
+	byTag := make(map[string]int64)
+
+	for _, anime := range a.s.anime {
+		byType[string(anime.Type)]++
+		byStatus[string(anime.Status)]++
+
+		season := data.StatUnknown
+		if anime.Season != nil {
+			season = string(*anime.Season)
+		}
+		bySeason[season]++
+
+		decade := data.StatUnknown
+		if anime.Year != nil {
+			decade = data.Decade(*anime.Year)
+		}
+		byDecade[decade]++
+
+		for _, tag := range anime.Tags {
+			byTag[tag]++
+		}
+	}
+
+	tags := statCounts(byTag)
+
+	return &data.CatalogStats{
+		Total:      int64(len(a.s.anime)),
+		ByType:     statCounts(byType),
+		ByStatus:   statCounts(byStatus),
+		BySeason:   statCounts(bySeason),
+		ByDecade:   statCounts(byDecade),
+		TopTags:    tags[:min(len(tags), topTags)],
+		ComputedAt: time.Now(),
+	}, nil
+}
+
+// statCounts turns the counts into the order of the repository: largest first, then
+// by key.
+func statCounts(counts map[string]int64) []data.StatCount {
+	stats := make([]data.StatCount, 0, len(counts))
+	for key, count := range counts {
+		stats = append(stats, data.StatCount{Key: key, Count: count})
+	}
+
+	slices.SortFunc(stats, func(a, b data.StatCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return stats
+}
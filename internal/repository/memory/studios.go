@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+)
+
+func (a *AnimeStore) GetAllStudios(_ context.Context) ([]string, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	names := make([]string, len(a.s.studios))
+	for i, studio := range a.s.studios {
+		names[i] = studio.Name
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+func (a *AnimeStore) InsertStudio(_ context.Context, studio *data.Studio) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if a.s.studioIndex(studio.Name) >= 0 {
+		return repository.ErrDuplicateEntry
+	}
+
+	a.s.nextStudioID++
+	studio.ID = a.s.nextStudioID
+	a.s.studios = append(a.s.studios, &data.Studio{ID: studio.ID, Name: studio.Name})
+
+	return nil
+}
+
+func (a *AnimeStore) GetStudio(_ context.Context, name string) (*data.Studio, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	i := a.s.studioIndex(name)
+	if i < 0 {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	studio := *a.s.studios[i]
+	return &studio, nil
+}
+
+func (a *AnimeStore) UpdateStudio(_ context.Context, studio *data.Studio) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	current := a.s.studioByID(studio.ID)
+	if current == nil {
+		return repository.ErrRecordNotFound
+	}
+
+	if i := a.s.studioIndex(studio.Name); i >= 0 && a.s.studios[i].ID != studio.ID {
+		return repository.ErrDuplicateEntry
+	}
+
+	a.s.eachAnime(func(anime *data.Anime) {
+		for i, name := range anime.Studios {
+			if name == current.Name {
+				anime.Studios[i] = studio.Name
+			}
+		}
+	})
+
+	current.Name = studio.Name
+
+	return nil
+}
+
+func (a *AnimeStore) DeleteStudio(_ context.Context, id int32, force bool) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	studio := a.s.studioByID(id)
+	if studio == nil {
+		return repository.ErrRecordNotFound
+	}
+
+	inUse := false
+	a.s.eachAnime(func(anime *data.Anime) {
+		inUse = inUse || slices.Contains(anime.Studios, studio.Name)
+	})
+
+	if inUse && !force {
+		return repository.ErrRecordInUse
+	}
+
+	a.s.eachAnime(func(anime *data.Anime) {
+		anime.Studios = slices.DeleteFunc(anime.Studios, func(name string) bool { return name == studio.Name })
+	})
+
+	a.s.studios = slices.DeleteFunc(a.s.studios, func(s *data.Studio) bool { return s.ID == id })
+
+	return nil
+}
+
+func (s *store) upsertStudios(studios []string) {
+	for _, name := range studios {
+		if s.studioIndex(name) < 0 {
+			s.nextStudioID++
+			s.studios = append(s.studios, &data.Studio{ID: s.nextStudioID, Name: name})
+		}
+	}
+}
+
+// studioIndex returns the position of the named studio, or -1.
+func (s *store) studioIndex(name string) int {
+	return slices.IndexFunc(s.studios, func(studio *data.Studio) bool { return studio.Name == name })
+}
+
+func (s *store) studioByID(id int32) *data.Studio {
+	i := slices.IndexFunc(s.studios, func(studio *data.Studio) bool { return studio.ID == id })
+	if i < 0 {
+		return nil
+	}
+
+	return s.studios[i]
+}
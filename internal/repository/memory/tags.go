@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"time"
+)
+
+func (a *AnimeStore) InsertTag(_ context.Context, tag *data.Tag) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if a.s.tagIndex(tag.Name) >= 0 {
+		return repository.ErrDuplicateEntry
+	}
+
+	a.s.nextTagID++
+	tag.ID = a.s.nextTagID
+	a.s.tags = append(a.s.tags, &data.Tag{ID: tag.ID, Name: tag.Name})
+
+	return nil
+}
+
+func (a *AnimeStore) GetTag(_ context.Context, name string) (*data.Tag, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	i := a.s.tagIndex(name)
+	if i < 0 {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	tag := *a.s.tags[i]
+	return &tag, nil
+}
+
+// UpdateTag renames the tag, and the tag on every anime, which the join does in Postgres.
+func (a *AnimeStore) UpdateTag(_ context.Context, tag *data.Tag) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	current := a.s.tagByID(tag.ID)
+	if current == nil {
+		return repository.ErrRecordNotFound
+	}
+
+	if i := a.s.tagIndex(tag.Name); i >= 0 && a.s.tags[i].ID != tag.ID {
+		return repository.ErrDuplicateEntry
+	}
+
+	a.s.eachAnime(func(anime *data.Anime) {
+		for i, name := range anime.Tags {
+			if name == current.Name {
+				anime.Tags[i] = tag.Name
+				touch(anime)
+			}
+		}
+	})
+
+	current.Name = tag.Name
+
+	return nil
+}
+
+func (a *AnimeStore) DeleteTag(_ context.Context, id int32, force bool) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	tag := a.s.tagByID(id)
+	if tag == nil {
+		return repository.ErrRecordNotFound
+	}
+
+	inUse := false
+	a.s.eachAnime(func(anime *data.Anime) {
+		inUse = inUse || slices.Contains(anime.Tags, tag.Name)
+	})
+
+	if inUse && !force {
+		return repository.ErrRecordInUse
+	}
+
+	onlyTag := false
+	a.s.eachAnime(func(anime *data.Anime) {
+		onlyTag = onlyTag || (len(anime.Tags) == 1 && anime.Tags[0] == tag.Name)
+	})
+	if onlyTag {
+		return repository.ErrOnlyTag
+	}
+
+	a.s.eachAnime(func(anime *data.Anime) {
+		if slices.Contains(anime.Tags, tag.Name) {
+			anime.Tags = slices.DeleteFunc(anime.Tags, func(name string) bool { return name == tag.Name })
+			touch(anime)
+		}
+	})
+
+	a.s.tags = slices.DeleteFunc(a.s.tags, func(t *data.Tag) bool { return t.ID == id })
+
+	return nil
+}
+
+func (a *AnimeStore) MergeTags(_ context.Context, sourceID, targetID int32) (int64, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	source, target := a.s.tagByID(sourceID), a.s.tagByID(targetID)
+	if source == nil || target == nil {
+		return 0, repository.ErrRecordNotFound
+	}
+
+	var moved int64
+	a.s.eachAnime(func(anime *data.Anime) {
+		if !slices.Contains(anime.Tags, source.Name) {
+			return
+		}
+
+		moved++
+		touch(anime)
+		anime.Tags = slices.DeleteFunc(anime.Tags, func(name string) bool { return name == source.Name })
+		if !slices.Contains(anime.Tags, target.Name) {
+			anime.Tags = append(anime.Tags, target.Name)
+			slices.Sort(anime.Tags)
+		}
+	})
+
+	a.s.tags = slices.DeleteFunc(a.s.tags, func(t *data.Tag) bool { return t.ID == sourceID })
+
+	return moved, nil
+}
+
+// touch bumps the version and updated_at of an anime whose tags changed along with a
+// tag, as the Postgres repository does.
+func touch(anime *data.Anime) {
+	anime.UpdatedAt = time.Now()
+	anime.Version++
+}
+
+// tagIndex returns the position of the named tag, or -1.
+func (s *store) tagIndex(name string) int {
+	return slices.IndexFunc(s.tags, func(t *data.Tag) bool { return t.Name == name })
+}
+
+func (s *store) tagByID(id int32) *data.Tag {
+	i := slices.IndexFunc(s.tags, func(t *data.Tag) bool { return t.ID == id })
+	if i < 0 {
+		return nil
+	}
+
+	return s.tags[i]
+}
+
+// eachAnime calls fn for every stored anime, soft deleted ones included, as anime_tags
+// rows are kept for those too.
+func (s *store) eachAnime(fn func(anime *data.Anime)) {
+	for _, set := range []map[int32]*data.Anime{s.anime, s.deletedAnime} {
+		for _, anime := range set {
+			fn(anime)
+		}
+	}
+}
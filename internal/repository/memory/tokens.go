@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"time"
+)
+
+// TokenStore is the in-memory repository.TokenStore.
+type TokenStore struct {
+	s *store
+}
+
+func (t *TokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string, issuer data.TokenIssuer) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientIP = issuer.ClientIP
+	token.UserAgent = issuer.UserAgent
+
+	err = t.Insert(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (t *TokenStore) Insert(_ context.Context, token *data.Token) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	token.CreatedAt = time.Now()
+
+	t.s.nextTokenID++
+	record := &tokenRecord{token: *token, id: t.s.nextTokenID}
+	record.token.Plaintext = ""
+	t.s.tokens = append(t.s.tokens, record)
+
+	return nil
+}
+
+func (t *TokenStore) DeleteAllForUser(_ context.Context, scope string, userID int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	t.s.deleteTokens(func(r *tokenRecord) bool { return r.token.Scope == scope && r.token.UserID == userID })
+
+	return nil
+}
+
+func (t *TokenStore) Delete(_ context.Context, scope, tokenPlaintext string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	t.s.deleteTokens(func(r *tokenRecord) bool { return r.token.Scope == scope && slices.Equal(r.token.Hash, tokenHash[:]) })
+
+	return nil
+}
+
+func (t *TokenStore) DeleteExpired(_ context.Context, before time.Time, limit int) (int64, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	n := 0
+	deleted := t.s.deleteTokens(func(r *tokenRecord) bool {
+		if n < limit && r.token.Expiry.Before(before) {
+			n++
+			return true
+		}
+		return false
+	})
+
+	n = 0
+	for jti, expiry := range t.s.revoked {
+		if n == limit {
+			break
+		}
+		if expiry.Before(before) {
+			delete(t.s.revoked, jti)
+			n++
+		}
+	}
+
+	return int64(deleted + n), nil
+}
+
+func (t *TokenStore) Revoke(_ context.Context, jti string, _ int64, expiry time.Time) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.revoked[jti]; !ok {
+		t.s.revoked[jti] = expiry
+	}
+
+	return nil
+}
+
+func (t *TokenStore) IsRevoked(_ context.Context, jti string, userID int64, tokenVersion int) (bool, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.revoked[jti]; ok {
+		return true, nil
+	}
+
+	record, ok := t.s.activeUser(userID)
+	return !ok || record.user.TokenVersion != tokenVersion, nil
+}
+
+func (t *TokenStore) Touch(_ context.Context, tokenPlaintext string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if record, ok := t.s.findToken(tokenPlaintext); ok && record.token.Scope == data.ScopeAuthentication {
+		now := time.Now()
+		if record.lastUsedAt == nil || record.lastUsedAt.Before(now.Add(-time.Minute)) {
+			record.lastUsedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (t *TokenStore) GetSessionsForUser(_ context.Context, userID int64) ([]*data.Session, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	sessions := make([]*data.Session, 0)
+	for _, record := range slices.Backward(t.s.tokens) {
+		token := record.token
+		if token.UserID != userID || token.Scope != data.ScopeAuthentication || !token.Expiry.After(time.Now()) {
+			continue
+		}
+
+		sessions = append(sessions, &data.Session{
+			ID:         record.id,
+			CreatedAt:  token.CreatedAt,
+			Expiry:     token.Expiry,
+			ClientIP:   token.ClientIP,
+			UserAgent:  token.UserAgent,
+			LastUsedAt: record.lastUsedAt,
+			Hash:       slices.Clone(token.Hash),
+		})
+	}
+
+	return sessions, nil
+}
+
+func (t *TokenStore) DeleteSession(_ context.Context, id, userID int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	n := t.s.deleteTokens(func(r *tokenRecord) bool {
+		return r.id == id && r.token.UserID == userID && r.token.Scope == data.ScopeAuthentication
+	})
+	if n == 0 {
+		return repository.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (t *TokenStore) LastIssued(_ context.Context, userID int64, scope string) (*time.Time, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	var issuedAt *time.Time
+	for _, record := range t.s.tokens {
+		if record.token.UserID == userID && record.token.Scope == scope &&
+			(issuedAt == nil || record.token.CreatedAt.After(*issuedAt)) {
+			createdAt := record.token.CreatedAt
+			issuedAt = &createdAt
+		}
+	}
+
+	return issuedAt, nil
+}
+
+func (t *TokenStore) GetIssuance(_ context.Context, tokenPlaintext string) (*data.Token, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	record, ok := t.s.findToken(tokenPlaintext)
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	token := record.token
+	return &token, nil
+}
+
+func (s *store) findToken(tokenPlaintext string) (*tokenRecord, bool) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	for _, record := range s.tokens {
+		if slices.Equal(record.token.Hash, tokenHash[:]) {
+			return record, true
+		}
+	}
+
+	return nil, false
+}
+
+// deleteTokens removes the tokens matching the predicate, returning how many were removed.
+func (s *store) deleteTokens(match func(*tokenRecord) bool) int {
+	before := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, match)
+	return before - len(s.tokens)
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"slices"
+	"time"
+)
+
+// TrendingStore is the in-memory repository.TrendingStore.
+type TrendingStore struct {
+	s *store
+}
+
+func (t *TrendingStore) RecordActivity(_ context.Context, activity []*data.AnimeActivity) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	for _, a := range activity {
+		_, live := t.s.anime[a.AnimeID]
+		_, deleted := t.s.deletedAnime[a.AnimeID]
+		if !live && !deleted {
+			continue
+		}
+
+		i := slices.IndexFunc(t.s.activity, func(stored *data.AnimeActivity) bool {
+			return stored.AnimeID == a.AnimeID && stored.Bucket.Equal(a.Bucket)
+		})
+		if i < 0 {
+			stored := *a
+			t.s.activity = append(t.s.activity, &stored)
+			continue
+		}
+
+		t.s.activity[i].Views += a.Views
+		t.s.activity[i].WatchlistAdds += a.WatchlistAdds
+	}
+
+	return nil
+}
+
+func (t *TrendingStore) Refresh(_ context.Context, window time.Duration) (int64, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	now := time.Now()
+	since := now.Add(-window)
+
+	t.s.activity = slices.DeleteFunc(t.s.activity, func(a *data.AnimeActivity) bool { return a.Bucket.Before(since) })
+
+	totals := make(map[int32]*data.TrendingAnime)
+	for _, a := range t.s.activity {
+		total, ok := totals[a.AnimeID]
+		if !ok {
+			total = &data.TrendingAnime{ID: a.AnimeID}
+			totals[a.AnimeID] = total
+		}
+
+		total.Score += float64(a.Views*data.TrendingViewWeight+a.WatchlistAdds*data.TrendingWatchlistAddWeight) * data.TrendingWeight(now.Sub(a.Bucket))
+		total.Views += int64(a.Views)
+		total.WatchlistAdds += int64(a.WatchlistAdds)
+	}
+
+	t.s.trending = make([]*data.TrendingAnime, 0, len(totals))
+	for _, total := range totals {
+		t.s.trending = append(t.s.trending, total)
+	}
+
+	return int64(len(t.s.trending)), nil
+}
+
+func (t *TrendingStore) GetTrending(_ context.Context, filters data.Filters) ([]*data.TrendingAnime, data.Metadata, error) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	trending := make([]*data.TrendingAnime, 0)
+	for _, stored := range t.s.trending {
+		anime, ok := t.s.anime[stored.ID]
+		if !ok {
+			continue
+		}
+
+		entry := *stored
+		entry.Title = anime.Title
+		entry.Type = anime.Type
+		entry.Status = anime.Status
+		entry.Year = anime.Year
+		entry.CoverURL = anime.CoverURL
+
+		trending = append(trending, &entry)
+	}
+
+	slices.SortFunc(trending, func(a, b *data.TrendingAnime) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	page, metadata := paginate(trending, filters)
+	for i, entry := range page {
+		entry.Rank = filters.Offset() + i + 1
+	}
+
+	return page, metadata, nil
+}
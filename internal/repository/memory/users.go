@@ -0,0 +1,337 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserStore is the in-memory repository.UserStore.
+type UserStore struct {
+	s *store
+}
+
+func (u *UserStore) Insert(_ context.Context, user *data.User) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if u.s.emailTaken(user.Email, 0) {
+		return repository.ErrDuplicateEntry
+	}
+
+	u.s.nextUserID++
+	user.ID = u.s.nextUserID
+	user.CreatedAt = time.Now()
+	user.Version = 1
+	user.TokenVersion = 1
+
+	u.s.users[user.ID] = &userRecord{user: *user, role: data.RoleUser}
+
+	return nil
+}
+
+func (u *UserStore) Get(_ context.Context, id int64) (*data.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	record, ok := u.s.activeUser(id)
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	user := record.user
+	return &user, nil
+}
+
+func (u *UserStore) GetByEmail(_ context.Context, email string) (*data.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for _, record := range u.s.users {
+		if record.deletedAt == nil && strings.EqualFold(record.user.Email, email) {
+			user := record.user
+			return &user, nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (u *UserStore) Update(_ context.Context, user *data.User) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	record, ok := u.s.users[user.ID]
+	if !ok || record.user.Version != user.Version {
+		return repository.ErrEditConflict
+	}
+
+	if u.s.emailTaken(user.Email, user.ID) {
+		return repository.ErrDuplicateEntry
+	}
+
+	user.Version++
+
+	// As the token version of the table, see UserRepository.Update.
+	user.TokenVersion = record.user.TokenVersion
+	if !bytes.Equal(user.Hash(), record.user.Hash()) || !strings.EqualFold(user.Email, record.user.Email) || (record.user.Activated && !user.Activated) {
+		user.TokenVersion++
+	}
+
+	lockedUntil := record.user.LockedUntil
+	record.user = *user
+	record.user.LockedUntil = lockedUntil
+
+	return nil
+}
+
+func (u *UserStore) GetForToken(_ context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	for _, t := range u.s.tokens {
+		if slices.Equal(t.token.Hash, tokenHash[:]) && t.token.Scope == tokenScope && t.token.Expiry.After(time.Now()) {
+			record, ok := u.s.activeUser(t.token.UserID)
+			if !ok {
+				break
+			}
+
+			user := record.user
+			user.LockedUntil = nil
+			return &user, nil
+		}
+	}
+
+	return nil, repository.ErrRecordNotFound
+}
+
+func (u *UserStore) GetAll(_ context.Context, email string, activated *bool, filters data.Filters) ([]*data.User, data.Metadata, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	var matched []*data.User
+	for _, record := range u.s.users {
+		if record.deletedAt != nil {
+			continue
+		}
+		if email != "" && !containsFold(record.user.Email, email) {
+			continue
+		}
+		if activated != nil && record.user.Activated != *activated {
+			continue
+		}
+
+		user := record.user
+		matched = append(matched, &user)
+	}
+
+	sortBy(matched, filters, compareUsers, func(u *data.User) int64 { return u.ID })
+	page, metadata := paginate(matched, filters)
+
+	return page, metadata, nil
+}
+
+func (u *UserStore) RecordFailedLogin(_ context.Context, id int64, maxAttempts int, lockout time.Duration) (*time.Time, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	record, ok := u.s.users[id]
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	now := time.Now()
+	if now.Sub(record.lastFailedAt) >= lockout {
+		record.failedAttempts = 0
+	}
+	record.lastFailedAt = now
+
+	record.failedAttempts++
+	if record.failedAttempts >= maxAttempts {
+		record.failedAttempts = 0
+		lockedUntil := now.Add(lockout)
+		record.user.LockedUntil = &lockedUntil
+	}
+
+	return record.user.LockedUntil, nil
+}
+
+func (u *UserStore) ResetFailedLogins(_ context.Context, id int64) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if record, ok := u.s.users[id]; ok {
+		record.failedAttempts = 0
+		record.lastFailedAt = time.Time{}
+		record.user.LockedUntil = nil
+	}
+
+	return nil
+}
+
+func (u *UserStore) SetPendingEmail(_ context.Context, id int64, email string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if record, ok := u.s.users[id]; ok {
+		record.pendingEmail = email
+	}
+
+	return nil
+}
+
+func (u *UserStore) ConfirmPendingEmail(_ context.Context, user *data.User) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	record, ok := u.s.users[user.ID]
+	if !ok || record.user.Version != user.Version || record.pendingEmail == "" {
+		return repository.ErrEditConflict
+	}
+
+	if u.s.emailTaken(record.pendingEmail, user.ID) {
+		return repository.ErrDuplicateEntry
+	}
+
+	record.user.Email = record.pendingEmail
+	record.user.Version++
+	record.user.TokenVersion++
+	record.pendingEmail = ""
+
+	user.Email = record.user.Email
+	user.Version = record.user.Version
+	user.TokenVersion = record.user.TokenVersion
+
+	return nil
+}
+
+func (u *UserStore) Delete(_ context.Context, id int64) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if _, ok := u.s.users[id]; !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	u.s.purge(id)
+
+	return nil
+}
+
+func (u *UserStore) SoftDelete(_ context.Context, id int64) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	record, ok := u.s.activeUser(id)
+	if !ok {
+		return repository.ErrRecordNotFound
+	}
+
+	now := time.Now()
+	record.deletedAt = &now
+
+	u.s.deleteTokens(func(t *tokenRecord) bool { return t.token.UserID == id })
+	u.s.deleteAPIKeys(id)
+
+	return nil
+}
+
+func (u *UserStore) PurgeDeleted(_ context.Context, before time.Time) (int64, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	var n int64
+	for id, record := range u.s.users {
+		if record.deletedAt != nil && record.deletedAt.Before(before) {
+			u.s.purge(id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (u *UserStore) PurgeUnactivated(_ context.Context, before time.Time) (int64, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	var n int64
+	for id, record := range u.s.users {
+		if !record.user.Activated && record.user.CreatedAt.Before(before) {
+			u.s.purge(id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// activeUser returns the user with the given ID, unless it has been soft deleted.
+func (s *store) activeUser(id int64) (*userRecord, bool) {
+	record, ok := s.users[id]
+	if !ok || record.deletedAt != nil {
+		return nil, false
+	}
+
+	return record, true
+}
+
+// emailTaken mimics the case-insensitive UNIQUE constraint on users.email.
+func (s *store) emailTaken(email string, exceptID int64) bool {
+	for id, record := range s.users {
+		if id != exceptID && strings.EqualFold(record.user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *store) purge(id int64) {
+	s.deleteTokens(func(t *tokenRecord) bool { return t.token.UserID == id })
+	s.deleteAPIKeys(id)
+	s.deleteWatchlist(func(e *data.WatchlistEntry) bool { return e.UserID == id })
+
+	// The emails to the user, and the snapshots of their account, go as well.
+	if record, ok := s.users[id]; ok {
+		s.emails = slices.DeleteFunc(s.emails, func(e *emailRecord) bool {
+			return strings.EqualFold(e.email.Recipient, record.user.Email) ||
+				(record.pendingEmail != "" && strings.EqualFold(e.email.Recipient, record.pendingEmail))
+		})
+	}
+	for _, entry := range s.audit {
+		if entry.Entity == data.AuditEntityUser && entry.EntityID == id {
+			entry.Before, entry.After = nil, nil
+		}
+	}
+	for _, record := range s.events {
+		if record.event.Entity == data.AuditEntityUser && record.event.EntityID == id {
+			record.event.Data = json.RawMessage(fmt.Sprintf(`{"id": %d}`, id))
+		}
+	}
+
+	delete(s.users, id)
+}
+
+func compareUsers(a, b *data.User, column string) int {
+	switch column {
+	case "name":
+		return cmp.Compare(a.Name, b.Name)
+	case "email":
+		return cmp.Compare(strings.ToLower(a.Email), strings.ToLower(b.Email))
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"github.com/ziliscite/purplelight/internal/repository"
+	"slices"
+	"time"
+)
+
+// WatchlistStore is the in-memory repository.WatchlistStore.
+type WatchlistStore struct {
+	s *store
+}
+
+func (l *WatchlistStore) Get(_ context.Context, userID int64, animeID int32) (*data.WatchlistEntry, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	i := l.s.watchlistIndex(userID, animeID)
+	if i < 0 {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	entry, ok := l.s.watchlistEntry(l.s.watchlist[i])
+	if !ok {
+		return nil, repository.ErrRecordNotFound
+	}
+
+	return entry, nil
+}
+
+func (l *WatchlistStore) GetAll(_ context.Context, userID int64, status string, filters data.Filters) ([]*data.WatchlistEntry, data.Metadata, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	matched := make([]*data.WatchlistEntry, 0)
+	for _, stored := range l.s.watchlist {
+		if stored.UserID != userID || (status != "" && stored.Status != status) {
+			continue
+		}
+
+		entry, ok := l.s.watchlistEntry(stored)
+		if !ok {
+			continue
+		}
+
+		matched = append(matched, entry)
+	}
+
+	sortBy(matched, filters, compareWatchlist, func(e *data.WatchlistEntry) int64 { return int64(e.AnimeID) })
+	page, metadata := paginate(matched, filters)
+
+	return page, metadata, nil
+}
+
+func (l *WatchlistStore) GetWatcherIDs(_ context.Context, animeID int32) ([]int64, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	ids := make([]int64, 0)
+	for _, stored := range l.s.watchlist {
+		if stored.AnimeID == animeID && stored.Status != data.WatchStatusDropped {
+			ids = append(ids, stored.UserID)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func (l *WatchlistStore) Insert(_ context.Context, entry *data.WatchlistEntry) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	if _, ok := l.s.anime[entry.AnimeID]; !ok {
+		if _, ok := l.s.deletedAnime[entry.AnimeID]; !ok {
+			return repository.ErrForeignKeyViolation
+		}
+	}
+
+	if l.s.watchlistIndex(entry.UserID, entry.AnimeID) >= 0 {
+		return repository.ErrDuplicateEntry
+	}
+
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+	entry.Version = 1
+
+	stored := *entry
+	l.s.watchlist = append(l.s.watchlist, &stored)
+
+	return nil
+}
+
+func (l *WatchlistStore) Update(_ context.Context, entry *data.WatchlistEntry) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	i := l.s.watchlistIndex(entry.UserID, entry.AnimeID)
+	if i < 0 || l.s.watchlist[i].Version != entry.Version {
+		return repository.ErrEditConflict
+	}
+
+	stored := l.s.watchlist[i]
+	stored.Status = entry.Status
+	stored.EpisodesWatched = entry.EpisodesWatched
+	stored.UpdatedAt = time.Now()
+	stored.Version++
+
+	entry.UpdatedAt = stored.UpdatedAt
+	entry.Version = stored.Version
+
+	return nil
+}
+
+func (l *WatchlistStore) Delete(_ context.Context, userID int64, animeID int32) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	i := l.s.watchlistIndex(userID, animeID)
+	if i < 0 {
+		return repository.ErrRecordNotFound
+	}
+
+	l.s.watchlist = slices.Delete(l.s.watchlist, i, i+1)
+
+	return nil
+}
+
+func (s *store) watchlistIndex(userID int64, animeID int32) int {
+	return slices.IndexFunc(s.watchlist, func(e *data.WatchlistEntry) bool {
+		return e.UserID == userID && e.AnimeID == animeID
+	})
+}
+
+// watchlistEntry returns a copy of the entry with the title and episodes of its anime,
+// or false if the anime has been soft deleted, like the join of the repository.
+func (s *store) watchlistEntry(stored *data.WatchlistEntry) (*data.WatchlistEntry, bool) {
+	anime, ok := s.anime[stored.AnimeID]
+	if !ok {
+		return nil, false
+	}
+
+	entry := *stored
+	entry.Title = anime.Title
+	entry.Episodes = anime.Episodes
+
+	return &entry, true
+}
+
+// deleteWatchlist removes the watchlist entries matching the predicate, the way the
+// foreign keys cascade.
+func (s *store) deleteWatchlist(match func(e *data.WatchlistEntry) bool) {
+	s.watchlist = slices.DeleteFunc(s.watchlist, match)
+}
+
+func compareWatchlist(a, b *data.WatchlistEntry, column string) int {
+	switch column {
+	case "title":
+		return cmp.Compare(a.Title, b.Title)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "episodes_watched":
+		return cmp.Compare(a.EpisodesWatched, b.EpisodesWatched)
+	default:
+		return cmp.Compare(a.AnimeID, b.AnimeID)
+	}
+}
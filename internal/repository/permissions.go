@@ -2,20 +2,22 @@ package repository
 
 import (
 	"context"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/ziliscite/purplelight/internal/data"
-	"time"
 )
 
 type PermissionRepository struct {
-	db     *pgxpool.Pool
-	logger *dbLogger
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
 }
 
-func NewPermissionRepository(db *pgxpool.Pool, logger *dbLogger) PermissionRepository {
+func NewPermissionRepository(db DBTX, logger *dbLogger, timeouts Timeouts) PermissionRepository {
 	return PermissionRepository{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
 	}
 }
 
@@ -23,7 +25,7 @@ func NewPermissionRepository(db *pgxpool.Pool, logger *dbLogger) PermissionRepos
 // Permissions slice. The code in this method should feel very familiar --- it uses the
 // standard pattern that we've already seen before for retrieving multiple data rows in
 // an SQL query.
-func (p PermissionRepository) GetAllForUser(userID int64) (data.Permissions, error) {
+func (p PermissionRepository) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
 	query := `
         SELECT p.code
         FROM permissions p
@@ -32,12 +34,12 @@ func (p PermissionRepository) GetAllForUser(userID int64) (data.Permissions, err
         WHERE u.id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Query)
 	defer cancel()
 
 	rows, err := p.db.Query(ctx, query, userID)
 	if err != nil {
-		return nil, p.logger.handleError(err)
+		return nil, p.logger.handleError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -48,13 +50,52 @@ func (p PermissionRepository) GetAllForUser(userID int64) (data.Permissions, err
 
 		err = rows.Scan(&permission)
 		if err != nil {
-			return nil, p.logger.handleError(err)
+			return nil, p.logger.handleError(ctx, err)
 		}
 
 		permissions = append(permissions, permission)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, p.logger.handleError(err)
+		return nil, p.logger.handleError(ctx, err)
+	}
+
+	return permissions, nil
+}
+
+// GetAllForUsers returns the permission codes of several users in one query, keyed by
+// user ID. The users without any permission are left out.
+func (p PermissionRepository) GetAllForUsers(ctx context.Context, userIDs []int64) (map[int64]data.Permissions, error) {
+	query := `
+        SELECT up.user_id, p.code
+        FROM permissions p
+        INNER JOIN users_permissions up ON up.permission_id = p.id
+        WHERE up.user_id = ANY($1)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Query)
+	defer cancel()
+
+	rows, err := p.db.Query(ctx, query, userIDs)
+	if err != nil {
+		return nil, p.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	permissions := make(map[int64]data.Permissions)
+
+	for rows.Next() {
+		var userID int64
+		var permission string
+
+		err = rows.Scan(&userID, &permission)
+		if err != nil {
+			return nil, p.logger.handleError(ctx, err)
+		}
+
+		permissions[userID] = append(permissions[userID], permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, p.logger.handleError(ctx, err)
 	}
 
 	return permissions, nil
@@ -63,19 +104,106 @@ func (p PermissionRepository) GetAllForUser(userID int64) (data.Permissions, err
 // AddForUser Add the provided permission codes for a specific user. Notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
 // single call.
-func (p PermissionRepository) AddForUser(userID int64, codes ...string) error {
+func (p PermissionRepository) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
         INSERT INTO users_permissions
         SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Query)
 	defer cancel()
 
 	_, err := p.db.Exec(ctx, query, userID, codes)
 	if err != nil {
-		return p.logger.handleError(err)
+		return p.logger.handleError(ctx, err)
 	}
 
 	return nil
 }
+
+// Seed makes sure every given permission code exists in the permissions table. It is
+// safe to call repeatedly, so it's used at startup to keep the table in sync with the
+// permission registry.
+func (p PermissionRepository) Seed(ctx context.Context, codes ...string) error {
+	query := `
+        INSERT INTO permissions (code)
+        SELECT UNNEST($1::text[])
+        ON CONFLICT (code) DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Query)
+	defer cancel()
+
+	_, err := p.db.Exec(ctx, query, codes)
+	if err != nil {
+		return p.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// AssignRole sets the role of a user and replaces their permissions with the
+// permissions of that role, in a single transaction so that the user never ends up with
+// a mix of the old and new permission sets.
+func (p PermissionRepository) AssignRole(ctx context.Context, userID int64, role string) (data.Permissions, error) {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, p.db, opts)
+	if err != nil {
+		return nil, p.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				p.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	res, err := tx.Exec(ctx, `UPDATE users SET role = $1 WHERE id = $2 AND deleted_at IS NULL`, role, userID)
+	if err != nil {
+		return nil, p.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		err = ErrRecordNotFound
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, p.logger.handleError(ctx, err)
+	}
+
+	query := `
+        INSERT INTO users_permissions (user_id, permission_id)
+        SELECT $1, rp.permission_id
+        FROM roles_permissions rp
+        INNER JOIN roles r ON r.id = rp.role_id
+        WHERE r.name = $2
+        RETURNING (SELECT code FROM permissions WHERE id = permission_id)
+	`
+
+	rows, err := tx.Query(ctx, query, userID, role)
+	if err != nil {
+		return nil, p.logger.handleError(ctx, err)
+	}
+
+	codes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, p.logger.handleError(ctx, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, p.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// readPool is the DBTX the read-only repository methods run their queries on when a read
+// replica is set up. The queries go to the replica, and fall back to the primary when
+// the replica can't be reached. A single row missing on the replica is looked for on the
+// primary as well, since the replica may lag behind: an anime fetched right after being
+// created, or the token of a user who just logged in, shouldn't come back as not found.
+//
+// The methods writing, and the ones taking a batch or a copy, always go to the primary,
+// as do the reads under a context returned by ReadPrimary.
+type readPool struct {
+	primary *pgxpool.Pool
+	replica *pgxpool.Pool
+}
+
+// primaryContextKey marks the contexts whose reads go to the primary, see ReadPrimary.
+type primaryContextKey struct{}
+
+// ReadPrimary returns a copy of ctx under which the read-only methods query the primary
+// rather than the read replica, for the reads which must see the latest commits.
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+// readsPrimary reports whether ctx was returned by ReadPrimary.
+func readsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryContextKey{}).(bool)
+	return primary
+}
+
+// unreachable reports whether err means the replica couldn't be queried at all, rather
+// than the query failing on it, so that running it on the primary instead is safe.
+func unreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
+
+func (p readPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+// BeginTx starts a transaction on the replica, or on the primary when the transaction
+// isn't read-only. Hot standbys don't run serializable transactions, so those run as
+// repeatable read on the replica, which is as consistent for a read-only transaction
+// short of the serialization anomalies with concurrent writers.
+func (p readPool) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	if opts.AccessMode != pgx.ReadOnly || readsPrimary(ctx) {
+		return p.primary.BeginTx(ctx, opts)
+	}
+
+	replicaOpts := opts
+	if replicaOpts.IsoLevel == pgx.Serializable {
+		replicaOpts.IsoLevel = pgx.RepeatableRead
+	}
+
+	tx, err := p.replica.BeginTx(ctx, replicaOpts)
+	if err != nil && unreachable(ctx, err) {
+		return p.primary.BeginTx(ctx, opts)
+	}
+
+	return tx, err
+}
+
+func (p readPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return p.primary.Exec(ctx, sql, arguments...)
+}
+
+func (p readPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if readsPrimary(ctx) {
+		return p.primary.Query(ctx, sql, args...)
+	}
+
+	rows, err := p.replica.Query(ctx, sql, args...)
+	if err != nil && unreachable(ctx, err) {
+		return p.primary.Query(ctx, sql, args...)
+	}
+
+	return rows, err
+}
+
+func (p readPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return readRow{pool: p, ctx: ctx, sql: sql, args: args}
+}
+
+func (p readPool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return p.primary.SendBatch(ctx, b)
+}
+
+func (p readPool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return p.primary.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// readRow is the row of readPool.QueryRow. The query only runs once Scan is called, as
+// whether it has to run again on the primary depends on how it went on the replica.
+type readRow struct {
+	pool readPool
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (r readRow) Scan(dest ...any) error {
+	if readsPrimary(r.ctx) {
+		return r.pool.primary.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	}
+
+	err := r.pool.replica.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	if err != nil && (errors.Is(err, pgx.ErrNoRows) || unreachable(r.ctx, err)) {
+		return r.pool.primary.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	}
+
+	return err
+}
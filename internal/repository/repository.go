@@ -1,27 +1,142 @@
 package repository
 
 import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log/slog"
+	"sync/atomic"
+	"time"
 )
 
+// DBTX is the subset of methods shared by *pgxpool.Pool and pgx.Tx. Repositories are
+// written against it so that the same repository code can run either directly on the
+// pool or inside a transaction that spans several repositories.
+type DBTX interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// beginTx starts a transaction with the given options on the pool. When the repository
+// is already bound to a transaction, a nested transaction (savepoint) is started
+// instead, and the options of the outer transaction apply.
+func beginTx(ctx context.Context, db DBTX, opts pgx.TxOptions) (pgx.Tx, error) {
+	switch pool := db.(type) {
+	case *pgxpool.Pool:
+		return pool.BeginTx(ctx, opts)
+	case readPool:
+		return pool.BeginTx(ctx, opts)
+	}
+
+	return db.Begin(ctx)
+}
+
+// Timeouts holds the deadlines applied to repository calls, on top of whatever deadline
+// the caller's context already carries. Query is used for single statements and
+// Transaction for multi-statement transactions.
+type Timeouts struct {
+	Query       time.Duration
+	Transaction time.Duration
+}
+
 // Repositories Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
+//
+// The fields hold the store interfaces rather than the concrete repositories, so that
+// they can be swapped for the in-memory fakes of the memory package.
 type Repositories struct {
-	Anime      AnimeRepository
-	User       UserRepository
-	Token      TokenRepository
-	Permission PermissionRepository
+	Anime      AnimeStore
+	User       UserStore
+	Token      TokenStore
+	Permission PermissionStore
+	APIKey     APIKeyStore
+	Audit      AuditStore
+	Character  CharacterStore
+	Watchlist  WatchlistStore
+	Trending   TrendingStore
+	Email      EmailStore
+	Event      EventStore
+
+	// logger and timeouts are kept around for WithTx.
+	logger   *dbLogger
+	timeouts Timeouts
+
+	// animeCache is the cache of the anime reads, if any. Bound to a transaction, the
+	// repositories flag animeWritten when they write anime, see Committed.
+	animeCache   *AnimeCache
+	animeWritten *atomic.Bool
+
+	// userCache is the cache of the token lookups and permissions, if any. Bound to a
+	// transaction, the repositories record in usersWritten what they invalidate in it.
+	userCache    *UserCache
+	usersWritten *usersWritten
 }
 
 // NewRepositories For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
-func NewRepositories(db *pgxpool.Pool, logger *slog.Logger) Repositories {
-	dblogger := &dbLogger{logger}
+//
+// When replica isn't nil, the read-only methods serving most of the read traffic, such
+// as GetAnime, GetAll, GetAllTags and GetForToken, query the read replica it connects
+// to, falling back to db, the primary.
+func NewRepositories(db *pgxpool.Pool, replica *pgxpool.Pool, logger *slog.Logger, timeouts Timeouts) Repositories {
+	var read DBTX = db
+	if replica != nil {
+		read = readPool{primary: db, replica: replica}
+	}
+
+	return newRepositories(db, read, &dbLogger{logger}, timeouts)
+}
+
+// WithTx returns a copy of the repositories bound to the given transaction, so that
+// calls made through it are committed or rolled back together. The returned stores are
+// always the Postgres backed repositories, reading from the transaction too. The anime
+// and user caches, if any, are kept for their invalidation, but not read from.
+func (r Repositories) WithTx(tx pgx.Tx) Repositories {
+	repos := newRepositories(tx, tx, r.logger, r.timeouts)
+
+	if r.animeCache != nil {
+		repos.animeCache = r.animeCache
+		repos.animeWritten = new(atomic.Bool)
+		repos.Anime = cachedAnime{AnimeStore: repos.Anime, cache: r.animeCache, written: repos.animeWritten}
+	}
+
+	if r.userCache != nil {
+		repos.userCache = r.userCache
+		repos.usersWritten = new(usersWritten)
+		repos.User = cachedUsers{UserStore: repos.User, cache: r.userCache, written: repos.usersWritten}
+		repos.Token = cachedTokens{TokenStore: repos.Token, cache: r.userCache, written: repos.usersWritten}
+		repos.Permission = cachedPermissions{PermissionStore: repos.Permission, cache: r.userCache, written: repos.usersWritten}
+	}
+
+	return repos
+}
+
+// newRepositories binds the repositories to db, and the read-only methods of the anime
+// and the users to read.
+func newRepositories(db, read DBTX, dblogger *dbLogger, timeouts Timeouts) Repositories {
+	anime := NewAnimeRepository(db, dblogger, timeouts)
+	anime.read = read
+	user := NewUserRepository(db, dblogger, timeouts)
+	user.read = read
+
 	return Repositories{
-		Anime:      NewAnimeRepository(db, dblogger),
-		User:       NewUserRepository(db, dblogger),
-		Token:      NewTokenRepository(db, dblogger),
-		Permission: NewPermissionRepository(db, dblogger),
+		Anime:      anime,
+		User:       user,
+		Token:      NewTokenRepository(db, dblogger, timeouts),
+		Permission: NewPermissionRepository(db, dblogger, timeouts),
+		APIKey:     NewAPIKeyRepository(db, dblogger, timeouts),
+		Audit:      NewAuditRepository(db, dblogger, timeouts),
+		Character:  NewCharacterRepository(db, dblogger, timeouts),
+		Watchlist:  NewWatchlistRepository(db, dblogger, timeouts),
+		Trending:   NewTrendingRepository(db, dblogger, timeouts),
+		Email:      NewEmailRepository(db, dblogger, timeouts),
+		Event:      NewEventRepository(db, dblogger, timeouts),
+		logger:     dblogger,
+		timeouts:   timeouts,
 	}
 }
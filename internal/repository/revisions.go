@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+// insertRevision stores a snapshot of the anime at its current version. It is called
+// from within the transactions of InsertAnime and UpdateAnime, so that every version
+// of an anime has a matching revision.
+func (a AnimeRepository) insertRevision(ctx context.Context, anime *data.Anime, tx pgx.Tx) error {
+	snapshot, err := json.Marshal(anime)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO anime_revisions (anime_id, version, snapshot) VALUES ($1, $2, $3)`,
+		anime.ID, anime.Version, string(snapshot))
+	return err
+}
+
+// GetRevisions lists the revisions of an anime, oldest first. Anime created before
+// revisions were recorded only have the revisions of their later edits, and restores
+// bump the version without changing any data, so they leave no revision either.
+func (a AnimeRepository) GetRevisions(ctx context.Context, animeID int32) ([]*data.AnimeRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+		SELECT r.anime_id, r.version, r.snapshot, r.created_at
+		FROM anime_revisions r
+		JOIN anime a ON a.id = r.anime_id
+		WHERE r.anime_id = $1 AND a.deleted_at IS NULL
+		ORDER BY r.version
+	`
+
+	rows, err := a.db.Query(ctx, query, animeID)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	revisions := make([]*data.AnimeRevision, 0)
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, a.logger.handleError(ctx, err)
+		}
+
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return revisions, nil
+}
+
+// GetRevision retrieves the revision of an anime at a specific version.
+func (a AnimeRepository) GetRevision(ctx context.Context, animeID, version int32) (*data.AnimeRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+		SELECT r.anime_id, r.version, r.snapshot, r.created_at
+		FROM anime_revisions r
+		JOIN anime a ON a.id = r.anime_id
+		WHERE r.anime_id = $1 AND r.version = $2 AND a.deleted_at IS NULL
+	`
+
+	revision, err := scanRevision(a.db.QueryRow(ctx, query, animeID, version))
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return revision, nil
+}
+
+func scanRevision(row pgx.Row) (*data.AnimeRevision, error) {
+	var revision data.AnimeRevision
+	var snapshot []byte
+
+	err := row.Scan(&revision.AnimeID, &revision.Version, &snapshot, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(snapshot, &revision.Anime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
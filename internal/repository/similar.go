@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+// GetSimilar returns the limit anime sharing the most tags with an anime, best first.
+// Each shared tag is weighted by its rarity, ln(1 + N / n) for a tag on n of the N
+// anime of the catalog, so the score favours niche tags over the ubiquitous ones. Soft
+// deleted anime are left out, both from the results and from the weights.
+func (a AnimeRepository) GetSimilar(ctx context.Context, id int32, limit int) ([]*data.SimilarAnime, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+        WITH live AS (
+            SELECT id FROM anime WHERE deleted_at IS NULL
+        ), weights AS (
+            SELECT at.tag_id, ln(1 + (SELECT count(*) FROM live)::double precision / count(*)) AS weight
+            FROM anime_tags at
+            JOIN live ON at.anime_id = live.id
+            GROUP BY at.tag_id
+        )
+        SELECT a.id, a.title, a.type, a.status, a.year, a.cover_url,
+            SUM(w.weight) AS score, ARRAY_AGG(t.name ORDER BY w.weight DESC, t.name) AS shared_tags
+        FROM anime_tags source
+        JOIN anime_tags other ON other.tag_id = source.tag_id AND other.anime_id <> source.anime_id
+        JOIN anime a ON a.id = other.anime_id AND a.deleted_at IS NULL
+        JOIN weights w ON w.tag_id = source.tag_id
+        JOIN tag t ON t.id = source.tag_id
+        WHERE source.anime_id = $1
+        GROUP BY a.id
+        ORDER BY score DESC, a.id
+        LIMIT $2
+	`
+
+	rows, err := a.db.Query(ctx, query, id, limit)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	similar := make([]*data.SimilarAnime, 0)
+	for rows.Next() {
+		var anime data.SimilarAnime
+		if err = rows.Scan(
+			&anime.ID, &anime.Title, &anime.Type, &anime.Status, &anime.Year, &anime.CoverURL,
+			&anime.Score, &anime.SharedTags,
+		); err != nil {
+			return nil, a.logger.handleError(ctx, err)
+		}
+
+		similar = append(similar, &anime)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return similar, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UniqueSlug returns base if it isn't taken, or else base suffixed with the first free
+// number from 2 on: "youjo-senki-2", "youjo-senki-3" and so on.
+func UniqueSlug(base string, taken func(slug string) bool) string {
+	slug := base
+	for n := 2; taken(slug); n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+
+	return slug
+}
+
+// SlugFits reports whether slug is base, or base with a numbered suffix given by
+// UniqueSlug. An anime whose title changes keeps its slug as long as it fits the new
+// title, so that its URLs only break when they have to.
+func SlugFits(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	if !ok {
+		return false
+	}
+
+	n, err := strconv.Atoi(suffix)
+	return err == nil && n >= 2 && strconv.Itoa(n) == suffix
+}
+
+// slugFor returns the slug to store for an anime: its current one if it still fits its
+// title, or else a new one that no other anime, deleted or not, has. New anime have an
+// ID of 0, and no current slug.
+//
+// Two anime given the same new slug at the same time still collide on the unique index,
+// which makes the second write fail with ErrDuplicateEntry.
+func (a AnimeRepository) slugFor(ctx context.Context, anime *data.Anime, tx pgx.Tx) (string, error) {
+	base := data.AnimeSlug(anime)
+
+	if anime.ID != 0 {
+		var current string
+		err := tx.QueryRow(ctx, `SELECT slug FROM anime WHERE id = $1`, anime.ID).Scan(&current)
+		if err != nil {
+			return "", err
+		}
+
+		if SlugFits(current, base) {
+			return current, nil
+		}
+	}
+
+	// The slugs are made of letters, digits and hyphens only, so base can't hold any of
+	// the LIKE wildcards.
+	rows, err := tx.Query(ctx, `
+		SELECT slug FROM anime WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id <> $2
+	`, base, anime.ID)
+	if err != nil {
+		return "", err
+	}
+
+	taken, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", err
+	}
+
+	return UniqueSlug(base, func(slug string) bool { return slices.Contains(taken, slug) }), nil
+}
+
+// GetAnimeBySlug fetches the anime with the slug, such as youjo-senki.
+func (a AnimeRepository) GetAnimeBySlug(ctx context.Context, slug string) (*data.Anime, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	var id int32
+	err := a.db.QueryRow(queryCtx, `
+		SELECT id FROM anime WHERE slug = $1 AND deleted_at IS NULL
+	`, slug).Scan(&id)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return a.GetAnime(ctx, id)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// GetStats counts the anime of the catalog by type, status, season and decade, and
+// returns the topTags most used tags. Soft deleted anime are left out.
+func (a AnimeRepository) GetStats(ctx context.Context, topTags int) (*data.CatalogStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	// Every breakdown comes from the same query, one group of rows per dimension.
+	query := `
+        WITH live AS (
+            SELECT id, type, status, season, year FROM anime WHERE deleted_at IS NULL
+        ), tags AS (
+            SELECT t.name, count(*) AS count
+            FROM anime_tags at
+            JOIN tag t ON at.tag_id = t.id
+            JOIN live ON at.anime_id = live.id
+            GROUP BY t.name
+            ORDER BY count DESC, t.name
+            LIMIT $1
+        )
+        SELECT 'total', '', count(*) FROM live
+        UNION ALL
+        SELECT 'type', type::text, count(*) FROM live GROUP BY type
+        UNION ALL
+        SELECT 'status', status::text, count(*) FROM live GROUP BY status
+        UNION ALL
+        SELECT 'season', COALESCE(season::text, $2), count(*) FROM live GROUP BY season
+        UNION ALL
+        SELECT 'decade', COALESCE((year / 10 * 10)::text || 's', $2), count(*) FROM live GROUP BY year / 10
+        UNION ALL
+        SELECT 'tag', name, count FROM tags
+        ORDER BY 1, 3 DESC, 2
+	`
+
+	rows, err := a.db.Query(ctx, query, topTags, data.StatUnknown)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	stats := &data.CatalogStats{
+		ByType:     make([]data.StatCount, 0),
+		ByStatus:   make([]data.StatCount, 0),
+		BySeason:   make([]data.StatCount, 0),
+		ByDecade:   make([]data.StatCount, 0),
+		TopTags:    make([]data.StatCount, 0),
+		ComputedAt: time.Now(),
+	}
+
+	for rows.Next() {
+		var dimension string
+		var count data.StatCount
+		if err = rows.Scan(&dimension, &count.Key, &count.Count); err != nil {
+			return nil, a.logger.handleError(ctx, err)
+		}
+
+		switch dimension {
+		case "total":
+			stats.Total = count.Count
+		case "type":
+			stats.ByType = append(stats.ByType, count)
+		case "status":
+			stats.ByStatus = append(stats.ByStatus, count)
+		case "season":
+			stats.BySeason = append(stats.BySeason, count)
+		case "decade":
+			stats.ByDecade = append(stats.ByDecade, count)
+		case "tag":
+			stats.TopTags = append(stats.TopTags, count)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return stats, nil
+}
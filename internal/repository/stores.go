@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+// The store interfaces describe what the handlers need from each repository. The
+// Postgres backed repositories in this package implement them, and so do the in-memory
+// fakes in the memory package, which lets handlers be exercised without a database.
+
+// AnimeStore is implemented by AnimeRepository.
+type AnimeStore interface {
+	InsertAnime(ctx context.Context, anime *data.Anime) error
+	GetAnime(ctx context.Context, id int32) (*data.Anime, error)
+	GetAnimeByIDs(ctx context.Context, ids []int32) ([]*data.Anime, error)
+	GetAnimeByExternalID(ctx context.Context, source string, externalID int32) (*data.Anime, error)
+	GetAnimeBySlug(ctx context.Context, slug string) (*data.Anime, error)
+	GetAll(ctx context.Context, search data.AnimeSearch, filters data.Filters) ([]*data.Anime, data.Metadata, error)
+	UpdateAnime(ctx context.Context, anime *data.Anime) error
+	DeleteAnime(ctx context.Context, id int32) error
+	RestoreAnime(ctx context.Context, id int32) error
+	PurgeAnime(ctx context.Context, id int32) error
+	SetCover(ctx context.Context, id int32, url string) (int32, error)
+	GetAllTags(ctx context.Context) ([]string, error)
+	InsertTag(ctx context.Context, tag *data.Tag) error
+	GetTag(ctx context.Context, name string) (*data.Tag, error)
+	UpdateTag(ctx context.Context, tag *data.Tag) error
+	DeleteTag(ctx context.Context, id int32, force bool) error
+	MergeTags(ctx context.Context, sourceID, targetID int32) (int64, error)
+	GetAllStudios(ctx context.Context) ([]string, error)
+	InsertStudio(ctx context.Context, studio *data.Studio) error
+	GetStudio(ctx context.Context, name string) (*data.Studio, error)
+	UpdateStudio(ctx context.Context, studio *data.Studio) error
+	DeleteStudio(ctx context.Context, id int32, force bool) error
+	Export(ctx context.Context, fn func(anime *data.Anime) error) error
+	GetRevisions(ctx context.Context, animeID int32) ([]*data.AnimeRevision, error)
+	GetRevision(ctx context.Context, animeID, version int32) (*data.AnimeRevision, error)
+	GetStats(ctx context.Context, topTags int) (*data.CatalogStats, error)
+	GetSimilar(ctx context.Context, id int32, limit int) ([]*data.SimilarAnime, error)
+}
+
+// UserStore is implemented by UserRepository.
+type UserStore interface {
+	Insert(ctx context.Context, user *data.User) error
+	Get(ctx context.Context, id int64) (*data.User, error)
+	GetByEmail(ctx context.Context, email string) (*data.User, error)
+	Update(ctx context.Context, user *data.User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error)
+	GetAll(ctx context.Context, email string, activated *bool, filters data.Filters) ([]*data.User, data.Metadata, error)
+	RecordFailedLogin(ctx context.Context, id int64, maxAttempts int, lockout time.Duration) (*time.Time, error)
+	ResetFailedLogins(ctx context.Context, id int64) error
+	SetPendingEmail(ctx context.Context, id int64, email string) error
+	ConfirmPendingEmail(ctx context.Context, user *data.User) error
+	Delete(ctx context.Context, id int64) error
+	SoftDelete(ctx context.Context, id int64) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	PurgeUnactivated(ctx context.Context, before time.Time) (int64, error)
+}
+
+// TokenStore is implemented by TokenRepository.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string, issuer data.TokenIssuer) (*data.Token, error)
+	Insert(ctx context.Context, token *data.Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	Delete(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error)
+	Revoke(ctx context.Context, jti string, userID int64, expiry time.Time) error
+	IsRevoked(ctx context.Context, jti string, userID int64, tokenVersion int) (bool, error)
+	Touch(ctx context.Context, tokenPlaintext string) error
+	GetSessionsForUser(ctx context.Context, userID int64) ([]*data.Session, error)
+	DeleteSession(ctx context.Context, id, userID int64) error
+	GetIssuance(ctx context.Context, tokenPlaintext string) (*data.Token, error)
+	LastIssued(ctx context.Context, userID int64, scope string) (*time.Time, error)
+}
+
+// PermissionStore is implemented by PermissionRepository.
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error)
+	GetAllForUsers(ctx context.Context, userIDs []int64) (map[int64]data.Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	Seed(ctx context.Context, codes ...string) error
+	AssignRole(ctx context.Context, userID int64, role string) (data.Permissions, error)
+}
+
+// APIKeyStore is implemented by APIKeyRepository.
+type APIKeyStore interface {
+	Insert(ctx context.Context, key *data.APIKey) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*data.APIKey, error)
+	GetForKey(ctx context.Context, plaintext string) (*data.User, *data.APIKey, error)
+	Delete(ctx context.Context, id, userID int64) error
+}
+
+// AuditStore is implemented by AuditRepository.
+type AuditStore interface {
+	Insert(ctx context.Context, entry *data.AuditEntry) error
+	GetAll(ctx context.Context, userID *int64, entity string, entityID *int64, action string, filters data.Filters) ([]*data.AuditEntry, data.Metadata, error)
+}
+
+// CharacterStore is implemented by CharacterRepository.
+type CharacterStore interface {
+	InsertCharacter(ctx context.Context, character *data.Character) error
+	GetCharacter(ctx context.Context, id int32) (*data.Character, error)
+	InsertPerson(ctx context.Context, person *data.Person) error
+	GetPerson(ctx context.Context, id int32) (*data.Person, error)
+	GetCast(ctx context.Context, animeID int32) ([]*data.CastMember, error)
+	SetCastMember(ctx context.Context, animeID int32, member *data.CastMember) error
+	DeleteCastMember(ctx context.Context, animeID, characterID int32) error
+}
+
+// WatchlistStore is implemented by WatchlistRepository.
+type WatchlistStore interface {
+	Get(ctx context.Context, userID int64, animeID int32) (*data.WatchlistEntry, error)
+	GetAll(ctx context.Context, userID int64, status string, filters data.Filters) ([]*data.WatchlistEntry, data.Metadata, error)
+	GetWatcherIDs(ctx context.Context, animeID int32) ([]int64, error)
+	Insert(ctx context.Context, entry *data.WatchlistEntry) error
+	Update(ctx context.Context, entry *data.WatchlistEntry) error
+	Delete(ctx context.Context, userID int64, animeID int32) error
+}
+
+// TrendingStore is implemented by TrendingRepository.
+type TrendingStore interface {
+	RecordActivity(ctx context.Context, activity []*data.AnimeActivity) error
+	Refresh(ctx context.Context, window time.Duration) (int64, error)
+	GetTrending(ctx context.Context, filters data.Filters) ([]*data.TrendingAnime, data.Metadata, error)
+}
+
+// EmailStore is implemented by EmailRepository.
+type EmailStore interface {
+	Enqueue(ctx context.Context, email *data.Email) error
+	ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]*data.Email, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error
+}
+
+// EventStore is implemented by EventRepository.
+type EventStore interface {
+	Insert(ctx context.Context, event *data.Event) error
+	TryLock(ctx context.Context) (bool, error)
+	GetPending(ctx context.Context, limit int) ([]*data.Event, error)
+	MarkPublished(ctx context.Context, ids []int64) error
+	MarkFailed(ctx context.Context, id int64, reason string) error
+	MarkDead(ctx context.Context, id int64, reason string) error
+	Purge(ctx context.Context, before time.Time, unpublished bool) (int64, error)
+}
+
+// Make sure the repositories keep satisfying the interfaces.
+var (
+	_ AnimeStore      = AnimeRepository{}
+	_ UserStore       = UserRepository{}
+	_ TokenStore      = TokenRepository{}
+	_ PermissionStore = PermissionRepository{}
+	_ APIKeyStore     = APIKeyRepository{}
+	_ AuditStore      = AuditRepository{}
+	_ CharacterStore  = CharacterRepository{}
+	_ WatchlistStore  = WatchlistRepository{}
+	_ TrendingStore   = TrendingRepository{}
+	_ EmailStore      = EmailRepository{}
+	_ EventStore      = EventRepository{}
+)
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+func (a AnimeRepository) GetAllStudios(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	rows, err := a.db.Query(ctx, `SELECT name FROM studio ORDER BY name`)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	studios, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return studios, nil
+}
+
+// InsertStudio creates a studio on its own, without linking it to any anime.
+func (a AnimeRepository) InsertStudio(ctx context.Context, studio *data.Studio) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	err := a.db.QueryRow(ctx, `INSERT INTO studio (name) VALUES ($1) RETURNING id`, studio.Name).Scan(&studio.ID)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// GetStudio looks a studio up by its (case-sensitive) name.
+func (a AnimeRepository) GetStudio(ctx context.Context, name string) (*data.Studio, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	var studio data.Studio
+	err := a.db.QueryRow(ctx, `SELECT id, name FROM studio WHERE name = $1`, name).Scan(&studio.ID, &studio.Name)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return &studio, nil
+}
+
+// UpdateStudio renames a studio, and with it the studio of every anime it made.
+func (a AnimeRepository) UpdateStudio(ctx context.Context, studio *data.Studio) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	result, err := a.db.Exec(ctx, `UPDATE studio SET name = $1 WHERE id = $2`, studio.Name, studio.ID)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteStudio deletes a studio. Like DeleteTag, studios still linked to an anime are
+// only deleted when force is set, and ErrRecordInUse is returned otherwise.
+func (a AnimeRepository) DeleteStudio(ctx context.Context, id int32, force bool) error {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, a.db, opts)
+	if err != nil {
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	var inUse bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM anime_studios WHERE studio_id = s.id)
+		FROM studio s
+		WHERE s.id = $1
+		FOR UPDATE
+	`, id).Scan(&inUse)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if inUse && !force {
+		err = ErrRecordInUse
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM studio WHERE id = $1`, id)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return nil
+}
+
+// upsertStudios gets or inserts studios by name in a single statement, returning their
+// ids. DO UPDATE (rather than DO NOTHING) makes existing studios show up in RETURNING.
+func (a AnimeRepository) upsertStudios(ctx context.Context, studios []string, tx pgx.Tx) ([]int32, error) {
+	if len(studios) == 0 {
+		return []int32{}, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO studio (name)
+		SELECT unnest($1::text[])
+		ON CONFLICT (name) DO UPDATE SET name = excluded.name
+		RETURNING id
+	`, studios)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int32])
+}
+
+// replaceAnimeStudios rebuilds the studio links of an anime, in the same way as
+// replaceAnimeTags.
+func (a AnimeRepository) replaceAnimeStudios(ctx context.Context, id int32, studioIds []int32, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM anime_studios
+		WHERE anime_id = $1 AND studio_id <> ALL($2::integer[])
+	`, id, studioIds)
+	if err != nil {
+		return err
+	}
+
+	if len(studioIds) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO anime_studios (anime_id, studio_id)
+		SELECT $1, unnest($2::integer[])
+		ON CONFLICT (anime_id, studio_id) DO NOTHING
+	`, id, studioIds)
+
+	return err
+}
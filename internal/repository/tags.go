@@ -4,15 +4,25 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jackc/pgx/v5"
-	"time"
+	"github.com/ziliscite/purplelight/internal/data"
 )
 
-func (a AnimeRepository) GetAllTags() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// touchTaggedAnime bumps the version and updated_at of every anime linked to the tag of
+// $1, whose tags a change of the tag changes too, so that their ETags and the delta
+// syncs see it. It locks the anime rows as well.
+const touchTaggedAnime = `
+	UPDATE anime
+	SET version = version + 1, updated_at = NOW()
+	WHERE id IN (SELECT anime_id FROM anime_tags WHERE tag_id = $1)
+`
+
+func (a AnimeRepository) GetAllTags(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
 	defer cancel()
 
-	rows, err := a.db.Query(ctx, `SELECT tag.name FROM tag`)
+	rows, err := a.reader().Query(ctx, `SELECT tag.name FROM tag`)
 	if err != nil {
 		return nil, err
 	}
@@ -30,13 +40,211 @@ func (a AnimeRepository) GetAllTags() ([]string, error) {
 	return tags, nil
 }
 
-// upsertTag will get or insert a tag by name, returning the tag id.
-func (a AnimeRepository) upsertTag(tag string, tx pgx.Tx) (int32, error) {
-	var tagId int32
+// InsertTag creates a tag on its own, without linking it to any anime.
+func (a AnimeRepository) InsertTag(ctx context.Context, tag *data.Tag) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	err := a.db.QueryRow(ctx, `INSERT INTO tag (name) VALUES ($1) RETURNING id`, tag.Name).Scan(&tag.ID)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// GetTag looks a tag up by its (case-sensitive) name.
+func (a AnimeRepository) GetTag(ctx context.Context, name string) (*data.Tag, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	var tag data.Tag
+	err := a.db.QueryRow(ctx, `SELECT id, name FROM tag WHERE name = $1`, name).Scan(&tag.ID, &tag.Name)
+	if err != nil {
+		return nil, a.logger.handleError(ctx, err)
+	}
+
+	return &tag, nil
+}
+
+// UpdateTag renames a tag. Since anime reference tags by ID, every anime tagged with it
+// is renamed along, and gets a new version. Both happen in a single statement.
+func (a AnimeRepository) UpdateTag(ctx context.Context, tag *data.Tag) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Query)
+	defer cancel()
+
+	query := `
+		WITH renamed AS (
+			UPDATE tag SET name = $1 WHERE id = $2 RETURNING id
+		), touched AS (
+			UPDATE anime
+			SET version = version + 1, updated_at = NOW()
+			WHERE id IN (SELECT at.anime_id FROM anime_tags at JOIN renamed r ON r.id = at.tag_id)
+		)
+		SELECT count(*) FROM renamed
+	`
+
+	var renamed int64
+	err := a.db.QueryRow(ctx, query, tag.Name, tag.ID).Scan(&renamed)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if renamed == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteTag deletes a tag. Unless force is set, tags that are still linked to an anime
+// (soft deleted ones included) are kept and ErrRecordInUse is returned; otherwise the
+// links are removed along with the tag, and the anime get a new version. An anime must
+// keep a tag, so the only tag of an anime is never deleted: ErrOnlyTag is returned.
+func (a AnimeRepository) DeleteTag(ctx context.Context, id int32, force bool) error {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, a.db, opts)
+	if err != nil {
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	// Lock the tag first, so that no anime gets linked to it between the check and
+	// the delete.
+	var inUse bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM anime_tags WHERE tag_id = t.id)
+		FROM tag t
+		WHERE t.id = $1
+		FOR UPDATE
+	`, id).Scan(&inUse)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if inUse && !force {
+		err = ErrRecordInUse
+		return err
+	}
+
+	if inUse {
+		// Lock the anime before looking at their other tags, which an update of the
+		// anime could otherwise remove in the meantime.
+		if _, err = tx.Exec(ctx, touchTaggedAnime, id); err != nil {
+			return a.logger.handleError(ctx, err)
+		}
+
+		var onlyTag bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM anime_tags at
+				WHERE at.tag_id = $1
+				AND NOT EXISTS (SELECT 1 FROM anime_tags o WHERE o.anime_id = at.anime_id AND o.tag_id <> $1)
+			)
+		`, id).Scan(&onlyTag)
+		if err != nil {
+			return a.logger.handleError(ctx, err)
+		}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		if onlyTag {
+			err = ErrOnlyTag
+			return err
+		}
+	}
+
+	// anime_tags rows go with the tag, through ON DELETE CASCADE.
+	_, err = tx.Exec(ctx, `DELETE FROM tag WHERE id = $1`, id)
+	if err != nil {
+		return a.logger.handleError(ctx, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return nil
+}
+
+// MergeTags moves every anime from the source tag over to the target tag, and deletes
+// the source tag, in one transaction. It returns the number of anime that were tagged
+// with the source tag.
+func (a AnimeRepository) MergeTags(ctx context.Context, sourceID, targetID int32) (int64, error) {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Transaction)
 	defer cancel()
 
+	tx, err := beginTx(ctx, a.db, opts)
+	if err != nil {
+		return 0, a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				a.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	// The tags of every anime with the source tag change, whether they had the target
+	// one already or not.
+	if _, err = tx.Exec(ctx, touchTaggedAnime, sourceID); err != nil {
+		return 0, a.logger.handleError(ctx, err)
+	}
+
+	// Anime that already have both tags just keep the target one.
+	_, err = tx.Exec(ctx, `
+		INSERT INTO anime_tags (anime_id, tag_id)
+		SELECT anime_id, $2 FROM anime_tags WHERE tag_id = $1
+		ON CONFLICT (anime_id, tag_id) DO NOTHING
+	`, sourceID, targetID)
+	if err != nil {
+		return 0, a.logger.handleError(ctx, err)
+	}
+
+	// Removing the source tag removes its anime_tags rows too, through ON DELETE CASCADE.
+	var moved int64
+	err = tx.QueryRow(ctx, `
+		WITH links AS (
+			SELECT count(*) AS n FROM anime_tags WHERE tag_id = $1
+		), deleted AS (
+			DELETE FROM tag WHERE id = $1 RETURNING id
+		)
+		SELECT links.n FROM links, deleted
+	`, sourceID).Scan(&moved)
+	if err != nil {
+		return 0, a.logger.handleError(ctx, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, a.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return moved, nil
+}
+
+// upsertTag will get or insert a tag by name, returning the tag id.
+func (a AnimeRepository) upsertTag(ctx context.Context, tag string, tx pgx.Tx) (int32, error) {
+	var tagId int32
+
 	err := tx.QueryRow(ctx, `INSERT INTO tag (name)
 		VALUES ($1)
 		ON CONFLICT (name) DO UPDATE SET name=excluded.name
@@ -71,7 +279,7 @@ func (a AnimeRepository) upsertTags(ctx context.Context, tags []string, tx pgx.T
 	defer func(br pgx.BatchResults) {
 		err := br.Close()
 		if err != nil {
-			a.logger.Error(ErrFailedCloseRows.Error(), "error", err)
+			a.logger.Error(ctx, ErrFailedCloseRows.Error(), "error", err)
 		}
 	}(br)
 
@@ -117,18 +325,41 @@ func (a AnimeRepository) deleteAnimeTags(ctx context.Context, id int32, tx pgx.T
 	return nil
 }
 
+// insertAnimeTags links the anime to all the given tags with a single multi-row insert,
+// instead of one round-trip per tag. Links that already exist are left alone.
 func (a AnimeRepository) insertAnimeTags(ctx context.Context, id int32, tagsIds []int32, tx pgx.Tx) error {
-	//uses a 1-second timeout (shorter than the transaction's 5-second timeout),
-	//causing premature cancellations and leaving the transaction in an invalid state.
-	//ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	//defer cancel()
+	if len(tagsIds) == 0 {
+		return nil
+	}
 
-	for _, tagId := range tagsIds {
-		_, err := tx.Exec(ctx, `INSERT INTO anime_tags (anime_id, tag_id) VALUES ($1, $2)`, id, tagId)
-		if err != nil {
-			return err
-		}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO anime_tags (anime_id, tag_id)
+		SELECT $1, unnest($2::integer[])
+		ON CONFLICT (anime_id, tag_id) DO NOTHING
+	`, id, tagsIds)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// replaceAnimeTags rebuilds the tag links of an anime in two statements: links to tags
+// that are no longer wanted are deleted, and the missing ones are inserted.
+func (a AnimeRepository) replaceAnimeTags(ctx context.Context, id int32, tagsIds []int32, tx pgx.Tx) error {
+	// <> ALL of an empty array is true, so passing no tags clears every link. A nil slice
+	// would be sent as NULL instead, which matches nothing.
+	if tagsIds == nil {
+		tagsIds = []int32{}
+	}
+
+	_, err := tx.Exec(ctx, `
+		DELETE FROM anime_tags 
+		WHERE anime_id = $1 AND tag_id <> ALL($2::integer[])
+	`, id, tagsIds)
+	if err != nil {
+		return err
+	}
+
+	return a.insertAnimeTags(ctx, id, tagsIds, tx)
+}
@@ -2,62 +2,68 @@ package repository
 
 import (
 	"context"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"crypto/sha256"
 	"github.com/ziliscite/purplelight/internal/data"
 	"time"
 )
 
 type TokenRepository struct {
-	db     *pgxpool.Pool
-	logger *dbLogger
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
 }
 
-func NewTokenRepository(db *pgxpool.Pool, logger *dbLogger) TokenRepository {
+func NewTokenRepository(db DBTX, logger *dbLogger, timeouts Timeouts) TokenRepository {
 	return TokenRepository{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
 	}
 }
 
 // New The method is a shortcut which creates a new Token struct and then inserts the
-// data in the tokens table.
-func (t TokenRepository) New(userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+// data in the tokens table, recording the client that the token was issued to.
+func (t TokenRepository) New(ctx context.Context, userID int64, ttl time.Duration, scope string, issuer data.TokenIssuer) (*data.Token, error) {
 	token, err := data.GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.Insert(token)
+	token.ClientIP = issuer.ClientIP
+	token.UserAgent = issuer.UserAgent
+
+	err = t.Insert(ctx, token)
 	if err != nil {
-		return nil, t.logger.handleError(err)
+		return nil, t.logger.handleError(ctx, err)
 	}
 
 	return token, nil
 }
 
 // Insert adds the data for a specific token to the tokens table.
-func (t TokenRepository) Insert(token *data.Token) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (t TokenRepository) Insert(ctx context.Context, token *data.Token) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
 	defer cancel()
 
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO tokens (hash, user_id, expiry, scope, client_ip, user_agent) 
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING created_at
 	`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.ClientIP, token.UserAgent}
 
-	_, err := t.db.Exec(ctx, query, args...)
+	err := t.db.QueryRow(ctx, query, args...).Scan(&token.CreatedAt)
 	if err != nil {
-		return t.logger.handleError(err)
+		return t.logger.handleError(ctx, err)
 	}
 
 	return nil
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (t TokenRepository) DeleteAllForUser(scope string, userID int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (t TokenRepository) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
 	defer cancel()
 
 	query := `
@@ -67,8 +73,229 @@ func (t TokenRepository) DeleteAllForUser(scope string, userID int64) error {
 
 	_, err := t.db.Exec(ctx, query, scope, userID)
 	if err != nil {
-		return t.logger.handleError(err)
+		return t.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// Delete removes a single token, identified by its plaintext, for the given scope.
+func (t TokenRepository) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        DELETE FROM tokens 
+        WHERE scope = $1 AND hash = $2
+	`
+
+	_, err := t.db.Exec(ctx, query, scope, tokenHash[:])
+	if err != nil {
+		return t.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes up to limit tokens, and up to limit revoked JWT IDs, which
+// expired before the given time, and returns how many rows it deleted in all. A revoked
+// JWT is rejected on its expiry alone once it has expired, so its jti is no longer
+// needed either. Deleting in batches keeps each statement short, so that the cleanup
+// doesn't hold locks on the tables for long.
+func (t TokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        WITH tokens_deleted AS (
+            DELETE FROM tokens
+            WHERE hash IN (SELECT hash FROM tokens WHERE expiry < $1 LIMIT $2)
+            RETURNING 1
+        ), revoked_deleted AS (
+            DELETE FROM revoked_tokens
+            WHERE jti IN (SELECT jti FROM revoked_tokens WHERE expiry < $1 LIMIT $2)
+            RETURNING 1
+        )
+        SELECT (SELECT count(*) FROM tokens_deleted) + (SELECT count(*) FROM revoked_deleted)
+	`
+
+	var deleted int64
+	err := t.db.QueryRow(ctx, query, before, limit).Scan(&deleted)
+	if err != nil {
+		return 0, t.logger.handleError(ctx, err)
+	}
+
+	return deleted, nil
+}
+
+// Revoke records the ID (jti) of a JWT so that it is rejected until it expires.
+func (t TokenRepository) Revoke(ctx context.Context, jti string, userID int64, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        INSERT INTO revoked_tokens (jti, user_id, expiry) 
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING
+	`
+
+	_, err := t.db.Exec(ctx, query, jti, userID, expiry)
+	if err != nil {
+		return t.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether a JWT can no longer be used. A token is revoked when its
+// jti has been explicitly revoked, or when the user has had to be logged out since the
+// token was issued (the token version embedded in the claims no longer matches), which
+// also covers deleted users. Other edits, such as a new name, leave the tokens alone.
+func (t TokenRepository) IsRevoked(ctx context.Context, jti string, userID int64, tokenVersion int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+            OR NOT EXISTS (SELECT 1 FROM users WHERE id = $2 AND token_version = $3 AND deleted_at IS NULL)
+	`
+
+	var revoked bool
+	err := t.db.QueryRow(ctx, query, jti, userID, tokenVersion).Scan(&revoked)
+	if err != nil {
+		return false, t.logger.handleError(ctx, err)
+	}
+
+	return revoked, nil
+}
+
+// Touch records that an authentication token has just been used. To avoid a write on
+// every single request, the timestamp is only refreshed once a minute.
+func (t TokenRepository) Touch(ctx context.Context, tokenPlaintext string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        UPDATE tokens 
+        SET last_used_at = NOW()
+        WHERE hash = $1 AND scope = $2 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	_, err := t.db.Exec(ctx, query, tokenHash[:], data.ScopeAuthentication)
+	if err != nil {
+		return t.logger.handleError(ctx, err)
 	}
 
 	return nil
 }
+
+// GetSessionsForUser lists the unexpired authentication tokens of a user, most recent
+// first.
+func (t TokenRepository) GetSessionsForUser(ctx context.Context, userID int64) ([]*data.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT id, created_at, expiry, client_ip, user_agent, last_used_at, hash
+        FROM tokens
+        WHERE user_id = $1 AND scope = $2 AND expiry > $3
+        ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := t.db.Query(ctx, query, userID, data.ScopeAuthentication, time.Now())
+	if err != nil {
+		return nil, t.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*data.Session, 0)
+	for rows.Next() {
+		var session data.Session
+		if err = rows.Scan(
+			&session.ID, &session.CreatedAt, &session.Expiry,
+			&session.ClientIP, &session.UserAgent, &session.LastUsedAt, &session.Hash,
+		); err != nil {
+			return nil, t.logger.handleError(ctx, err)
+		}
+
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, t.logger.handleError(ctx, err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one authentication token of a user by its ID.
+func (t TokenRepository) DeleteSession(ctx context.Context, id, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        DELETE FROM tokens 
+        WHERE id = $1 AND user_id = $2 AND scope = $3
+	`
+
+	res, err := t.db.Exec(ctx, query, id, userID, data.ScopeAuthentication)
+	if err != nil {
+		return t.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// LastIssued returns when the latest token of the scope was issued to the user, or nil if
+// they have none, such as to limit how often the activation email can be sent again.
+func (t TokenRepository) LastIssued(ctx context.Context, userID int64, scope string) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT MAX(created_at)
+        FROM tokens
+        WHERE user_id = $1 AND scope = $2
+	`
+
+	var issuedAt *time.Time
+	err := t.db.QueryRow(ctx, query, userID, scope).Scan(&issuedAt)
+	if err != nil {
+		return nil, t.logger.handleError(ctx, err)
+	}
+
+	return issuedAt, nil
+}
+
+// GetIssuance looks up a token by its plaintext regardless of scope and expiry, so that
+// failed authentication attempts can be logged together with where the token came from.
+func (t TokenRepository) GetIssuance(ctx context.Context, tokenPlaintext string) (*data.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT user_id, expiry, scope, created_at, client_ip, user_agent
+        FROM tokens
+        WHERE hash = $1
+	`
+
+	token := data.Token{Hash: tokenHash[:]}
+	err := t.db.QueryRow(ctx, query, tokenHash[:]).Scan(
+		&token.UserID, &token.Expiry, &token.Scope,
+		&token.CreatedAt, &token.ClientIP, &token.UserAgent,
+	)
+	if err != nil {
+		return nil, t.logger.handleError(ctx, err)
+	}
+
+	return &token, nil
+}
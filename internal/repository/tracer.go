@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// QueryTracer is a pgx.QueryTracer logging the queries that take longer than a
+// threshold, and counting them. Set it as the Tracer of the pgx connection config; the
+// tracer it wraps, if any, is called for every query too.
+type QueryTracer struct {
+	next      pgx.QueryTracer
+	logger    *dbLogger
+	threshold time.Duration
+	slow      atomic.Int64
+}
+
+// NewQueryTracer returns a tracer logging the queries slower than threshold. A zero
+// threshold logs none of them. next may be nil.
+func NewQueryTracer(logger *slog.Logger, threshold time.Duration, next pgx.QueryTracer) *QueryTracer {
+	return &QueryTracer{
+		next:      next,
+		logger:    &dbLogger{logger},
+		threshold: threshold,
+	}
+}
+
+// SlowQueries returns the number of slow queries logged so far.
+func (t *QueryTracer) SlowQueries() int64 {
+	return t.slow.Load()
+}
+
+type queryStartKey struct{}
+
+// queryStart is what TraceQueryStart hands over to TraceQueryEnd through the context.
+type queryStart struct {
+	at  time.Time
+	sql string
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if t.next != nil {
+		ctx = t.next.TraceQueryStart(ctx, conn, data)
+	}
+
+	if t.threshold <= 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), sql: data.SQL})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if start, ok := ctx.Value(queryStartKey{}).(queryStart); ok {
+		if duration := time.Since(start.at); duration >= t.threshold {
+			t.slow.Add(1)
+			t.logger.Warn(ctx, "slow query",
+				"query", queryName(start.sql),
+				"duration", duration.String(),
+				"rows", data.CommandTag.RowsAffected(),
+			)
+		}
+	}
+
+	if t.next != nil {
+		t.next.TraceQueryEnd(ctx, conn, data)
+	}
+}
+
+// queryName names a query after the repository method that ran it, such as
+// AnimeRepository.GetAnime. pgx ends the trace of a query before the method returns,
+// so the method is still on the stack. Queries made from elsewhere are named after
+// their first keyword.
+func queryName(sql string) string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+
+	for {
+		frame, more := frames.Next()
+
+		const pkg = "/internal/repository."
+		if i := strings.LastIndex(frame.Function, pkg); i >= 0 && !strings.Contains(frame.Function, "QueryTracer") {
+			name := frame.Function[i+len(pkg):]
+			// Drop the pointer receiver notation, such as (*TxManager), and the suffix of
+			// the closures within the method.
+			name = strings.NewReplacer("(", "", ")", "", "*", "").Replace(name)
+			if j := strings.Index(name, ".func"); j >= 0 {
+				name = name[:j]
+			}
+			return name
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+
+	return "unknown"
+}
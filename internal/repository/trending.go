@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+	"time"
+)
+
+type TrendingRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewTrendingRepository(db DBTX, logger *dbLogger, timeouts Timeouts) TrendingRepository {
+	return TrendingRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// RecordActivity adds the counts to the hourly buckets of each anime. The counts of
+// anime that have been purged in the meantime are dropped.
+func (t TrendingRepository) RecordActivity(ctx context.Context, activity []*data.AnimeActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	ids := make([]int32, len(activity))
+	buckets := make([]time.Time, len(activity))
+	views := make([]int32, len(activity))
+	adds := make([]int32, len(activity))
+	for i, a := range activity {
+		ids[i], buckets[i], views[i], adds[i] = a.AnimeID, a.Bucket, a.Views, a.WatchlistAdds
+	}
+
+	query := `
+        INSERT INTO anime_activity (anime_id, bucket, views, watchlist_adds)
+        SELECT u.anime_id, u.bucket, u.views, u.watchlist_adds
+        FROM unnest($1::integer[], $2::timestamptz[], $3::integer[], $4::integer[]) AS u(anime_id, bucket, views, watchlist_adds)
+        JOIN anime a ON a.id = u.anime_id
+        ON CONFLICT (anime_id, bucket) DO UPDATE
+        SET views = anime_activity.views + EXCLUDED.views,
+            watchlist_adds = anime_activity.watchlist_adds + EXCLUDED.watchlist_adds
+	`
+
+	_, err := t.db.Exec(ctx, query, ids, buckets, views, adds)
+	if err != nil {
+		return t.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// Refresh recomputes the trending scores from the activity within the window, and
+// removes the activity that has fallen out of it. It returns the number of anime that
+// are trending.
+func (t TrendingRepository) Refresh(ctx context.Context, window time.Duration) (n int64, err error) {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, t.db, opts)
+	if err != nil {
+		return 0, t.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				t.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	since := time.Now().Add(-window)
+
+	_, err = tx.Exec(ctx, `DELETE FROM anime_activity WHERE bucket < $1`, since)
+	if err != nil {
+		return 0, t.logger.handleError(ctx, err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM anime_trending`)
+	if err != nil {
+		return 0, t.logger.handleError(ctx, err)
+	}
+
+	// Each bucket is weighted by its age, halving every half-life, which is the same
+	// as data.TrendingWeight.
+	query := `
+        INSERT INTO anime_trending (anime_id, score, views, watchlist_adds)
+        SELECT anime_id,
+            SUM((views * $2 + watchlist_adds * $3) * power(0.5, EXTRACT(EPOCH FROM NOW() - bucket) / $4)),
+            SUM(views), SUM(watchlist_adds)
+        FROM anime_activity
+        WHERE bucket >= $1
+        GROUP BY anime_id
+	`
+
+	res, err := tx.Exec(ctx, query, since, data.TrendingViewWeight, data.TrendingWatchlistAddWeight, data.TrendingHalfLife.Seconds())
+	if err != nil {
+		return 0, t.logger.handleError(ctx, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, t.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return res.RowsAffected(), nil
+}
+
+// GetTrending returns a page of the anime with the highest trending scores, as of the
+// last refresh. Soft deleted anime are left out.
+func (t TrendingRepository) GetTrending(ctx context.Context, filters data.Filters) ([]*data.TrendingAnime, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT count(*) OVER(), a.id, a.title, a.type, a.status, a.year, a.cover_url, t.score, t.views, t.watchlist_adds
+        FROM anime_trending t
+        JOIN anime a ON t.anime_id = a.id
+        WHERE a.deleted_at IS NULL
+        ORDER BY t.score DESC, a.id
+        LIMIT $1 OFFSET $2
+	`
+
+	var metadata data.Metadata
+
+	rows, err := t.db.Query(ctx, query, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, metadata, t.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	records := 0
+	trending := make([]*data.TrendingAnime, 0)
+	for rows.Next() {
+		var anime data.TrendingAnime
+		if err = rows.Scan(
+			&records,
+			&anime.ID, &anime.Title, &anime.Type, &anime.Status, &anime.Year, &anime.CoverURL,
+			&anime.Score, &anime.Views, &anime.WatchlistAdds,
+		); err != nil {
+			return nil, metadata, t.logger.handleError(ctx, err)
+		}
+
+		anime.Rank = filters.Offset() + len(trending) + 1
+		trending = append(trending, &anime)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, metadata, t.logger.handleError(ctx, err)
+	}
+
+	metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+
+	return trending, metadata, nil
+}
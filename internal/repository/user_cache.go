@@ -0,0 +1,435 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/cache"
+	"github.com/ziliscite/purplelight/internal/data"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// userGenerationKey holds the generation of every cached user, which the bulk purges
+// move on from. Each user also has a generation of their own, see userKey, which the
+// writes of the user, of their tokens and of their permissions move on from. A cached
+// entry is only used while both are still those it was read at.
+const userGenerationKey = "user:generation"
+
+// UserCache keeps the users of the authentication tokens, as read by GetForToken, and
+// their permissions, as read by GetAllForUser, in a cache in front of the repositories,
+// so that authenticating and authorizing a request doesn't go to the database every
+// time.
+//
+// The entries are invalidated as soon as the user, their tokens or their permissions
+// are written, but a token lookup racing with the write may still cache what it read
+// before it, until the TTL. The TTL also bounds how long a token is accepted past its
+// expiry.
+type UserCache struct {
+	cache          cache.Cache
+	tokenTTL       time.Duration
+	permissionsTTL time.Duration
+	logger         *dbLogger
+
+	hits, misses, errors atomic.Int64
+}
+
+// UserCacheStats are the metrics of a UserCache.
+type UserCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// NewUserCache returns a UserCache keeping the users of the authentication tokens for
+// tokenTTL, and their permissions for permissionsTTL. Either isn't cached if its TTL is
+// zero. Use it with Repositories.CacheUsers.
+func NewUserCache(c cache.Cache, tokenTTL, permissionsTTL time.Duration) *UserCache {
+	return &UserCache{cache: c, tokenTTL: tokenTTL, permissionsTTL: permissionsTTL}
+}
+
+// Stats returns the number of cache hits, misses and errors so far.
+func (c *UserCache) Stats() UserCacheStats {
+	return UserCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+}
+
+// CacheUsers puts the cache in front of the token lookups and the permissions of the
+// users. Bound to a transaction by WithTx, the repositories don't read through the
+// cache, and invalidate it as they write, and once more when the transaction is
+// committed: see Committed.
+func (r *Repositories) CacheUsers(c *UserCache) {
+	c.logger = r.logger
+	r.userCache = c
+	r.User = cachedUsers{UserStore: r.User, cache: c}
+	r.Token = cachedTokens{TokenStore: r.Token, cache: c}
+	r.Permission = cachedPermissions{PermissionStore: r.Permission, cache: c}
+}
+
+// usersWritten records what a transaction invalidated in the user cache, to invalidate
+// it again once committed.
+type usersWritten struct {
+	mu     sync.Mutex
+	ids    []int64
+	tokens []string
+	all    bool
+}
+
+// invalidateAgain invalidates again what the transaction invalidated.
+func (w *usersWritten) invalidateAgain(ctx context.Context, c *UserCache) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.all {
+		c.invalidate(ctx, userGenerationKey)
+	}
+	for _, id := range w.ids {
+		c.invalidate(ctx, userKey(id))
+	}
+	for _, token := range w.tokens {
+		c.forget(ctx, token)
+	}
+}
+
+// cachedToken is what GetForToken caches: the user, with their password hash, which
+// gob can't see, and the generation of the user it was read at.
+type cachedToken struct {
+	ID         int64
+	CreatedAt  time.Time
+	Name       string
+	Email      string
+	Hash       []byte
+	Activated  bool
+	Version    int
+	Generation string
+}
+
+func (t cachedToken) user() *data.User {
+	user := &data.User{
+		ID:        t.ID,
+		CreatedAt: t.CreatedAt,
+		Name:      t.Name,
+		Email:     t.Email,
+		Activated: t.Activated,
+		Version:   t.Version,
+	}
+	user.Password.InsertHash(t.Hash)
+
+	return user
+}
+
+// cachedUsers is the UserStore reading the users of the authentication tokens through a
+// UserCache. Bound to a transaction, with written set, it doesn't read through the
+// cache, which can't see what the transaction wrote.
+type cachedUsers struct {
+	UserStore
+	cache   *UserCache
+	written *usersWritten
+}
+
+func (u cachedUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	// The other tokens are used once, if ever.
+	if u.written != nil || u.cache.tokenTTL == 0 || tokenScope != data.ScopeAuthentication {
+		return u.UserStore.GetForToken(ctx, tokenScope, tokenPlaintext)
+	}
+
+	// The generation of every user is read before the database, and that of the user
+	// after it, once known.
+	generation := u.cache.generation(ctx, userGenerationKey)
+	key := tokenKey(tokenPlaintext) + ":" + generation
+
+	var entry cachedToken
+	if generation != "" && u.cache.get(ctx, key, &entry) {
+		if u.cache.generation(ctx, userKey(entry.ID)) == entry.Generation {
+			u.cache.hits.Add(1)
+			return entry.user(), nil
+		}
+	}
+	u.cache.misses.Add(1)
+
+	user, err := u.UserStore.GetForToken(ctx, tokenScope, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if generation == "" {
+		return user, nil
+	}
+
+	userGeneration := u.cache.generation(ctx, userKey(user.ID))
+	if userGeneration == "" {
+		return user, nil
+	}
+
+	u.cache.set(ctx, key, cachedToken{
+		ID:         user.ID,
+		CreatedAt:  user.CreatedAt,
+		Name:       user.Name,
+		Email:      user.Email,
+		Hash:       user.Hash(),
+		Activated:  user.Activated,
+		Version:    user.Version,
+		Generation: userGeneration,
+	}, u.cache.tokenTTL)
+
+	return user, nil
+}
+
+// The writes of a user, and the purges, invalidate the cache once they succeed.
+
+func (u cachedUsers) Update(ctx context.Context, user *data.User) error {
+	return u.invalidateUser(ctx, user.ID, u.UserStore.Update(ctx, user))
+}
+
+func (u cachedUsers) ConfirmPendingEmail(ctx context.Context, user *data.User) error {
+	return u.invalidateUser(ctx, user.ID, u.UserStore.ConfirmPendingEmail(ctx, user))
+}
+
+func (u cachedUsers) Delete(ctx context.Context, id int64) error {
+	return u.invalidateUser(ctx, id, u.UserStore.Delete(ctx, id))
+}
+
+func (u cachedUsers) SoftDelete(ctx context.Context, id int64) error {
+	return u.invalidateUser(ctx, id, u.UserStore.SoftDelete(ctx, id))
+}
+
+func (u cachedUsers) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	n, err := u.UserStore.PurgeDeleted(ctx, before)
+	return n, u.invalidateAll(ctx, n, err)
+}
+
+func (u cachedUsers) PurgeUnactivated(ctx context.Context, before time.Time) (int64, error) {
+	n, err := u.UserStore.PurgeUnactivated(ctx, before)
+	return n, u.invalidateAll(ctx, n, err)
+}
+
+// invalidateUser invalidates the cached entries of a user unless err is set, and
+// returns err.
+func (u cachedUsers) invalidateUser(ctx context.Context, id int64, err error) error {
+	return invalidateUser(ctx, u.cache, u.written, id, err)
+}
+
+// invalidateAll invalidates every cached entry if n users were purged, and returns err.
+func (u cachedUsers) invalidateAll(ctx context.Context, n int64, err error) error {
+	if err != nil || n == 0 {
+		return err
+	}
+
+	if u.written != nil {
+		u.written.mu.Lock()
+		u.written.all = true
+		u.written.mu.Unlock()
+	}
+	u.cache.invalidate(ctx, userGenerationKey)
+
+	return nil
+}
+
+// cachedTokens is the TokenStore invalidating a UserCache as it deletes authentication
+// tokens.
+type cachedTokens struct {
+	TokenStore
+	cache   *UserCache
+	written *usersWritten
+}
+
+func (t cachedTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	err := t.TokenStore.DeleteAllForUser(ctx, scope, userID)
+	if scope != data.ScopeAuthentication {
+		return err
+	}
+
+	return invalidateUser(ctx, t.cache, t.written, userID, err)
+}
+
+func (t cachedTokens) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	err := t.TokenStore.Delete(ctx, scope, tokenPlaintext)
+	if err != nil || scope != data.ScopeAuthentication {
+		return err
+	}
+
+	if t.written != nil {
+		t.written.mu.Lock()
+		t.written.tokens = append(t.written.tokens, tokenPlaintext)
+		t.written.mu.Unlock()
+	}
+	t.cache.forget(ctx, tokenPlaintext)
+
+	return nil
+}
+
+func (t cachedTokens) DeleteSession(ctx context.Context, id, userID int64) error {
+	return invalidateUser(ctx, t.cache, t.written, userID, t.TokenStore.DeleteSession(ctx, id, userID))
+}
+
+// cachedPermission is what GetAllForUser caches: the permissions, and the generation of
+// the user they were read at.
+type cachedPermission struct {
+	Permissions data.Permissions
+	Generation  string
+}
+
+// cachedPermissions is the PermissionStore reading the permissions of a user through a
+// UserCache. Bound to a transaction, with written set, it doesn't read through the
+// cache.
+type cachedPermissions struct {
+	PermissionStore
+	cache   *UserCache
+	written *usersWritten
+}
+
+func (p cachedPermissions) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	if p.written != nil || p.cache.permissionsTTL == 0 {
+		return p.PermissionStore.GetAllForUser(ctx, userID)
+	}
+
+	// The user is known beforehand, so their generation is read before the database.
+	generation := p.cache.generation(ctx, userKey(userID))
+	key := permissionsKey(userID)
+
+	var entry cachedPermission
+	if generation != "" && p.cache.get(ctx, key, &entry) && entry.Generation == generation {
+		p.cache.hits.Add(1)
+		return entry.Permissions, nil
+	}
+	p.cache.misses.Add(1)
+
+	permissions, err := p.PermissionStore.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if generation != "" {
+		p.cache.set(ctx, key, cachedPermission{Permissions: permissions, Generation: generation}, p.cache.permissionsTTL)
+	}
+
+	return permissions, nil
+}
+
+// The permissions written invalidate the cached entries of the user once they succeed.
+
+func (p cachedPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return invalidateUser(ctx, p.cache, p.written, userID, p.PermissionStore.AddForUser(ctx, userID, codes...))
+}
+
+func (p cachedPermissions) AssignRole(ctx context.Context, userID int64, role string) (data.Permissions, error) {
+	permissions, err := p.PermissionStore.AssignRole(ctx, userID, role)
+	return permissions, invalidateUser(ctx, p.cache, p.written, userID, err)
+}
+
+// invalidateUser invalidates the cached entries of a user unless err is set, recording
+// it in written when bound to a transaction, and returns err.
+func invalidateUser(ctx context.Context, c *UserCache, written *usersWritten, id int64, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if written != nil {
+		written.mu.Lock()
+		written.ids = append(written.ids, id)
+		written.mu.Unlock()
+	}
+	c.invalidate(ctx, userKey(id))
+
+	return nil
+}
+
+// userKey is the key of the generation of a user.
+func userKey(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10) + ":generation"
+}
+
+// permissionsKey is the key of the cached permissions of a user.
+func permissionsKey(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10) + ":permissions"
+}
+
+// tokenKey is the key of the cached user of a token, without its generation. Like in
+// the database, the token is only kept hashed.
+func tokenKey(tokenPlaintext string) string {
+	sum := sha256.Sum256([]byte(tokenPlaintext))
+	return "user:token:" + hex.EncodeToString(sum[:])
+}
+
+// generation returns the current generation held by key, starting a new one if there
+// is none, as AnimeCache.get does. It returns an empty generation if the cache can't
+// be reached.
+func (c *UserCache) generation(ctx context.Context, key string) string {
+	generation, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		c.failed(ctx, err)
+		return ""
+	}
+	if !ok {
+		return c.invalidate(ctx, key)
+	}
+
+	return string(generation)
+}
+
+// invalidate moves key to a new generation, and returns it. The generations of the
+// users expire with the entries cached at them, so that they don't pile up. It returns
+// an empty generation if the cache can't be reached.
+func (c *UserCache) invalidate(ctx context.Context, key string) string {
+	var ttl time.Duration
+	if key != userGenerationKey {
+		ttl = max(c.tokenTTL, c.permissionsTTL)
+	}
+
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := c.cache.Set(ctx, key, []byte(generation), ttl); err != nil {
+		c.failed(ctx, fmt.Errorf("invalidating the user cache: %w", err))
+		return ""
+	}
+
+	return generation
+}
+
+// forget removes the cached user of a token.
+func (c *UserCache) forget(ctx context.Context, tokenPlaintext string) {
+	generation, ok, err := c.cache.Get(ctx, userGenerationKey)
+	if err != nil {
+		c.failed(ctx, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if err := c.cache.Delete(ctx, tokenKey(tokenPlaintext)+":"+string(generation)); err != nil {
+		c.failed(ctx, fmt.Errorf("invalidating the user cache: %w", err))
+	}
+}
+
+// get reads the entry of key into value, and reports whether there was one.
+func (c *UserCache) get(ctx context.Context, key string, value any) bool {
+	entry, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		c.failed(ctx, err)
+		return false
+	}
+
+	return ok && gob.NewDecoder(bytes.NewReader(entry)).Decode(value) == nil
+}
+
+// set caches value under key for ttl.
+func (c *UserCache) set(ctx context.Context, key string, value any, ttl time.Duration) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		c.failed(ctx, err)
+		return
+	}
+
+	if err := c.cache.Set(ctx, key, buf.Bytes(), ttl); err != nil {
+		c.failed(ctx, err)
+	}
+}
+
+func (c *UserCache) failed(ctx context.Context, err error) {
+	c.errors.Add(1)
+	c.logger.Warn(ctx, "user cache error", "error", err.Error())
+}
@@ -6,37 +6,51 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ziliscite/purplelight/internal/data"
 )
 
 type UserRepository struct {
-	db     *pgxpool.Pool
-	logger *dbLogger
+	db DBTX
+	// read is where the read-only methods query, such as a read replica. They use db
+	// when it's nil.
+	read     DBTX
+	logger   *dbLogger
+	timeouts Timeouts
 }
 
-func NewUserRepository(db *pgxpool.Pool, logger *dbLogger) UserRepository {
+func NewUserRepository(db DBTX, logger *dbLogger, timeouts Timeouts) UserRepository {
 	return UserRepository{
-		db:     db,
-		logger: logger,
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
 	}
 }
 
+// reader returns where the read-only methods query.
+func (u UserRepository) reader() DBTX {
+	if u.read != nil {
+		return u.read
+	}
+
+	return u.db
+}
+
 // Insert a new record in the database for the user. Note that the id, created_at and
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the User struct after the insert, in the same way
 // that we did when creating a movie.
-func (u UserRepository) Insert(user *data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (u UserRepository) Insert(ctx context.Context, user *data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
 	defer cancel()
 
 	query := `
         INSERT INTO users (name, email, password_hash, activated) 
         VALUES ($1, $2, $3, $4)
-        RETURNING id, created_at, version
+        RETURNING id, created_at, version, token_version
 	`
 
 	args := []any{user.Name, user.Email, user.Hash(), user.Activated}
@@ -45,30 +59,58 @@ func (u UserRepository) Insert(user *data.User) error {
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := u.db.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := u.db.QueryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version, &user.TokenVersion)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return u.logger.handleError(err)
+			return u.logger.handleError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
+// Get Retrieve the User details from the database based on the user's ID.
+func (u UserRepository) Get(ctx context.Context, id int64) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version, token_version
+        FROM users
+        WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	var user data.User
+
+	var hash []byte
+	err := u.db.QueryRow(ctx, query, id).Scan(
+		&user.ID, &user.CreatedAt, &user.Name,
+		&user.Email, &hash, &user.Activated,
+		&user.Version, &user.TokenVersion,
+	)
+	if err != nil {
+		return nil, u.logger.handleError(ctx, err)
+	}
+
+	user.Password.InsertHash(hash)
+
+	return &user, nil
+}
+
 // GetByEmail Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (u UserRepository) GetByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (u UserRepository) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
 	defer cancel()
 
 	query := `
-        SELECT id, created_at, name, email, password_hash, activated, version
+        SELECT id, created_at, name, email, password_hash, activated, version, token_version, locked_until
         FROM users
-        WHERE email = $1
+        WHERE email = $1 AND deleted_at IS NULL
 	`
 
 	var user data.User
@@ -77,7 +119,7 @@ func (u UserRepository) GetByEmail(email string) (*data.User, error) {
 	err := u.db.QueryRow(ctx, query, email).Scan(
 		&user.ID, &user.CreatedAt, &user.Name,
 		&user.Email, &hash, &user.Activated,
-		&user.Version,
+		&user.Version, &user.TokenVersion, &user.LockedUntil,
 	)
 
 	user.Password.InsertHash(hash)
@@ -87,7 +129,7 @@ func (u UserRepository) GetByEmail(email string) (*data.User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, u.logger.handleError(err)
+			return nil, u.logger.handleError(ctx, err)
 		}
 	}
 
@@ -99,34 +141,40 @@ func (u UserRepository) GetByEmail(email string) (*data.User, error) {
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (u UserRepository) Update(user *data.User) error {
+//
+// The token version only moves on with a new password, a new email address or a
+// deactivation, which revokes the JWTs of the user.
+func (u UserRepository) Update(ctx context.Context, user *data.User) error {
 	opts := pgx.TxOptions{
 		IsoLevel:   pgx.ReadCommitted,
 		AccessMode: pgx.ReadWrite,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
 	defer cancel()
 
-	tx, err := u.db.BeginTx(ctx, opts)
+	tx, err := beginTx(ctx, u.db, opts)
 	if err != nil {
-		return u.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	defer func() {
 		if err != nil {
 			// Rollback if an error occurs during the transaction
 			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				u.logger.Error(ErrTransaction.Error(), "error", rbErr)
+				u.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
 			}
 		}
 	}()
 
 	query := `
         UPDATE users 
-        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1,
+            token_version = token_version + CASE
+                WHEN password_hash <> $3 OR email <> $2 OR (activated AND NOT $4) THEN 1 ELSE 0
+            END
         WHERE id = $5 AND version = $6
-        RETURNING version
+        RETURNING version, token_version
 	`
 
 	args := []any{
@@ -138,26 +186,26 @@ func (u UserRepository) Update(user *data.User) error {
 		user.Version,
 	}
 
-	err = tx.QueryRow(ctx, query, args...).Scan(&user.Version)
+	err = tx.QueryRow(ctx, query, args...).Scan(&user.Version, &user.TokenVersion)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return u.logger.handleError(err)
+			return u.logger.handleError(ctx, err)
 		}
 	}
 
 	// Commit transaction
 	if err = tx.Commit(ctx); err != nil {
-		return u.logger.handleError(fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+		return u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
 	}
 
 	return nil
 }
 
-func (u UserRepository) GetForToken(tokenScope, tokenPlaintext string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (u UserRepository) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
 	defer cancel()
 
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
@@ -170,7 +218,7 @@ func (u UserRepository) GetForToken(tokenScope, tokenPlaintext string) (*data.Us
         FROM users u
         INNER JOIN tokens t
         ON u.id = t.user_id
-        WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
+        WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3 AND u.deleted_at IS NULL
 	`
 
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
@@ -184,7 +232,7 @@ func (u UserRepository) GetForToken(tokenScope, tokenPlaintext string) (*data.Us
 	var hash []byte
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
-	err := u.db.QueryRow(ctx, query, args...).Scan(
+	err := u.reader().QueryRow(ctx, query, args...).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -194,7 +242,7 @@ func (u UserRepository) GetForToken(tokenScope, tokenPlaintext string) (*data.Us
 		&user.Version,
 	)
 	if err != nil {
-		return nil, u.logger.handleError(err)
+		return nil, u.logger.handleError(ctx, err)
 	}
 
 	user.Password.InsertHash(hash)
@@ -202,3 +250,319 @@ func (u UserRepository) GetForToken(tokenScope, tokenPlaintext string) (*data.Us
 	// Return the matching user.
 	return &user, nil
 }
+
+// GetAll returns a page of users, optionally filtered by a partial email match and by
+// activation status, together with the pagination metadata.
+func (u UserRepository) GetAll(ctx context.Context, email string, activated *bool, filters data.Filters) ([]*data.User, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	var args []any
+	conditions := []string{"deleted_at IS NULL"}
+
+	var metadata data.Metadata
+
+	if email != "" {
+		conditions = append(conditions, fmt.Sprintf("email ILIKE '%%' || $%d || '%%'", len(args)+1))
+		args = append(args, email)
+	}
+
+	if activated != nil {
+		conditions = append(conditions, fmt.Sprintf("activated = $%d", len(args)+1))
+		args = append(args, *activated)
+	}
+
+	query := `
+        SELECT count(*) OVER(), id, created_at, name, email, activated, version
+        FROM users
+	`
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+
+	// Secondary sort on the ID to ensure a consistent ordering.
+	query += fmt.Sprintf(" ORDER BY %s %s, id", filters.SortColumn(), filters.SortDirection())
+
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, filters.Limit(), filters.Offset())
+
+	rows, err := u.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, metadata, u.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	records := 0
+	users := make([]*data.User, 0)
+	for rows.Next() {
+		var user data.User
+		if err = rows.Scan(
+			&records,
+			&user.ID, &user.CreatedAt, &user.Name,
+			&user.Email, &user.Activated, &user.Version,
+		); err != nil {
+			return nil, metadata, u.logger.handleError(ctx, err)
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, metadata, u.logger.handleError(ctx, err)
+	}
+
+	metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// RecordFailedLogin increments the failed login counter of a user. The counter starts
+// over when the previous failure is older than the lockout window, so that only the
+// failures in quick succession add up. Once the counter reaches maxAttempts the account
+// is locked for the lockout duration and the counter starts over. It returns the time
+// until which the account is locked, if it is.
+//
+// The version is deliberately left untouched, as this isn't an edit of the user.
+func (u UserRepository) RecordFailedLogin(ctx context.Context, id int64, maxAttempts int, lockout time.Duration) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET failed_login_attempts = CASE WHEN f.attempts >= $2 THEN 0 ELSE f.attempts END,
+            locked_until = CASE WHEN f.attempts >= $2 THEN $3 ELSE users.locked_until END,
+            last_failed_login_at = $4
+        FROM (
+            SELECT CASE WHEN last_failed_login_at > $5 THEN failed_login_attempts ELSE 0 END + 1 AS attempts
+            FROM users
+            WHERE id = $1
+        ) AS f
+        WHERE users.id = $1
+        RETURNING users.locked_until
+	`
+
+	now := time.Now()
+
+	var lockedUntil *time.Time
+	err := u.db.QueryRow(ctx, query, id, maxAttempts, now.Add(lockout), now, now.Add(-lockout)).Scan(&lockedUntil)
+	if err != nil {
+		return nil, u.logger.handleError(ctx, err)
+	}
+
+	return lockedUntil, nil
+}
+
+// ResetFailedLogins clears the failed login counter and any lock after a successful login.
+func (u UserRepository) ResetFailedLogins(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET failed_login_attempts = 0, locked_until = NULL, last_failed_login_at = NULL
+        WHERE id = $1 AND (failed_login_attempts > 0 OR locked_until IS NOT NULL OR last_failed_login_at IS NOT NULL)
+	`
+
+	_, err := u.db.Exec(ctx, query, id)
+	if err != nil {
+		return u.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// SetPendingEmail records the address a user wants to switch to. The email column itself
+// is only changed once the new address has been verified with ConfirmPendingEmail.
+func (u UserRepository) SetPendingEmail(ctx context.Context, id int64, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	_, err := u.db.Exec(ctx, `UPDATE users SET pending_email = $1 WHERE id = $2`, email, id)
+	if err != nil {
+		return u.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// ConfirmPendingEmail swaps the verified pending email in as the user's email address,
+// bumping the version, and the token version. The UNIQUE constraint on the email column still applies, so an
+// address claimed by someone else in the meantime results in ErrDuplicateEntry.
+func (u UserRepository) ConfirmPendingEmail(ctx context.Context, user *data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE users 
+        SET email = pending_email, pending_email = NULL, version = version + 1, token_version = token_version + 1
+        WHERE id = $1 AND version = $2 AND pending_email IS NOT NULL
+        RETURNING email, version, token_version
+	`
+
+	err := u.db.QueryRow(ctx, query, user.ID, user.Version).Scan(&user.Email, &user.Version, &user.TokenVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return u.logger.handleError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete permanently removes a user together with every row they own, in a single
+// transaction.
+func (u UserRepository) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Transaction)
+	defer cancel()
+
+	n, err := u.purge(ctx, `SELECT id FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SoftDelete marks a user as deleted so that it disappears from every read, and logs
+// them out everywhere by removing their tokens and api keys. The remaining data is
+// removed later by PurgeDeleted.
+func (u UserRepository) SoftDelete(ctx context.Context, id int64) error {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Transaction)
+	defer cancel()
+
+	tx, err := beginTx(ctx, u.db, opts)
+	if err != nil {
+		return u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				u.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	res, err := tx.Exec(ctx, `UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return u.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		err = ErrRecordNotFound
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+	} {
+		if _, err = tx.Exec(ctx, query, id); err != nil {
+			return u.logger.handleError(ctx, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return nil
+}
+
+// PurgeDeleted permanently removes the users that were soft deleted before the given
+// time, returning the number of purged accounts.
+func (u UserRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Transaction)
+	defer cancel()
+
+	return u.purge(ctx, `SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1`, before)
+}
+
+// PurgeUnactivated permanently removes the users who registered before the given time
+// and never activated their account, returning the number of purged accounts. Their
+// emails can then be registered again.
+func (u UserRepository) PurgeUnactivated(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeouts.Transaction)
+	defer cancel()
+
+	return u.purge(ctx, `SELECT id FROM users WHERE NOT activated AND created_at < $1`, before)
+}
+
+// purge deletes the users selected by the given query, along with all of their rows,
+// inside one transaction. Most tables cascade on user deletion, but the rows are
+// removed explicitly so that the purge doesn't silently depend on the foreign keys.
+// Any new user-owned table must be added to the list below.
+//
+// Some tables hold the personal data of users without being owned by them: the emails
+// queued to their addresses, and the snapshots of their account in the audit log and
+// the change stream. The emails are deleted, while the snapshots are cleared so that
+// the history of the changes, and the order of the events, are kept.
+func (u UserRepository) purge(ctx context.Context, selectIDs string, args ...any) (int64, error) {
+	opts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	tx, err := beginTx(ctx, u.db, opts)
+	if err != nil {
+		return 0, u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				u.logger.Error(ctx, ErrTransaction.Error(), "error", rbErr)
+			}
+		}
+	}()
+
+	rows, err := tx.Query(ctx, selectIDs+" FOR UPDATE", args...)
+	if err != nil {
+		return 0, u.logger.handleError(ctx, err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, u.logger.handleError(ctx, err)
+	}
+
+	if len(ids) == 0 {
+		return 0, tx.Rollback(ctx)
+	}
+
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = ANY($1)`,
+		`DELETE FROM revoked_tokens WHERE user_id = ANY($1)`,
+		`DELETE FROM api_keys WHERE user_id = ANY($1)`,
+		`DELETE FROM watchlist WHERE user_id = ANY($1)`,
+		`DELETE FROM users_permissions WHERE user_id = ANY($1)`,
+		`DELETE FROM email_outbox WHERE recipient IN (
+            SELECT email FROM users WHERE id = ANY($1)
+            UNION
+            SELECT pending_email FROM users WHERE id = ANY($1) AND pending_email IS NOT NULL
+        )`,
+		`UPDATE audit_log SET before = NULL, after = NULL WHERE entity = 'user' AND entity_id = ANY($1)`,
+		`UPDATE events SET data = jsonb_build_object('id', entity_id) WHERE entity = 'user' AND entity_id = ANY($1)`,
+		`DELETE FROM users WHERE id = ANY($1)`,
+	} {
+		if _, err = tx.Exec(ctx, query, ids); err != nil {
+			return 0, u.logger.handleError(ctx, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, u.logger.handleError(ctx, fmt.Errorf("%w: %s", ErrTransaction, err.Error()))
+	}
+
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/ziliscite/purplelight/internal/data"
+)
+
+type WatchlistRepository struct {
+	db       DBTX
+	logger   *dbLogger
+	timeouts Timeouts
+}
+
+func NewWatchlistRepository(db DBTX, logger *dbLogger, timeouts Timeouts) WatchlistRepository {
+	return WatchlistRepository{
+		db:       db,
+		logger:   logger,
+		timeouts: timeouts,
+	}
+}
+
+// Get fetches the watchlist entry of a user for an anime.
+func (l WatchlistRepository) Get(ctx context.Context, userID int64, animeID int32) (*data.WatchlistEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT w.user_id, w.anime_id, a.title, a.episodes, w.status, w.episodes_watched, w.created_at, w.updated_at, w.version
+        FROM watchlist w
+        JOIN anime a ON w.anime_id = a.id
+        WHERE w.user_id = $1 AND w.anime_id = $2 AND a.deleted_at IS NULL
+	`
+
+	var entry data.WatchlistEntry
+	err := l.db.QueryRow(ctx, query, userID, animeID).Scan(
+		&entry.UserID, &entry.AnimeID, &entry.Title, &entry.Episodes, &entry.Status,
+		&entry.EpisodesWatched, &entry.CreatedAt, &entry.UpdatedAt, &entry.Version,
+	)
+	if err != nil {
+		return nil, l.logger.handleError(ctx, err)
+	}
+
+	return &entry, nil
+}
+
+// GetAll returns a page of the watchlist of a user, optionally only the entries with the
+// given status. Soft deleted anime are left out.
+func (l WatchlistRepository) GetAll(ctx context.Context, userID int64, status string, filters data.Filters) ([]*data.WatchlistEntry, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	args := []any{userID}
+	query := `
+        SELECT count(*) OVER(), w.user_id, w.anime_id, a.title, a.episodes, w.status, w.episodes_watched, w.created_at, w.updated_at, w.version
+        FROM watchlist w
+        JOIN anime a ON w.anime_id = a.id
+        WHERE w.user_id = $1 AND a.deleted_at IS NULL
+	`
+
+	var metadata data.Metadata
+
+	if status != "" {
+		query += fmt.Sprintf(" AND w.status = $%d", len(args)+1)
+		args = append(args, status)
+	}
+
+	// The title is the only sort column coming from the anime.
+	column := "w." + filters.SortColumn()
+	if filters.SortColumn() == "title" {
+		column = "a.title"
+	}
+
+	query += fmt.Sprintf(" ORDER BY %s %s, w.anime_id", column, filters.SortDirection())
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, filters.Limit(), filters.Offset())
+
+	rows, err := l.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, metadata, l.logger.handleError(ctx, err)
+	}
+	defer rows.Close()
+
+	records := 0
+	entries := make([]*data.WatchlistEntry, 0)
+	for rows.Next() {
+		var entry data.WatchlistEntry
+		if err = rows.Scan(
+			&records,
+			&entry.UserID, &entry.AnimeID, &entry.Title, &entry.Episodes, &entry.Status,
+			&entry.EpisodesWatched, &entry.CreatedAt, &entry.UpdatedAt, &entry.Version,
+		); err != nil {
+			return nil, metadata, l.logger.handleError(ctx, err)
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, metadata, l.logger.handleError(ctx, err)
+	}
+
+	metadata.CalculateMetadata(records, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// GetWatcherIDs returns the IDs of the users who have an anime on their watchlist,
+// except those who dropped it.
+func (l WatchlistRepository) GetWatcherIDs(ctx context.Context, animeID int32) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT user_id
+        FROM watchlist
+        WHERE anime_id = $1 AND status <> $2
+        ORDER BY user_id
+	`
+
+	rows, err := l.db.Query(ctx, query, animeID, data.WatchStatusDropped)
+	if err != nil {
+		return nil, l.logger.handleError(ctx, err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, l.logger.handleError(ctx, err)
+	}
+
+	return ids, nil
+}
+
+// Insert adds an anime to the watchlist of a user. An anime already on the watchlist
+// results in ErrDuplicateEntry.
+func (l WatchlistRepository) Insert(ctx context.Context, entry *data.WatchlistEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	query := `
+        INSERT INTO watchlist (user_id, anime_id, status, episodes_watched)
+        VALUES ($1, $2, $3, $4)
+        RETURNING created_at, updated_at, version
+	`
+
+	err := l.db.QueryRow(ctx, query, entry.UserID, entry.AnimeID, entry.Status, entry.EpisodesWatched).
+		Scan(&entry.CreatedAt, &entry.UpdatedAt, &entry.Version)
+	if err != nil {
+		return l.logger.handleError(ctx, err)
+	}
+
+	return nil
+}
+
+// Update saves the status and progress of a watchlist entry, if its version hasn't
+// changed in the meantime; otherwise it returns ErrEditConflict.
+func (l WatchlistRepository) Update(ctx context.Context, entry *data.WatchlistEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	query := `
+        UPDATE watchlist
+        SET status = $1, episodes_watched = $2, updated_at = NOW(), version = version + 1
+        WHERE user_id = $3 AND anime_id = $4 AND version = $5
+        RETURNING updated_at, version
+	`
+
+	args := []any{entry.Status, entry.EpisodesWatched, entry.UserID, entry.AnimeID, entry.Version}
+
+	err := l.db.QueryRow(ctx, query, args...).Scan(&entry.UpdatedAt, &entry.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return l.logger.handleError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an anime from the watchlist of a user.
+func (l WatchlistRepository) Delete(ctx context.Context, userID int64, animeID int32) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Query)
+	defer cancel()
+
+	res, err := l.db.Exec(ctx, `DELETE FROM watchlist WHERE user_id = $1 AND anime_id = $2`, userID, animeID)
+	if err != nil {
+		return l.logger.handleError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package scheduler runs the periodic jobs of the API, such as the cleanup of the expired
+// tokens. Each job runs on a goroutine of its own at its interval, so that a slow job
+// doesn't delay the others, and never overlaps with itself.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/ziliscite/purplelight/internal/telemetry"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PanicHandler is called with the value of a job run which panicked, from the deferred
+// function which recovered it, so that debug.Stack() still returns the stack of the
+// panic.
+type PanicHandler func(ctx context.Context, name string, value any)
+
+// Job is a function run periodically, under a name for the logs and the metrics.
+type Job struct {
+	Name string
+	// Every is the interval between two runs, the first one included.
+	Every time.Duration
+	// Timeout bounds each run, unless it's zero, in which case the timeout of the
+	// scheduler applies.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Stats are the metrics of a job.
+type Stats struct {
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	Running  bool  `json:"running"`
+	// LastRun is when the last run started, and LastDuration how long it took.
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration float64    `json:"last_duration_seconds"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type job struct {
+	Job
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Scheduler runs the jobs registered with it, from Start until Stop. A run which fails
+// or panics is logged and counted, and the job runs again at its next tick.
+type Scheduler struct {
+	logger  *slog.Logger
+	timeout time.Duration
+	onPanic PanicHandler
+
+	jobs []*job
+
+	// ctx is the parent of the contexts of the runs, canceled when Stop gives up
+	// waiting for them. stop is closed when Stop is called.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New returns a scheduler whose job runs last at most timeout, unless the job sets
+// another one, or it's zero.
+func New(logger *slog.Logger, timeout time.Duration, onPanic PanicHandler) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		logger:  logger,
+		timeout: timeout,
+		onPanic: onPanic,
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Register adds a job to the scheduler. It must be called before Start. A job with an
+// interval of zero or less is disabled, and left out.
+func (s *Scheduler) Register(j Job) {
+	if j.Every <= 0 {
+		return
+	}
+	if j.Timeout == 0 {
+		j.Timeout = s.timeout
+	}
+
+	s.jobs = append(s.jobs, &job{Job: j})
+}
+
+// Start starts running the jobs registered.
+func (s *Scheduler) Start() {
+	s.wg.Add(len(s.jobs))
+	for _, j := range s.jobs {
+		go func() {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(j.Every)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-s.stop:
+					return
+				case <-ticker.C:
+					s.run(j)
+				}
+			}
+		}()
+	}
+}
+
+// run runs a job once, and records how it went.
+func (s *Scheduler) run(j *job) {
+	ctx := s.ctx
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	ctx, span := telemetry.Start(ctx, "job."+j.Name)
+
+	start := time.Now()
+	j.mu.Lock()
+	j.stats.Running = true
+	j.stats.LastRun = &start
+	j.mu.Unlock()
+
+	var err error
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+			if s.onPanic != nil {
+				s.onPanic(ctx, j.Name, v)
+			}
+		}
+
+		telemetry.End(span, err)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.stats.Running = false
+		j.stats.Runs++
+		j.stats.LastDuration = time.Since(start).Seconds()
+		j.stats.LastError = ""
+		if err != nil {
+			j.stats.Failures++
+			j.stats.LastError = err.Error()
+		}
+	}()
+
+	err = j.Run(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "job failed", "job", j.Name, "error", err.Error())
+	}
+}
+
+// Stats returns the metrics of every job, by name.
+func (s *Scheduler) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		stats[j.Name] = j.stats
+		j.mu.Unlock()
+	}
+
+	return stats
+}
+
+// Stop stops scheduling the jobs, and waits for the runs in progress to finish. If ctx
+// is done first, their contexts are canceled, and Stop returns the error of ctx without
+// waiting any longer.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+// Package service holds logic that spans more than one repository. Most handlers only
+// talk to a single repository and keep calling it directly, but operations which must
+// either fully happen or not happen at all go through a TxManager.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ziliscite/purplelight/internal/repository"
+)
+
+// Transactor is what handlers depend on to run multi-repository operations atomically.
+// TxManager implements it on top of Postgres; memory.Transactor is the in-memory fake.
+type Transactor interface {
+	WithinTx(ctx context.Context, fn func(repos repository.Repositories) error) error
+}
+
+// TxManager runs functions inside a database transaction, handing them a set of
+// repositories bound to that transaction.
+type TxManager struct {
+	db    *pgxpool.Pool
+	repos repository.Repositories
+}
+
+// NewTxManager returns a TxManager using the given pool and repositories. The
+// repositories are only used as a template for WithTx(), so their timeouts and
+// logger carry over to the transaction bound copies.
+func NewTxManager(db *pgxpool.Pool, repos repository.Repositories) *TxManager {
+	return &TxManager{
+		db:    db,
+		repos: repos,
+	}
+}
+
+// WithinTx begins a transaction and calls fn with repositories bound to it. If fn
+// returns an error (or panics) the transaction is rolled back and the error is returned
+// unchanged, so callers can keep matching on the repository sentinel errors. Otherwise
+// the transaction is committed.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(repos repository.Repositories) error) (err error) {
+	tx, err := m.db.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", repository.ErrTransaction, err.Error())
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+
+		if err != nil {
+			// The context may already be canceled, in which case pgx closes the
+			// connection and the transaction is aborted anyway.
+			if rbErr := tx.Rollback(context.Background()); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+				err = errors.Join(err, rbErr)
+			}
+		}
+	}()
+
+	repos := m.repos.WithTx(tx)
+
+	err = fn(repos)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", repository.ErrTransaction, err.Error())
+	}
+
+	repos.Committed(ctx)
+
+	return nil
+}
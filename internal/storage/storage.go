@@ -0,0 +1,23 @@
+// Package storage stores uploaded files, such as anime cover images, behind a small
+// interface so that the backend can be swapped (local disk now, an object store such as
+// S3 later) without touching the handlers.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrInvalidKey is returned for keys that would escape the storage root.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage saves files under slash separated keys such as "anime/42.png".
+type Storage interface {
+	// Put stores the content under the key, replacing any file already stored there,
+	// and returns the URL the file can be fetched from.
+	Put(ctx context.Context, key string, content io.Reader, contentType string) (string, error)
+	// Delete removes the file stored under the key. Deleting a missing file is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,79 @@
+// Package vcs describes the build of the running binary: its version, the commit it was
+// built from, and the Go version it was built with. The commit comes from the version
+// control information the go command embeds when building inside the repository, so it
+// is missing from binaries built with -buildvcs=false or outside of it.
+package vcs
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// version and buildTime can be set when building, with:
+//
+//	go build -ldflags "-X github.com/ziliscite/purplelight/internal/vcs.version=1.2.0 \
+//	    -X github.com/ziliscite/purplelight/internal/vcs.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "1.0.0"
+	buildTime string
+)
+
+// BuildInfo describes the build of the running binary.
+type BuildInfo struct {
+	Version string `json:"version"`
+	// Commit is the hash of the commit the binary was built from, and Modified tells
+	// whether the working tree had uncommitted changes.
+	Commit     string     `json:"commit,omitempty"`
+	Modified   bool       `json:"modified"`
+	CommitTime *time.Time `json:"commit_time,omitempty"`
+	BuildTime  *time.Time `json:"build_time,omitempty"`
+	GoVersion  string     `json:"go_version"`
+}
+
+// Info returns the build info of the running binary.
+var Info = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version()}
+
+	if t, err := time.Parse(time.RFC3339, buildTime); err == nil {
+		info.BuildTime = &t
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		case "vcs.time":
+			if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+				info.CommitTime = &t
+			}
+		}
+	}
+
+	return info
+})
+
+// Version returns the version of the running binary, along with the commit it was built
+// from as build metadata, such as 1.0.0+3ac85f3, or 1.0.0+3ac85f3.dirty when the working
+// tree had uncommitted changes.
+func Version() string {
+	info := Info()
+	if info.Commit == "" {
+		return info.Version
+	}
+
+	v := info.Version + "+" + info.Commit[:min(len(info.Commit), 7)]
+	if info.Modified {
+		v += ".dirty"
+	}
+
+	return v
+}
@@ -0,0 +1,152 @@
+// Package worker runs the background tasks of the API, such as sending emails, on a
+// fixed number of goroutines. Tasks wait in a bounded queue for a free worker, so that
+// a burst of them can't start an unbounded number of goroutines.
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Submit once the pool is shut down.
+var ErrClosed = errors.New("worker: pool is shut down")
+
+// PanicHandler is called with the value of a task which panicked, from the deferred
+// function which recovered it, so that debug.Stack() still returns the stack of the
+// panic.
+type PanicHandler func(ctx context.Context, name string, value any)
+
+type task struct {
+	ctx  context.Context
+	name string
+	fn   func(ctx context.Context)
+}
+
+// Pool runs the tasks submitted to it on a fixed number of workers. A task panicking is
+// recovered and handed to the PanicHandler, leaving its worker and the other tasks be.
+type Pool struct {
+	tasks   chan task
+	timeout time.Duration
+	onPanic PanicHandler
+
+	// closing is closed when the shutdown starts. mu is held by Submit while it waits
+	// to queue a task, so that the tasks channel is only closed once no one is about to
+	// send on it.
+	closing   chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+
+	wg      sync.WaitGroup
+	running atomic.Int64
+}
+
+// New starts a pool of workers goroutines, with room for queueSize tasks waiting for
+// one of them. Each task runs for at most timeout, unless it's zero.
+func New(workers, queueSize int, timeout time.Duration, onPanic PanicHandler) *Pool {
+	p := &Pool{
+		tasks:   make(chan task, queueSize),
+		timeout: timeout,
+		onPanic: onPanic,
+		closing: make(chan struct{}),
+	}
+
+	p.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer p.wg.Done()
+
+			// The workers keep going until the queue is closed and empty, which drains
+			// the tasks queued before the shutdown.
+			for t := range p.tasks {
+				p.run(t)
+			}
+		}()
+	}
+
+	return p
+}
+
+// Submit queues fn to run with ctx, under name for the PanicHandler. It waits for room
+// in the queue, and gives up when ctx is done or the pool shuts down. The task itself
+// runs with ctx as it is, so it shouldn't be the context of a request which will be
+// over by then: see context.WithoutCancel.
+func (p *Pool) Submit(ctx context.Context, name string, fn func(ctx context.Context)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	select {
+	case <-p.closing:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case p.tasks <- task{ctx: ctx, name: name, fn: fn}:
+		return nil
+	case <-p.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) run(t task) {
+	p.running.Add(1)
+	defer p.running.Add(-1)
+
+	ctx := t.ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if v := recover(); v != nil && p.onPanic != nil {
+			p.onPanic(ctx, t.name, v)
+		}
+	}()
+
+	t.fn(ctx)
+}
+
+// Queued returns the number of tasks waiting for a worker.
+func (p *Pool) Queued() int {
+	return len(p.tasks)
+}
+
+// Running returns the number of tasks being run.
+func (p *Pool) Running() int64 {
+	return p.running.Load()
+}
+
+// Shutdown stops the pool from taking new tasks, and waits for the ones queued and
+// running to finish. If ctx is done first, it returns its error and the tasks left are
+// abandoned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+
+		// Every Submit waiting returns now that closing is closed, after which no one
+		// sends on the queue anymore.
+		p.mu.Lock()
+		close(p.tasks)
+		p.mu.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
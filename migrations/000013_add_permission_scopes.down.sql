@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code IN ('tags:write', 'metrics:read');
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,13 @@
+-- Remove duplicate codes (keeping the oldest row) so that the codes can be unique.
+DELETE FROM permissions a
+USING permissions b
+WHERE a.code = b.code AND a.id > b.id;
+
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+INSERT INTO permissions (code)
+VALUES
+('tags:write'),
+('metrics:read'),
+('users:admin')
+ON CONFLICT (code) DO NOTHING;
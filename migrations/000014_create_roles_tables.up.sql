@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO roles (name)
+VALUES
+('admin'),
+('moderator'),
+('user')
+ON CONFLICT (name) DO NOTHING;
+
+-- Admins get every permission, moderators can curate the catalog, users can read it.
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin'
+   OR (r.name = 'moderator' AND p.code IN ('anime:read', 'anime:write', 'tags:write'))
+   OR (r.name = 'user' AND p.code IN ('anime:read'))
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'user' REFERENCES roles (name);
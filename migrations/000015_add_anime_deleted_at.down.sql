@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS anime_deleted_at_idx;
+ALTER TABLE anime DROP COLUMN IF EXISTS deleted_at;
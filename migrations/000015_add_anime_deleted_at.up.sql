@@ -0,0 +1,3 @@
+ALTER TABLE anime ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS anime_deleted_at_idx ON anime (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS anime_revisions (
+    anime_id integer NOT NULL REFERENCES anime ON DELETE CASCADE,
+    version integer NOT NULL,
+    snapshot jsonb NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (anime_id, version)
+);
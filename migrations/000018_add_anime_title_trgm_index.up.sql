@@ -0,0 +1,2 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS anime_title_trgm_idx ON anime USING GIN (title gin_trgm_ops);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS anime_synopsis_idx;
+
+ALTER TABLE anime
+    DROP COLUMN IF EXISTS alt_titles,
+    DROP COLUMN IF EXISTS synopsis;
@@ -0,0 +1,5 @@
+ALTER TABLE anime
+    ADD COLUMN IF NOT EXISTS synopsis text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS alt_titles text[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS anime_synopsis_idx ON anime USING GIN (to_tsvector('simple', synopsis));
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS anime_studios;
+DROP TABLE IF EXISTS studio;
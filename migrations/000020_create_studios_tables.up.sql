@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS studio (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS anime_studios (
+    anime_id INTEGER REFERENCES anime(id) ON DELETE CASCADE,
+    studio_id INTEGER REFERENCES studio(id) ON DELETE CASCADE,
+    PRIMARY KEY (anime_id, studio_id)
+);
+
+CREATE INDEX IF NOT EXISTS anime_studios_studio_id_idx ON anime_studios (studio_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS anime_voice_actors;
+DROP TABLE IF EXISTS anime_characters;
+DROP TABLE IF EXISTS people;
+DROP TABLE IF EXISTS characters;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS characters (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    about TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS people (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- The characters appearing in an anime, and how prominently.
+CREATE TABLE IF NOT EXISTS anime_characters (
+    anime_id INTEGER NOT NULL REFERENCES anime(id) ON DELETE CASCADE,
+    character_id INTEGER NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    PRIMARY KEY (anime_id, character_id)
+);
+
+-- Who voices a character in an anime, per dub language.
+CREATE TABLE IF NOT EXISTS anime_voice_actors (
+    anime_id INTEGER NOT NULL,
+    character_id INTEGER NOT NULL,
+    person_id INTEGER NOT NULL REFERENCES people(id) ON DELETE CASCADE,
+    language TEXT NOT NULL,
+    PRIMARY KEY (anime_id, character_id, person_id, language),
+    FOREIGN KEY (anime_id, character_id) REFERENCES anime_characters (anime_id, character_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS anime_characters_character_id_idx ON anime_characters (character_id);
+CREATE INDEX IF NOT EXISTS anime_voice_actors_person_id_idx ON anime_voice_actors (person_id);
@@ -0,0 +1 @@
+ALTER TABLE anime ADD COLUMN IF NOT EXISTS cover_url text NOT NULL DEFAULT '';
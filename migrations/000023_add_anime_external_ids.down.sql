@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS anime_anilist_id_idx;
+DROP INDEX IF EXISTS anime_mal_id_idx;
+
+ALTER TABLE anime
+    DROP COLUMN IF EXISTS anilist_id,
+    DROP COLUMN IF EXISTS mal_id;
@@ -0,0 +1,6 @@
+ALTER TABLE anime
+    ADD COLUMN IF NOT EXISTS mal_id integer,
+    ADD COLUMN IF NOT EXISTS anilist_id integer;
+
+CREATE UNIQUE INDEX IF NOT EXISTS anime_mal_id_idx ON anime (mal_id);
+CREATE UNIQUE INDEX IF NOT EXISTS anime_anilist_id_idx ON anime (anilist_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS watchlist (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    anime_id integer NOT NULL REFERENCES anime(id) ON DELETE CASCADE,
+    status text NOT NULL CHECK (status IN ('watching', 'completed', 'plan_to_watch', 'dropped')),
+    episodes_watched integer NOT NULL DEFAULT 0 CHECK (episodes_watched >= 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (user_id, anime_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_user_id_status_idx ON watchlist (user_id, status);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS anime_trending;
+DROP TABLE IF EXISTS anime_activity;
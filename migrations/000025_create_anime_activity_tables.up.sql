@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS anime_activity (
+    anime_id integer NOT NULL REFERENCES anime(id) ON DELETE CASCADE,
+    bucket timestamp(0) with time zone NOT NULL,
+    views integer NOT NULL DEFAULT 0,
+    watchlist_adds integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (anime_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS anime_activity_bucket_idx ON anime_activity (bucket);
+
+CREATE TABLE IF NOT EXISTS anime_trending (
+    anime_id integer PRIMARY KEY REFERENCES anime(id) ON DELETE CASCADE,
+    score double precision NOT NULL,
+    views bigint NOT NULL,
+    watchlist_adds bigint NOT NULL,
+    computed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS anime_trending_score_idx ON anime_trending (score DESC, anime_id);
@@ -0,0 +1 @@
+ALTER TABLE anime DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,4 @@
+ALTER TABLE anime ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- The anime added before this migration are taken as last changed when they were added.
+UPDATE anime SET updated_at = created_at;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS anime_updated_at_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS anime_updated_at_idx ON anime (updated_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS anime_slug_idx;
+ALTER TABLE anime DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,18 @@
+ALTER TABLE anime ADD COLUMN IF NOT EXISTS slug text;
+
+-- The slugs of the anime added before this migration are approximated in SQL: accents
+-- aren't stripped, and anime sharing a slug get their ID as a suffix. New slugs are made
+-- by the application.
+UPDATE anime SET slug = COALESCE(NULLIF(trim(BOTH '-' FROM lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g'))), ''), 'anime');
+
+UPDATE anime SET slug = slug || '-' || id
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY slug ORDER BY id) AS n FROM anime
+    ) ranked
+    WHERE n > 1
+);
+
+ALTER TABLE anime ALTER COLUMN slug SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS anime_slug_idx ON anime (slug);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS users_unactivated_created_at_idx;
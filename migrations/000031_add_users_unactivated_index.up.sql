@@ -0,0 +1,2 @@
+-- The purge of the accounts never activated only ever looks for those.
+CREATE INDEX IF NOT EXISTS users_unactivated_created_at_idx ON users (created_at) WHERE NOT activated;
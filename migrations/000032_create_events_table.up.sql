@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS events (
+    id bigserial PRIMARY KEY,
+    entity text NOT NULL,
+    entity_id bigint NOT NULL,
+    action text NOT NULL,
+    data jsonb NOT NULL DEFAULT 'null',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    published_at timestamp(0) with time zone DEFAULT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- The relay only ever looks for the events still to be published, in order.
+CREATE INDEX IF NOT EXISTS events_unpublished_idx ON events (id) WHERE published_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE anime DROP COLUMN IF EXISTS search;
+
+DROP FUNCTION IF EXISTS anime_alt_titles_text(text[]);
@@ -0,0 +1,16 @@
+-- array_to_string() is only stable, while a generated column takes immutable functions,
+-- so the alternative titles are joined by a function of our own, which is immutable for
+-- text arrays.
+CREATE OR REPLACE FUNCTION anime_alt_titles_text(text[]) RETURNS text
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$ SELECT array_to_string($1, ' ') $$;
+
+-- The words of the anime searched by the q parameter, weighted by where they are found:
+-- the title first, then the alternative titles, and the synopsis last. The tags, in
+-- another table, are added to it by the query with the weight C, which is why it has no
+-- index: keeping it saves parsing every synopsis again on every search.
+ALTER TABLE anime ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', anime_alt_titles_text(alt_titles)), 'B') ||
+    setweight(to_tsvector('simple', synopsis), 'D')
+) STORED;
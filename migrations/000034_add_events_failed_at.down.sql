@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS events_unpublished_idx;
+CREATE INDEX IF NOT EXISTS events_unpublished_idx ON events (id) WHERE published_at IS NULL;
+
+ALTER TABLE events DROP COLUMN IF EXISTS failed_at;
@@ -0,0 +1,5 @@
+ALTER TABLE events ADD COLUMN IF NOT EXISTS failed_at timestamp(0) with time zone DEFAULT NULL;
+
+-- The events given up on aren't pending anymore either.
+DROP INDEX IF EXISTS events_unpublished_idx;
+CREATE INDEX IF NOT EXISTS events_unpublished_idx ON events (id) WHERE published_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,7 @@
+-- token_version only moves on with the changes which must log the user out everywhere:
+-- a new password, a new email address, or a deactivation. The JWTs carry it, and are
+-- rejected once it did.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version integer NOT NULL DEFAULT 1;
+
+-- The JWTs issued so far carry the version of the user.
+UPDATE users SET token_version = version;
@@ -0,0 +1,4 @@
+-- last_failed_login_at is the time of the latest failed login. The failed logins only
+-- add up while each follows the previous one within the lockout window, so that a
+-- forgotten password now and then doesn't eventually lock the account.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at timestamp(0) with time zone DEFAULT NULL;
@@ -0,0 +1,10 @@
+// Package migrations embeds the schema migrations, so that the binary can apply them
+// itself. Each one is a pair of NNNNNN_name.up.sql and NNNNNN_name.down.sql files.
+package migrations
+
+import "embed"
+
+// FS holds the migration files.
+//
+//go:embed *.sql
+var FS embed.FS